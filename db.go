@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/pphee/assessment-tax/store"
+	"os"
+	"strconv"
+	"time"
+)
+
+const defaultConnectTimeout = 30 * time.Second
+
+// storePool reads the connection pool limits from DB_MAX_OPEN_CONNS,
+// DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME and DB_CONN_MAX_IDLE_TIME.
+func storePool() (store.Pool, error) {
+	var pool store.Pool
+	for name, limit := range map[string]*int{
+		"DB_MAX_OPEN_CONNS": &pool.MaxOpenConns,
+		"DB_MAX_IDLE_CONNS": &pool.MaxIdleConns,
+	} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return pool, fmt.Errorf("invalid %s: %q", name, v)
+			}
+			*limit = n
+		}
+	}
+	for name, duration := range map[string]*time.Duration{
+		"DB_CONN_MAX_LIFETIME":  &pool.ConnMaxLifetime,
+		"DB_CONN_MAX_IDLE_TIME": &pool.ConnMaxIdleTime,
+	} {
+		if v := os.Getenv(name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return pool, fmt.Errorf("invalid %s: %q", name, v)
+			}
+			*duration = d
+		}
+	}
+	return pool, nil
+}
+
+// connectTimeout is how long to wait for the database at startup,
+// DB_CONNECT_TIMEOUT or 30 seconds. 0 waits without limit.
+func connectTimeout() (time.Duration, error) {
+	v := os.Getenv("DB_CONNECT_TIMEOUT")
+	if v == "" {
+		return defaultConnectTimeout, nil
+	}
+	timeout, err := time.ParseDuration(v)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid DB_CONNECT_TIMEOUT: %q", v)
+	}
+	return timeout, nil
+}
+
+// openStore opens dsn and waits for it to answer. The server has its own
+// handling for a database that does not; commands use this.
+func openStore(dsn string) (store.Store, error) {
+	pool, err := storePool()
+	if err != nil {
+		return nil, err
+	}
+	timeout, err := connectTimeout()
+	if err != nil {
+		return nil, err
+	}
+	opened, err := store.Open(dsn, pool)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := connectContext(timeout)
+	defer cancel()
+	if err := store.Wait(ctx, opened); err != nil {
+		opened.Close()
+		return nil, err
+	}
+	return opened, nil
+}
+
+func connectContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), timeout)
+}
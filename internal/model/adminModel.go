@@ -0,0 +1,33 @@
+package model
+
+import "time"
+
+type AdminUser struct {
+	Username          string    `json:"username"`
+	Role              string    `json:"role"`
+	Disabled          bool      `json:"disabled"`
+	PasswordChangedAt time.Time `json:"passwordChangedAt"`
+	CreatedAt         time.Time `json:"createdAt"`
+}
+
+type CreateAdminUserRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+type RotatePasswordRequest struct {
+	Password string `json:"password"`
+}
+
+type LoginLockout struct {
+	Username    string    `json:"username,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"lockedUntil"`
+}
+
+type UnlockRequest struct {
+	Username string `json:"username"`
+	IP       string `json:"ip"`
+}
@@ -0,0 +1,34 @@
+package model
+
+import "time"
+
+type APIKey struct {
+	ID            uint        `json:"id"`
+	Name          string      `json:"name"`
+	Prefix        string      `json:"prefix"`
+	RatePerMinute int         `json:"ratePerMinute"`
+	DailyRowQuota int64       `json:"dailyRowQuota"`
+	CreatedBy     string      `json:"createdBy"`
+	CreatedAt     time.Time   `json:"createdAt"`
+	RevokedAt     *time.Time  `json:"revokedAt,omitempty"`
+	Today         APIKeyUsage `json:"today"`
+}
+
+// CreatedAPIKey is only returned when a key is created; the plaintext key
+// cannot be retrieved later.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name          string `json:"name"`
+	RatePerMinute int    `json:"ratePerMinute"`
+	DailyRowQuota int64  `json:"dailyRowQuota"`
+}
+
+type APIKeyUsage struct {
+	Day      string `json:"day"`
+	Requests int64  `json:"requests"`
+	Rows     int64  `json:"rows"`
+}
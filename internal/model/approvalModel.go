@@ -0,0 +1,48 @@
+package model
+
+import "time"
+
+type AuditEvent struct {
+	ID        uint      `json:"id"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Entity    string    `json:"entity"`
+	EntityID  string    `json:"entityId"`
+	Detail    string    `json:"detail,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+type AuditFilter struct {
+	Entity   string
+	EntityID string
+	Limit    int
+}
+
+type ChangeRequestSubmission struct {
+	Kind       string                   `json:"kind"`
+	Settings   []AllowanceSettingUpdate `json:"settings,omitempty"`
+	TaxYear    int                      `json:"taxYear,omitempty"`
+	ScheduleID uint                     `json:"scheduleId,omitempty"`
+	Reason     string                   `json:"reason,omitempty"`
+}
+
+type ChangeRequest struct {
+	ID            uint                     `json:"id"`
+	Kind          string                   `json:"kind"`
+	Status        string                   `json:"status"`
+	Settings      []AllowanceSettingUpdate `json:"settings,omitempty"`
+	TaxYear       int                      `json:"taxYear,omitempty"`
+	ScheduleID    uint                     `json:"scheduleId,omitempty"`
+	Reason        string                   `json:"reason,omitempty"`
+	SubmittedBy   string                   `json:"submittedBy"`
+	ReviewedBy    string                   `json:"reviewedBy,omitempty"`
+	ReviewComment string                   `json:"reviewComment,omitempty"`
+	ApplyError    string                   `json:"applyError,omitempty"`
+	CreatedAt     time.Time                `json:"createdAt"`
+	ExpiresAt     time.Time                `json:"expiresAt"`
+	DecidedAt     *time.Time               `json:"decidedAt,omitempty"`
+}
+
+type ChangeRequestDecision struct {
+	Comment string `json:"comment"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import "time"
+
+// RetentionClass describes one class of stored data. Retention is empty and
+// Before nil when the class is kept forever; Records counts what a purge run
+// now would remove.
+type RetentionClass struct {
+	Class     string     `json:"class"`
+	Retention string     `json:"retention,omitempty"`
+	Before    *time.Time `json:"before,omitempty"`
+	Records   int64      `json:"records"`
+}
+
+type RetentionPreview struct {
+	GeneratedAt time.Time        `json:"generatedAt"`
+	Classes     []RetentionClass `json:"classes"`
+}
@@ -3,17 +3,27 @@ package model
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 )
 
+// TaxRequest is checked by the validate package before it is calculated;
+// amounts are capped at a trillion baht.
 type TaxRequest struct {
-	TotalIncome float64     `json:"totalIncome"`
-	WHT         float64     `json:"wht"`
-	Allowances  []Allowance `json:"allowances"`
+	TotalIncome float64     `json:"totalIncome" validate:"required,min=0,max=1e12"`
+	WHT         float64     `json:"wht" validate:"min=0,ltefield=totalIncome"`
+	Allowances  []Allowance `json:"allowances" validate:"maxitems=10,unique=allowanceType"`
+	TaxpayerID  uint        `json:"taxpayerId,omitempty"`
+	NationalID  string      `json:"nationalId,omitempty"`
+	// TaxYear is the Buddhist Era year to calculate for; zero means the
+	// current year.
+	TaxYear int `json:"taxYear,omitempty"`
+	// Owner identifies the caller and is set by the handler, never bound.
+	Owner string `json:"-"`
 }
 
 type Allowance struct {
-	AllowanceType string  `json:"allowanceType"`
-	Amount        float64 `json:"amount"`
+	AllowanceType string  `json:"allowanceType" validate:"required,oneof=personal donation k-receipt k-receipt-admin"`
+	Amount        float64 `json:"amount" validate:"required,min=0,max=1e12"`
 }
 
 type AllowanceConfig struct {
@@ -21,8 +31,13 @@ type AllowanceConfig struct {
 }
 
 type TaxResponse struct {
-	Tax       float64      `json:"-"`
-	TaxLevels []TaxBracket `json:"taxLevel"`
+	NationalID    string       `json:"-"`
+	Tax           float64      `json:"-"`
+	TaxLevels     []TaxBracket `json:"taxLevel"`
+	ConfigVersion int64        `json:"configVersion"`
+	// Breakdown and Warnings are written by v2 only; the v1 JSON is fixed.
+	Breakdown TaxBreakdown `json:"-"`
+	Warnings  []Warning    `json:"-"`
 }
 
 func (tr TaxResponse) MarshalJSON() ([]byte, error) {
@@ -42,24 +57,70 @@ func (tr TaxResponse) MarshalJSON() ([]byte, error) {
 	}
 
 	return json.Marshal(&struct {
-		Tax       json.Number `json:"tax"`
-		TaxLevels []struct {
+		NationalID string      `json:"nationalId,omitempty"`
+		Tax        json.Number `json:"tax"`
+		TaxLevels  []struct {
 			Level string      `json:"level"`
 			Tax   json.Number `json:"tax"`
 		} `json:"taxLevel"`
+		ConfigVersion int64 `json:"configVersion"`
 	}{
-		Tax:       json.Number(fmt.Sprintf("%.1f", tr.Tax)),
-		TaxLevels: taxLevels,
+		NationalID:    tr.NationalID,
+		Tax:           json.Number(fmt.Sprintf("%.1f", tr.Tax)),
+		TaxLevels:     taxLevels,
+		ConfigVersion: tr.ConfigVersion,
 	})
 }
 
 type TaxBracket struct {
 	Level string  `json:"level"`
 	Tax   float64 `json:"tax"`
+	// Rate is the bracket Level labels, so that it can be labelled again
+	// in the caller's language.
+	Rate TaxRate `json:"-"`
+}
+
+// TaxBreakdown is how a tax was arrived at: the deductions taken off the
+// income and the tax of each bracket, before WHT. Warnings are about the
+// inputs of this one income.
+type TaxBreakdown struct {
+	TotalIncome   float64
+	Deductions    []Deduction
+	TaxableIncome float64
+	Brackets      []TaxBracket
+	GrossTax      float64
+	WHT           float64
+	Warnings      []Warning
+}
+
+// Net returns the tax left to pay after WHT, or the refund due when WHT
+// exceeds the tax. At most one of them is not zero.
+func (b TaxBreakdown) Net() (tax, refund float64) {
+	net := b.GrossTax - b.WHT
+	if net < 0 {
+		return 0, -net
+	}
+	return net, 0
+}
+
+// Deduction is an allowance as it was taken off the income, after any cap.
+type Deduction struct {
+	Type   string
+	Amount float64
+}
+
+// Warning is something the caller may want to know about a calculation
+// that succeeded. Code is stable, Field names the input it is about and,
+// for a capped allowance, Allowance and Limit say which and to what.
+type Warning struct {
+	Code      string
+	Field     string
+	Allowance string
+	Limit     float64
 }
 
 type AdminRequest struct {
-	Amount float64 `json:"amount"`
+	Amount float64 `json:"amount" validate:"required,min=0,max=1e12"`
 }
 
 type AdminPersonalDeductionResponse struct {
@@ -87,24 +148,36 @@ func (ar AdminKReceiptDeductionResponse) MarshalJSON() ([]byte, error) {
 
 }
 
+// TotalIncomeCsv is one CSV row. The nationalId column is optional.
 type TotalIncomeCsv struct {
-	TotalIncome float64 `csv:"totalIncome"`
-	WHT         float64 `csv:"wht"`
-	Donation    float64 `csv:"donation"`
+	TotalIncome float64 `csv:"totalIncome" json:"totalIncome"`
+	WHT         float64 `csv:"wht" json:"wht"`
+	Donation    float64 `csv:"donation" json:"donation"`
+	NationalID  string  `csv:"nationalId" json:"nationalId,omitempty"`
+	TaxYear     int     `csv:"taxYear" json:"taxYear,omitempty"`
+	// Owner identifies the caller and is set by the handler, never read
+	// from the file.
+	Owner string `csv:"-" json:"-"`
 }
 
 type TaxDetail struct {
-	TotalIncome float64 `json:"totalIncome"`
-	Tax         float64 `json:"tax"`
-	TaxRefund   float64 `json:"taxRefund,omitempty"`
+	NationalID  string       `json:"nationalId,omitempty"`
+	TotalIncome float64      `json:"totalIncome"`
+	Tax         float64      `json:"tax"`
+	TaxRefund   float64      `json:"taxRefund,omitempty"`
+	Breakdown   TaxBreakdown `json:"-"`
 }
 
 type TaxResponseCSV struct {
-	Taxes []TaxDetail `json:"taxes"`
+	Taxes         []TaxDetail `json:"taxes"`
+	ConfigVersion int64       `json:"configVersion"`
+	// Warnings are about the batch as a whole and are written by v2 only.
+	Warnings []Warning `json:"-"`
 }
 
 func (tr TaxResponseCSV) MarshalJSON() ([]byte, error) {
 	taxes := make([]struct {
+		NationalID  string      `json:"nationalId,omitempty"`
 		TotalIncome json.Number `json:"totalIncome"`
 		Tax         json.Number `json:"tax"`
 		TaxRefund   json.Number `json:"taxRefund,omitempty"`
@@ -112,10 +185,12 @@ func (tr TaxResponseCSV) MarshalJSON() ([]byte, error) {
 
 	for i, tl := range tr.Taxes {
 		taxes[i] = struct {
+			NationalID  string      `json:"nationalId,omitempty"`
 			TotalIncome json.Number `json:"totalIncome"`
 			Tax         json.Number `json:"tax"`
 			TaxRefund   json.Number `json:"taxRefund,omitempty"`
 		}{
+			NationalID:  tl.NationalID,
 			TotalIncome: json.Number(fmt.Sprintf("%.1f", tl.TotalIncome)),
 			Tax:         json.Number(fmt.Sprintf("%.1f", tl.Tax)),
 			TaxRefund:   json.Number(fmt.Sprintf("%.1f", tl.TaxRefund)),
@@ -124,11 +199,83 @@ func (tr TaxResponseCSV) MarshalJSON() ([]byte, error) {
 
 	return json.Marshal(&struct {
 		Taxes []struct {
+			NationalID  string      `json:"nationalId,omitempty"`
 			TotalIncome json.Number `json:"totalIncome"`
 			Tax         json.Number `json:"tax"`
 			TaxRefund   json.Number `json:"taxRefund,omitempty"`
 		} `json:"taxes"`
+		ConfigVersion int64 `json:"configVersion"`
 	}{
-		Taxes: taxes,
+		Taxes:         taxes,
+		ConfigVersion: tr.ConfigVersion,
 	})
 }
+
+type AllowanceSetting struct {
+	Key     string  `json:"key"`
+	Amount  float64 `json:"amount"`
+	Min     float64 `json:"min"`
+	Max     float64 `json:"max"`
+	Version int64   `json:"version"`
+}
+
+func (s AllowanceSetting) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Key     string      `json:"key"`
+		Amount  json.Number `json:"amount"`
+		Min     json.Number `json:"min"`
+		Max     json.Number `json:"max"`
+		Version int64       `json:"version"`
+	}{
+		Key:     s.Key,
+		Amount:  json.Number(fmt.Sprintf("%.1f", s.Amount)),
+		Min:     json.Number(fmt.Sprintf("%.1f", s.Min)),
+		Max:     json.Number(fmt.Sprintf("%.1f", s.Max)),
+		Version: s.Version,
+	})
+}
+
+// AllowanceSettingUpdate.Version is the version the setting must still have;
+// it comes from If-Match, not the body, and zero skips the check.
+type AllowanceSettingUpdate struct {
+	Key     string  `json:"key" validate:"required,oneof=PersonalDefault PersonalMax DonationMax KReceiptDefault KReceiptMax"`
+	Amount  float64 `json:"amount" validate:"required,min=0,max=1e12"`
+	Version int64   `json:"-"`
+}
+
+type AdminSettingsRequest struct {
+	Settings []AllowanceSettingUpdate `json:"settings" validate:"required,maxitems=5,unique=key"`
+}
+
+type AdminSettingsResponse struct {
+	Settings []AllowanceSetting `json:"settings"`
+}
+
+type TaxRate struct {
+	Level string   `json:"level,omitempty"`
+	Min   float64  `json:"min"`
+	Max   *float64 `json:"max,omitempty"`
+	Rate  float64  `json:"rate"`
+}
+
+type TaxSchedule struct {
+	ID          uint              `json:"id"`
+	TaxYear     int               `json:"taxYear"`
+	Status      string            `json:"status"`
+	Brackets    []TaxRate         `json:"brackets"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	ActivatedAt *time.Time        `json:"activatedAt,omitempty"`
+	Preview     []SchedulePreview `json:"preview,omitempty"`
+}
+
+type TaxScheduleProposal struct {
+	Brackets         []TaxRate `json:"brackets"`
+	ReferenceIncomes []float64 `json:"referenceIncomes,omitempty"`
+}
+
+type SchedulePreview struct {
+	Income      float64 `json:"income"`
+	CurrentTax  float64 `json:"currentTax"`
+	ProposedTax float64 `json:"proposedTax"`
+	Difference  float64 `json:"difference"`
+}
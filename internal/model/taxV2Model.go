@@ -0,0 +1,81 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Money is an amount of baht, written as a string with two decimals, such
+// as "29000.00", so that clients never read it as a binary float.
+type Money float64
+
+// String writes m rounded to two decimals, and never as "-0.00".
+func (m Money) String() string {
+	rounded := math.Round(float64(m)*100) / 100
+	if rounded == 0 {
+		rounded = 0
+	}
+	return strconv.FormatFloat(rounded, 'f', 2, 64)
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("money must be a decimal string: %w", err)
+	}
+	amount, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return fmt.Errorf("money must be a decimal string: %w", err)
+	}
+	*m = Money(amount)
+	return nil
+}
+
+// TaxCalculationsV2 is the body of every v2 calculation response, whether
+// for one income or a whole file: one result per income, in request order.
+// Warnings are about the request as a whole.
+type TaxCalculationsV2 struct {
+	ConfigVersion int64              `json:"configVersion"`
+	Results       []TaxCalculationV2 `json:"results"`
+	Warnings      []WarningV2        `json:"warnings"`
+}
+
+// TaxCalculationV2 is the tax of one income with its breakdown. Tax is what
+// is left to pay after WHT and Refund what is due back; at most one of them
+// is not zero.
+type TaxCalculationV2 struct {
+	NationalID    string         `json:"nationalId,omitempty"`
+	TotalIncome   Money          `json:"totalIncome"`
+	Deductions    []DeductionV2  `json:"deductions"`
+	TaxableIncome Money          `json:"taxableIncome"`
+	Brackets      []TaxBracketV2 `json:"brackets"`
+	GrossTax      Money          `json:"grossTax"`
+	WHT           Money          `json:"wht"`
+	Tax           Money          `json:"tax"`
+	Refund        Money          `json:"refund"`
+	Warnings      []WarningV2    `json:"warnings"`
+}
+
+type DeductionV2 struct {
+	Type   string `json:"type"`
+	Amount Money  `json:"amount"`
+}
+
+type TaxBracketV2 struct {
+	Level string `json:"level"`
+	Tax   Money  `json:"tax"`
+}
+
+// WarningV2 is a Warning as shown to the caller, its detail in their
+// language.
+type WarningV2 struct {
+	Code   string `json:"code"`
+	Field  string `json:"field,omitempty"`
+	Detail string `json:"detail"`
+}
@@ -0,0 +1,92 @@
+package model
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type Taxpayer struct {
+	ID         uint      `json:"id"`
+	NationalID string    `json:"nationalId"`
+	Name       string    `json:"name"`
+	Dependents int       `json:"dependents"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+type TaxpayerRequest struct {
+	NationalID string `json:"nationalId"`
+	Name       string `json:"name"`
+	Dependents int    `json:"dependents"`
+}
+
+// TaxpayerLookup is sent in a request body, so the ID stays out of URLs
+// and access logs.
+type TaxpayerLookup struct {
+	NationalID string `json:"nationalId"`
+}
+
+type Calculation struct {
+	ID            uint            `json:"id"`
+	TaxpayerID    *uint           `json:"taxpayerId,omitempty"`
+	BatchID       *uint           `json:"batchId,omitempty"`
+	Input         json.RawMessage `json:"input"`
+	Output        json.RawMessage `json:"output"`
+	ConfigVersion int64           `json:"configVersion"`
+	CreatedAt     time.Time       `json:"createdAt"`
+}
+
+// RecomputeRequest names either a stored calculation or an input with the
+// time whose configuration should be used. Original is the response the
+// caller received, if they want it compared.
+type RecomputeRequest struct {
+	CalculationID uint            `json:"calculationId,omitempty"`
+	Input         *TaxRequest     `json:"input,omitempty"`
+	AsOf          *time.Time      `json:"asOf,omitempty"`
+	Original      json.RawMessage `json:"original,omitempty"`
+}
+
+type RecomputeResult struct {
+	CalculationID uint            `json:"calculationId,omitempty"`
+	ConfigVersion int64           `json:"configVersion"`
+	Original      json.RawMessage `json:"original,omitempty"`
+	Recomputed    json.RawMessage `json:"recomputed"`
+	Matches       *bool           `json:"matches,omitempty"`
+}
+
+// SubjectRequest identifies a data subject by national ID. Format selects
+// "json" (the default) or "zip" for exports; Reference is the caller's
+// reference for the request, kept in the audit log.
+type SubjectRequest struct {
+	NationalID string `json:"nationalId"`
+	Format     string `json:"format,omitempty"`
+	Reference  string `json:"reference,omitempty"`
+}
+
+// SubjectProfile is a taxpayer profile together with the caller that keeps
+// it.
+type SubjectProfile struct {
+	Taxpayer
+	Owner string `json:"owner"`
+}
+
+type SubjectBatch struct {
+	ID            uint      `json:"id"`
+	Rows          int       `json:"rows"`
+	ConfigVersion int64     `json:"configVersion"`
+	CreatedAt     time.Time `json:"createdAt"`
+}
+
+// SubjectExport is everything held about one data subject.
+type SubjectExport struct {
+	GeneratedAt  time.Time        `json:"generatedAt"`
+	Profiles     []SubjectProfile `json:"profiles"`
+	Calculations []Calculation    `json:"calculations"`
+	Batches      []SubjectBatch   `json:"batches"`
+	AuditEvents  []AuditEvent     `json:"auditEvents"`
+}
+
+type SubjectErasure struct {
+	Profiles     int `json:"profiles"`
+	Calculations int `json:"calculations"`
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/module/taxpayer"
+	"github.com/pphee/assessment-tax/store"
+	"github.com/pphee/assessment-tax/store/envelope"
+	"io"
+	"log"
+	"os"
+)
+
+const keysUsage = "usage: keys generate <id> | rotate <id> | reencrypt"
+
+// defaultKeyfile is used when PII_KEYFILE is not set, so the server still
+// starts with only the required environment variables.
+const defaultKeyfile = "pii-keys.json"
+
+func keyfilePath() string {
+	if path := os.Getenv("PII_KEYFILE"); path != "" {
+		return path
+	}
+	return defaultKeyfile
+}
+
+// loadKeyring reads the keyfile named by PII_KEYFILE. Without the variable a
+// keyfile is created in the working directory on first start. An in-memory
+// database forgets its data on exit, so it gets keys that do too.
+func loadKeyring(scheme string) (*envelope.Keyring, error) {
+	if os.Getenv("PII_KEYFILE") == "" {
+		if scheme == store.SchemeMemory {
+			keyfile, err := envelope.GenerateKeyfile("temporary")
+			if err != nil {
+				return nil, err
+			}
+			return envelope.NewKeyring(keyfile)
+		}
+		if _, err := os.Stat(defaultKeyfile); errors.Is(err, os.ErrNotExist) {
+			keyfile, err := envelope.GenerateKeyfile("k1")
+			if err != nil {
+				return nil, err
+			}
+			if err := envelope.WriteKeyfile(defaultKeyfile, keyfile); err != nil {
+				return nil, err
+			}
+			log.Printf("Created keyfile %s; stored taxpayer data cannot be read without it", defaultKeyfile)
+		}
+	}
+	return envelope.LoadKeyfile(keyfilePath())
+}
+
+// runKeys implements the "keys" subcommand: generating a keyfile, adding a
+// new primary key to it, and re-encrypting stored data under that key.
+func runKeys(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	switch args[0] {
+	case "generate":
+		if len(args) != 2 {
+			return errors.New(keysUsage)
+		}
+		keyfile, err := envelope.GenerateKeyfile(args[1])
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(keyfile)
+	case "rotate":
+		if len(args) != 2 {
+			return errors.New(keysUsage)
+		}
+		path := keyfilePath()
+		keyfile, err := envelope.ReadKeyfile(path)
+		if err != nil {
+			return err
+		}
+		if err := keyfile.Rotate(args[1]); err != nil {
+			return err
+		}
+		if err := envelope.WriteKeyfile(path, keyfile); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Key %s is now primary; restart the server, then run \"keys reencrypt\"\n", args[1])
+		return nil
+	case "reencrypt":
+		return runReencrypt(out)
+	}
+	return errors.New(keysUsage)
+}
+
+func runReencrypt(out io.Writer) error {
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		return errors.New("DATABASE_URL not set in environment variables")
+	}
+	scheme, err := store.Scheme(dsn)
+	if err != nil {
+		return err
+	}
+	keys, err := loadKeyring(scheme)
+	if err != nil {
+		return err
+	}
+	opened, err := openStore(dsn)
+	if err != nil {
+		return err
+	}
+	defer opened.Close()
+
+	ctx := context.Background()
+	if err := opened.CheckMigrations(ctx); err != nil {
+		return err
+	}
+	repo := &taxpayer.TaxpayerRepository{DB: opened.Gorm(), Keys: keys}
+	rewritten, err := repo.Reencrypt(ctx)
+	fmt.Fprintf(out, "Re-encrypted %d rows\n", rewritten)
+	return err
+}
@@ -2,69 +2,293 @@ package main
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	"github.com/pphee/assessment-tax/module/adminuser"
+	"github.com/pphee/assessment-tax/module/apikey"
+	"github.com/pphee/assessment-tax/module/approval"
+	"github.com/pphee/assessment-tax/module/audit"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/health"
+	"github.com/pphee/assessment-tax/module/openapi"
+	"github.com/pphee/assessment-tax/module/retention"
 	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/module/taxpayer"
+	"github.com/pphee/assessment-tax/module/taxrpc"
 	"github.com/pphee/assessment-tax/store"
+	"google.golang.org/grpc"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 	"time"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "keys" {
+		if err := runKeys(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	e := echo.New()
+	// Client addresses feed login lockouts, so forwarded headers are only
+	// trusted when running behind a known proxy.
+	e.IPExtractor = echo.ExtractIPDirect()
+	if os.Getenv("TRUST_PROXY_HEADERS") == "true" {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	}
 
 	// Database connection setup
 	dsn := os.Getenv("DATABASE_URL")
 	if dsn == "" {
 		e.Logger.Fatal("DATABASE_URL not set in environment variables")
 	}
-	dbStore := store.NewPostgresStore(dsn)
-
-	sqlDB, err := dbStore.DB.DB()
+	scheme, err := store.Scheme(dsn)
 	if err != nil {
-		e.Logger.Fatal("Failed to get raw database object: ", err)
+		e.Logger.Fatal(err)
 	}
-	defer func(sqlDB *sql.DB) {
-		err := sqlDB.Close()
-		if err != nil {
+	pool, err := storePool()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	dbStore, err := store.Open(dsn, pool)
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	defer func() {
+		if err := dbStore.Close(); err != nil {
 			e.Logger.Fatal("Failed to close database connection: ", err)
 		}
-	}(sqlDB)
+	}()
+	db := dbStore.Gorm()
+
+	// Wait for the database, which may still be starting; if it does not
+	// come up the API starts degraded from the saved configuration snapshot
+	timeout, err := connectTimeout()
+	if err != nil {
+		e.Logger.Fatal(err)
+	}
+	connectCtx, cancelConnect := connectContext(timeout)
+	dbErr := store.Wait(connectCtx, dbStore)
+	cancelConnect()
+	monitor := health.NewMonitor(dbStore.Ping)
+	healthInterval := health.DefaultInterval
+	if v := os.Getenv("DB_HEALTH_INTERVAL"); v != "" {
+		if healthInterval, err = time.ParseDuration(v); err != nil || healthInterval <= 0 {
+			e.Logger.Fatal("Invalid DB_HEALTH_INTERVAL: ", v)
+		}
+	}
+
+	auditService := audit.NewAuditService(audit.NewAuditRepository(db))
+	auditHandler := audit.NewAuditHandler(auditService)
+
+	// Admin accounts; the env credentials only bootstrap the first superuser
+	adminUserRepo := adminuser.NewAdminUserRepository(db)
+	adminUserService := adminuser.NewAdminUserService(adminUserRepo)
+	adminUserHandler := adminuser.NewAdminUserHandler(adminUserService)
+	loginGuard := adminuser.NewLoginGuard(adminUserRepo, auditService, adminuser.DefaultLockoutPolicy)
+	lockoutHandler := adminuser.NewLockoutHandler(loginGuard)
+
+	refreshInterval := time.Minute
+	if v := os.Getenv("CONFIG_REFRESH_INTERVAL"); v != "" {
+		if refreshInterval, err = time.ParseDuration(v); err != nil {
+			e.Logger.Fatal("Invalid CONFIG_REFRESH_INTERVAL: ", err)
+		}
+	}
 
-	// Basic Auth for admin routes
-	adminUser := os.Getenv("ADMIN_USERNAME")
-	adminPass := os.Getenv("ADMIN_PASSWORD")
-	if adminUser == "" || adminPass == "" {
-		e.Logger.Fatal("Admin username or password not set in environment variables")
+	taxRepo := tax.NewTaxRepository(db)
+	if scheme == store.SchemeMemory {
+		taxRepo = tax.NewMemoryTaxRepository()
+	}
+	configCache := tax.NewConfigCache(taxRepo)
+	if scheme == store.SchemePostgres {
+		configCache.File = "config-snapshot.json"
+		if v := os.Getenv("CONFIG_SNAPSHOT_FILE"); v != "" {
+			configCache.File = v
+		}
 	}
 
-	taxRepo := tax.NewTaxRepository(dbStore.DB)
-	taxService := tax.NewTaxService(taxRepo)
-	taxHandler := tax.NewTaxHandler(taxService)
+	// prepare readies the database for use, at startup and again each time
+	// it comes back after being unavailable.
+	prepare := func(ctx context.Context) error {
+		// AUTO_MIGRATE=false leaves migrations to "migrate up" and refuses to
+		// start against a schema this build does not match.
+		if os.Getenv("AUTO_MIGRATE") == "false" {
+			if err := dbStore.CheckMigrations(ctx); err != nil {
+				return fmt.Errorf("database schema is not up to date: %w", err)
+			}
+		} else if err := dbStore.Migrate(ctx); err != nil {
+			return fmt.Errorf("failed to migrate database: %w", err)
+		}
+		if err := adminUserService.Bootstrap(os.Getenv("ADMIN_USERNAME"), os.Getenv("ADMIN_PASSWORD")); err != nil {
+			return fmt.Errorf("failed to bootstrap admin user: %w", err)
+		}
+		if _, err := configCache.Refresh(ctx); err != nil {
+			return fmt.Errorf("failed to load configuration: %w", err)
+		}
+		return nil
+	}
+	if dbErr == nil {
+		if err := prepare(context.Background()); err != nil {
+			e.Logger.Fatal(err)
+		}
+	} else {
+		if configCache.File == "" {
+			e.Logger.Fatal(dbErr)
+		}
+		if _, err := configCache.LoadFile(); err != nil {
+			e.Logger.Fatal(dbErr, "; cannot start degraded: ", err)
+		}
+		log.Printf("Starting degraded: %v", dbErr)
+		monitor.MarkDown()
+	}
 
-	taxGroup := e.Group("/tax")
-	taxGroup.POST("/calculations", taxHandler.PostTaxCalculation)
-	taxGroup.POST("/calculations/upload-csv", taxHandler.TaxCalculationsCSVHandler)
+	// Deadlines for tax operations; a request that outlives one gets a 503
+	timeouts := tax.DefaultTimeouts
+	for name, timeout := range map[string]*time.Duration{
+		"TAX_TIMEOUT_CALCULATION": &timeouts.Calculation,
+		"TAX_TIMEOUT_BATCH":       &timeouts.Batch,
+		"TAX_TIMEOUT_READ":        &timeouts.Read,
+		"TAX_TIMEOUT_WRITE":       &timeouts.Write,
+	} {
+		if v := os.Getenv(name); v != "" {
+			if *timeout, err = time.ParseDuration(v); err != nil {
+				e.Logger.Fatal("Invalid "+name+": ", err)
+			}
+		}
+	}
+	taxService := &tax.TaxService{Repo: taxRepo, Config: configCache, Timeouts: timeouts}
+	// The year calculations are made for when they name none
+	if v := os.Getenv("TAX_YEAR"); v != "" {
+		if taxService.TaxYear, err = strconv.Atoi(v); err != nil || taxService.TaxYear <= 0 {
+			e.Logger.Fatal("Invalid TAX_YEAR: ", v)
+		}
+	}
 
-	admin := e.Group("/admin")
-	admin.Use(middleware.BasicAuth(func(username, password string, c echo.Context) (bool, error) {
-		return username == adminUser && password == adminPass, nil
-	}))
-	{
-		admin.POST("/deductions/personal", taxHandler.SetPersonalDeduction)
-		admin.POST("/deductions/k-receipt", taxHandler.SetKreceiptDeduction)
+	// Calculations made through the API are stored with their inputs;
+	// taxpayer data is encrypted with the keys in PII_KEYFILE
+	keys, err := loadKeyring(scheme)
+	if err != nil {
+		e.Logger.Fatal("Failed to load encryption keys: ", err)
 	}
+	taxpayerRepo := taxpayer.NewTaxpayerRepository(db, keys)
+	taxpayerHandler := taxpayer.NewTaxpayerHandler(taxpayer.NewTaxpayerService(taxpayerRepo))
+	taxHandler := tax.NewTaxHandler(taxpayer.NewRecordingTaxService(taxService, taxpayerRepo, monitor))
+	recomputeHandler := taxpayer.NewRecomputeHandler(taxpayer.NewRecomputeService(taxpayerRepo, taxService))
+	subjectHandler := taxpayer.NewSubjectHandler(taxpayer.NewSubjectService(taxpayerRepo, auditService))
 
-	e.GET("/", func(c echo.Context) error {
-		return c.String(http.StatusOK, "Hello, Go Bootcamp!")
-	})
+	apiKeyService := apikey.NewAPIKeyService(apikey.NewAPIKeyRepository(db))
+	apiKeyHandler := apikey.NewAPIKeyHandler(apiKeyService)
+	apiKeyGuard := apikey.NewGuard(apiKeyService, os.Getenv("API_KEYS_REQUIRED") == "true")
+	apiKeyGuard.Health = monitor
+	// Callers without a key are limited per IP address
+	if v := os.Getenv("ANONYMOUS_RATE_PER_MINUTE"); v != "" {
+		if apiKeyGuard.Anonymous.RatePerMinute, err = strconv.Atoi(v); err != nil || apiKeyGuard.Anonymous.RatePerMinute <= 0 {
+			e.Logger.Fatal("Invalid ANONYMOUS_RATE_PER_MINUTE: ", v)
+		}
+	}
+	if v := os.Getenv("ANONYMOUS_DAILY_ROW_QUOTA"); v != "" {
+		if apiKeyGuard.Anonymous.DailyRowQuota, err = strconv.ParseInt(v, 10, 64); err != nil || apiKeyGuard.Anonymous.DailyRowQuota <= 0 {
+			e.Logger.Fatal("Invalid ANONYMOUS_DAILY_ROW_QUOTA: ", v)
+		}
+	}
+	taxHandler.RowQuota = apiKeyGuard
+
+	changeRequestTTL := approval.DefaultTTL
+	if v := os.Getenv("CHANGE_REQUEST_TTL"); v != "" {
+		if changeRequestTTL, err = time.ParseDuration(v); err != nil {
+			e.Logger.Fatal("Invalid CHANGE_REQUEST_TTL: ", err)
+		}
+	}
+
+	changeRequestService := approval.NewChangeRequestService(approval.NewChangeRequestRepository(db), taxService, changeRequestTTL)
+	changeRequestHandler := approval.NewChangeRequestHandler(changeRequestService)
+
+	// Retention periods per data class; unset classes are kept forever
+	var retentionPolicy retention.Policy
+	for name, period := range map[string]*time.Duration{
+		"RETENTION_BATCH_INPUTS":  &retentionPolicy.BatchInputs,
+		"RETENTION_BATCH_OUTPUTS": &retentionPolicy.BatchOutputs,
+		"RETENTION_CALCULATIONS":  &retentionPolicy.Calculations,
+		"RETENTION_AUDIT_EVENTS":  &retentionPolicy.AuditEvents,
+	} {
+		if *period, err = retention.ParsePeriod(os.Getenv(name)); err != nil {
+			e.Logger.Fatal("Invalid "+name+": ", err)
+		}
+	}
+	purgeInterval := retention.DefaultInterval
+	if v := os.Getenv("RETENTION_PURGE_INTERVAL"); v != "" {
+		if purgeInterval, err = time.ParseDuration(v); err != nil || purgeInterval <= 0 {
+			e.Logger.Fatal("Invalid RETENTION_PURGE_INTERVAL: ", v)
+		}
+	}
+	retentionService := retention.NewRetentionService(retention.NewRetentionRepository(db), retentionPolicy)
+	retentionHandler := retention.NewRetentionHandler(retentionService)
+
+	backgroundCtx, stopBackground := context.WithCancel(context.Background())
+	defer stopBackground()
+	go configCache.Run(backgroundCtx, refreshInterval)
+	if scheme == store.SchemePostgres {
+		go store.ListenConfigChanges(backgroundCtx, dsn, configCache.Invalidate)
+	}
+	go approval.RunExpiry(backgroundCtx, changeRequestService, time.Minute)
+	go retention.RunPurger(backgroundCtx, retentionService, purgeInterval)
+	go monitor.Run(backgroundCtx, healthInterval, prepare)
+
+	providers, err := authProviders(adminUserService, loginGuard)
+	if err != nil {
+		e.Logger.Fatal("Invalid authentication settings: ", err)
+	}
+
+	// V1_SUNSET is the date, such as 2027-06-30, the v1 calculation routes
+	// are to be removed on; it is announced in their Sunset header
+	var v1Sunset time.Time
+	if v := os.Getenv("V1_SUNSET"); v != "" {
+		if v1Sunset, err = time.Parse(time.DateOnly, v); err != nil {
+			e.Logger.Fatal("Invalid V1_SUNSET: ", err)
+		}
+	}
+
+	spec, err := openapi.NewSpec()
+	if err != nil {
+		e.Logger.Fatal("Invalid OpenAPI document: ", err)
+	}
+
+	srv := &server{
+		tax:           taxHandler,
+		taxpayer:      taxpayerHandler,
+		recompute:     recomputeHandler,
+		subject:       subjectHandler,
+		changeRequest: changeRequestHandler,
+		audit:         auditHandler,
+		retention:     retentionHandler,
+		apiKey:        apiKeyHandler,
+		adminUser:     adminUserHandler,
+		lockout:       lockoutHandler,
+
+		spec:         spec,
+		monitor:      monitor,
+		apiKeyGuard:  apiKeyGuard,
+		authenticate: auth.Middleware(providers...),
+
+		validateRequests:        os.Getenv("VALIDATE_REQUESTS") != "false",
+		taxAPIAuth:              os.Getenv("TAX_API_AUTH") == "true",
+		allowDirectConfigWrites: os.Getenv("ALLOW_DIRECT_CONFIG_WRITES") == "true",
+		v1Sunset:                v1Sunset,
+	}
+	srv.routes(e)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -74,17 +298,46 @@ func main() {
 
 	log.Printf("Starting server on %s", addr)
 
+	// Request contexts derive from requestCtx, so requests still running
+	// when the shutdown timeout expires can be cancelled.
+	requestCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	e.Server.BaseContext = func(net.Listener) context.Context { return requestCtx }
+
 	go func() {
 		if err := e.Start(addr); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			e.Logger.Fatal("shutting down the server")
 		}
 	}()
 
+	// The gRPC API serves the same calculations on its own port, to the
+	// same API keys; it asks for a key whenever the tax API authenticates
+	grpcPort := os.Getenv("GRPC_PORT")
+	if grpcPort == "" {
+		grpcPort = "9090"
+	}
+	rpcServer := taxrpc.NewServer(taxHandler.TaxService)
+	rpcServer.Keys = apiKeyGuard
+	rpcServer.RequireKey = srv.taxAPIAuth
+	grpcServer := grpc.NewServer(rpcServer.Options()...)
+	rpcServer.Register(grpcServer)
+	grpcListener, err := net.Listen("tcp", ":"+grpcPort)
+	if err != nil {
+		e.Logger.Fatal("Failed to listen for gRPC: ", err)
+	}
+	log.Printf("Starting gRPC server on :%s", grpcPort)
+	go func() {
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			e.Logger.Fatal("gRPC server failed: ", err)
+		}
+	}()
+
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 	<-shutdown
 
 	log.Println("Shutting down server...")
+	stopBackground()
 	startTime := time.Now()
 	log.Println("Sleeping for 3 seconds...")
 	time.Sleep(3 * time.Second)
@@ -94,9 +347,61 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
+	// Calls still running when the timeout expires are cut off
+	grpcStopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(grpcStopped)
+	}()
 	if err := e.Shutdown(ctx); err != nil {
-		e.Logger.Fatal(err)
+		cancelRequests()
+		log.Printf("Cancelled requests still running at shutdown: %v", err)
+	}
+	select {
+	case <-grpcStopped:
+	case <-ctx.Done():
+		grpcServer.Stop()
+		log.Println("Cancelled gRPC calls still running at shutdown")
 	}
 
 	fmt.Println("Server shutdown complete")
 }
+
+// authProviders returns the bearer token provider when JWT_JWKS is set,
+// followed by Basic Auth unless BASIC_AUTH_ENABLED is "false".
+func authProviders(authenticator auth.Authenticator, guard auth.LoginGuard) ([]auth.Provider, error) {
+	var providers []auth.Provider
+
+	if location := os.Getenv("JWT_JWKS"); location != "" {
+		keys := auth.NewJWKSSource(location)
+		provider, err := auth.NewJWTProvider(keys, os.Getenv("JWT_ISSUER"), os.Getenv("JWT_AUDIENCE"))
+		if err != nil {
+			return nil, fmt.Errorf("JWT_JWKS needs JWT_ISSUER and JWT_AUDIENCE: %w", err)
+		}
+		if err := keys.Load(); err != nil {
+			return nil, err
+		}
+		if claim := os.Getenv("JWT_USERNAME_CLAIM"); claim != "" {
+			provider.UsernameClaim = claim
+		}
+		if claim := os.Getenv("JWT_ROLE_CLAIM"); claim != "" {
+			provider.RoleClaim = claim
+		}
+		if mapping := os.Getenv("JWT_ROLE_MAP"); mapping != "" {
+			roles, err := auth.ParseRoleMapping(mapping)
+			if err != nil {
+				return nil, err
+			}
+			provider.RoleMapping = roles
+		}
+		providers = append(providers, provider)
+	}
+
+	if os.Getenv("BASIC_AUTH_ENABLED") != "false" {
+		providers = append(providers, auth.NewBasicProvider(authenticator, guard))
+	}
+	if len(providers) == 0 {
+		return nil, errors.New("no authentication provider enabled")
+	}
+	return providers, nil
+}
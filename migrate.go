@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/store"
+	"io"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "usage: migrate up | down [steps] | status"
+
+// runMigrate implements the "migrate" subcommand so schema changes can be
+// applied separately from starting the server.
+func runMigrate(args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	dsn := os.Getenv("DATABASE_URL")
+	if dsn == "" {
+		return errors.New("DATABASE_URL not set in environment variables")
+	}
+	opened, err := openStore(dsn)
+	if err != nil {
+		return err
+	}
+	defer opened.Close()
+
+	ctx := context.Background()
+	if args[0] == "up" {
+		return opened.Migrate(ctx)
+	}
+	dbStore, ok := opened.(*store.PostgresStore)
+	if !ok {
+		return errors.New("only Postgres databases have migration history")
+	}
+	switch args[0] {
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return errors.New("steps must be a positive integer")
+			}
+		}
+		return dbStore.RollbackMigrations(ctx, steps)
+	case "status":
+		statuses, err := dbStore.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	}
+	return errors.New(migrateUsage)
+}
@@ -0,0 +1,118 @@
+package adminuser
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/problem"
+	"net/http"
+)
+
+type AdminUserHandler struct {
+	AdminUserService AdminUserServices
+}
+
+func NewAdminUserHandler(service AdminUserServices) *AdminUserHandler {
+	return &AdminUserHandler{AdminUserService: service}
+}
+
+func (h *AdminUserHandler) ListUsers(c echo.Context) error {
+	users, err := h.AdminUserService.ListUsers()
+	if err != nil {
+		return problem.Internal(c, "Failed to list admin users", err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"users": users})
+}
+
+func (h *AdminUserHandler) CreateUser(c echo.Context) error {
+	var req model.CreateAdminUserRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	user, err := h.AdminUserService.CreateUser(req)
+	if err != nil {
+		return userError(c, "Failed to create admin user", err)
+	}
+
+	return c.JSON(http.StatusCreated, user)
+}
+
+func (h *AdminUserHandler) DisableUser(c echo.Context) error {
+	user, err := h.AdminUserService.DisableUser(c.Param("username"))
+	if err != nil {
+		return userError(c, "Failed to disable admin user", err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+// RotatePassword lets superusers reset any password and every other user
+// change only their own.
+func (h *AdminUserHandler) RotatePassword(c echo.Context) error {
+	username := c.Param("username")
+	principal, _ := auth.PrincipalFrom(c)
+	if principal.Username != username && principal.Role != auth.RoleSuperuser {
+		return problem.Respond(c, http.StatusForbidden, "forbidden", "Only superusers can change another user's password")
+	}
+
+	var req model.RotatePasswordRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	user, err := h.AdminUserService.RotatePassword(username, req.Password)
+	if err != nil {
+		return userError(c, "Failed to rotate password", err)
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+func userError(c echo.Context, message string, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidUser):
+		return problem.Respond(c, http.StatusBadRequest, "invalid_user", err.Error())
+	case errors.Is(err, ErrUserNotFound):
+		return problem.Respond(c, http.StatusNotFound, "user_not_found", err.Error())
+	case errors.Is(err, ErrUserExists):
+		return problem.Respond(c, http.StatusConflict, "user_exists", err.Error())
+	case errors.Is(err, ErrLastSuperuser):
+		return problem.Respond(c, http.StatusConflict, "last_superuser", err.Error())
+	}
+	return problem.Internal(c, message, err)
+}
+
+type LockoutHandler struct {
+	LoginGuard LoginGuardServices
+}
+
+func NewLockoutHandler(guard LoginGuardServices) *LockoutHandler {
+	return &LockoutHandler{LoginGuard: guard}
+}
+
+func (h *LockoutHandler) ListLockouts(c echo.Context) error {
+	lockouts, err := h.LoginGuard.ListLockouts()
+	if err != nil {
+		return problem.Internal(c, "Failed to list lockouts", err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"lockouts": lockouts})
+}
+
+func (h *LockoutHandler) Unlock(c echo.Context) error {
+	var req model.UnlockRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	cleared, err := h.LoginGuard.Unlock(principal.Username, req)
+	if err != nil {
+		return userError(c, "Failed to unlock login", err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"cleared": cleared})
+}
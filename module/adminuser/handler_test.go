@@ -0,0 +1,194 @@
+package adminuser
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type MockAdminUserService struct {
+	mock.Mock
+}
+
+func (m *MockAdminUserService) Authenticate(username, password string) (auth.Principal, error) {
+	args := m.Called(username, password)
+	return args.Get(0).(auth.Principal), args.Error(1)
+}
+
+func (m *MockAdminUserService) Bootstrap(username, password string) error {
+	args := m.Called(username, password)
+	return args.Error(0)
+}
+
+func (m *MockAdminUserService) ListUsers() ([]model.AdminUser, error) {
+	args := m.Called()
+	return args.Get(0).([]model.AdminUser), args.Error(1)
+}
+
+func (m *MockAdminUserService) CreateUser(req model.CreateAdminUserRequest) (model.AdminUser, error) {
+	args := m.Called(req)
+	return args.Get(0).(model.AdminUser), args.Error(1)
+}
+
+func (m *MockAdminUserService) DisableUser(username string) (model.AdminUser, error) {
+	args := m.Called(username)
+	return args.Get(0).(model.AdminUser), args.Error(1)
+}
+
+func (m *MockAdminUserService) RotatePassword(username, password string) (model.AdminUser, error) {
+	args := m.Called(username, password)
+	return args.Get(0).(model.AdminUser), args.Error(1)
+}
+
+func TestAdminUserHandler_CreateUser(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"username":"bob","password":"long-enough","role":"viewer"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	mockService := new(MockAdminUserService)
+	mockService.On("CreateUser", model.CreateAdminUserRequest{Username: "bob", Password: "long-enough", Role: "viewer"}).
+		Return(model.AdminUser{Username: "bob", Role: "viewer"}, nil)
+
+	h := &AdminUserHandler{AdminUserService: mockService}
+
+	if assert.NoError(t, h.CreateUser(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.NotContains(t, rec.Body.String(), "long-enough")
+	}
+}
+
+func TestAdminUserHandler_CreateUser_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"Invalid", ErrInvalidUser, http.StatusBadRequest},
+		{"Exists", ErrUserExists, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"username":"bob"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			mockService := new(MockAdminUserService)
+			mockService.On("CreateUser", mock.Anything).Return(model.AdminUser{}, tt.err)
+
+			h := &AdminUserHandler{AdminUserService: mockService}
+
+			if assert.NoError(t, h.CreateUser(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAdminUserHandler_DisableUser_LastSuperuser(t *testing.T) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+	c.SetParamNames("username")
+	c.SetParamValues("root")
+
+	mockService := new(MockAdminUserService)
+	mockService.On("DisableUser", "root").Return(model.AdminUser{}, ErrLastSuperuser)
+
+	h := &AdminUserHandler{AdminUserService: mockService}
+
+	if assert.NoError(t, h.DisableUser(c)) {
+		assert.Equal(t, http.StatusConflict, rec.Code)
+	}
+}
+
+func TestAdminUserHandler_RotatePassword(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal auth.Principal
+		want      int
+	}{
+		{"Self", auth.Principal{Username: "bob", Role: auth.RoleViewer}, http.StatusOK},
+		{"Superuser", auth.Principal{Username: "root", Role: auth.RoleSuperuser}, http.StatusOK},
+		{"Someone Else", auth.Principal{Username: "eve", Role: auth.RoleEditor}, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"password":"new-password"}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("username")
+			c.SetParamValues("bob")
+			auth.SetPrincipal(c, tt.principal)
+
+			mockService := new(MockAdminUserService)
+			mockService.On("RotatePassword", "bob", "new-password").Return(model.AdminUser{Username: "bob"}, nil)
+
+			h := &AdminUserHandler{AdminUserService: mockService}
+
+			if assert.NoError(t, h.RotatePassword(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+type MockLoginGuard struct {
+	mock.Mock
+}
+
+func (m *MockLoginGuard) Check(username, ip string) error {
+	args := m.Called(username, ip)
+	return args.Error(0)
+}
+
+func (m *MockLoginGuard) RecordFailure(username, ip string) error {
+	args := m.Called(username, ip)
+	return args.Error(0)
+}
+
+func (m *MockLoginGuard) RecordSuccess(username string) error {
+	args := m.Called(username)
+	return args.Error(0)
+}
+
+func (m *MockLoginGuard) ListLockouts() ([]model.LoginLockout, error) {
+	args := m.Called()
+	return args.Get(0).([]model.LoginLockout), args.Error(1)
+}
+
+func (m *MockLoginGuard) Unlock(actor string, req model.UnlockRequest) (int64, error) {
+	args := m.Called(actor, req)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func TestLockoutHandler_Unlock(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/admin/lockouts/unlock", strings.NewReader(`{"username":"alice"}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	auth.SetPrincipal(c, auth.Principal{Username: "root", Role: auth.RoleSuperuser})
+
+	mockGuard := new(MockLoginGuard)
+	mockGuard.On("Unlock", "root", model.UnlockRequest{Username: "alice"}).Return(int64(1), nil)
+	h := &LockoutHandler{LoginGuard: mockGuard}
+
+	if assert.NoError(t, h.Unlock(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"cleared":1}`, rec.Body.String())
+	}
+}
@@ -0,0 +1,176 @@
+package adminuser
+
+import (
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/audit"
+	"github.com/pphee/assessment-tax/module/auth"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	userPrefix = "user:"
+	ipPrefix   = "ip:"
+)
+
+// LockoutPolicy locks a subject once it reaches its threshold of failures.
+// Each further failure doubles the lockout, up to MaxLockout. Failures are
+// forgotten after ResetAfter without another one.
+type LockoutPolicy struct {
+	UserThreshold int
+	IPThreshold   int
+	BaseLockout   time.Duration
+	MaxLockout    time.Duration
+	ResetAfter    time.Duration
+}
+
+var DefaultLockoutPolicy = LockoutPolicy{
+	UserThreshold: 5,
+	IPThreshold:   20,
+	BaseLockout:   time.Minute,
+	MaxLockout:    time.Hour,
+	ResetAfter:    15 * time.Minute,
+}
+
+func (p LockoutPolicy) lockout(failures, threshold int) time.Duration {
+	if failures < threshold {
+		return 0
+	}
+	lockout := p.BaseLockout
+	for i := threshold; i < failures && lockout < p.MaxLockout; i++ {
+		lockout *= 2
+	}
+	if lockout > p.MaxLockout {
+		lockout = p.MaxLockout
+	}
+	return lockout
+}
+
+type LoginGuardServices interface {
+	auth.LoginGuard
+	ListLockouts() ([]model.LoginLockout, error)
+	Unlock(actor string, req model.UnlockRequest) (int64, error)
+}
+
+type LoginGuard struct {
+	Repo   AdminUserRepositories
+	Audit  audit.AuditServices
+	Policy LockoutPolicy
+	now    func() time.Time
+}
+
+func NewLoginGuard(repo AdminUserRepositories, auditService audit.AuditServices, policy LockoutPolicy) LoginGuardServices {
+	return &LoginGuard{Repo: repo, Audit: auditService, Policy: policy, now: time.Now}
+}
+
+func (guard *LoginGuard) Check(username, ip string) error {
+	failures, err := guard.Repo.GetLoginFailures(userPrefix+username, ipPrefix+ip)
+	if err != nil {
+		return fmt.Errorf("failed to check login failures: %w", err)
+	}
+
+	now := guard.now()
+	var until time.Time
+	for _, failure := range failures {
+		if failure.LockedUntil != nil && failure.LockedUntil.After(now) && failure.LockedUntil.After(until) {
+			until = *failure.LockedUntil
+		}
+	}
+	if !until.IsZero() {
+		return &auth.LockoutError{Until: until}
+	}
+	return nil
+}
+
+func (guard *LoginGuard) RecordFailure(username, ip string) error {
+	if err := guard.recordFailure(userPrefix+username, guard.Policy.UserThreshold); err != nil {
+		return err
+	}
+	return guard.recordFailure(ipPrefix+ip, guard.Policy.IPThreshold)
+}
+
+func (guard *LoginGuard) recordFailure(subject string, threshold int) error {
+	now := guard.now()
+	failure, err := guard.Repo.RecordLoginFailure(subject, now, now.Add(-guard.Policy.ResetAfter))
+	if err != nil {
+		return fmt.Errorf("failed to record login failure: %w", err)
+	}
+
+	lockout := guard.Policy.lockout(failure.Failures, threshold)
+	if lockout == 0 {
+		return nil
+	}
+	until := now.Add(lockout)
+	if err := guard.Repo.LockLogin(subject, until); err != nil {
+		return fmt.Errorf("failed to lock login: %w", err)
+	}
+
+	log.Printf("login locked for %s after %d failures until %s", subject, failure.Failures, until.Format(time.RFC3339))
+	entity, id := splitSubject(subject)
+	detail := map[string]interface{}{"failures": failure.Failures, "lockedUntil": until}
+	if err := guard.Audit.Record("system", "login.locked", entity, id, detail); err != nil {
+		log.Printf("failed to audit lockout of %s: %v", subject, err)
+	}
+	return nil
+}
+
+// RecordSuccess clears the username's failures. The address keeps its
+// count, so one valid account cannot be used to reset it.
+func (guard *LoginGuard) RecordSuccess(username string) error {
+	_, err := guard.Repo.ClearLoginFailures(userPrefix + username)
+	return err
+}
+
+func splitSubject(subject string) (entity, id string) {
+	if strings.HasPrefix(subject, ipPrefix) {
+		return "source_ip", strings.TrimPrefix(subject, ipPrefix)
+	}
+	return "admin_user", strings.TrimPrefix(subject, userPrefix)
+}
+
+func (guard *LoginGuard) ListLockouts() ([]model.LoginLockout, error) {
+	failures, err := guard.Repo.ListLockouts(guard.now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list lockouts: %w", err)
+	}
+
+	result := make([]model.LoginLockout, len(failures))
+	for i, failure := range failures {
+		result[i] = model.LoginLockout{Failures: failure.Failures, LockedUntil: *failure.LockedUntil}
+		if entity, id := splitSubject(failure.Subject); entity == "source_ip" {
+			result[i].IP = id
+		} else {
+			result[i].Username = id
+		}
+	}
+	return result, nil
+}
+
+// Unlock clears failures for the given username and/or address and returns
+// how many lockout records were removed.
+func (guard *LoginGuard) Unlock(actor string, req model.UnlockRequest) (int64, error) {
+	var subjects []string
+	if req.Username != "" {
+		subjects = append(subjects, userPrefix+req.Username)
+	}
+	if req.IP != "" {
+		subjects = append(subjects, ipPrefix+req.IP)
+	}
+	if len(subjects) == 0 {
+		return 0, fmt.Errorf("%w: username or ip is required", ErrInvalidUser)
+	}
+
+	cleared, err := guard.Repo.ClearLoginFailures(subjects...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unlock login: %w", err)
+	}
+	for _, subject := range subjects {
+		entity, id := splitSubject(subject)
+		if err := guard.Audit.Record(actor, "login.unlocked", entity, id, nil); err != nil {
+			log.Printf("failed to audit unlock of %s: %v", subject, err)
+		}
+	}
+	return cleared, nil
+}
@@ -0,0 +1,140 @@
+package adminuser
+
+import (
+	"errors"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+	"time"
+)
+
+type MockAuditService struct {
+	mock.Mock
+}
+
+func (m *MockAuditService) Record(actor, action, entity, entityID string, detail interface{}) error {
+	args := m.Called(actor, action, entity, entityID, detail)
+	return args.Error(0)
+}
+
+func (m *MockAuditService) ListEvents(filter model.AuditFilter) ([]model.AuditEvent, error) {
+	args := m.Called(filter)
+	return args.Get(0).([]model.AuditEvent), args.Error(1)
+}
+
+var testNow = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func newTestGuard(repo *MockRepo, auditService *MockAuditService) *LoginGuard {
+	return &LoginGuard{Repo: repo, Audit: auditService, Policy: DefaultLockoutPolicy, now: func() time.Time { return testNow }}
+}
+
+func TestLockoutPolicy(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{4, 0},
+		{5, time.Minute},
+		{6, 2 * time.Minute},
+		{8, 8 * time.Minute},
+		{20, time.Hour},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, DefaultLockoutPolicy.lockout(tt.failures, 5), "failures: %d", tt.failures)
+	}
+}
+
+func TestLoginGuard_Check(t *testing.T) {
+	soon, later, past := testNow.Add(time.Minute), testNow.Add(time.Hour), testNow.Add(-time.Minute)
+	tests := []struct {
+		name     string
+		failures []modelgorm.LoginFailureGorm
+		want     *time.Time
+	}{
+		{"Clean", nil, nil},
+		{"Expired Lock", []modelgorm.LoginFailureGorm{{Subject: "user:alice", LockedUntil: &past}}, nil},
+		{"Latest Lock Wins", []modelgorm.LoginFailureGorm{{Subject: "user:alice", LockedUntil: &soon}, {Subject: "ip:10.0.0.1", LockedUntil: &later}}, &later},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			mockRepo.On("GetLoginFailures", []string{"user:alice", "ip:10.0.0.1"}).Return(tt.failures, nil)
+
+			err := newTestGuard(mockRepo, new(MockAuditService)).Check("alice", "10.0.0.1")
+
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			var lockout *auth.LockoutError
+			if assert.True(t, errors.As(err, &lockout)) {
+				assert.Equal(t, *tt.want, lockout.Until)
+			}
+		})
+	}
+}
+
+func TestLoginGuard_RecordFailure_Locks(t *testing.T) {
+	resetBefore := testNow.Add(-DefaultLockoutPolicy.ResetAfter)
+	mockRepo := new(MockRepo)
+	mockRepo.On("RecordLoginFailure", "user:alice", testNow, resetBefore).Return(modelgorm.LoginFailureGorm{Subject: "user:alice", Failures: 6}, nil)
+	mockRepo.On("RecordLoginFailure", "ip:10.0.0.1", testNow, resetBefore).Return(modelgorm.LoginFailureGorm{Subject: "ip:10.0.0.1", Failures: 6}, nil)
+	mockRepo.On("LockLogin", "user:alice", testNow.Add(2*time.Minute)).Return(nil)
+	mockAudit := new(MockAuditService)
+	mockAudit.On("Record", "system", "login.locked", "admin_user", "alice", mock.Anything).Return(nil)
+
+	err := newTestGuard(mockRepo, mockAudit).RecordFailure("alice", "10.0.0.1")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+	mockAudit.AssertExpectations(t)
+	mockRepo.AssertNotCalled(t, "LockLogin", "ip:10.0.0.1", mock.Anything)
+}
+
+func TestLoginGuard_RecordSuccess(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("ClearLoginFailures", []string{"user:alice"}).Return(int64(1), nil)
+
+	assert.NoError(t, newTestGuard(mockRepo, new(MockAuditService)).RecordSuccess("alice"))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestLoginGuard_Unlock(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("ClearLoginFailures", []string{"user:alice", "ip:10.0.0.1"}).Return(int64(2), nil)
+	mockAudit := new(MockAuditService)
+	mockAudit.On("Record", "root", "login.unlocked", "admin_user", "alice", nil).Return(nil)
+	mockAudit.On("Record", "root", "login.unlocked", "source_ip", "10.0.0.1", nil).Return(nil)
+	guard := newTestGuard(mockRepo, mockAudit)
+
+	cleared, err := guard.Unlock("root", model.UnlockRequest{Username: "alice", IP: "10.0.0.1"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), cleared)
+	mockAudit.AssertExpectations(t)
+
+	_, err = guard.Unlock("root", model.UnlockRequest{})
+	assert.ErrorIs(t, err, ErrInvalidUser)
+}
+
+func TestLoginGuard_ListLockouts(t *testing.T) {
+	until := testNow.Add(time.Minute)
+	mockRepo := new(MockRepo)
+	mockRepo.On("ListLockouts", testNow).Return([]modelgorm.LoginFailureGorm{
+		{Subject: "user:alice", Failures: 5, LockedUntil: &until},
+		{Subject: "ip:10.0.0.1", Failures: 20, LockedUntil: &until},
+	}, nil)
+
+	lockouts, err := newTestGuard(mockRepo, new(MockAuditService)).ListLockouts()
+
+	assert.NoError(t, err)
+	assert.Equal(t, []model.LoginLockout{
+		{Username: "alice", Failures: 5, LockedUntil: until},
+		{IP: "10.0.0.1", Failures: 20, LockedUntil: until},
+	}, lockouts)
+}
@@ -0,0 +1,108 @@
+package adminuser
+
+import (
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type AdminUserRepositories interface {
+	CountUsers() (int64, error)
+	GetUser(username string) (modelgorm.AdminUserGorm, error)
+	ListUsers() ([]modelgorm.AdminUserGorm, error)
+	CreateUser(user *modelgorm.AdminUserGorm) error
+	UpdateUser(user *modelgorm.AdminUserGorm) error
+	CountActiveSuperusers() (int64, error)
+	GetLoginFailures(subjects ...string) ([]modelgorm.LoginFailureGorm, error)
+	RecordLoginFailure(subject string, at, resetBefore time.Time) (modelgorm.LoginFailureGorm, error)
+	LockLogin(subject string, until time.Time) error
+	ClearLoginFailures(subjects ...string) (int64, error)
+	ListLockouts(now time.Time) ([]modelgorm.LoginFailureGorm, error)
+}
+
+type AdminUserRepository struct {
+	DB *gorm.DB
+}
+
+func NewAdminUserRepository(db *gorm.DB) AdminUserRepositories {
+	return &AdminUserRepository{DB: db}
+}
+
+func (repo *AdminUserRepository) CountUsers() (int64, error) {
+	var count int64
+	err := repo.DB.Model(&modelgorm.AdminUserGorm{}).Count(&count).Error
+	return count, err
+}
+
+func (repo *AdminUserRepository) GetUser(username string) (modelgorm.AdminUserGorm, error) {
+	var user modelgorm.AdminUserGorm
+	err := repo.DB.Where("username = ?", username).First(&user).Error
+	return user, err
+}
+
+func (repo *AdminUserRepository) ListUsers() ([]modelgorm.AdminUserGorm, error) {
+	var users []modelgorm.AdminUserGorm
+	if err := repo.DB.Order("username").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (repo *AdminUserRepository) CreateUser(user *modelgorm.AdminUserGorm) error {
+	return repo.DB.Create(user).Error
+}
+
+func (repo *AdminUserRepository) UpdateUser(user *modelgorm.AdminUserGorm) error {
+	return repo.DB.Save(user).Error
+}
+
+func (repo *AdminUserRepository) CountActiveSuperusers() (int64, error) {
+	var count int64
+	err := repo.DB.Model(&modelgorm.AdminUserGorm{}).
+		Where("role = ? AND disabled = ?", "superuser", false).
+		Count(&count).Error
+	return count, err
+}
+
+func (repo *AdminUserRepository) GetLoginFailures(subjects ...string) ([]modelgorm.LoginFailureGorm, error) {
+	var failures []modelgorm.LoginFailureGorm
+	if err := repo.DB.Where("subject IN ?", subjects).Find(&failures).Error; err != nil {
+		return nil, err
+	}
+	return failures, nil
+}
+
+// RecordLoginFailure counts a failure for subject and returns the updated row.
+// Failures older than resetBefore are forgotten first.
+func (repo *AdminUserRepository) RecordLoginFailure(subject string, at, resetBefore time.Time) (modelgorm.LoginFailureGorm, error) {
+	failure := modelgorm.LoginFailureGorm{Subject: subject, Failures: 1, LastFailureAt: at}
+	err := repo.DB.Clauses(
+		clause.OnConflict{
+			Columns: []clause.Column{{Name: "subject"}},
+			DoUpdates: clause.Assignments(map[string]interface{}{
+				"failures":        gorm.Expr("CASE WHEN login_failure_gorms.last_failure_at < ? THEN 1 ELSE login_failure_gorms.failures + 1 END", resetBefore),
+				"last_failure_at": at,
+			}),
+		},
+		clause.Returning{},
+	).Create(&failure).Error
+	return failure, err
+}
+
+func (repo *AdminUserRepository) LockLogin(subject string, until time.Time) error {
+	return repo.DB.Model(&modelgorm.LoginFailureGorm{}).Where("subject = ?", subject).Update("locked_until", until).Error
+}
+
+func (repo *AdminUserRepository) ClearLoginFailures(subjects ...string) (int64, error) {
+	result := repo.DB.Where("subject IN ?", subjects).Delete(&modelgorm.LoginFailureGorm{})
+	return result.RowsAffected, result.Error
+}
+
+func (repo *AdminUserRepository) ListLockouts(now time.Time) ([]modelgorm.LoginFailureGorm, error) {
+	var lockouts []modelgorm.LoginFailureGorm
+	if err := repo.DB.Where("locked_until > ?", now).Order("locked_until DESC").Find(&lockouts).Error; err != nil {
+		return nil, err
+	}
+	return lockouts, nil
+}
@@ -0,0 +1,104 @@
+package adminuser
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func setupMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+
+	dialector := postgres.New(postgres.Config{
+		Conn:                 mockDB,
+		PreferSimpleProtocol: true,
+	})
+	gormDB, err := gorm.Open(dialector, &gorm.Config{})
+	require.NoError(t, err)
+
+	return gormDB, mock
+}
+
+func TestGetUser(t *testing.T) {
+	db, mock := setupMockDB(t)
+	repo := NewAdminUserRepository(db)
+
+	mock.ExpectQuery(`SELECT \* FROM "admin_user_gorms" WHERE username = \$1 ORDER BY "admin_user_gorms"\."id" LIMIT \$2`).
+		WithArgs("alice", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(1, "alice", "editor"))
+
+	user, err := repo.GetUser("alice")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "editor", user.Role)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetUser_NotFound(t *testing.T) {
+	db, mock := setupMockDB(t)
+	repo := NewAdminUserRepository(db)
+
+	mock.ExpectQuery(`SELECT \* FROM "admin_user_gorms" WHERE username = \$1`).
+		WithArgs("ghost", 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := repo.GetUser("ghost")
+
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func TestCountActiveSuperusers(t *testing.T) {
+	db, mock := setupMockDB(t)
+	repo := NewAdminUserRepository(db)
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "admin_user_gorms" WHERE role = \$1 AND disabled = \$2`).
+		WithArgs("superuser", false).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	count, err := repo.CountActiveSuperusers()
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), count)
+}
+
+func TestCreateUser_Repository(t *testing.T) {
+	db, mock := setupMockDB(t)
+	repo := NewAdminUserRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "admin_user_gorms"`).
+		WithArgs("bob", "hash", "viewer", false, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+	mock.ExpectCommit()
+
+	user := modelgorm.AdminUserGorm{Username: "bob", PasswordHash: "hash", Role: "viewer"}
+	err := repo.CreateUser(&user)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(3), user.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRecordLoginFailure(t *testing.T) {
+	db, mock := setupMockDB(t)
+	repo := NewAdminUserRepository(db)
+	now := time.Now()
+	resetBefore := now.Add(-15 * time.Minute)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "login_failure_gorms" .* ON CONFLICT \("subject"\) DO UPDATE SET "failures"=CASE WHEN login_failure_gorms.last_failure_at < \$\d+ THEN 1 ELSE login_failure_gorms.failures \+ 1 END,"last_failure_at"=\$\d+ RETURNING \*`).
+		WillReturnRows(sqlmock.NewRows([]string{"subject", "failures", "last_failure_at"}).AddRow("user:alice", 3, now))
+	mock.ExpectCommit()
+
+	failure, err := repo.RecordLoginFailure("user:alice", now, resetBefore)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, failure.Failures)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
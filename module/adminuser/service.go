@@ -0,0 +1,226 @@
+package adminuser
+
+import (
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/store/model"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"regexp"
+	"sync"
+	"time"
+)
+
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72 // bcrypt rejects longer passwords
+)
+
+var (
+	ErrUserNotFound  = errors.New("admin user not found")
+	ErrUserExists    = errors.New("admin user already exists")
+	ErrInvalidUser   = errors.New("invalid admin user")
+	ErrLastSuperuser = errors.New("cannot disable the last active superuser")
+	ErrNoAdminUsers  = errors.New("no admin users exist and no bootstrap credentials were given")
+)
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9._-]{3,100}$`)
+
+// dummyHash is compared against when a user does not exist, so that unknown
+// usernames take as long to reject as wrong passwords.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+	return hash
+})
+
+type AdminUserServices interface {
+	auth.Authenticator
+	Bootstrap(username, password string) error
+	ListUsers() ([]model.AdminUser, error)
+	CreateUser(req model.CreateAdminUserRequest) (model.AdminUser, error)
+	DisableUser(username string) (model.AdminUser, error)
+	RotatePassword(username, password string) (model.AdminUser, error)
+}
+
+type AdminUserService struct {
+	Repo AdminUserRepositories
+}
+
+func NewAdminUserService(repo AdminUserRepositories) AdminUserServices {
+	return &AdminUserService{Repo: repo}
+}
+
+func toAdminUser(user modelgorm.AdminUserGorm) model.AdminUser {
+	return model.AdminUser{
+		Username:          user.Username,
+		Role:              user.Role,
+		Disabled:          user.Disabled,
+		PasswordChangedAt: user.PasswordChangedAt,
+		CreatedAt:         user.CreatedAt,
+	}
+}
+
+func (service *AdminUserService) Authenticate(username, password string) (auth.Principal, error) {
+	user, err := service.Repo.GetUser(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return auth.Principal{}, auth.ErrInvalidCredentials
+	}
+	if err != nil {
+		return auth.Principal{}, fmt.Errorf("failed to look up admin user: %w", err)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return auth.Principal{}, auth.ErrInvalidCredentials
+	}
+	if user.Disabled {
+		return auth.Principal{}, auth.ErrInvalidCredentials
+	}
+
+	return auth.Principal{Username: user.Username, Role: auth.Role(user.Role)}, nil
+}
+
+// Bootstrap creates the first superuser from the given credentials when no
+// admin users exist yet. Once any user exists it does nothing.
+func (service *AdminUserService) Bootstrap(username, password string) error {
+	count, err := service.Repo.CountUsers()
+	if err != nil {
+		return fmt.Errorf("failed to count admin users: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+	if username == "" || password == "" {
+		return ErrNoAdminUsers
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	now := time.Now()
+	user := modelgorm.AdminUserGorm{
+		Username:          username,
+		PasswordHash:      string(hash),
+		Role:              string(auth.RoleSuperuser),
+		PasswordChangedAt: now,
+	}
+	if err := service.Repo.CreateUser(&user); err != nil {
+		return fmt.Errorf("failed to create bootstrap superuser: %w", err)
+	}
+	return nil
+}
+
+func (service *AdminUserService) ListUsers() ([]model.AdminUser, error) {
+	users, err := service.Repo.ListUsers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list admin users: %w", err)
+	}
+
+	result := make([]model.AdminUser, len(users))
+	for i, user := range users {
+		result[i] = toAdminUser(user)
+	}
+	return result, nil
+}
+
+func (service *AdminUserService) CreateUser(req model.CreateAdminUserRequest) (model.AdminUser, error) {
+	if !usernamePattern.MatchString(req.Username) {
+		return model.AdminUser{}, fmt.Errorf("%w: username must be 3-100 letters, digits, '.', '_' or '-'", ErrInvalidUser)
+	}
+	if !auth.Role(req.Role).Valid() {
+		return model.AdminUser{}, fmt.Errorf("%w: unknown role %q", ErrInvalidUser, req.Role)
+	}
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		return model.AdminUser{}, err
+	}
+
+	_, err = service.Repo.GetUser(req.Username)
+	if err == nil {
+		return model.AdminUser{}, fmt.Errorf("%w: %s", ErrUserExists, req.Username)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.AdminUser{}, fmt.Errorf("failed to look up admin user: %w", err)
+	}
+
+	user := modelgorm.AdminUserGorm{
+		Username:          req.Username,
+		PasswordHash:      hash,
+		Role:              req.Role,
+		PasswordChangedAt: time.Now(),
+	}
+	if err := service.Repo.CreateUser(&user); err != nil {
+		return model.AdminUser{}, fmt.Errorf("failed to create admin user: %w", err)
+	}
+	return toAdminUser(user), nil
+}
+
+func (service *AdminUserService) DisableUser(username string) (model.AdminUser, error) {
+	user, err := service.getUser(username)
+	if err != nil {
+		return model.AdminUser{}, err
+	}
+	if user.Disabled {
+		return toAdminUser(user), nil
+	}
+
+	if user.Role == string(auth.RoleSuperuser) {
+		count, err := service.Repo.CountActiveSuperusers()
+		if err != nil {
+			return model.AdminUser{}, fmt.Errorf("failed to count superusers: %w", err)
+		}
+		if count <= 1 {
+			return model.AdminUser{}, ErrLastSuperuser
+		}
+	}
+
+	user.Disabled = true
+	if err := service.Repo.UpdateUser(&user); err != nil {
+		return model.AdminUser{}, fmt.Errorf("failed to disable admin user: %w", err)
+	}
+	return toAdminUser(user), nil
+}
+
+func (service *AdminUserService) RotatePassword(username, password string) (model.AdminUser, error) {
+	user, err := service.getUser(username)
+	if err != nil {
+		return model.AdminUser{}, err
+	}
+	hash, err := hashPassword(password)
+	if err != nil {
+		return model.AdminUser{}, err
+	}
+
+	user.PasswordHash = hash
+	user.PasswordChangedAt = time.Now()
+	if err := service.Repo.UpdateUser(&user); err != nil {
+		return model.AdminUser{}, fmt.Errorf("failed to rotate password: %w", err)
+	}
+	return toAdminUser(user), nil
+}
+
+func (service *AdminUserService) getUser(username string) (modelgorm.AdminUserGorm, error) {
+	user, err := service.Repo.GetUser(username)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return modelgorm.AdminUserGorm{}, fmt.Errorf("%w: %s", ErrUserNotFound, username)
+	}
+	if err != nil {
+		return modelgorm.AdminUserGorm{}, fmt.Errorf("failed to look up admin user: %w", err)
+	}
+	return user, nil
+}
+
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", fmt.Errorf("%w: password must be %d-%d characters", ErrInvalidUser, minPasswordLength, maxPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
@@ -0,0 +1,254 @@
+package adminuser
+
+import (
+	"errors"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) CountUsers() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) GetUser(username string) (modelgorm.AdminUserGorm, error) {
+	args := m.Called(username)
+	return args.Get(0).(modelgorm.AdminUserGorm), args.Error(1)
+}
+
+func (m *MockRepo) ListUsers() ([]modelgorm.AdminUserGorm, error) {
+	args := m.Called()
+	return args.Get(0).([]modelgorm.AdminUserGorm), args.Error(1)
+}
+
+func (m *MockRepo) CreateUser(user *modelgorm.AdminUserGorm) error {
+	args := m.Called(user)
+	return args.Error(0)
+}
+
+func (m *MockRepo) UpdateUser(user *modelgorm.AdminUserGorm) error {
+	args := m.Called(user)
+	return args.Error(0)
+}
+
+func (m *MockRepo) CountActiveSuperusers() (int64, error) {
+	args := m.Called()
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) GetLoginFailures(subjects ...string) ([]modelgorm.LoginFailureGorm, error) {
+	args := m.Called(subjects)
+	return args.Get(0).([]modelgorm.LoginFailureGorm), args.Error(1)
+}
+
+func (m *MockRepo) RecordLoginFailure(subject string, at, resetBefore time.Time) (modelgorm.LoginFailureGorm, error) {
+	args := m.Called(subject, at, resetBefore)
+	return args.Get(0).(modelgorm.LoginFailureGorm), args.Error(1)
+}
+
+func (m *MockRepo) LockLogin(subject string, until time.Time) error {
+	args := m.Called(subject, until)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ClearLoginFailures(subjects ...string) (int64, error) {
+	args := m.Called(subjects)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) ListLockouts(now time.Time) ([]modelgorm.LoginFailureGorm, error) {
+	args := m.Called(now)
+	return args.Get(0).([]modelgorm.LoginFailureGorm), args.Error(1)
+}
+
+func hashed(t *testing.T, password string) string {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(hash)
+}
+
+func TestAuthenticate(t *testing.T) {
+	hash := hashed(t, "correct-horse")
+
+	tests := []struct {
+		name     string
+		user     modelgorm.AdminUserGorm
+		lookup   error
+		password string
+		want     auth.Principal
+		wantErr  error
+	}{
+		{"Valid", modelgorm.AdminUserGorm{Username: "alice", PasswordHash: hash, Role: "editor"}, nil, "correct-horse", auth.Principal{Username: "alice", Role: auth.RoleEditor}, nil},
+		{"Wrong Password", modelgorm.AdminUserGorm{Username: "alice", PasswordHash: hash, Role: "editor"}, nil, "wrong", auth.Principal{}, auth.ErrInvalidCredentials},
+		{"Disabled", modelgorm.AdminUserGorm{Username: "alice", PasswordHash: hash, Role: "editor", Disabled: true}, nil, "correct-horse", auth.Principal{}, auth.ErrInvalidCredentials},
+		{"Unknown User", modelgorm.AdminUserGorm{}, gorm.ErrRecordNotFound, "correct-horse", auth.Principal{}, auth.ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			mockRepo.On("GetUser", "alice").Return(tt.user, tt.lookup)
+			service := NewAdminUserService(mockRepo)
+
+			principal, err := service.Authenticate("alice", tt.password)
+
+			assert.Equal(t, tt.want, principal)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticate_LookupError(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetUser", "alice").Return(modelgorm.AdminUserGorm{}, errors.New("connection refused"))
+	service := NewAdminUserService(mockRepo)
+
+	_, err := service.Authenticate("alice", "whatever")
+
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, auth.ErrInvalidCredentials)
+}
+
+func TestBootstrap(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("CountUsers").Return(int64(0), nil)
+	mockRepo.On("CreateUser", mock.MatchedBy(func(user *modelgorm.AdminUserGorm) bool {
+		return user.Username == "adminTax" && user.Role == "superuser" &&
+			bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("admin!")) == nil
+	})).Return(nil)
+	service := NewAdminUserService(mockRepo)
+
+	assert.NoError(t, service.Bootstrap("adminTax", "admin!"))
+	mockRepo.AssertExpectations(t)
+}
+
+func TestBootstrap_UsersExist(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("CountUsers").Return(int64(2), nil)
+	service := NewAdminUserService(mockRepo)
+
+	assert.NoError(t, service.Bootstrap("", ""))
+	mockRepo.AssertNotCalled(t, "CreateUser", mock.Anything)
+}
+
+func TestBootstrap_NoCredentials(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("CountUsers").Return(int64(0), nil)
+	service := NewAdminUserService(mockRepo)
+
+	assert.ErrorIs(t, service.Bootstrap("", ""), ErrNoAdminUsers)
+}
+
+func TestCreateUser(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetUser", "bob").Return(modelgorm.AdminUserGorm{}, gorm.ErrRecordNotFound)
+	mockRepo.On("CreateUser", mock.Anything).Return(nil)
+	service := NewAdminUserService(mockRepo)
+
+	user, err := service.CreateUser(model.CreateAdminUserRequest{Username: "bob", Password: "long-enough", Role: "viewer"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, "bob", user.Username)
+	assert.Equal(t, "viewer", user.Role)
+	created := mockRepo.Calls[1].Arguments.Get(0).(*modelgorm.AdminUserGorm)
+	assert.NotEqual(t, "long-enough", created.PasswordHash)
+}
+
+func TestCreateUser_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		req  model.CreateAdminUserRequest
+	}{
+		{"Bad Username", model.CreateAdminUserRequest{Username: "b!", Password: "long-enough", Role: "viewer"}},
+		{"Bad Role", model.CreateAdminUserRequest{Username: "bob", Password: "long-enough", Role: "owner"}},
+		{"Short Password", model.CreateAdminUserRequest{Username: "bob", Password: "short", Role: "viewer"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			service := NewAdminUserService(mockRepo)
+
+			_, err := service.CreateUser(tt.req)
+
+			assert.ErrorIs(t, err, ErrInvalidUser)
+			mockRepo.AssertNotCalled(t, "CreateUser", mock.Anything)
+		})
+	}
+}
+
+func TestCreateUser_Exists(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetUser", "bob").Return(modelgorm.AdminUserGorm{Username: "bob"}, nil)
+	service := NewAdminUserService(mockRepo)
+
+	_, err := service.CreateUser(model.CreateAdminUserRequest{Username: "bob", Password: "long-enough", Role: "viewer"})
+
+	assert.ErrorIs(t, err, ErrUserExists)
+}
+
+func TestDisableUser(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetUser", "bob").Return(modelgorm.AdminUserGorm{Username: "bob", Role: "editor"}, nil)
+	mockRepo.On("UpdateUser", mock.MatchedBy(func(user *modelgorm.AdminUserGorm) bool { return user.Disabled })).Return(nil)
+	service := NewAdminUserService(mockRepo)
+
+	user, err := service.DisableUser("bob")
+
+	assert.NoError(t, err)
+	assert.True(t, user.Disabled)
+}
+
+func TestDisableUser_LastSuperuser(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetUser", "root").Return(modelgorm.AdminUserGorm{Username: "root", Role: "superuser"}, nil)
+	mockRepo.On("CountActiveSuperusers").Return(int64(1), nil)
+	service := NewAdminUserService(mockRepo)
+
+	_, err := service.DisableUser("root")
+
+	assert.ErrorIs(t, err, ErrLastSuperuser)
+	mockRepo.AssertNotCalled(t, "UpdateUser", mock.Anything)
+}
+
+func TestDisableUser_NotFound(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetUser", "ghost").Return(modelgorm.AdminUserGorm{}, gorm.ErrRecordNotFound)
+	service := NewAdminUserService(mockRepo)
+
+	_, err := service.DisableUser("ghost")
+
+	assert.ErrorIs(t, err, ErrUserNotFound)
+}
+
+func TestRotatePassword(t *testing.T) {
+	oldHash := hashed(t, "old-password")
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetUser", "bob").Return(modelgorm.AdminUserGorm{Username: "bob", Role: "editor", PasswordHash: oldHash}, nil)
+	mockRepo.On("UpdateUser", mock.MatchedBy(func(user *modelgorm.AdminUserGorm) bool {
+		return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte("new-password")) == nil
+	})).Return(nil)
+	service := NewAdminUserService(mockRepo)
+
+	_, err := service.RotatePassword("bob", "new-password")
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
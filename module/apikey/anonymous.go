@@ -0,0 +1,86 @@
+package apikey
+
+import (
+	"fmt"
+	"github.com/pphee/assessment-tax/module/tax"
+	"golang.org/x/time/rate"
+	"time"
+)
+
+// AnonymousLimits are the rate limit and daily row quota of each client that
+// calls without a key, told apart by IP address.
+type AnonymousLimits struct {
+	RatePerMinute int
+	DailyRowQuota int64
+}
+
+// DefaultAnonymousLimits are tighter than a new key's, so that leaving the
+// key out never gets a caller more.
+var DefaultAnonymousLimits = AnonymousLimits{RatePerMinute: 30, DailyRowQuota: 1000}
+
+// minClientsPruned is how many anonymous clients are tracked before idle
+// ones are forgotten.
+const minClientsPruned = 1024
+
+// clientLimiter returns the rate limiter of an anonymous client.
+func (g *Guard) clientLimiter(client string) *rate.Limiter {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	limiter, ok := g.clients[client]
+	if !ok {
+		if len(g.clients) >= g.prunesAt {
+			g.pruneClients()
+		}
+		limiter = rate.NewLimiter(rate.Limit(float64(g.Anonymous.RatePerMinute)/60), g.Anonymous.RatePerMinute)
+		g.clients[client] = limiter
+	}
+	return limiter
+}
+
+// pruneClients forgets the clients whose limiter has refilled, which a new
+// limiter would be indistinguishable from. g.mu must be held.
+func (g *Guard) pruneClients() {
+	for client, limiter := range g.clients {
+		if limiter.Tokens() >= float64(limiter.Burst()) {
+			delete(g.clients, client)
+		}
+	}
+	g.prunesAt = max(2*len(g.clients), minClientsPruned)
+}
+
+// admitClient counts a request against an anonymous client's rate limit.
+func (g *Guard) admitClient(client string) error {
+	reservation := g.clientLimiter(client).Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return &RateLimitError{PerMinute: g.Anonymous.RatePerMinute, Delay: delay}
+	}
+	return nil
+}
+
+// ReserveClientRows counts rows against an anonymous client's daily quota,
+// as ReserveRows does. Like rate limits, the counts are kept per process.
+func (g *Guard) ReserveClientRows(client string, rows int) (release func(), err error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if today := day(time.Now()); !today.Equal(g.rowsDay) {
+		g.rowsDay = today
+		g.clientRows = make(map[string]int64)
+	}
+	quota := g.Anonymous.DailyRowQuota
+	if g.clientRows[client]+int64(rows) > quota {
+		return nil, fmt.Errorf("%w: %d rows would exceed %d per day", tax.ErrRowQuotaExceeded, rows, quota)
+	}
+	g.clientRows[client] += int64(rows)
+
+	reserved := g.rowsDay
+	return func() {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		if g.rowsDay.Equal(reserved) {
+			g.clientRows[client] -= int64(rows)
+		}
+	}, nil
+}
@@ -0,0 +1,87 @@
+package apikey
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/problem"
+	"net/http"
+	"strconv"
+)
+
+type APIKeyHandler struct {
+	APIKeyService APIKeyServices
+}
+
+func NewAPIKeyHandler(service APIKeyServices) *APIKeyHandler {
+	return &APIKeyHandler{APIKeyService: service}
+}
+
+func (h *APIKeyHandler) CreateKey(c echo.Context) error {
+	var req model.CreateAPIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	key, err := h.APIKeyService.CreateKey(principal.Username, req)
+	if err != nil {
+		return apiKeyError(c, "Failed to create API key", err)
+	}
+
+	return c.JSON(http.StatusCreated, key)
+}
+
+func (h *APIKeyHandler) ListKeys(c echo.Context) error {
+	keys, err := h.APIKeyService.ListKeys()
+	if err != nil {
+		return problem.Internal(c, "Failed to list API keys", err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"apiKeys": keys})
+}
+
+func (h *APIKeyHandler) RevokeKey(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "id", "Invalid API key id")
+	}
+
+	key, err := h.APIKeyService.RevokeKey(uint(id))
+	if err != nil {
+		return apiKeyError(c, "Failed to revoke API key", err)
+	}
+
+	return c.JSON(http.StatusOK, key)
+}
+
+func (h *APIKeyHandler) GetUsage(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "id", "Invalid API key id")
+	}
+	days := 30
+	if v := c.QueryParam("days"); v != "" {
+		if days, err = strconv.Atoi(v); err != nil {
+			return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "days", "days must be an integer")
+		}
+	}
+
+	usage, err := h.APIKeyService.GetUsage(uint(id), days)
+	if err != nil {
+		return apiKeyError(c, "Failed to get API key usage", err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"usage": usage})
+}
+
+func apiKeyError(c echo.Context, message string, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidAPIKeyRequest):
+		return problem.Respond(c, http.StatusBadRequest, "invalid_api_key_request", err.Error())
+	case errors.Is(err, ErrAPIKeyNotFound):
+		return problem.Respond(c, http.StatusNotFound, "api_key_not_found", err.Error())
+	}
+	return problem.Internal(c, message, err)
+}
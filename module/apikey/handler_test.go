@@ -0,0 +1,79 @@
+package apikey
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPIKeyHandler_CreateKey(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/admin/api-keys", strings.NewReader(`{"name":"payroll","dailyRowQuota":500}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	auth.SetPrincipal(c, auth.Principal{Username: "alice", Role: auth.RoleSuperuser})
+
+	mockService := new(MockAPIKeyService)
+	mockService.On("CreateKey", "alice", model.CreateAPIKeyRequest{Name: "payroll", DailyRowQuota: 500}).
+		Return(model.CreatedAPIKey{APIKey: model.APIKey{ID: 3, Name: "payroll"}, Key: "atx_secret"}, nil)
+	h := &APIKeyHandler{APIKeyService: mockService}
+
+	if assert.NoError(t, h.CreateKey(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"key":"atx_secret"`)
+	}
+}
+
+func TestAPIKeyHandler_RevokeKey(t *testing.T) {
+	tests := []struct {
+		name string
+		id   string
+		err  error
+		want int
+	}{
+		{"Success", "3", nil, http.StatusOK},
+		{"Not Found", "3", ErrAPIKeyNotFound, http.StatusNotFound},
+		{"Bad ID", "abc", nil, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+			c.SetParamNames("id")
+			c.SetParamValues(tt.id)
+
+			mockService := new(MockAPIKeyService)
+			mockService.On("RevokeKey", uint(3)).Return(model.APIKey{ID: 3}, tt.err)
+			h := &APIKeyHandler{APIKeyService: mockService}
+
+			if assert.NoError(t, h.RevokeKey(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestAPIKeyHandler_GetUsage(t *testing.T) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/?days=7", nil), rec)
+	c.SetParamNames("id")
+	c.SetParamValues("3")
+
+	mockService := new(MockAPIKeyService)
+	mockService.On("GetUsage", uint(3), 7).Return([]model.APIKeyUsage{{Day: "2024-03-01", Requests: 2, Rows: 40}}, nil)
+	h := &APIKeyHandler{APIKeyService: mockService}
+
+	if assert.NoError(t, h.GetUsage(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"usage":[{"day":"2024-03-01","requests":2,"rows":40}]}`, rec.Body.String())
+	}
+}
@@ -0,0 +1,184 @@
+package apikey
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/health"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/problem"
+	"golang.org/x/time/rate"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	Header = "X-API-Key"
+
+	keyContextKey = "apikey.key"
+)
+
+// Principal identifies a key's caller. It holds no admin role.
+func Principal(key model.APIKey) auth.Principal {
+	return auth.Principal{Username: "apikey:" + strconv.FormatUint(uint64(key.ID), 10)}
+}
+
+func KeyFrom(c echo.Context) (model.APIKey, bool) {
+	key, ok := c.Get(keyContextKey).(model.APIKey)
+	return key, ok
+}
+
+// Guard authenticates API keys on the public endpoints and enforces their
+// rate limits and row quotas. Rate limits are tracked per process; row
+// quotas are shared through the database. Requests without a key are held
+// to Anonymous per client IP address instead. While Health is degraded keys
+// cannot be checked, so requests with a key are served as anonymous ones,
+// or refused when keys are required.
+type Guard struct {
+	Service   APIKeyServices
+	Required  bool
+	Health    *health.Monitor
+	Anonymous AnonymousLimits
+
+	mu         sync.Mutex
+	limiters   map[uint]*rate.Limiter
+	clients    map[string]*rate.Limiter
+	prunesAt   int
+	rowsDay    time.Time
+	clientRows map[string]int64
+}
+
+func NewGuard(service APIKeyServices, required bool) *Guard {
+	return &Guard{
+		Service:   service,
+		Required:  required,
+		Anonymous: DefaultAnonymousLimits,
+		limiters:  make(map[uint]*rate.Limiter),
+		clients:   make(map[string]*rate.Limiter),
+		prunesAt:  minClientsPruned,
+	}
+}
+
+func (g *Guard) limiter(key model.APIKey) *rate.Limiter {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	limiter, ok := g.limiters[key.ID]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(float64(key.RatePerMinute)/60), key.RatePerMinute)
+		g.limiters[key.ID] = limiter
+	}
+	return limiter
+}
+
+var (
+	ErrAPIKeyRequired  = errors.New("an API key is required")
+	ErrKeysUnavailable = errors.New("API keys cannot be checked while the database is unavailable")
+)
+
+// RateLimitError refuses a key over its rate limit until Delay has passed.
+type RateLimitError struct {
+	PerMinute int
+	Delay     time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("rate limit of %d requests per minute exceeded", e.PerMinute)
+}
+
+// Admit checks the key a request from client presented, plaintext being
+// empty when it presented none, and counts the request against it, or
+// against client when it is served anonymously; ok is false then. A request
+// to be refused gets ErrAPIKeyRequired, ErrAPIKeyRejected,
+// ErrKeysUnavailable or a *RateLimitError; any other error is the guard's
+// own failure.
+func (g *Guard) Admit(plaintext, client string) (key model.APIKey, ok bool, err error) {
+	if plaintext == "" {
+		if g.Required {
+			return model.APIKey{}, false, ErrAPIKeyRequired
+		}
+		return model.APIKey{}, false, g.admitClient(client)
+	}
+	if g.Health.Degraded() {
+		if g.Required {
+			return model.APIKey{}, false, ErrKeysUnavailable
+		}
+		return model.APIKey{}, false, g.admitClient(client)
+	}
+
+	key, err = g.Service.Authenticate(plaintext)
+	if err != nil {
+		return model.APIKey{}, false, err
+	}
+
+	reservation := g.limiter(key).Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		return model.APIKey{}, false, &RateLimitError{PerMinute: key.RatePerMinute, Delay: delay}
+	}
+
+	if err := g.Service.RecordRequest(key.ID); err != nil {
+		log.Printf("api key %s: %v", key.Prefix, err)
+	}
+	return key, true, nil
+}
+
+// Middleware rejects unknown or revoked keys and callers over their rate
+// limit. Requests without a key pass through unless keys are required.
+func (g *Guard) Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key, ok, err := g.Admit(c.Request().Header.Get(Header), c.RealIP())
+		var limited *RateLimitError
+		switch {
+		case errors.Is(err, ErrAPIKeyRequired):
+			return problem.RespondMessage(c, http.StatusUnauthorized, "api_key_required", "", i18n.Msg("problem.api_key_required", Header))
+		case errors.Is(err, ErrKeysUnavailable):
+			return problem.Respond(c, http.StatusServiceUnavailable, "database_unavailable", err.Error())
+		case errors.Is(err, ErrAPIKeyRejected):
+			return problem.RespondMessage(c, http.StatusUnauthorized, "api_key_rejected", "", i18n.Msg("problem.api_key_rejected"))
+		case errors.As(err, &limited):
+			c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(limited.Delay.Seconds()))))
+			return problem.RespondMessage(c, http.StatusTooManyRequests, "rate_limited", "", i18n.Msg("problem.rate_limited", limited.PerMinute))
+		case err != nil:
+			return problem.Internal(c, "Failed to check API key", err)
+		case !ok:
+			return next(c)
+		}
+
+		c.Set(keyContextKey, key)
+		if _, ok := auth.PrincipalFrom(c); !ok {
+			auth.SetPrincipal(c, Principal(key))
+		}
+		return next(c)
+	}
+}
+
+// ReserveRows counts rows against the caller's daily quota, that of its
+// key or, for an anonymous request, that of its IP address. release gives
+// them back when they end up not being calculated.
+func (g *Guard) ReserveRows(c echo.Context, rows int) (release func(), err error) {
+	key, ok := KeyFrom(c)
+	if !ok {
+		return g.ReserveClientRows(c.RealIP(), rows)
+	}
+	return g.ReserveKeyRows(key, rows)
+}
+
+// ReserveKeyRows counts rows against key's daily quota, as ReserveRows does.
+func (g *Guard) ReserveKeyRows(key model.APIKey, rows int) (release func(), err error) {
+	giveBack, err := g.Service.ReserveRows(key.ID, key.DailyRowQuota, rows)
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		if err := giveBack(); err != nil {
+			log.Printf("api key %s: %v", key.Prefix, err)
+		}
+	}, nil
+}
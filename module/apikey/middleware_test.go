@@ -0,0 +1,175 @@
+package apikey
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/health"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type MockAPIKeyService struct {
+	mock.Mock
+}
+
+func (m *MockAPIKeyService) CreateKey(creator string, req model.CreateAPIKeyRequest) (model.CreatedAPIKey, error) {
+	args := m.Called(creator, req)
+	return args.Get(0).(model.CreatedAPIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) ListKeys() ([]model.APIKey, error) {
+	args := m.Called()
+	return args.Get(0).([]model.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RevokeKey(id uint) (model.APIKey, error) {
+	args := m.Called(id)
+	return args.Get(0).(model.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) GetUsage(id uint, days int) ([]model.APIKeyUsage, error) {
+	args := m.Called(id, days)
+	return args.Get(0).([]model.APIKeyUsage), args.Error(1)
+}
+
+func (m *MockAPIKeyService) Authenticate(key string) (model.APIKey, error) {
+	args := m.Called(key)
+	return args.Get(0).(model.APIKey), args.Error(1)
+}
+
+func (m *MockAPIKeyService) RecordRequest(id uint) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockAPIKeyService) ReserveRows(id uint, quota int64, rows int) (func() error, error) {
+	args := m.Called(id, quota, rows)
+	release, _ := args.Get(0).(func() error)
+	return release, args.Error(1)
+}
+
+func serve(guard *Guard, key string) *httptest.ResponseRecorder {
+	return serveFrom(guard, key, "192.0.2.1:1234")
+}
+
+func serveFrom(guard *Guard, key, remoteAddr string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/tax/calculations", nil)
+	req.RemoteAddr = remoteAddr
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	_ = guard.Middleware(func(c echo.Context) error {
+		key, _ := KeyFrom(c)
+		principal, _ := auth.PrincipalFrom(c)
+		return c.String(http.StatusOK, key.Name+" "+principal.Username)
+	})(c)
+	return rec
+}
+
+func TestGuard_Middleware(t *testing.T) {
+	mockService := new(MockAPIKeyService)
+	mockService.On("Authenticate", "atx_good").Return(model.APIKey{ID: 3, Name: "payroll", RatePerMinute: 2}, nil)
+	mockService.On("Authenticate", "atx_bad").Return(model.APIKey{}, ErrAPIKeyRejected)
+	mockService.On("RecordRequest", uint(3)).Return(nil)
+
+	t.Run("Anonymous Optional", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, serve(NewGuard(mockService, false), "").Code)
+	})
+
+	t.Run("Anonymous Required", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve(NewGuard(mockService, true), "").Code)
+	})
+
+	t.Run("Rejected Key", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, serve(NewGuard(mockService, false), "atx_bad").Code)
+	})
+
+	t.Run("Rate Limited", func(t *testing.T) {
+		guard := NewGuard(mockService, true)
+
+		first := serve(guard, "atx_good")
+		assert.Equal(t, http.StatusOK, first.Code)
+		assert.Equal(t, "payroll apikey:3", first.Body.String())
+		assert.Equal(t, http.StatusOK, serve(guard, "atx_good").Code)
+
+		limited := serve(guard, "atx_good")
+		assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+		assert.Equal(t, "30", limited.Header().Get("Retry-After"))
+		mockService.AssertNumberOfCalls(t, "RecordRequest", 2)
+	})
+}
+
+func TestGuard_Middleware_Anonymous(t *testing.T) {
+	mockService := new(MockAPIKeyService)
+	guard := NewGuard(mockService, false)
+	guard.Anonymous.RatePerMinute = 2
+
+	assert.Equal(t, http.StatusOK, serveFrom(guard, "", "192.0.2.1:1234").Code)
+	assert.Equal(t, http.StatusOK, serveFrom(guard, "", "192.0.2.1:5678").Code)
+
+	limited := serveFrom(guard, "", "192.0.2.1:1234")
+	assert.Equal(t, http.StatusTooManyRequests, limited.Code)
+	assert.Equal(t, "30", limited.Header().Get("Retry-After"))
+
+	assert.Equal(t, http.StatusOK, serveFrom(guard, "", "198.51.100.7:1234").Code, "each address has its own limit")
+}
+
+func TestGuard_Middleware_Degraded(t *testing.T) {
+	monitor := health.NewMonitor(nil)
+	monitor.MarkDown()
+	mockService := new(MockAPIKeyService)
+
+	optional := NewGuard(mockService, false)
+	optional.Health = monitor
+	optional.Anonymous.RatePerMinute = 1
+	served := serve(optional, "atx_good")
+	assert.Equal(t, http.StatusOK, served.Code)
+	assert.Equal(t, " ", served.Body.String(), "the key is not checked, so the request is anonymous")
+	assert.Equal(t, http.StatusTooManyRequests, serve(optional, "atx_good").Code, "and limited as one")
+
+	required := NewGuard(mockService, true)
+	required.Health = monitor
+	assert.Equal(t, http.StatusServiceUnavailable, serve(required, "atx_good").Code)
+	mockService.AssertNotCalled(t, "Authenticate", mock.Anything)
+}
+
+func TestGuard_ReserveRows(t *testing.T) {
+	released := 0
+	mockService := new(MockAPIKeyService)
+	mockService.On("ReserveRows", uint(3), int64(100), 10).Return(func() error { released++; return nil }, nil)
+	guard := NewGuard(mockService, false)
+	guard.Anonymous.DailyRowQuota = 15
+
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/", nil), httptest.NewRecorder())
+	_, err := guard.ReserveRows(c, 10)
+	assert.NoError(t, err)
+	_, err = guard.ReserveRows(c, 10)
+	assert.ErrorIs(t, err, tax.ErrRowQuotaExceeded, "anonymous rows count against the address")
+	mockService.AssertNotCalled(t, "ReserveRows", mock.Anything, mock.Anything, mock.Anything)
+
+	c.Set(keyContextKey, model.APIKey{ID: 3, DailyRowQuota: 100})
+	release, err := guard.ReserveRows(c, 10)
+	assert.NoError(t, err)
+	release()
+	assert.Equal(t, 1, released)
+	mockService.AssertExpectations(t)
+}
+
+func TestGuard_ReserveClientRows_Release(t *testing.T) {
+	guard := NewGuard(new(MockAPIKeyService), false)
+	guard.Anonymous.DailyRowQuota = 15
+
+	release, err := guard.ReserveClientRows("192.0.2.1", 10)
+	assert.NoError(t, err)
+	release()
+
+	_, err = guard.ReserveClientRows("192.0.2.1", 15)
+	assert.NoError(t, err, "released rows no longer count")
+}
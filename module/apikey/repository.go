@@ -0,0 +1,110 @@
+package apikey
+
+import (
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+type APIKeyRepositories interface {
+	CreateKey(key *modelgorm.APIKeyGorm) error
+	GetKey(id uint) (modelgorm.APIKeyGorm, error)
+	GetKeyByHash(hash string) (modelgorm.APIKeyGorm, error)
+	ListKeys() ([]modelgorm.APIKeyGorm, error)
+	RevokeKey(id uint, at time.Time) error
+	RecordRequest(keyID uint, day time.Time) error
+	ReserveRows(keyID uint, day time.Time, rows, quota int64) (bool, error)
+	ReleaseRows(keyID uint, day time.Time, rows int64) error
+	ListUsage(keyID uint, since time.Time) ([]modelgorm.APIKeyUsageGorm, error)
+	ListUsageOn(day time.Time) ([]modelgorm.APIKeyUsageGorm, error)
+}
+
+type APIKeyRepository struct {
+	DB *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) APIKeyRepositories {
+	return &APIKeyRepository{DB: db}
+}
+
+func (repo *APIKeyRepository) CreateKey(key *modelgorm.APIKeyGorm) error {
+	return repo.DB.Create(key).Error
+}
+
+func (repo *APIKeyRepository) GetKey(id uint) (modelgorm.APIKeyGorm, error) {
+	var key modelgorm.APIKeyGorm
+	err := repo.DB.First(&key, id).Error
+	return key, err
+}
+
+func (repo *APIKeyRepository) GetKeyByHash(hash string) (modelgorm.APIKeyGorm, error) {
+	var key modelgorm.APIKeyGorm
+	err := repo.DB.Where("hash = ?", hash).First(&key).Error
+	return key, err
+}
+
+func (repo *APIKeyRepository) ListKeys() ([]modelgorm.APIKeyGorm, error) {
+	var keys []modelgorm.APIKeyGorm
+	if err := repo.DB.Order("id").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (repo *APIKeyRepository) RevokeKey(id uint, at time.Time) error {
+	return repo.DB.Model(&modelgorm.APIKeyGorm{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", at).Error
+}
+
+func (repo *APIKeyRepository) RecordRequest(keyID uint, day time.Time) error {
+	usage := modelgorm.APIKeyUsageGorm{KeyID: keyID, Day: day, Requests: 1}
+	return repo.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"requests": gorm.Expr("api_key_usage_gorms.requests + 1")}),
+	}).Create(&usage).Error
+}
+
+// ReserveRows adds rows to the day's count unless that would take it over
+// quota, in a single statement so concurrent uploads cannot overshoot.
+// ReleaseRows takes rows reserved on day off the day's count again.
+func (repo *APIKeyRepository) ReleaseRows(keyID uint, day time.Time, rows int64) error {
+	return repo.DB.Model(&modelgorm.APIKeyUsageGorm{}).
+		Where("key_id = ? AND day = ? AND rows >= ?", keyID, day, rows).
+		Update("rows", gorm.Expr("rows - ?", rows)).Error
+}
+
+func (repo *APIKeyRepository) ReserveRows(keyID uint, day time.Time, rows, quota int64) (bool, error) {
+	if rows > quota {
+		return false, nil
+	}
+
+	usage := modelgorm.APIKeyUsageGorm{KeyID: keyID, Day: day, Rows: rows}
+	result := repo.DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"rows": gorm.Expr("api_key_usage_gorms.rows + excluded.rows")}),
+		Where:     clause.Where{Exprs: []clause.Expression{gorm.Expr("api_key_usage_gorms.rows + excluded.rows <= ?", quota)}},
+	}).Create(&usage)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (repo *APIKeyRepository) ListUsage(keyID uint, since time.Time) ([]modelgorm.APIKeyUsageGorm, error) {
+	var usage []modelgorm.APIKeyUsageGorm
+	err := repo.DB.Where("key_id = ? AND day >= ?", keyID, since).Order("day DESC").Find(&usage).Error
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+func (repo *APIKeyRepository) ListUsageOn(day time.Time) ([]modelgorm.APIKeyUsageGorm, error) {
+	var usage []modelgorm.APIKeyUsageGorm
+	if err := repo.DB.Where("day = ?", day).Find(&usage).Error; err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
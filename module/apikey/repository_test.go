@@ -0,0 +1,83 @@
+package apikey
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func newMockRepository(t *testing.T) (APIKeyRepositories, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB, PreferSimpleProtocol: true}), &gorm.Config{})
+	require.NoError(t, err)
+	return NewAPIKeyRepository(db), mock
+}
+
+func TestReserveRows_Repository(t *testing.T) {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		affected int64
+		want     bool
+	}{
+		{"Within Quota", 1, true},
+		{"Over Quota", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := newMockRepository(t)
+			mock.ExpectBegin()
+			mock.ExpectExec(`INSERT INTO "api_key_usage_gorms" .* ON CONFLICT \("key_id","day"\) DO UPDATE SET "rows"=api_key_usage_gorms.rows \+ excluded.rows WHERE api_key_usage_gorms.rows \+ excluded.rows <= \$\d`).
+				WillReturnResult(sqlmock.NewResult(0, tt.affected))
+			mock.ExpectCommit()
+
+			ok, err := repo.ReserveRows(3, day, 10, 100)
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, ok)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestReserveRows_Repository_LargerThanQuota(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	ok, err := repo.ReserveRows(3, time.Now(), 101, 100)
+
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReleaseRows_Repository(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "api_key_usage_gorms" SET "rows"=rows - \$1 WHERE key_id = \$2 AND day = \$3 AND rows >= \$4`).
+		WithArgs(int64(10), 3, day, int64(10)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, repo.ReleaseRows(3, day, 10))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRevokeKey_Repository(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	at := time.Now()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "api_key_gorms" SET "revoked_at"=\$1 WHERE id = \$2 AND revoked_at IS NULL`).
+		WithArgs(at, 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, repo.RevokeKey(3, at))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,239 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	keyPrefix    = "atx_"
+	prefixLength = 12
+
+	DefaultRatePerMinute = 60
+	DefaultDailyRowQuota = 100000
+
+	maxRatePerMinute = 10000
+	maxDailyRowQuota = 100000000
+	maxUsageDays     = 366
+)
+
+var (
+	ErrInvalidAPIKeyRequest = errors.New("invalid API key request")
+	ErrAPIKeyNotFound       = errors.New("API key not found")
+	ErrAPIKeyRejected       = errors.New("API key is unknown or revoked")
+)
+
+type APIKeyServices interface {
+	CreateKey(creator string, req model.CreateAPIKeyRequest) (model.CreatedAPIKey, error)
+	ListKeys() ([]model.APIKey, error)
+	RevokeKey(id uint) (model.APIKey, error)
+	GetUsage(id uint, days int) ([]model.APIKeyUsage, error)
+	Authenticate(key string) (model.APIKey, error)
+	RecordRequest(id uint) error
+	ReserveRows(id uint, quota int64, rows int) (release func() error, err error)
+}
+
+type APIKeyService struct {
+	Repo APIKeyRepositories
+	now  func() time.Time
+}
+
+func NewAPIKeyService(repo APIKeyRepositories) APIKeyServices {
+	return &APIKeyService{Repo: repo, now: time.Now}
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func day(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
+
+func toUsage(usage modelgorm.APIKeyUsageGorm) model.APIKeyUsage {
+	return model.APIKeyUsage{Day: usage.Day.Format(time.DateOnly), Requests: usage.Requests, Rows: usage.Rows}
+}
+
+func toAPIKey(key modelgorm.APIKeyGorm) model.APIKey {
+	return model.APIKey{
+		ID:            key.ID,
+		Name:          key.Name,
+		Prefix:        key.Prefix,
+		RatePerMinute: key.RatePerMinute,
+		DailyRowQuota: key.DailyRowQuota,
+		CreatedBy:     key.CreatedBy,
+		CreatedAt:     key.CreatedAt,
+		RevokedAt:     key.RevokedAt,
+	}
+}
+
+// CreateKey issues a random key. Only its SHA-256 hash is stored, so the
+// plaintext is returned here and never again.
+func (service *APIKeyService) CreateKey(creator string, req model.CreateAPIKeyRequest) (model.CreatedAPIKey, error) {
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > 100 {
+		return model.CreatedAPIKey{}, fmt.Errorf("%w: name must be 1-100 characters", ErrInvalidAPIKeyRequest)
+	}
+	if req.RatePerMinute == 0 {
+		req.RatePerMinute = DefaultRatePerMinute
+	}
+	if req.RatePerMinute < 1 || req.RatePerMinute > maxRatePerMinute {
+		return model.CreatedAPIKey{}, fmt.Errorf("%w: ratePerMinute must be between 1 and %d", ErrInvalidAPIKeyRequest, maxRatePerMinute)
+	}
+	if req.DailyRowQuota == 0 {
+		req.DailyRowQuota = DefaultDailyRowQuota
+	}
+	if req.DailyRowQuota < 1 || req.DailyRowQuota > maxDailyRowQuota {
+		return model.CreatedAPIKey{}, fmt.Errorf("%w: dailyRowQuota must be between 1 and %d", ErrInvalidAPIKeyRequest, maxDailyRowQuota)
+	}
+
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return model.CreatedAPIKey{}, fmt.Errorf("failed to generate API key: %w", err)
+	}
+	plaintext := keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := modelgorm.APIKeyGorm{
+		Name:          name,
+		Prefix:        plaintext[:prefixLength],
+		Hash:          hashKey(plaintext),
+		RatePerMinute: req.RatePerMinute,
+		DailyRowQuota: req.DailyRowQuota,
+		CreatedBy:     creator,
+		CreatedAt:     service.now(),
+	}
+	if err := service.Repo.CreateKey(&key); err != nil {
+		return model.CreatedAPIKey{}, fmt.Errorf("failed to create API key: %w", err)
+	}
+	return model.CreatedAPIKey{APIKey: toAPIKey(key), Key: plaintext}, nil
+}
+
+func (service *APIKeyService) ListKeys() ([]model.APIKey, error) {
+	keys, err := service.Repo.ListKeys()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API keys: %w", err)
+	}
+	today := day(service.now())
+	usage, err := service.Repo.ListUsageOn(today)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API key usage: %w", err)
+	}
+
+	byKey := make(map[uint]modelgorm.APIKeyUsageGorm, len(usage))
+	for _, u := range usage {
+		byKey[u.KeyID] = u
+	}
+
+	result := make([]model.APIKey, len(keys))
+	for i, key := range keys {
+		result[i] = toAPIKey(key)
+		u, ok := byKey[key.ID]
+		if !ok {
+			u = modelgorm.APIKeyUsageGorm{KeyID: key.ID, Day: today}
+		}
+		result[i].Today = toUsage(u)
+	}
+	return result, nil
+}
+
+func (service *APIKeyService) getKey(id uint) (modelgorm.APIKeyGorm, error) {
+	key, err := service.Repo.GetKey(id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return modelgorm.APIKeyGorm{}, fmt.Errorf("%w: %d", ErrAPIKeyNotFound, id)
+	}
+	if err != nil {
+		return modelgorm.APIKeyGorm{}, fmt.Errorf("failed to get API key: %w", err)
+	}
+	return key, nil
+}
+
+// RevokeKey is idempotent; revoking a revoked key keeps the first revocation
+// time.
+func (service *APIKeyService) RevokeKey(id uint) (model.APIKey, error) {
+	key, err := service.getKey(id)
+	if err != nil {
+		return model.APIKey{}, err
+	}
+	if key.RevokedAt == nil {
+		now := service.now()
+		if err := service.Repo.RevokeKey(id, now); err != nil {
+			return model.APIKey{}, fmt.Errorf("failed to revoke API key: %w", err)
+		}
+		key.RevokedAt = &now
+	}
+	return toAPIKey(key), nil
+}
+
+func (service *APIKeyService) GetUsage(id uint, days int) ([]model.APIKeyUsage, error) {
+	if days < 1 || days > maxUsageDays {
+		return nil, fmt.Errorf("%w: days must be between 1 and %d", ErrInvalidAPIKeyRequest, maxUsageDays)
+	}
+	if _, err := service.getKey(id); err != nil {
+		return nil, err
+	}
+
+	since := day(service.now()).AddDate(0, 0, 1-days)
+	usage, err := service.Repo.ListUsage(id, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list API key usage: %w", err)
+	}
+	result := make([]model.APIKeyUsage, len(usage))
+	for i, u := range usage {
+		result[i] = toUsage(u)
+	}
+	return result, nil
+}
+
+func (service *APIKeyService) Authenticate(plaintext string) (model.APIKey, error) {
+	if !strings.HasPrefix(plaintext, keyPrefix) {
+		return model.APIKey{}, ErrAPIKeyRejected
+	}
+	key, err := service.Repo.GetKeyByHash(hashKey(plaintext))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.APIKey{}, ErrAPIKeyRejected
+	}
+	if err != nil {
+		return model.APIKey{}, fmt.Errorf("failed to look up API key: %w", err)
+	}
+	if key.RevokedAt != nil {
+		return model.APIKey{}, ErrAPIKeyRejected
+	}
+	return toAPIKey(key), nil
+}
+
+func (service *APIKeyService) RecordRequest(id uint) error {
+	if err := service.Repo.RecordRequest(id, day(service.now())); err != nil {
+		return fmt.Errorf("failed to record API key usage: %w", err)
+	}
+	return nil
+}
+
+// ReserveRows counts rows against key id's quota for today. release gives
+// them back, to the same day, when they end up not being calculated.
+func (service *APIKeyService) ReserveRows(id uint, quota int64, rows int) (release func() error, err error) {
+	today := day(service.now())
+	ok, err := service.Repo.ReserveRows(id, today, int64(rows), quota)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve rows: %w", err)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: %d rows would exceed %d per day", tax.ErrRowQuotaExceeded, rows, quota)
+	}
+	return func() error {
+		if err := service.Repo.ReleaseRows(id, today, int64(rows)); err != nil {
+			return fmt.Errorf("failed to release rows: %w", err)
+		}
+		return nil
+	}, nil
+}
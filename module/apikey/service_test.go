@@ -0,0 +1,201 @@
+package apikey
+
+import (
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) CreateKey(key *modelgorm.APIKeyGorm) error {
+	args := m.Called(key)
+	return args.Error(0)
+}
+
+func (m *MockRepo) GetKey(id uint) (modelgorm.APIKeyGorm, error) {
+	args := m.Called(id)
+	return args.Get(0).(modelgorm.APIKeyGorm), args.Error(1)
+}
+
+func (m *MockRepo) GetKeyByHash(hash string) (modelgorm.APIKeyGorm, error) {
+	args := m.Called(hash)
+	return args.Get(0).(modelgorm.APIKeyGorm), args.Error(1)
+}
+
+func (m *MockRepo) ListKeys() ([]modelgorm.APIKeyGorm, error) {
+	args := m.Called()
+	return args.Get(0).([]modelgorm.APIKeyGorm), args.Error(1)
+}
+
+func (m *MockRepo) RevokeKey(id uint, at time.Time) error {
+	args := m.Called(id, at)
+	return args.Error(0)
+}
+
+func (m *MockRepo) RecordRequest(keyID uint, day time.Time) error {
+	args := m.Called(keyID, day)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ReserveRows(keyID uint, day time.Time, rows, quota int64) (bool, error) {
+	args := m.Called(keyID, day, rows, quota)
+	return args.Bool(0), args.Error(1)
+}
+
+func (m *MockRepo) ReleaseRows(keyID uint, day time.Time, rows int64) error {
+	args := m.Called(keyID, day, rows)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ListUsage(keyID uint, since time.Time) ([]modelgorm.APIKeyUsageGorm, error) {
+	args := m.Called(keyID, since)
+	return args.Get(0).([]modelgorm.APIKeyUsageGorm), args.Error(1)
+}
+
+func (m *MockRepo) ListUsageOn(day time.Time) ([]modelgorm.APIKeyUsageGorm, error) {
+	args := m.Called(day)
+	return args.Get(0).([]modelgorm.APIKeyUsageGorm), args.Error(1)
+}
+
+var (
+	testNow   = time.Date(2024, 3, 1, 15, 30, 0, 0, time.UTC)
+	testToday = time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+)
+
+func newTestService(repo *MockRepo) *APIKeyService {
+	return &APIKeyService{Repo: repo, now: func() time.Time { return testNow }}
+}
+
+func TestCreateKey(t *testing.T) {
+	mockRepo := new(MockRepo)
+	var stored *modelgorm.APIKeyGorm
+	mockRepo.On("CreateKey", mock.Anything).Run(func(args mock.Arguments) {
+		stored = args.Get(0).(*modelgorm.APIKeyGorm)
+		stored.ID = 3
+	}).Return(nil)
+	service := newTestService(mockRepo)
+
+	created, err := service.CreateKey("alice", model.CreateAPIKeyRequest{Name: " payroll "})
+
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(created.Key, keyPrefix))
+	assert.Equal(t, created.Key[:prefixLength], created.Prefix)
+	assert.Equal(t, hashKey(created.Key), stored.Hash)
+	assert.NotContains(t, stored.Hash, created.Key)
+	assert.Equal(t, "payroll", created.Name)
+	assert.Equal(t, DefaultRatePerMinute, created.RatePerMinute)
+	assert.Equal(t, int64(DefaultDailyRowQuota), created.DailyRowQuota)
+}
+
+func TestCreateKey_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		req  model.CreateAPIKeyRequest
+	}{
+		{"No Name", model.CreateAPIKeyRequest{}},
+		{"Negative Rate", model.CreateAPIKeyRequest{Name: "batch", RatePerMinute: -1}},
+		{"Huge Quota", model.CreateAPIKeyRequest{Name: "batch", DailyRowQuota: maxDailyRowQuota + 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newTestService(new(MockRepo)).CreateKey("alice", tt.req)
+
+			assert.ErrorIs(t, err, ErrInvalidAPIKeyRequest)
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	revokedAt := testNow
+	tests := []struct {
+		name   string
+		stored modelgorm.APIKeyGorm
+		err    error
+		want   error
+	}{
+		{"Valid", modelgorm.APIKeyGorm{ID: 3, Name: "payroll"}, nil, nil},
+		{"Unknown", modelgorm.APIKeyGorm{}, gorm.ErrRecordNotFound, ErrAPIKeyRejected},
+		{"Revoked", modelgorm.APIKeyGorm{ID: 3, RevokedAt: &revokedAt}, nil, ErrAPIKeyRejected},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			mockRepo.On("GetKeyByHash", hashKey("atx_secret")).Return(tt.stored, tt.err)
+
+			key, err := newTestService(mockRepo).Authenticate("atx_secret")
+
+			assert.ErrorIs(t, err, tt.want)
+			if tt.want == nil {
+				assert.Equal(t, uint(3), key.ID)
+			}
+		})
+	}
+}
+
+func TestRevokeKey(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetKey", uint(3)).Return(modelgorm.APIKeyGorm{ID: 3}, nil)
+	mockRepo.On("RevokeKey", uint(3), testNow).Return(nil)
+
+	key, err := newTestService(mockRepo).RevokeKey(3)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testNow, *key.RevokedAt)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestReserveRows(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("ReserveRows", uint(3), testToday, int64(10), int64(100)).Return(true, nil).Once()
+	mockRepo.On("ReserveRows", uint(3), testToday, int64(10), int64(100)).Return(false, nil).Once()
+	mockRepo.On("ReleaseRows", uint(3), testToday, int64(10)).Return(nil).Once()
+	service := newTestService(mockRepo)
+
+	release, err := service.ReserveRows(3, 100, 10)
+	assert.NoError(t, err)
+	_, err = service.ReserveRows(3, 100, 10)
+	assert.ErrorIs(t, err, tax.ErrRowQuotaExceeded)
+
+	// Rows are given back to the day they were reserved on
+	service.now = func() time.Time { return testNow.Add(24 * time.Hour) }
+	assert.NoError(t, release())
+	mockRepo.AssertExpectations(t)
+}
+
+func TestListKeys_TodayUsage(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("ListKeys").Return([]modelgorm.APIKeyGorm{{ID: 1}, {ID: 2}}, nil)
+	mockRepo.On("ListUsageOn", testToday).Return([]modelgorm.APIKeyUsageGorm{{KeyID: 2, Day: testToday, Requests: 5, Rows: 40}}, nil)
+
+	keys, err := newTestService(mockRepo).ListKeys()
+
+	assert.NoError(t, err)
+	assert.Equal(t, model.APIKeyUsage{Day: "2024-03-01"}, keys[0].Today)
+	assert.Equal(t, model.APIKeyUsage{Day: "2024-03-01", Requests: 5, Rows: 40}, keys[1].Today)
+}
+
+func TestGetUsage(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetKey", uint(3)).Return(modelgorm.APIKeyGorm{ID: 3}, nil)
+	mockRepo.On("ListUsage", uint(3), testToday.AddDate(0, 0, -6)).Return([]modelgorm.APIKeyUsageGorm{{KeyID: 3, Day: testToday, Requests: 2}}, nil)
+	service := newTestService(mockRepo)
+
+	usage, err := service.GetUsage(3, 7)
+
+	assert.NoError(t, err)
+	assert.Len(t, usage, 1)
+
+	_, err = service.GetUsage(3, 0)
+	assert.ErrorIs(t, err, ErrInvalidAPIKeyRequest)
+}
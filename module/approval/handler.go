@@ -0,0 +1,119 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/problem"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/store/model"
+	"net/http"
+	"strconv"
+)
+
+type ChangeRequestHandler struct {
+	ChangeRequestService ChangeRequestServices
+}
+
+func NewChangeRequestHandler(service ChangeRequestServices) *ChangeRequestHandler {
+	return &ChangeRequestHandler{ChangeRequestService: service}
+}
+
+func (h *ChangeRequestHandler) SubmitChangeRequest(c echo.Context) error {
+	var req model.ChangeRequestSubmission
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	cr, err := h.ChangeRequestService.Submit(c.Request().Context(), principal.Username, req)
+	if err != nil {
+		return changeRequestError(c, "Failed to submit change request", err)
+	}
+
+	return c.JSON(http.StatusCreated, cr)
+}
+
+func (h *ChangeRequestHandler) ListChangeRequests(c echo.Context) error {
+	status := c.QueryParam("status")
+	switch status {
+	case "", modelgorm.ChangePending, modelgorm.ChangeApproved, modelgorm.ChangeRejected, modelgorm.ChangeExpired, modelgorm.ChangeFailed:
+	default:
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "status", "Unknown status: "+status)
+	}
+
+	crs, err := h.ChangeRequestService.ListChangeRequests(c.Request().Context(), status)
+	if err != nil {
+		return tax.InternalError(c, "Failed to list change requests", err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"changeRequests": crs})
+}
+
+func (h *ChangeRequestHandler) GetChangeRequest(c echo.Context) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "id", "Invalid change request id")
+	}
+
+	cr, err := h.ChangeRequestService.GetChangeRequest(c.Request().Context(), uint(id))
+	if err != nil {
+		return changeRequestError(c, "Failed to get change request", err)
+	}
+
+	return c.JSON(http.StatusOK, cr)
+}
+
+func (h *ChangeRequestHandler) ApproveChangeRequest(c echo.Context) error {
+	return h.decide(c, "Failed to approve change request", h.ChangeRequestService.Approve)
+}
+
+func (h *ChangeRequestHandler) RejectChangeRequest(c echo.Context) error {
+	return h.decide(c, "Failed to reject change request", h.ChangeRequestService.Reject)
+}
+
+func (h *ChangeRequestHandler) decide(c echo.Context, message string, decide func(context.Context, uint, string, string) (model.ChangeRequest, error)) error {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "id", "Invalid change request id")
+	}
+
+	var req model.ChangeRequestDecision
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	principal, _ := auth.PrincipalFrom(c)
+	cr, err := decide(c.Request().Context(), uint(id), principal.Username, req.Comment)
+	if err != nil {
+		return changeRequestError(c, message, err)
+	}
+
+	return c.JSON(http.StatusOK, cr)
+}
+
+// RequireChangeRequest blocks direct configuration writes so that they can
+// only happen through an approved change request.
+func RequireChangeRequest(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return problem.Respond(c, http.StatusForbidden, "change_request_required", "Configuration changes require an approved change request; submit one to /admin/change-requests")
+	}
+}
+
+func changeRequestError(c echo.Context, message string, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidChangeRequest):
+		return tax.Problem(c, http.StatusBadRequest, "invalid_change_request", err)
+	case errors.Is(err, ErrSelfApproval):
+		return tax.Problem(c, http.StatusForbidden, "self_approval", err)
+	case errors.Is(err, ErrChangeRequestNotFound):
+		return tax.Problem(c, http.StatusNotFound, "change_request_not_found", err)
+	case errors.Is(err, ErrNotPending):
+		return tax.Problem(c, http.StatusConflict, "change_request_not_pending", err)
+	case errors.Is(err, ErrChangeRequestExpired):
+		return tax.Problem(c, http.StatusConflict, "change_request_expired", err)
+	}
+	return tax.InternalError(c, message, err)
+}
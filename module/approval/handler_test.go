@@ -0,0 +1,118 @@
+package approval
+
+import (
+	"context"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type MockChangeRequestService struct {
+	mock.Mock
+}
+
+func (m *MockChangeRequestService) Submit(ctx context.Context, submitter string, submission model.ChangeRequestSubmission) (model.ChangeRequest, error) {
+	args := m.Called(submitter, submission)
+	return args.Get(0).(model.ChangeRequest), args.Error(1)
+}
+
+func (m *MockChangeRequestService) GetChangeRequest(ctx context.Context, id uint) (model.ChangeRequest, error) {
+	args := m.Called(id)
+	return args.Get(0).(model.ChangeRequest), args.Error(1)
+}
+
+func (m *MockChangeRequestService) ListChangeRequests(ctx context.Context, status string) ([]model.ChangeRequest, error) {
+	args := m.Called(status)
+	return args.Get(0).([]model.ChangeRequest), args.Error(1)
+}
+
+func (m *MockChangeRequestService) Approve(ctx context.Context, id uint, reviewer, comment string) (model.ChangeRequest, error) {
+	args := m.Called(id, reviewer, comment)
+	return args.Get(0).(model.ChangeRequest), args.Error(1)
+}
+
+func (m *MockChangeRequestService) Reject(ctx context.Context, id uint, reviewer, comment string) (model.ChangeRequest, error) {
+	args := m.Called(id, reviewer, comment)
+	return args.Get(0).(model.ChangeRequest), args.Error(1)
+}
+
+func (m *MockChangeRequestService) ExpirePending(ctx context.Context) (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+func newContext(method, target, body string, principal auth.Principal) (echo.Context, *httptest.ResponseRecorder) {
+	e := echo.New()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	auth.SetPrincipal(c, principal)
+	return c, rec
+}
+
+func TestChangeRequestHandler_Submit(t *testing.T) {
+	c, rec := newContext(http.MethodPost, "/", `{"kind":"allowance-settings","settings":[{"key":"personal","amount":70000}]}`, auth.Principal{Username: "alice", Role: auth.RoleEditor})
+
+	mockService := new(MockChangeRequestService)
+	mockService.On("Submit", "alice", mock.Anything).Return(model.ChangeRequest{ID: 7, Status: "pending"}, nil)
+	h := &ChangeRequestHandler{ChangeRequestService: mockService}
+
+	if assert.NoError(t, h.SubmitChangeRequest(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"status":"pending"`)
+	}
+}
+
+func TestChangeRequestHandler_Approve(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"Success", nil, http.StatusOK},
+		{"SelfApproval", ErrSelfApproval, http.StatusForbidden},
+		{"Expired", ErrChangeRequestExpired, http.StatusConflict},
+		{"NotFound", ErrChangeRequestNotFound, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newContext(http.MethodPost, "/", `{"comment":"ok"}`, auth.Principal{Username: "bob", Role: auth.RoleApprover})
+			c.SetParamNames("id")
+			c.SetParamValues("7")
+
+			mockService := new(MockChangeRequestService)
+			mockService.On("Approve", uint(7), "bob", "ok").Return(model.ChangeRequest{ID: 7}, tt.err)
+			h := &ChangeRequestHandler{ChangeRequestService: mockService}
+
+			if assert.NoError(t, h.ApproveChangeRequest(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestChangeRequestHandler_ListChangeRequests_UnknownStatus(t *testing.T) {
+	c, rec := newContext(http.MethodGet, "/?status=maybe", "", auth.Principal{Username: "bob", Role: auth.RoleViewer})
+	h := &ChangeRequestHandler{ChangeRequestService: new(MockChangeRequestService)}
+
+	if assert.NoError(t, h.ListChangeRequests(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestRequireChangeRequest(t *testing.T) {
+	c, rec := newContext(http.MethodPut, "/", "", auth.Principal{Username: "alice", Role: auth.RoleEditor})
+	handler := RequireChangeRequest(func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	if assert.NoError(t, handler(c)) {
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+var ErrConcurrentDecision = errors.New("change request was decided concurrently")
+
+type ChangeRequestRepositories interface {
+	CreateChangeRequest(ctx context.Context, cr *modelgorm.ChangeRequestGorm, event *modelgorm.AuditEventGorm) error
+	GetChangeRequest(ctx context.Context, id uint) (modelgorm.ChangeRequestGorm, error)
+	ListChangeRequests(ctx context.Context, status string) ([]modelgorm.ChangeRequestGorm, error)
+	ListExpiredChangeRequests(ctx context.Context, now time.Time) ([]modelgorm.ChangeRequestGorm, error)
+	TransitionChangeRequest(ctx context.Context, cr *modelgorm.ChangeRequestGorm, from string, event *modelgorm.AuditEventGorm) error
+}
+
+type ChangeRequestRepository struct {
+	DB *gorm.DB
+}
+
+func NewChangeRequestRepository(db *gorm.DB) ChangeRequestRepositories {
+	return &ChangeRequestRepository{DB: db}
+}
+
+// CreateChangeRequest stores cr and its audit event in one transaction. The
+// event's EntityID is set to the new request ID.
+func (repo *ChangeRequestRepository) CreateChangeRequest(ctx context.Context, cr *modelgorm.ChangeRequestGorm, event *modelgorm.AuditEventGorm) error {
+	return repo.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(cr).Error; err != nil {
+			return err
+		}
+		event.EntityID = strconv.FormatUint(uint64(cr.ID), 10)
+		return tx.Create(event).Error
+	})
+}
+
+func (repo *ChangeRequestRepository) GetChangeRequest(ctx context.Context, id uint) (modelgorm.ChangeRequestGorm, error) {
+	var cr modelgorm.ChangeRequestGorm
+	err := repo.DB.WithContext(ctx).First(&cr, id).Error
+	return cr, err
+}
+
+func (repo *ChangeRequestRepository) ListChangeRequests(ctx context.Context, status string) ([]modelgorm.ChangeRequestGorm, error) {
+	query := repo.DB.WithContext(ctx).Order("id DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var crs []modelgorm.ChangeRequestGorm
+	if err := query.Find(&crs).Error; err != nil {
+		return nil, err
+	}
+	return crs, nil
+}
+
+func (repo *ChangeRequestRepository) ListExpiredChangeRequests(ctx context.Context, now time.Time) ([]modelgorm.ChangeRequestGorm, error) {
+	var crs []modelgorm.ChangeRequestGorm
+	err := repo.DB.WithContext(ctx).Where("status = ? AND expires_at < ?", modelgorm.ChangePending, now).
+		Order("id").
+		Find(&crs).Error
+	if err != nil {
+		return nil, err
+	}
+	return crs, nil
+}
+
+// TransitionChangeRequest saves the decision fields of cr only if it is still
+// in status from, and records the audit event in the same transaction.
+func (repo *ChangeRequestRepository) TransitionChangeRequest(ctx context.Context, cr *modelgorm.ChangeRequestGorm, from string, event *modelgorm.AuditEventGorm) error {
+	return repo.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&modelgorm.ChangeRequestGorm{}).
+			Where("id = ? AND status = ?", cr.ID, from).
+			Updates(map[string]interface{}{
+				"status":         cr.Status,
+				"reviewed_by":    cr.ReviewedBy,
+				"review_comment": cr.ReviewComment,
+				"apply_error":    cr.ApplyError,
+				"decided_at":     cr.DecidedAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrConcurrentDecision
+		}
+		return tx.Create(event).Error
+	})
+}
@@ -0,0 +1,91 @@
+package approval
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func newMockRepository(t *testing.T) (ChangeRequestRepositories, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB, PreferSimpleProtocol: true}), &gorm.Config{})
+	require.NoError(t, err)
+	return NewChangeRequestRepository(db), mock
+}
+
+func TestCreateChangeRequest_Repository(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "change_request_gorms"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery(`INSERT INTO "audit_event_gorms"`).
+		WithArgs("alice", "change_request.submitted", "change_request", "7", "", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	cr := modelgorm.ChangeRequestGorm{Kind: KindAllowanceSettings, Payload: "{}", Status: modelgorm.ChangePending, SubmittedBy: "alice"}
+	event := modelgorm.AuditEventGorm{Actor: "alice", Action: "change_request.submitted", Entity: "change_request"}
+	err := repo.CreateChangeRequest(context.Background(), &cr, &event)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), cr.ID)
+	assert.Equal(t, "7", event.EntityID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTransitionChangeRequest_Repository(t *testing.T) {
+	decidedAt := time.Now()
+	cr := modelgorm.ChangeRequestGorm{ID: 7, Status: modelgorm.ChangeApproved, ReviewedBy: "bob", ReviewComment: "ok", DecidedAt: &decidedAt}
+	event := modelgorm.AuditEventGorm{Actor: "bob", Action: "change_request.approved", Entity: "change_request", EntityID: "7"}
+
+	t.Run("Success", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "change_request_gorms" SET .* WHERE id = \$\d+ AND status = \$\d+`).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectQuery(`INSERT INTO "audit_event_gorms"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+		mock.ExpectCommit()
+
+		err := repo.TransitionChangeRequest(context.Background(), &cr, modelgorm.ChangePending, &event)
+
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		repo, mock := newMockRepository(t)
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE "change_request_gorms"`).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		err := repo.TransitionChangeRequest(context.Background(), &cr, modelgorm.ChangePending, &event)
+
+		assert.ErrorIs(t, err, ErrConcurrentDecision)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestListExpiredChangeRequests_Repository(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectQuery(`SELECT \* FROM "change_request_gorms" WHERE status = \$1 AND expires_at < \$2 ORDER BY id`).
+		WithArgs(modelgorm.ChangePending, now).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(3, modelgorm.ChangePending))
+
+	crs, err := repo.ListExpiredChangeRequests(context.Background(), now)
+
+	assert.NoError(t, err)
+	assert.Len(t, crs, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,346 @@
+package approval
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/audit"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	KindAllowanceSettings  = "allowance-settings"
+	KindScheduleActivation = "schedule-activation"
+
+	DefaultTTL = 72 * time.Hour
+
+	auditEntity = "change_request"
+	systemActor = "system"
+)
+
+var (
+	ErrInvalidChangeRequest  = errors.New("invalid change request")
+	ErrChangeRequestNotFound = errors.New("change request not found")
+	ErrNotPending            = errors.New("change request is not pending")
+	ErrSelfApproval          = errors.New("change request cannot be decided by its submitter")
+	ErrChangeRequestExpired  = errors.New("change request has expired")
+)
+
+type ChangeRequestServices interface {
+	Submit(ctx context.Context, submitter string, submission model.ChangeRequestSubmission) (model.ChangeRequest, error)
+	GetChangeRequest(ctx context.Context, id uint) (model.ChangeRequest, error)
+	ListChangeRequests(ctx context.Context, status string) ([]model.ChangeRequest, error)
+	Approve(ctx context.Context, id uint, reviewer, comment string) (model.ChangeRequest, error)
+	Reject(ctx context.Context, id uint, reviewer, comment string) (model.ChangeRequest, error)
+	ExpirePending(ctx context.Context) (int, error)
+}
+
+type ChangeRequestService struct {
+	Repo       ChangeRequestRepositories
+	TaxService tax.TaxServices
+	TTL        time.Duration
+	now        func() time.Time
+}
+
+func NewChangeRequestService(repo ChangeRequestRepositories, taxService tax.TaxServices, ttl time.Duration) ChangeRequestServices {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	return &ChangeRequestService{Repo: repo, TaxService: taxService, TTL: ttl, now: time.Now}
+}
+
+// payload is what gets stored for a request; only the fields for its kind
+// are set.
+type payload struct {
+	Settings   []model.AllowanceSettingUpdate `json:"settings,omitempty"`
+	TaxYear    int                            `json:"taxYear,omitempty"`
+	ScheduleID uint                           `json:"scheduleId,omitempty"`
+	Reason     string                         `json:"reason,omitempty"`
+}
+
+func decodePayload(cr modelgorm.ChangeRequestGorm) (payload, error) {
+	var p payload
+	if err := json.Unmarshal([]byte(cr.Payload), &p); err != nil {
+		return payload{}, fmt.Errorf("failed to decode change request %d: %w", cr.ID, err)
+	}
+	return p, nil
+}
+
+func toChangeRequest(cr modelgorm.ChangeRequestGorm) (model.ChangeRequest, error) {
+	p, err := decodePayload(cr)
+	if err != nil {
+		return model.ChangeRequest{}, err
+	}
+	return model.ChangeRequest{
+		ID:            cr.ID,
+		Kind:          cr.Kind,
+		Status:        cr.Status,
+		Settings:      p.Settings,
+		TaxYear:       p.TaxYear,
+		ScheduleID:    p.ScheduleID,
+		Reason:        p.Reason,
+		SubmittedBy:   cr.SubmittedBy,
+		ReviewedBy:    cr.ReviewedBy,
+		ReviewComment: cr.ReviewComment,
+		ApplyError:    cr.ApplyError,
+		CreatedAt:     cr.CreatedAt,
+		ExpiresAt:     cr.ExpiresAt,
+		DecidedAt:     cr.DecidedAt,
+	}, nil
+}
+
+// validate checks the proposed change against the current configuration so
+// that approvers only ever see changes that could be applied.
+func (service *ChangeRequestService) validate(ctx context.Context, submission model.ChangeRequestSubmission) (payload, error) {
+	p := payload{Reason: strings.TrimSpace(submission.Reason)}
+
+	switch submission.Kind {
+	case KindAllowanceSettings:
+		if err := service.TaxService.ValidateAllowanceSettings(submission.Settings); err != nil {
+			return payload{}, fmt.Errorf("%w: %w", ErrInvalidChangeRequest, err)
+		}
+		p.Settings = submission.Settings
+	case KindScheduleActivation:
+		schedule, err := service.TaxService.GetTaxSchedule(ctx, submission.TaxYear, submission.ScheduleID)
+		if err != nil {
+			return payload{}, fmt.Errorf("%w: %v", ErrInvalidChangeRequest, err)
+		}
+		if schedule.Status != modelgorm.ScheduleProposed {
+			return payload{}, fmt.Errorf("%w: schedule %d is %s, not %s", ErrInvalidChangeRequest, schedule.ID, schedule.Status, modelgorm.ScheduleProposed)
+		}
+		p.TaxYear = submission.TaxYear
+		p.ScheduleID = submission.ScheduleID
+	default:
+		return payload{}, fmt.Errorf("%w: unknown kind %q", ErrInvalidChangeRequest, submission.Kind)
+	}
+	return p, nil
+}
+
+func (service *ChangeRequestService) Submit(ctx context.Context, submitter string, submission model.ChangeRequestSubmission) (model.ChangeRequest, error) {
+	p, err := service.validate(ctx, submission)
+	if err != nil {
+		return model.ChangeRequest{}, err
+	}
+
+	encoded, err := json.Marshal(p)
+	if err != nil {
+		return model.ChangeRequest{}, fmt.Errorf("failed to encode change request: %w", err)
+	}
+
+	now := service.now()
+	cr := modelgorm.ChangeRequestGorm{
+		Kind:        submission.Kind,
+		Payload:     string(encoded),
+		Status:      modelgorm.ChangePending,
+		SubmittedBy: submitter,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(service.TTL),
+	}
+	event, err := audit.NewEvent(submitter, "change_request.submitted", auditEntity, "", p)
+	if err != nil {
+		return model.ChangeRequest{}, err
+	}
+	if err := service.Repo.CreateChangeRequest(ctx, &cr, &event); err != nil {
+		return model.ChangeRequest{}, fmt.Errorf("failed to create change request: %w", err)
+	}
+	return toChangeRequest(cr)
+}
+
+func (service *ChangeRequestService) load(ctx context.Context, id uint) (modelgorm.ChangeRequestGorm, error) {
+	cr, err := service.Repo.GetChangeRequest(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return modelgorm.ChangeRequestGorm{}, fmt.Errorf("%w: %d", ErrChangeRequestNotFound, id)
+	}
+	if err != nil {
+		return modelgorm.ChangeRequestGorm{}, fmt.Errorf("failed to get change request: %w", err)
+	}
+	return cr, nil
+}
+
+func (service *ChangeRequestService) GetChangeRequest(ctx context.Context, id uint) (model.ChangeRequest, error) {
+	cr, err := service.load(ctx, id)
+	if err != nil {
+		return model.ChangeRequest{}, err
+	}
+	return toChangeRequest(cr)
+}
+
+func (service *ChangeRequestService) ListChangeRequests(ctx context.Context, status string) ([]model.ChangeRequest, error) {
+	crs, err := service.Repo.ListChangeRequests(ctx, status)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list change requests: %w", err)
+	}
+
+	result := make([]model.ChangeRequest, 0, len(crs))
+	for _, cr := range crs {
+		view, err := toChangeRequest(cr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, view)
+	}
+	return result, nil
+}
+
+// decide moves a pending request into status on behalf of reviewer. A request
+// found past its expiry is expired instead.
+func (service *ChangeRequestService) decide(ctx context.Context, id uint, reviewer, comment, status string) (modelgorm.ChangeRequestGorm, error) {
+	comment = strings.TrimSpace(comment)
+	if comment == "" {
+		return modelgorm.ChangeRequestGorm{}, fmt.Errorf("%w: comment is required", ErrInvalidChangeRequest)
+	}
+
+	cr, err := service.load(ctx, id)
+	if err != nil {
+		return modelgorm.ChangeRequestGorm{}, err
+	}
+	if cr.Status != modelgorm.ChangePending {
+		return modelgorm.ChangeRequestGorm{}, fmt.Errorf("%w: %d is %s", ErrNotPending, id, cr.Status)
+	}
+	if cr.SubmittedBy == reviewer {
+		return modelgorm.ChangeRequestGorm{}, ErrSelfApproval
+	}
+
+	now := service.now()
+	if now.After(cr.ExpiresAt) {
+		if err := service.expire(ctx, &cr, now); err != nil {
+			return modelgorm.ChangeRequestGorm{}, err
+		}
+		return modelgorm.ChangeRequestGorm{}, fmt.Errorf("%w: %d", ErrChangeRequestExpired, id)
+	}
+
+	cr.Status = status
+	cr.ReviewedBy = reviewer
+	cr.ReviewComment = comment
+	cr.DecidedAt = &now
+	event, err := audit.NewEvent(reviewer, "change_request."+status, auditEntity, strconv.FormatUint(uint64(id), 10), map[string]string{"comment": comment})
+	if err != nil {
+		return modelgorm.ChangeRequestGorm{}, err
+	}
+	if err := service.transition(ctx, &cr, modelgorm.ChangePending, &event); err != nil {
+		return modelgorm.ChangeRequestGorm{}, err
+	}
+	return cr, nil
+}
+
+func (service *ChangeRequestService) transition(ctx context.Context, cr *modelgorm.ChangeRequestGorm, from string, event *modelgorm.AuditEventGorm) error {
+	err := service.Repo.TransitionChangeRequest(ctx, cr, from, event)
+	if errors.Is(err, ErrConcurrentDecision) {
+		return fmt.Errorf("%w: %d was updated concurrently", ErrNotPending, cr.ID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update change request: %w", err)
+	}
+	return nil
+}
+
+// Approve records the approval and then applies the change. A change that
+// fails to apply is marked failed with the reason, and the error returned.
+func (service *ChangeRequestService) Approve(ctx context.Context, id uint, reviewer, comment string) (model.ChangeRequest, error) {
+	cr, err := service.decide(ctx, id, reviewer, comment, modelgorm.ChangeApproved)
+	if err != nil {
+		return model.ChangeRequest{}, err
+	}
+
+	// The approval is committed; a client going away now must not leave it
+	// approved but never applied.
+	ctx = context.WithoutCancel(ctx)
+	if applyErr := service.apply(ctx, cr); applyErr != nil {
+		cr.Status = modelgorm.ChangeFailed
+		cr.ApplyError = applyErr.Error()
+		event, err := audit.NewEvent(systemActor, "change_request.failed", auditEntity, strconv.FormatUint(uint64(id), 10), map[string]string{"error": cr.ApplyError})
+		if err != nil {
+			return model.ChangeRequest{}, err
+		}
+		if err := service.transition(ctx, &cr, modelgorm.ChangeApproved, &event); err != nil {
+			log.Printf("failed to mark change request %d as failed: %v", id, err)
+		}
+		return model.ChangeRequest{}, fmt.Errorf("failed to apply change request %d: %w", id, applyErr)
+	}
+	return toChangeRequest(cr)
+}
+
+func (service *ChangeRequestService) apply(ctx context.Context, cr modelgorm.ChangeRequestGorm) error {
+	p, err := decodePayload(cr)
+	if err != nil {
+		return err
+	}
+
+	switch cr.Kind {
+	case KindAllowanceSettings:
+		_, err = service.TaxService.UpdateAllowanceSettings(ctx, p.Settings)
+	case KindScheduleActivation:
+		_, err = service.TaxService.ActivateTaxSchedule(ctx, p.TaxYear, p.ScheduleID)
+	default:
+		err = fmt.Errorf("unknown kind %q", cr.Kind)
+	}
+	return err
+}
+
+func (service *ChangeRequestService) Reject(ctx context.Context, id uint, reviewer, comment string) (model.ChangeRequest, error) {
+	cr, err := service.decide(ctx, id, reviewer, comment, modelgorm.ChangeRejected)
+	if err != nil {
+		return model.ChangeRequest{}, err
+	}
+	return toChangeRequest(cr)
+}
+
+func (service *ChangeRequestService) expire(ctx context.Context, cr *modelgorm.ChangeRequestGorm, now time.Time) error {
+	cr.Status = modelgorm.ChangeExpired
+	cr.DecidedAt = &now
+	event, err := audit.NewEvent(systemActor, "change_request.expired", auditEntity, strconv.FormatUint(uint64(cr.ID), 10), nil)
+	if err != nil {
+		return err
+	}
+	return service.transition(ctx, cr, modelgorm.ChangePending, &event)
+}
+
+// ExpirePending expires every pending request past its deadline and returns
+// how many were expired.
+func (service *ChangeRequestService) ExpirePending(ctx context.Context) (int, error) {
+	now := service.now()
+	crs, err := service.Repo.ListExpiredChangeRequests(ctx, now)
+	if err != nil {
+		return 0, fmt.Errorf("failed to list expired change requests: %w", err)
+	}
+
+	expired := 0
+	for i := range crs {
+		err := service.expire(ctx, &crs[i], now)
+		if errors.Is(err, ErrNotPending) {
+			continue
+		}
+		if err != nil {
+			return expired, err
+		}
+		expired++
+	}
+	return expired, nil
+}
+
+// RunExpiry calls ExpirePending every interval until ctx is cancelled.
+func RunExpiry(ctx context.Context, service ChangeRequestServices, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if n, err := service.ExpirePending(ctx); err != nil {
+				log.Printf("change request expiry failed: %v", err)
+			} else if n > 0 {
+				log.Printf("expired %d change requests", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package approval
+
+import (
+	"context"
+	"errors"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) CreateChangeRequest(ctx context.Context, cr *modelgorm.ChangeRequestGorm, event *modelgorm.AuditEventGorm) error {
+	args := m.Called(cr, event)
+	return args.Error(0)
+}
+
+func (m *MockRepo) GetChangeRequest(ctx context.Context, id uint) (modelgorm.ChangeRequestGorm, error) {
+	args := m.Called(id)
+	return args.Get(0).(modelgorm.ChangeRequestGorm), args.Error(1)
+}
+
+func (m *MockRepo) ListChangeRequests(ctx context.Context, status string) ([]modelgorm.ChangeRequestGorm, error) {
+	args := m.Called(status)
+	return args.Get(0).([]modelgorm.ChangeRequestGorm), args.Error(1)
+}
+
+func (m *MockRepo) ListExpiredChangeRequests(ctx context.Context, now time.Time) ([]modelgorm.ChangeRequestGorm, error) {
+	args := m.Called(now)
+	return args.Get(0).([]modelgorm.ChangeRequestGorm), args.Error(1)
+}
+
+func (m *MockRepo) TransitionChangeRequest(ctx context.Context, cr *modelgorm.ChangeRequestGorm, from string, event *modelgorm.AuditEventGorm) error {
+	args := m.Called(cr, from, event)
+	return args.Error(0)
+}
+
+// MockTaxService only implements the methods the approval workflow calls.
+type MockTaxService struct {
+	tax.TaxServices
+	mock.Mock
+}
+
+func (m *MockTaxService) ValidateAllowanceSettings(updates []model.AllowanceSettingUpdate) error {
+	args := m.Called(updates)
+	return args.Error(0)
+}
+
+func (m *MockTaxService) UpdateAllowanceSettings(ctx context.Context, updates []model.AllowanceSettingUpdate) ([]model.AllowanceSetting, error) {
+	args := m.Called(updates)
+	return args.Get(0).([]model.AllowanceSetting), args.Error(1)
+}
+
+func (m *MockTaxService) GetTaxSchedule(ctx context.Context, taxYear int, id uint) (model.TaxSchedule, error) {
+	args := m.Called(taxYear, id)
+	return args.Get(0).(model.TaxSchedule), args.Error(1)
+}
+
+func (m *MockTaxService) ActivateTaxSchedule(ctx context.Context, taxYear int, id uint) (model.TaxSchedule, error) {
+	args := m.Called(taxYear, id)
+	return args.Get(0).(model.TaxSchedule), args.Error(1)
+}
+
+var testNow = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func newTestService(repo *MockRepo, taxService *MockTaxService) *ChangeRequestService {
+	return &ChangeRequestService{Repo: repo, TaxService: taxService, TTL: time.Hour, now: func() time.Time { return testNow }}
+}
+
+var personalUpdate = []model.AllowanceSettingUpdate{{Key: modelgorm.PersonalDefault, Amount: 70000}}
+
+func pendingRequest() modelgorm.ChangeRequestGorm {
+	return modelgorm.ChangeRequestGorm{
+		ID:          7,
+		Kind:        KindAllowanceSettings,
+		Payload:     `{"settings":[{"key":"PersonalDefault","amount":70000}]}`,
+		Status:      modelgorm.ChangePending,
+		SubmittedBy: "alice",
+		CreatedAt:   testNow.Add(-time.Minute),
+		ExpiresAt:   testNow.Add(time.Hour),
+	}
+}
+
+func eventWithAction(action string) interface{} {
+	return mock.MatchedBy(func(event *modelgorm.AuditEventGorm) bool { return event.Action == action })
+}
+
+func TestSubmit_AllowanceSettings(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockTax := new(MockTaxService)
+	mockTax.On("ValidateAllowanceSettings", personalUpdate).Return(nil)
+	mockRepo.On("CreateChangeRequest", mock.MatchedBy(func(cr *modelgorm.ChangeRequestGorm) bool {
+		return cr.Status == modelgorm.ChangePending && cr.SubmittedBy == "alice" && cr.ExpiresAt.Equal(testNow.Add(time.Hour))
+	}), eventWithAction("change_request.submitted")).Return(nil)
+	service := newTestService(mockRepo, mockTax)
+
+	cr, err := service.Submit(context.Background(), "alice", model.ChangeRequestSubmission{Kind: KindAllowanceSettings, Settings: personalUpdate, Reason: " budget "})
+
+	assert.NoError(t, err)
+	assert.Equal(t, personalUpdate, cr.Settings)
+	assert.Equal(t, "budget", cr.Reason)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestSubmit_Invalid(t *testing.T) {
+	tests := []struct {
+		name       string
+		submission model.ChangeRequestSubmission
+		setup      func(m *MockTaxService)
+	}{
+		{"UnknownKind", model.ChangeRequestSubmission{Kind: "delete-everything"}, func(m *MockTaxService) {}},
+		{"InvalidSettings", model.ChangeRequestSubmission{Kind: KindAllowanceSettings, Settings: personalUpdate}, func(m *MockTaxService) {
+			m.On("ValidateAllowanceSettings", personalUpdate).Return(tax.ErrAllowanceOutOfRange)
+		}},
+		{"ScheduleNotProposed", model.ChangeRequestSubmission{Kind: KindScheduleActivation, TaxYear: 2567, ScheduleID: 2}, func(m *MockTaxService) {
+			m.On("GetTaxSchedule", 2567, uint(2)).Return(model.TaxSchedule{ID: 2, Status: modelgorm.ScheduleActive}, nil)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockTax := new(MockTaxService)
+			tt.setup(mockTax)
+			service := newTestService(new(MockRepo), mockTax)
+
+			_, err := service.Submit(context.Background(), "alice", tt.submission)
+
+			assert.ErrorIs(t, err, ErrInvalidChangeRequest)
+		})
+	}
+}
+
+func TestApprove_AppliesChange(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockTax := new(MockTaxService)
+	mockRepo.On("GetChangeRequest", uint(7)).Return(pendingRequest(), nil)
+	mockRepo.On("TransitionChangeRequest", mock.MatchedBy(func(cr *modelgorm.ChangeRequestGorm) bool {
+		return cr.Status == modelgorm.ChangeApproved && cr.ReviewedBy == "bob" && cr.ReviewComment == "looks right"
+	}), modelgorm.ChangePending, eventWithAction("change_request.approved")).Return(nil)
+	mockTax.On("UpdateAllowanceSettings", personalUpdate).Return([]model.AllowanceSetting{}, nil)
+	service := newTestService(mockRepo, mockTax)
+
+	cr, err := service.Approve(context.Background(), 7, "bob", "looks right")
+
+	assert.NoError(t, err)
+	assert.Equal(t, modelgorm.ChangeApproved, cr.Status)
+	mockTax.AssertExpectations(t)
+}
+
+func TestApprove_ApplyFailure(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockTax := new(MockTaxService)
+	mockRepo.On("GetChangeRequest", uint(7)).Return(pendingRequest(), nil)
+	mockRepo.On("TransitionChangeRequest", mock.Anything, modelgorm.ChangePending, eventWithAction("change_request.approved")).Return(nil)
+	mockRepo.On("TransitionChangeRequest", mock.MatchedBy(func(cr *modelgorm.ChangeRequestGorm) bool {
+		return cr.Status == modelgorm.ChangeFailed && cr.ApplyError == "db down"
+	}), modelgorm.ChangeApproved, eventWithAction("change_request.failed")).Return(nil)
+	mockTax.On("UpdateAllowanceSettings", personalUpdate).Return([]model.AllowanceSetting(nil), errors.New("db down"))
+	service := newTestService(mockRepo, mockTax)
+
+	_, err := service.Approve(context.Background(), 7, "bob", "looks right")
+
+	assert.ErrorContains(t, err, "failed to apply change request 7")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestDecide_Rejections(t *testing.T) {
+	expired := pendingRequest()
+	expired.ExpiresAt = testNow.Add(-time.Minute)
+	rejected := pendingRequest()
+	rejected.Status = modelgorm.ChangeRejected
+
+	tests := []struct {
+		name     string
+		reviewer string
+		comment  string
+		stored   modelgorm.ChangeRequestGorm
+		err      error
+		want     error
+	}{
+		{"MissingComment", "bob", " ", pendingRequest(), nil, ErrInvalidChangeRequest},
+		{"NotFound", "bob", "ok", modelgorm.ChangeRequestGorm{}, gorm.ErrRecordNotFound, ErrChangeRequestNotFound},
+		{"SelfApproval", "alice", "ok", pendingRequest(), nil, ErrSelfApproval},
+		{"NotPending", "bob", "ok", rejected, nil, ErrNotPending},
+		{"Expired", "bob", "ok", expired, nil, ErrChangeRequestExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			mockRepo.On("GetChangeRequest", uint(7)).Return(tt.stored, tt.err)
+			mockRepo.On("TransitionChangeRequest", mock.MatchedBy(func(cr *modelgorm.ChangeRequestGorm) bool {
+				return cr.Status == modelgorm.ChangeExpired
+			}), modelgorm.ChangePending, eventWithAction("change_request.expired")).Return(nil)
+			service := newTestService(mockRepo, new(MockTaxService))
+
+			_, err := service.Approve(context.Background(), 7, tt.reviewer, tt.comment)
+
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestReject(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetChangeRequest", uint(7)).Return(pendingRequest(), nil)
+	mockRepo.On("TransitionChangeRequest", mock.Anything, modelgorm.ChangePending, eventWithAction("change_request.rejected")).Return(nil)
+	service := newTestService(mockRepo, new(MockTaxService))
+
+	cr, err := service.Reject(context.Background(), 7, "bob", "not this quarter")
+
+	assert.NoError(t, err)
+	assert.Equal(t, modelgorm.ChangeRejected, cr.Status)
+	assert.Equal(t, "not this quarter", cr.ReviewComment)
+}
+
+func TestExpirePending(t *testing.T) {
+	mockRepo := new(MockRepo)
+	first, second := pendingRequest(), pendingRequest()
+	second.ID = 8
+	mockRepo.On("ListExpiredChangeRequests", testNow).Return([]modelgorm.ChangeRequestGorm{first, second}, nil)
+	mockRepo.On("TransitionChangeRequest", mock.MatchedBy(func(cr *modelgorm.ChangeRequestGorm) bool { return cr.ID == 7 }), modelgorm.ChangePending, mock.Anything).Return(nil)
+	mockRepo.On("TransitionChangeRequest", mock.MatchedBy(func(cr *modelgorm.ChangeRequestGorm) bool { return cr.ID == 8 }), modelgorm.ChangePending, mock.Anything).Return(ErrConcurrentDecision)
+	service := newTestService(mockRepo, new(MockTaxService))
+
+	n, err := service.ExpirePending(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
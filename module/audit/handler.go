@@ -0,0 +1,38 @@
+package audit
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/problem"
+	"net/http"
+	"strconv"
+)
+
+type AuditHandler struct {
+	AuditService AuditServices
+}
+
+func NewAuditHandler(service AuditServices) *AuditHandler {
+	return &AuditHandler{AuditService: service}
+}
+
+func (h *AuditHandler) ListEvents(c echo.Context) error {
+	filter := model.AuditFilter{
+		Entity:   c.QueryParam("entity"),
+		EntityID: c.QueryParam("entityId"),
+	}
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "limit", "limit must be a positive integer")
+		}
+		filter.Limit = n
+	}
+
+	events, err := h.AuditService.ListEvents(filter)
+	if err != nil {
+		return problem.Internal(c, "Failed to list audit events", err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"events": events})
+}
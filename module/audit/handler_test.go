@@ -0,0 +1,54 @@
+package audit
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type MockAuditService struct {
+	mock.Mock
+}
+
+func (m *MockAuditService) Record(actor, action, entity, entityID string, detail interface{}) error {
+	args := m.Called(actor, action, entity, entityID, detail)
+	return args.Error(0)
+}
+
+func (m *MockAuditService) ListEvents(filter model.AuditFilter) ([]model.AuditEvent, error) {
+	args := m.Called(filter)
+	return args.Get(0).([]model.AuditEvent), args.Error(1)
+}
+
+func TestAuditHandler_ListEvents(t *testing.T) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/?entity=change_request&entityId=3&limit=5", nil), rec)
+
+	mockService := new(MockAuditService)
+	mockService.On("ListEvents", model.AuditFilter{Entity: "change_request", EntityID: "3", Limit: 5}).
+		Return([]model.AuditEvent{{ID: 1, Action: "change_request.approved"}}, nil)
+
+	h := &AuditHandler{AuditService: mockService}
+
+	if assert.NoError(t, h.ListEvents(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "change_request.approved")
+	}
+}
+
+func TestAuditHandler_ListEvents_InvalidLimit(t *testing.T) {
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/?limit=abc", nil), rec)
+
+	h := &AuditHandler{AuditService: new(MockAuditService)}
+
+	if assert.NoError(t, h.ListEvents(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+}
@@ -0,0 +1,40 @@
+package audit
+
+import (
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+)
+
+type AuditRepositories interface {
+	CreateEvent(event *modelgorm.AuditEventGorm) error
+	ListEvents(filter model.AuditFilter) ([]modelgorm.AuditEventGorm, error)
+}
+
+type AuditRepository struct {
+	DB *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) AuditRepositories {
+	return &AuditRepository{DB: db}
+}
+
+func (repo *AuditRepository) CreateEvent(event *modelgorm.AuditEventGorm) error {
+	return repo.DB.Create(event).Error
+}
+
+func (repo *AuditRepository) ListEvents(filter model.AuditFilter) ([]modelgorm.AuditEventGorm, error) {
+	query := repo.DB.Order("id DESC").Limit(filter.Limit)
+	if filter.Entity != "" {
+		query = query.Where("entity = ?", filter.Entity)
+	}
+	if filter.EntityID != "" {
+		query = query.Where("entity_id = ?", filter.EntityID)
+	}
+
+	var events []modelgorm.AuditEventGorm
+	if err := query.Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
@@ -0,0 +1,29 @@
+package audit
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestListEvents_Repository(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB, PreferSimpleProtocol: true}), &gorm.Config{})
+	require.NoError(t, err)
+	repo := NewAuditRepository(db)
+
+	mock.ExpectQuery(`SELECT \* FROM "audit_event_gorms" WHERE entity = \$1 AND entity_id = \$2 ORDER BY id DESC LIMIT \$3`).
+		WithArgs("change_request", "3", 10).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "actor", "action"}).AddRow(2, "bob", "change_request.approved"))
+
+	events, err := repo.ListEvents(model.AuditFilter{Entity: "change_request", EntityID: "3", Limit: 10})
+
+	assert.NoError(t, err)
+	assert.Len(t, events, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,88 @@
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/store/model"
+	"time"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+type AuditServices interface {
+	Record(actor, action, entity, entityID string, detail interface{}) error
+	ListEvents(filter model.AuditFilter) ([]model.AuditEvent, error)
+}
+
+type AuditService struct {
+	Repo AuditRepositories
+}
+
+func NewAuditService(repo AuditRepositories) AuditServices {
+	return &AuditService{Repo: repo}
+}
+
+// NewEvent builds an audit event with detail encoded as JSON. Repositories
+// that must audit inside their own transaction insert it themselves.
+func NewEvent(actor, action, entity, entityID string, detail interface{}) (modelgorm.AuditEventGorm, error) {
+	event := modelgorm.AuditEventGorm{
+		Actor:     actor,
+		Action:    action,
+		Entity:    entity,
+		EntityID:  entityID,
+		CreatedAt: time.Now(),
+	}
+	if detail != nil {
+		encoded, err := json.Marshal(detail)
+		if err != nil {
+			return modelgorm.AuditEventGorm{}, fmt.Errorf("failed to encode audit detail: %w", err)
+		}
+		event.Detail = string(encoded)
+	}
+	return event, nil
+}
+
+// Record appends an event to the audit log.
+func (service *AuditService) Record(actor, action, entity, entityID string, detail interface{}) error {
+	event, err := NewEvent(actor, action, entity, entityID, detail)
+	if err != nil {
+		return err
+	}
+
+	if err := service.Repo.CreateEvent(&event); err != nil {
+		return fmt.Errorf("failed to record audit event: %w", err)
+	}
+	return nil
+}
+
+func (service *AuditService) ListEvents(filter model.AuditFilter) ([]model.AuditEvent, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = defaultListLimit
+	}
+	if filter.Limit > maxListLimit {
+		filter.Limit = maxListLimit
+	}
+
+	events, err := service.Repo.ListEvents(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list audit events: %w", err)
+	}
+
+	result := make([]model.AuditEvent, len(events))
+	for i, event := range events {
+		result[i] = model.AuditEvent{
+			ID:        event.ID,
+			Actor:     event.Actor,
+			Action:    event.Action,
+			Entity:    event.Entity,
+			EntityID:  event.EntityID,
+			Detail:    event.Detail,
+			CreatedAt: event.CreatedAt,
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"errors"
+	"github.com/pphee/assessment-tax/internal/model"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"testing"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) CreateEvent(event *modelgorm.AuditEventGorm) error {
+	args := m.Called(event)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ListEvents(filter model.AuditFilter) ([]modelgorm.AuditEventGorm, error) {
+	args := m.Called(filter)
+	return args.Get(0).([]modelgorm.AuditEventGorm), args.Error(1)
+}
+
+func TestRecord(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("CreateEvent", mock.MatchedBy(func(event *modelgorm.AuditEventGorm) bool {
+		return event.Actor == "alice" && event.Action == "change_request.submitted" &&
+			event.Entity == "change_request" && event.EntityID == "7" &&
+			event.Detail == `{"reason":"budget"}` && !event.CreatedAt.IsZero()
+	})).Return(nil)
+	service := NewAuditService(mockRepo)
+
+	err := service.Record("alice", "change_request.submitted", "change_request", "7", map[string]string{"reason": "budget"})
+
+	assert.NoError(t, err)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRecord_Error(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("CreateEvent", mock.Anything).Return(errors.New("disk full"))
+	service := NewAuditService(mockRepo)
+
+	err := service.Record("alice", "login", "admin_user", "alice", nil)
+
+	assert.ErrorContains(t, err, "failed to record audit event")
+}
+
+func TestListEvents_Limits(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		want  int
+	}{
+		{"Default", 0, defaultListLimit},
+		{"Capped", 5000, maxListLimit},
+		{"Given", 10, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			mockRepo.On("ListEvents", model.AuditFilter{Entity: "change_request", Limit: tt.want}).
+				Return([]modelgorm.AuditEventGorm{{ID: 1, Actor: "alice"}}, nil)
+			service := NewAuditService(mockRepo)
+
+			events, err := service.ListEvents(model.AuditFilter{Entity: "change_request", Limit: tt.limit})
+
+			assert.NoError(t, err)
+			assert.Equal(t, "alice", events[0].Actor)
+		})
+	}
+}
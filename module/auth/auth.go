@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/problem"
+	"net/http"
+)
+
+type Role string
+
+const (
+	RoleViewer    Role = "viewer"
+	RoleEditor    Role = "editor"
+	RoleApprover  Role = "approver"
+	RoleSuperuser Role = "superuser"
+)
+
+var Roles = []Role{RoleViewer, RoleEditor, RoleApprover, RoleSuperuser}
+
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+func (r Role) Valid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type Principal struct {
+	Username string
+	Role     Role
+}
+
+func (p Principal) HasRole(roles ...Role) bool {
+	if p.Role == RoleSuperuser {
+		return true
+	}
+	for _, role := range roles {
+		if p.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+// CanSeePersonalData reports whether unmasked national IDs may be shown to
+// the principal.
+func (p Principal) CanSeePersonalData() bool {
+	return p.HasRole(RoleApprover)
+}
+
+type Authenticator interface {
+	Authenticate(username, password string) (Principal, error)
+}
+
+const principalKey = "auth.principal"
+
+func SetPrincipal(c echo.Context, principal Principal) {
+	c.Set(principalKey, principal)
+}
+
+func PrincipalFrom(c echo.Context) (Principal, bool) {
+	principal, ok := c.Get(principalKey).(Principal)
+	return principal, ok
+}
+
+// CanSeePersonalData is false for anonymous requests.
+func CanSeePersonalData(c echo.Context) bool {
+	principal, ok := PrincipalFrom(c)
+	return ok && principal.CanSeePersonalData()
+}
+
+// RequireRole rejects requests whose principal holds none of the given roles.
+// Superusers are always allowed.
+func RequireRole(roles ...Role) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok := PrincipalFrom(c)
+			if !ok {
+				return problem.RespondMessage(c, http.StatusUnauthorized, "unauthenticated", "", i18n.Msg("problem.unauthenticated"))
+			}
+			if !principal.HasRole(roles...) {
+				return problem.RespondMessage(c, http.StatusForbidden, "forbidden", "", i18n.Msg("problem.forbidden"))
+			}
+			return next(c)
+		}
+	}
+}
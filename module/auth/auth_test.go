@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type MockAuthenticator struct {
+	mock.Mock
+}
+
+func (m *MockAuthenticator) Authenticate(username, password string) (Principal, error) {
+	args := m.Called(username, password)
+	return args.Get(0).(Principal), args.Error(1)
+}
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *Principal
+		want      int
+	}{
+		{"Anonymous", nil, http.StatusUnauthorized},
+		{"Viewer", &Principal{Username: "v", Role: RoleViewer}, http.StatusForbidden},
+		{"Editor", &Principal{Username: "e", Role: RoleEditor}, http.StatusOK},
+		{"Superuser", &Principal{Username: "s", Role: RoleSuperuser}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			c := e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec)
+			if tt.principal != nil {
+				SetPrincipal(c, *tt.principal)
+			}
+
+			handler := RequireRole(RoleEditor)(func(c echo.Context) error {
+				return c.NoContent(http.StatusOK)
+			})
+
+			assert.NoError(t, handler(c))
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestRole_Valid(t *testing.T) {
+	assert.True(t, RoleApprover.Valid())
+	assert.False(t, Role("owner").Valid())
+}
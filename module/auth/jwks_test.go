@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+
+	parsed, err := ParseJWKS(keys.jwks(t))
+
+	require.NoError(t, err)
+	assert.Len(t, parsed, 2)
+	assert.True(t, parsed["rsa-1"].(*rsa.PublicKey).Equal(&keys.rsa.PublicKey))
+	assert.True(t, keys.ec.PublicKey.Equal(parsed["ec-1"]))
+
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"EC","kid":"bad","crv":"P-256","x":"AQ","y":"AQ"}]}`))
+	assert.Error(t, err)
+
+	_, err = ParseJWKS([]byte(`{"keys":[]}`))
+	assert.Error(t, err)
+}
+
+func TestJWKSSource_URLRotation(t *testing.T) {
+	first, second := newTestKeys(t), newTestKeys(t)
+	var served atomic.Pointer[[]byte]
+	doc := first.jwks(t)
+	served.Store(&doc)
+
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(*served.Load())
+	}))
+	defer server.Close()
+
+	source := NewJWKSSource(server.URL)
+	source.MinRefresh = 0
+	require.NoError(t, source.Load())
+
+	_, err := source.Key("rsa-1")
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	rotated := []byte(`{"keys":[{"kty":"RSA","kid":"rsa-2","n":"` + encodeInt(second.rsa.N) + `","e":"AQAB"}]}`)
+	served.Store(&rotated)
+
+	key, err := source.Key("rsa-2")
+	assert.NoError(t, err)
+	assert.True(t, key.(*rsa.PublicKey).Equal(&second.rsa.PublicKey))
+	assert.Equal(t, int32(2), fetches.Load())
+}
+
+func TestJWKSSource_ThrottlesUnknownKid(t *testing.T) {
+	keys := newTestKeys(t)
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		_, _ = w.Write(keys.jwks(t))
+	}))
+	defer server.Close()
+
+	source := NewJWKSSource(server.URL)
+	source.MinRefresh = time.Hour
+
+	for i := 0; i < 3; i++ {
+		_, err := source.Key("missing")
+		assert.ErrorIs(t, err, ErrUnknownKey)
+	}
+	assert.Equal(t, int32(1), fetches.Load())
+}
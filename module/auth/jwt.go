@@ -0,0 +1,150 @@
+package auth
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"log"
+	"strings"
+	"time"
+)
+
+type KeySource interface {
+	Key(kid string) (crypto.PublicKey, error)
+}
+
+// rolePrecedence decides which admin role a token gets when its claims map
+// to several.
+var rolePrecedence = []Role{RoleSuperuser, RoleApprover, RoleEditor, RoleViewer}
+
+// JWTProvider accepts bearer tokens signed by a key from Keys.
+//
+// RoleClaim names a string or string-array claim whose values are looked up
+// in RoleMapping; without a mapping the values are taken as role names.
+// Tokens that map to no role still authenticate, but hold no admin role.
+type JWTProvider struct {
+	Keys          KeySource
+	Issuer        string
+	Audience      string
+	UsernameClaim string
+	RoleClaim     string
+	RoleMapping   map[string]Role
+	Leeway        time.Duration
+}
+
+// ErrIssuerAudienceRequired refuses a provider that would accept tokens
+// meant for any issuer or audience the key set signs for.
+var ErrIssuerAudienceRequired = errors.New("bearer tokens need both an issuer and an audience to check")
+
+func NewJWTProvider(keys KeySource, issuer, audience string) (*JWTProvider, error) {
+	if issuer == "" || audience == "" {
+		return nil, ErrIssuerAudienceRequired
+	}
+	return &JWTProvider{
+		Keys:          keys,
+		Issuer:        issuer,
+		Audience:      audience,
+		UsernameClaim: "sub",
+		RoleClaim:     "roles",
+		Leeway:        30 * time.Second,
+	}, nil
+}
+
+// ParseRoleMapping reads "claimValue=role" pairs separated by commas.
+func ParseRoleMapping(s string) (map[string]Role, error) {
+	mapping := make(map[string]Role)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		value, role, ok := strings.Cut(pair, "=")
+		if !ok || strings.TrimSpace(value) == "" || !Role(strings.TrimSpace(role)).Valid() {
+			return nil, fmt.Errorf("invalid role mapping %q", pair)
+		}
+		mapping[strings.TrimSpace(value)] = Role(strings.TrimSpace(role))
+	}
+	return mapping, nil
+}
+
+func (p *JWTProvider) Scheme() string {
+	return "Bearer"
+}
+
+func (p *JWTProvider) Authenticate(c echo.Context) (Principal, error) {
+	token, ok := authorization(c, "Bearer")
+	if !ok {
+		return Principal{}, ErrNoCredentials
+	}
+
+	principal, err := p.Validate(token)
+	if err != nil {
+		log.Printf("rejected bearer token: %v", err)
+		return Principal{}, ErrInvalidCredentials
+	}
+	return principal, nil
+}
+
+// Validate checks the token's signature, issuer, audience and lifetime and
+// maps its claims to a principal. A provider without an issuer or audience
+// accepts no token.
+func (p *JWTProvider) Validate(tokenString string) (Principal, error) {
+	if p.Issuer == "" || p.Audience == "" {
+		return Principal{}, ErrIssuerAudienceRequired
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(p.Leeway),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.Audience),
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.Keys.Key(kid)
+	}, options...)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	username, _ := claims[p.UsernameClaim].(string)
+	if username == "" {
+		return Principal{}, fmt.Errorf("token has no %q claim", p.UsernameClaim)
+	}
+	return Principal{Username: username, Role: p.role(claims[p.RoleClaim])}, nil
+}
+
+func (p *JWTProvider) role(claim interface{}) Role {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+
+	granted := make(map[Role]bool)
+	for _, value := range values {
+		role := Role(value)
+		if p.RoleMapping != nil {
+			role = p.RoleMapping[value]
+		}
+		if role.Valid() {
+			granted[role] = true
+		}
+	}
+	for _, role := range rolePrecedence {
+		if granted[role] {
+			return role
+		}
+	}
+	return ""
+}
@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://sso.example.test"
+	testAudience = "assessment-tax"
+)
+
+type testKeys struct {
+	rsa *rsa.PrivateKey
+	ec  *ecdsa.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	return testKeys{rsa: rsaKey, ec: ecKey}
+}
+
+func encodeInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func (k testKeys) jwks(t *testing.T) []byte {
+	doc := map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": encodeInt(k.rsa.N), "e": encodeInt(big.NewInt(int64(k.rsa.E)))},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": encodeInt(k.ec.X), "y": encodeInt(k.ec.Y)},
+			{"kty": "oct", "kid": "hmac", "k": "c2VjcmV0"},
+		},
+	}
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+	return data
+}
+
+func (k testKeys) sign(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	var key interface{} = k.rsa
+	if _, ok := method.(*jwt.SigningMethodECDSA); ok {
+		key = k.ec
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "somchai",
+		"iss":   testIssuer,
+		"aud":   testAudience,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"tax-editors"},
+	}
+}
+
+func newTestProvider(t *testing.T, keys testKeys) *JWTProvider {
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, keys.jwks(t), 0o600))
+
+	provider, err := NewJWTProvider(NewJWKSSource(path), testIssuer, testAudience)
+	require.NoError(t, err)
+	provider.RoleMapping = map[string]Role{"tax-editors": RoleEditor, "tax-admins": RoleSuperuser}
+	return provider
+}
+
+func TestJWTProvider_Validate(t *testing.T) {
+	keys := newTestKeys(t)
+	provider := newTestProvider(t, keys)
+
+	with := func(change func(jwt.MapClaims)) jwt.MapClaims {
+		claims := validClaims()
+		change(claims)
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    Principal
+		wantErr bool
+	}{
+		{"RSA", keys.sign(t, jwt.SigningMethodRS256, "rsa-1", validClaims()), Principal{Username: "somchai", Role: RoleEditor}, false},
+		{"EC", keys.sign(t, jwt.SigningMethodES256, "ec-1", validClaims()), Principal{Username: "somchai", Role: RoleEditor}, false},
+		{"Highest Role", keys.sign(t, jwt.SigningMethodRS256, "rsa-1", with(func(c jwt.MapClaims) { c["roles"] = []string{"tax-editors", "tax-admins"} })), Principal{Username: "somchai", Role: RoleSuperuser}, false},
+		{"Unmapped Role", keys.sign(t, jwt.SigningMethodRS256, "rsa-1", with(func(c jwt.MapClaims) { c["roles"] = "staff" })), Principal{Username: "somchai"}, false},
+		{"Wrong Issuer", keys.sign(t, jwt.SigningMethodRS256, "rsa-1", with(func(c jwt.MapClaims) { c["iss"] = "https://evil.test" })), Principal{}, true},
+		{"Wrong Audience", keys.sign(t, jwt.SigningMethodRS256, "rsa-1", with(func(c jwt.MapClaims) { c["aud"] = "other" })), Principal{}, true},
+		{"No Issuer", keys.sign(t, jwt.SigningMethodRS256, "rsa-1", with(func(c jwt.MapClaims) { delete(c, "iss") })), Principal{}, true},
+		{"No Audience", keys.sign(t, jwt.SigningMethodRS256, "rsa-1", with(func(c jwt.MapClaims) { delete(c, "aud") })), Principal{}, true},
+		{"Expired", keys.sign(t, jwt.SigningMethodRS256, "rsa-1", with(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() })), Principal{}, true},
+		{"No Expiry", keys.sign(t, jwt.SigningMethodRS256, "rsa-1", with(func(c jwt.MapClaims) { delete(c, "exp") })), Principal{}, true},
+		{"No Subject", keys.sign(t, jwt.SigningMethodRS256, "rsa-1", with(func(c jwt.MapClaims) { delete(c, "sub") })), Principal{}, true},
+		{"Unknown Key", keys.sign(t, jwt.SigningMethodRS256, "rsa-2", validClaims()), Principal{}, true},
+		{"Key Mismatch", keys.sign(t, jwt.SigningMethodRS256, "ec-1", validClaims()), Principal{}, true},
+		{"Garbage", "not-a-token", Principal{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			principal, err := provider.Validate(tt.token)
+
+			assert.Equal(t, tt.wantErr, err != nil, "error: %v", err)
+			assert.Equal(t, tt.want, principal)
+		})
+	}
+}
+
+func TestNewJWTProvider_RequiresIssuerAndAudience(t *testing.T) {
+	keys := NewJWKSSource("jwks.json")
+
+	for _, tt := range []struct{ name, issuer, audience string }{
+		{"Neither", "", ""},
+		{"No Issuer", "", testAudience},
+		{"No Audience", testIssuer, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewJWTProvider(keys, tt.issuer, tt.audience)
+			assert.ErrorIs(t, err, ErrIssuerAudienceRequired)
+		})
+	}
+}
+
+func TestJWTProvider_ValidateUnconfigured(t *testing.T) {
+	keys := newTestKeys(t)
+	provider := newTestProvider(t, keys)
+	provider.Audience = ""
+
+	_, err := provider.Validate(keys.sign(t, jwt.SigningMethodRS256, "rsa-1", validClaims()))
+
+	assert.ErrorIs(t, err, ErrIssuerAudienceRequired)
+}
+
+func TestJWTProvider_RejectsHMAC(t *testing.T) {
+	keys := newTestKeys(t)
+	provider := newTestProvider(t, keys)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, validClaims())
+	token.Header["kid"] = "hmac"
+	signed, err := token.SignedString([]byte("secret"))
+	require.NoError(t, err)
+
+	_, err = provider.Validate(signed)
+
+	assert.Error(t, err)
+}
+
+func TestJWTProvider_Authenticate(t *testing.T) {
+	keys := newTestKeys(t)
+	provider := newTestProvider(t, keys)
+
+	tests := []struct {
+		name   string
+		header string
+		want   error
+	}{
+		{"Valid", "Bearer " + keys.sign(t, jwt.SigningMethodRS256, "rsa-1", validClaims()), nil},
+		{"Invalid", "Bearer nope", ErrInvalidCredentials},
+		{"Basic", "Basic YWxpY2U6c2VjcmV0", ErrNoCredentials},
+		{"Missing", "", ErrNoCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(echo.HeaderAuthorization, tt.header)
+			}
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			_, err := provider.Authenticate(c)
+
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestParseRoleMapping(t *testing.T) {
+	mapping, err := ParseRoleMapping("tax-admins=superuser, tax-editors=editor")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]Role{"tax-admins": RoleSuperuser, "tax-editors": RoleEditor}, mapping)
+
+	_, err = ParseRoleMapping("tax-admins=root")
+	assert.Error(t, err)
+}
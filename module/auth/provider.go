@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/problem"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoCredentials means a request carries no credentials for a provider,
+// so the next provider should be tried.
+var ErrNoCredentials = errors.New("no credentials for provider")
+
+// LockoutError is returned while a username or source address is locked out
+// after too many failed logins.
+type LockoutError struct {
+	Until time.Time
+}
+
+func (e *LockoutError) Error() string {
+	return fmt.Sprintf("too many failed logins; locked until %s", e.Until.UTC().Format(time.RFC3339))
+}
+
+// LoginGuard tracks failed password logins by username and source address.
+type LoginGuard interface {
+	// Check returns a *LockoutError if either is locked out.
+	Check(username, ip string) error
+	RecordFailure(username, ip string) error
+	RecordSuccess(username string) error
+}
+
+type Provider interface {
+	// Scheme is the authentication scheme used in WWW-Authenticate challenges.
+	Scheme() string
+	Authenticate(c echo.Context) (Principal, error)
+}
+
+// Middleware authenticates requests with the first provider that finds
+// credentials for its scheme and stores the principal on the context. A
+// request with no credentials for any provider is let through if an earlier
+// middleware, such as the API key guard, has already set a principal.
+func Middleware(providers ...Provider) echo.MiddlewareFunc {
+	challenges := make([]string, len(providers))
+	for i, provider := range providers {
+		challenges[i] = provider.Scheme()
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			rejected := false
+			for _, provider := range providers {
+				principal, err := provider.Authenticate(c)
+				if errors.Is(err, ErrNoCredentials) {
+					continue
+				}
+				if errors.Is(err, ErrInvalidCredentials) {
+					rejected = true
+					break
+				}
+				var lockout *LockoutError
+				if errors.As(err, &lockout) {
+					retryAfter := math.Ceil(time.Until(lockout.Until).Seconds())
+					c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Max(retryAfter, 1))))
+					return problem.Respond(c, http.StatusTooManyRequests, "locked_out", lockout.Error())
+				}
+				if err != nil {
+					log.Printf("authentication failed: %v", err)
+					return problem.Respond(c, http.StatusInternalServerError, problem.CodeInternal, "Authentication failed")
+				}
+				SetPrincipal(c, principal)
+				return next(c)
+			}
+			if _, ok := PrincipalFrom(c); ok && !rejected {
+				return next(c)
+			}
+
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, strings.Join(challenges, ", "))
+			return problem.RespondMessage(c, http.StatusUnauthorized, "unauthenticated", "", i18n.Msg("problem.unauthenticated"))
+		}
+	}
+}
+
+// authorization returns the credentials of the Authorization header if it
+// uses scheme.
+func authorization(c echo.Context, scheme string) (string, bool) {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if len(header) <= len(scheme) || !strings.EqualFold(header[:len(scheme)], scheme) || header[len(scheme)] != ' ' {
+		return "", false
+	}
+	return strings.TrimSpace(header[len(scheme)+1:]), true
+}
+
+type BasicProvider struct {
+	Authenticator Authenticator
+	Guard         LoginGuard
+}
+
+// NewBasicProvider checks Basic Auth credentials with authenticator. guard
+// may be nil to allow unlimited attempts.
+func NewBasicProvider(authenticator Authenticator, guard LoginGuard) Provider {
+	return &BasicProvider{Authenticator: authenticator, Guard: guard}
+}
+
+func (p *BasicProvider) Scheme() string {
+	return `Basic realm="Restricted"`
+}
+
+func (p *BasicProvider) Authenticate(c echo.Context) (Principal, error) {
+	if _, ok := authorization(c, "Basic"); !ok {
+		return Principal{}, ErrNoCredentials
+	}
+	username, password, ok := c.Request().BasicAuth()
+	if !ok {
+		return Principal{}, ErrInvalidCredentials
+	}
+	if p.Guard == nil {
+		return p.Authenticator.Authenticate(username, password)
+	}
+
+	ip := c.RealIP()
+	if err := p.Guard.Check(username, ip); err != nil {
+		return Principal{}, err
+	}
+	principal, err := p.Authenticator.Authenticate(username, password)
+	switch {
+	case errors.Is(err, ErrInvalidCredentials):
+		if err := p.Guard.RecordFailure(username, ip); err != nil {
+			log.Printf("failed to record login failure for %q: %v", username, err)
+		}
+	case err == nil:
+		if err := p.Guard.RecordSuccess(username); err != nil {
+			log.Printf("failed to clear login failures for %q: %v", username, err)
+		}
+	}
+	return principal, err
+}
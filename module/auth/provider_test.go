@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBasicProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		wantErr error
+	}{
+		{"Valid", nil, nil},
+		{"Invalid Credentials", ErrInvalidCredentials, ErrInvalidCredentials},
+		{"Lookup Failure", errors.New("database down"), errors.New("database down")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.SetBasicAuth("alice", "secret")
+			c := echo.New().NewContext(req, httptest.NewRecorder())
+
+			authenticator := new(MockAuthenticator)
+			authenticator.On("Authenticate", "alice", "secret").Return(Principal{Username: "alice", Role: RoleEditor}, tt.err)
+
+			principal, err := NewBasicProvider(authenticator, nil).Authenticate(c)
+
+			if tt.wantErr != nil {
+				assert.EqualError(t, err, tt.wantErr.Error())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "alice", principal.Username)
+		})
+	}
+}
+
+func TestBasicProvider_NoCredentials(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer abc")
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	_, err := NewBasicProvider(new(MockAuthenticator), nil).Authenticate(c)
+
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+type MockLoginGuard struct {
+	mock.Mock
+}
+
+func (m *MockLoginGuard) Check(username, ip string) error {
+	args := m.Called(username, ip)
+	return args.Error(0)
+}
+
+func (m *MockLoginGuard) RecordFailure(username, ip string) error {
+	args := m.Called(username, ip)
+	return args.Error(0)
+}
+
+func (m *MockLoginGuard) RecordSuccess(username string) error {
+	args := m.Called(username)
+	return args.Error(0)
+}
+
+func TestBasicProvider_Guard(t *testing.T) {
+	newContext := func(password string) echo.Context {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = "10.0.0.1:5555"
+		req.SetBasicAuth("alice", password)
+		return echo.New().NewContext(req, httptest.NewRecorder())
+	}
+
+	t.Run("Locked", func(t *testing.T) {
+		authenticator := new(MockAuthenticator)
+		guard := new(MockLoginGuard)
+		guard.On("Check", "alice", "10.0.0.1").Return(&LockoutError{Until: time.Now().Add(time.Minute)})
+
+		_, err := NewBasicProvider(authenticator, guard).Authenticate(newContext("secret"))
+
+		var lockout *LockoutError
+		assert.True(t, errors.As(err, &lockout))
+		authenticator.AssertNotCalled(t, "Authenticate", mock.Anything, mock.Anything)
+	})
+
+	t.Run("Failure Recorded", func(t *testing.T) {
+		authenticator := new(MockAuthenticator)
+		authenticator.On("Authenticate", "alice", "wrong").Return(Principal{}, ErrInvalidCredentials)
+		guard := new(MockLoginGuard)
+		guard.On("Check", "alice", "10.0.0.1").Return(nil)
+		guard.On("RecordFailure", "alice", "10.0.0.1").Return(nil)
+
+		_, err := NewBasicProvider(authenticator, guard).Authenticate(newContext("wrong"))
+
+		assert.ErrorIs(t, err, ErrInvalidCredentials)
+		guard.AssertExpectations(t)
+	})
+
+	t.Run("Success Clears", func(t *testing.T) {
+		authenticator := new(MockAuthenticator)
+		authenticator.On("Authenticate", "alice", "secret").Return(Principal{Username: "alice", Role: RoleEditor}, nil)
+		guard := new(MockLoginGuard)
+		guard.On("Check", "alice", "10.0.0.1").Return(nil)
+		guard.On("RecordSuccess", "alice").Return(nil)
+
+		_, err := NewBasicProvider(authenticator, guard).Authenticate(newContext("secret"))
+
+		assert.NoError(t, err)
+		guard.AssertExpectations(t)
+	})
+}
+
+type stubProvider struct {
+	scheme    string
+	principal Principal
+	err       error
+}
+
+func (p stubProvider) Scheme() string { return p.scheme }
+
+func (p stubProvider) Authenticate(c echo.Context) (Principal, error) { return p.principal, p.err }
+
+func TestMiddleware(t *testing.T) {
+	alice := Principal{Username: "alice", Role: RoleEditor}
+	tests := []struct {
+		name      string
+		providers []Provider
+		// preset is a principal set before the middleware runs, as the
+		// API key guard does.
+		preset   *Principal
+		want     int
+		wantUser string
+	}{
+		{"First Provider", []Provider{stubProvider{"Bearer", alice, nil}, stubProvider{"Basic", Principal{}, ErrNoCredentials}}, nil, http.StatusOK, ""},
+		{"Fallback", []Provider{stubProvider{"Bearer", Principal{}, ErrNoCredentials}, stubProvider{"Basic", alice, nil}}, nil, http.StatusOK, ""},
+		{"Invalid Stops", []Provider{stubProvider{"Bearer", Principal{}, ErrInvalidCredentials}, stubProvider{"Basic", alice, nil}}, nil, http.StatusUnauthorized, ""},
+		{"No Credentials", []Provider{stubProvider{"Bearer", Principal{}, ErrNoCredentials}, stubProvider{"Basic", Principal{}, ErrNoCredentials}}, nil, http.StatusUnauthorized, ""},
+		{"Provider Error", []Provider{stubProvider{"Bearer", Principal{}, errors.New("database down")}}, nil, http.StatusInternalServerError, ""},
+		{"Locked Out", []Provider{stubProvider{"Basic", Principal{}, &LockoutError{Until: time.Now().Add(time.Minute)}}}, nil, http.StatusTooManyRequests, ""},
+		{"Preset Principal", []Provider{stubProvider{"Basic", Principal{}, ErrNoCredentials}}, &Principal{Username: "apikey:3"}, http.StatusOK, "apikey:3"},
+		{"Credentials Over Preset", []Provider{stubProvider{"Basic", alice, nil}}, &Principal{Username: "apikey:3"}, http.StatusOK, "alice"},
+		{"Invalid With Preset", []Provider{stubProvider{"Basic", Principal{}, ErrInvalidCredentials}}, &Principal{Username: "apikey:3"}, http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+			if tt.preset != nil {
+				SetPrincipal(c, *tt.preset)
+			}
+
+			handler := Middleware(tt.providers...)(func(c echo.Context) error {
+				principal, _ := PrincipalFrom(c)
+				return c.String(http.StatusOK, principal.Username)
+			})
+
+			assert.NoError(t, handler(c))
+			assert.Equal(t, tt.want, rec.Code)
+			if tt.wantUser != "" {
+				assert.Equal(t, tt.wantUser, rec.Body.String())
+			}
+			if tt.want == http.StatusUnauthorized {
+				schemes := make([]string, len(tt.providers))
+				for i, provider := range tt.providers {
+					schemes[i] = provider.Scheme()
+				}
+				assert.Equal(t, strings.Join(schemes, ", "), rec.Header().Get(echo.HeaderWWWAuthenticate))
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package health
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/problem"
+	"net/http"
+)
+
+// retryAfter is the Retry-After, in seconds, sent while degraded.
+const retryAfter = "5"
+
+// RequireDatabase answers writes with 503 while the database is down. Reads
+// pass through.
+func (m *Monitor) RequireDatabase(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		switch c.Request().Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			return next(c)
+		}
+		if m.Degraded() {
+			c.Response().Header().Set("Retry-After", retryAfter)
+			return problem.RespondMessage(c, http.StatusServiceUnavailable, "database_unavailable", "", i18n.Msg("problem.database_unavailable"))
+		}
+		return next(c)
+	}
+}
+
+// Status reports whether the API is degraded. It answers 200 either way,
+// because a degraded instance still serves calculations.
+func (m *Monitor) Status(c echo.Context) error {
+	if m.Degraded() {
+		return c.JSON(http.StatusOK, echo.Map{"status": "degraded", "database": "down"})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"status": "ok", "database": "up"})
+}
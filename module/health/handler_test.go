@@ -0,0 +1,43 @@
+package health
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func serve(monitor *Monitor, method string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(method, "/admin/settings", nil), rec)
+	_ = monitor.RequireDatabase(func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})(c)
+	return rec
+}
+
+func TestRequireDatabase(t *testing.T) {
+	monitor := NewMonitor(nil)
+	assert.Equal(t, http.StatusNoContent, serve(monitor, http.MethodPatch).Code)
+
+	monitor.MarkDown()
+	refused := serve(monitor, http.MethodPatch)
+	assert.Equal(t, http.StatusServiceUnavailable, refused.Code)
+	assert.Equal(t, "5", refused.Header().Get("Retry-After"))
+	assert.Equal(t, http.StatusServiceUnavailable, serve(monitor, http.MethodPost).Code)
+	assert.Equal(t, http.StatusNoContent, serve(monitor, http.MethodGet).Code)
+}
+
+func TestStatus(t *testing.T) {
+	monitor := NewMonitor(nil)
+	for _, want := range []string{`"status":"ok"`, `"status":"degraded"`} {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/health", nil), rec)
+
+		assert.NoError(t, monitor.Status(c))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), want)
+		monitor.MarkDown()
+	}
+}
@@ -0,0 +1,74 @@
+// Package health tracks whether the database answers. While it does not, the
+// API runs degraded: calculations are served from the configuration
+// snapshot and writes that need the database are refused.
+package health
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+const DefaultInterval = 5 * time.Second
+
+type Monitor struct {
+	ping func(ctx context.Context) error
+	down atomic.Bool
+}
+
+func NewMonitor(ping func(ctx context.Context) error) *Monitor {
+	return &Monitor{ping: ping}
+}
+
+// Degraded reports whether the database was unreachable at the last check.
+// A nil Monitor is never degraded.
+func (m *Monitor) Degraded() bool {
+	return m != nil && m.down.Load()
+}
+
+// MarkDown starts degraded mode, for when the database could not be
+// reached at startup.
+func (m *Monitor) MarkDown() {
+	m.down.Store(true)
+}
+
+// Check pings the database once. Leaving degraded mode waits until
+// onRecover, which prepares the database for use, has succeeded.
+func (m *Monitor) Check(ctx context.Context, interval time.Duration, onRecover func(ctx context.Context) error) {
+	pingCtx, cancel := context.WithTimeout(ctx, interval)
+	err := m.ping(pingCtx)
+	cancel()
+	if err != nil {
+		if !m.down.Swap(true) {
+			log.Printf("Database is not available, running degraded: %v", err)
+		}
+		return
+	}
+	if !m.down.Load() {
+		return
+	}
+	if onRecover != nil {
+		if err := onRecover(ctx); err != nil {
+			log.Printf("Database is available but could not be prepared: %v", err)
+			return
+		}
+	}
+	m.down.Store(false)
+	log.Println("Database is available again")
+}
+
+// Run calls Check every interval until ctx is cancelled.
+func (m *Monitor) Run(ctx context.Context, interval time.Duration, onRecover func(ctx context.Context) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Check(ctx, interval, onRecover)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+type fakeDatabase struct {
+	err error
+}
+
+func (db *fakeDatabase) ping(ctx context.Context) error {
+	return db.err
+}
+
+func TestMonitor_Check(t *testing.T) {
+	db := &fakeDatabase{}
+	monitor := NewMonitor(db.ping)
+	recoveries := 0
+	recoverErr := errors.New("migration failed")
+	onRecover := func(ctx context.Context) error {
+		recoveries++
+		return recoverErr
+	}
+
+	monitor.Check(context.Background(), time.Second, onRecover)
+	assert.False(t, monitor.Degraded())
+	assert.Zero(t, recoveries, "nothing to recover while up")
+
+	db.err = errors.New("connection refused")
+	monitor.Check(context.Background(), time.Second, onRecover)
+	assert.True(t, monitor.Degraded())
+
+	db.err = nil
+	monitor.Check(context.Background(), time.Second, onRecover)
+	assert.True(t, monitor.Degraded(), "stays degraded until recovery succeeds")
+
+	recoverErr = nil
+	monitor.Check(context.Background(), time.Second, onRecover)
+	assert.False(t, monitor.Degraded())
+	assert.Equal(t, 2, recoveries)
+}
+
+func TestMonitor_MarkDown(t *testing.T) {
+	monitor := NewMonitor((&fakeDatabase{}).ping)
+	monitor.MarkDown()
+	assert.True(t, monitor.Degraded())
+
+	monitor.Check(context.Background(), time.Second, nil)
+	assert.False(t, monitor.Degraded())
+
+	var missing *Monitor
+	assert.False(t, missing.Degraded())
+}
+
+func TestMonitor_Run(t *testing.T) {
+	monitor := NewMonitor((&fakeDatabase{}).ping)
+	monitor.MarkDown()
+	recovered := make(chan struct{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go monitor.Run(ctx, time.Millisecond, func(ctx context.Context) error {
+		close(recovered)
+		return nil
+	})
+
+	select {
+	case <-recovered:
+	case <-time.After(time.Second):
+		t.Fatal("monitor did not recover")
+	}
+}
@@ -0,0 +1,220 @@
+// Package i18n shows messages, labels and numbers in the caller's language.
+// Responses are in English unless the caller asks for Thai, with the lang
+// query parameter or Accept-Language.
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+	"net/http"
+	"path"
+	"strings"
+)
+
+//go:embed locales/*.json
+var locales embed.FS
+
+// Supported lists the languages with a catalog. The first is the default.
+var Supported = []language.Tag{language.English, language.Thai}
+
+// QueryParam overrides Accept-Language, e.g. ?lang=th.
+const QueryParam = "lang"
+
+var (
+	catalogs = mustLoad()
+	builder  = mustBuild(catalogs)
+	matcher  = language.NewMatcher(Supported)
+)
+
+// mustLoad reads the catalog of each supported language, keyed by message.
+func mustLoad() map[language.Tag]map[string]string {
+	loaded := make(map[language.Tag]map[string]string, len(Supported))
+	for _, tag := range Supported {
+		data, err := locales.ReadFile(path.Join("locales", tag.String()+".json"))
+		if err != nil {
+			panic(err)
+		}
+		messages := map[string]string{}
+		if err := json.Unmarshal(data, &messages); err != nil {
+			panic(fmt.Sprintf("locales/%s.json: %v", tag, err))
+		}
+		loaded[tag] = messages
+	}
+	return loaded
+}
+
+func mustBuild(catalogs map[language.Tag]map[string]string) *catalog.Builder {
+	b := catalog.NewBuilder(catalog.Fallback(Supported[0]))
+	for tag, messages := range catalogs {
+		for key, text := range messages {
+			if err := b.SetString(tag, key, text); err != nil {
+				panic(err)
+			}
+		}
+	}
+	return b
+}
+
+// Message is text to show in the caller's language: the catalog key of its
+// translations and the arguments they are formatted with. Arguments that
+// are themselves messages, or lists of them, are translated too.
+type Message struct {
+	Key  string
+	Args []interface{}
+}
+
+// Amount is a message argument rendered as a whole-baht amount with the
+// language's digit grouping.
+type Amount float64
+
+// Msg returns the message with key formatted with args.
+func Msg(key string, args ...interface{}) Message {
+	return Message{Key: key, Args: args}
+}
+
+// Localizer renders messages and numbers in one language.
+type Localizer struct {
+	tag     language.Tag
+	printer *message.Printer
+}
+
+// New returns the localizer for the supported language closest to tag.
+func New(tag language.Tag) *Localizer {
+	_, i, _ := matcher.Match(tag)
+	tag = Supported[i]
+	return &Localizer{tag: tag, printer: message.NewPrinter(tag, message.Catalog(builder))}
+}
+
+// Negotiate picks the language for r: the lang query parameter if it names
+// a supported language, otherwise the best match for Accept-Language.
+func Negotiate(r *http.Request) *Localizer {
+	if lang := r.URL.Query().Get(QueryParam); lang != "" {
+		if tag, err := language.Parse(lang); err == nil {
+			if _, _, confidence := matcher.Match(tag); confidence != language.No {
+				return New(tag)
+			}
+		}
+	}
+	return Accept(r.Header.Get("Accept-Language"))
+}
+
+// Accept picks the best match for an Accept-Language value, such as
+// "th-TH,th;q=0.9", or the default language if nothing matches.
+func Accept(acceptLanguage string) *Localizer {
+	tags, _, _ := language.ParseAcceptLanguage(acceptLanguage)
+	_, i, _ := matcher.Match(tags...)
+	return New(Supported[i])
+}
+
+const contextKey = "i18n.localizer"
+
+// From returns the localizer for the request c is serving.
+func From(c echo.Context) *Localizer {
+	if l, ok := c.Get(contextKey).(*Localizer); ok {
+		return l
+	}
+	l := Negotiate(c.Request())
+	c.Set(contextKey, l)
+	return l
+}
+
+// Middleware negotiates the language of each request and names it in
+// Content-Language.
+func Middleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		header := c.Response().Header()
+		header.Set("Content-Language", From(c).Language())
+		header.Add(echo.HeaderVary, "Accept-Language")
+		return next(c)
+	}
+}
+
+// Language is the BCP 47 tag of the language, such as "th".
+func (l *Localizer) Language() string {
+	return l.tag.String()
+}
+
+// Text renders m. A key missing from the catalogs is rendered as it is.
+func (l *Localizer) Text(m Message) string {
+	args := make([]interface{}, len(m.Args))
+	for i, arg := range m.Args {
+		switch arg := arg.(type) {
+		case Message:
+			args[i] = l.Text(arg)
+		case []Message:
+			texts := make([]string, len(arg))
+			for j := range arg {
+				texts[j] = l.Text(arg[j])
+			}
+			args[i] = strings.Join(texts, "; ")
+		case Amount:
+			args[i] = l.Amount(float64(arg))
+		default:
+			args[i] = arg
+		}
+	}
+	return l.printer.Sprintf(m.Key, args...)
+}
+
+// T renders the message with key formatted with args.
+func (l *Localizer) T(key string, args ...interface{}) string {
+	return l.Text(Msg(key, args...))
+}
+
+// Has reports whether key is in the catalogs.
+func Has(key string) bool {
+	_, ok := catalogs[Supported[0]][key]
+	return ok
+}
+
+// Amount renders a whole-baht amount with the language's digit grouping.
+func (l *Localizer) Amount(amount float64) string {
+	return l.printer.Sprintf("%.0f", amount)
+}
+
+// AllowanceName is the name of an allowance type, or the type as given if
+// it is not one the catalogs know.
+func AllowanceName(allowanceType string) Message {
+	key := "allowance." + allowanceType
+	if !Has(key) {
+		return Msg("allowance.other", allowanceType)
+	}
+	return Msg(key)
+}
+
+// Allowance names an allowance type in l's language.
+func (l *Localizer) Allowance(allowanceType string) string {
+	return l.Text(AllowanceName(allowanceType))
+}
+
+// TaxLevel labels a bracket, such as "150,001-500,000".
+func (l *Localizer) TaxLevel(rate model.TaxRate) string {
+	lower := rate.Min
+	if lower > 0 {
+		lower++
+	}
+	if rate.Max == nil {
+		return l.T("tax.level.above", l.Amount(lower))
+	}
+	return l.T("tax.level.range", l.Amount(lower), l.Amount(*rate.Max))
+}
+
+// CSVHeader returns the field a CSV column heading names, in any supported
+// language, or the heading itself if it names none.
+func CSVHeader(heading string) string {
+	heading = strings.TrimSpace(strings.TrimPrefix(heading, "\ufeff"))
+	for _, tag := range Supported {
+		for key, text := range catalogs[tag] {
+			if field, ok := strings.CutPrefix(key, "csv."); ok && strings.EqualFold(text, heading) {
+				return field
+			}
+		}
+	}
+	return heading
+}
@@ -0,0 +1,133 @@
+package i18n
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/utils"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/language"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"testing"
+)
+
+var verb = regexp.MustCompile(`%\[\d+\][a-z]`)
+
+// Every key must be translated into every language, with the same
+// arguments, so that no caller ever sees a raw key or a missing argument.
+func TestCatalogs_SameKeys(t *testing.T) {
+	reference := catalogs[Supported[0]]
+	for _, tag := range Supported[1:] {
+		for key, text := range reference {
+			translated, ok := catalogs[tag][key]
+			if !assert.True(t, ok, "%s is missing %q", tag, key) {
+				continue
+			}
+			assert.Equal(t, verbs(text), verbs(translated), "%s %q takes other arguments", tag, key)
+		}
+		for key := range catalogs[tag] {
+			_, ok := reference[key]
+			assert.True(t, ok, "%s has %q, which %s does not", tag, key, Supported[0])
+		}
+	}
+}
+
+func verbs(text string) []string {
+	found := verb.FindAllString(text, -1)
+	sort.Strings(found)
+	return found
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		acceptLanguage string
+		want           string
+	}{
+		{"default", "/", "", "en"},
+		{"thai", "/", "th-TH,th;q=0.9,en;q=0.8", "th"},
+		{"preferred english", "/", "en-GB,th;q=0.5", "en"},
+		{"unsupported", "/", "fr-FR", "en"},
+		{"query override", "/?lang=th", "en-US", "th"},
+		{"query region", "/?lang=th-TH", "", "th"},
+		{"unsupported query", "/?lang=fr", "th", "th"},
+		{"malformed query", "/?lang=!!", "", "en"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			assert.Equal(t, tt.want, Negotiate(req).Language())
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/?lang=th", nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	assert.NoError(t, Middleware(func(c echo.Context) error {
+		assert.Equal(t, "th", From(c).Language())
+		return c.NoContent(http.StatusNoContent)
+	})(c))
+
+	assert.Equal(t, "th", rec.Header().Get("Content-Language"))
+	assert.Equal(t, "Accept-Language", rec.Header().Get(echo.HeaderVary))
+}
+
+func TestLocalizer_Text(t *testing.T) {
+	en, th := New(language.English), New(language.Thai)
+
+	negative := Msg("validation.negative_allowance", Msg("allowance.donation"))
+	assert.Equal(t, "Donation cannot be negative", en.Text(negative))
+	assert.Equal(t, "เงินบริจาค ต้องไม่ติดลบ", th.Text(negative))
+
+	between := Msg("validation.personal_allowance_range", 10000.0, 100000.0)
+	assert.Equal(t, "Personal allowance must be between 10,000 and 100,000", en.Text(between))
+	assert.Equal(t, "ค่าลดหย่อนส่วนตัวต้องอยู่ระหว่าง 10,000 ถึง 100,000", th.Text(between))
+
+	rows := Msg("validation.invalid_national_ids", []Message{
+		Msg("validation.row_invalid_national_id", 1),
+		Msg("validation.row_duplicate_national_id", 3, 2),
+	})
+	assert.Equal(t, "Invalid national IDs: row 1: nationalId must be 13 digits with a valid check digit; row 3: nationalId duplicates row 2", en.Text(rows))
+
+	assert.Equal(t, "no.such.key", en.T("no.such.key"))
+}
+
+func TestLocalizer_Allowance(t *testing.T) {
+	th := New(language.Thai)
+
+	assert.Equal(t, "ค่าลดหย่อนส่วนตัว", th.Allowance("personal"))
+	assert.Equal(t, "gym", th.Allowance("gym"))
+}
+
+func TestLocalizer_TaxLevel(t *testing.T) {
+	en, th := New(language.English), New(language.Thai)
+
+	var english, thai []string
+	for _, rate := range utils.DefaultTaxRates {
+		english = append(english, en.TaxLevel(rate))
+		thai = append(thai, th.TaxLevel(rate))
+	}
+	assert.Equal(t, []string{"0-150,000", "150,001-500,000", "500,001-1,000,000", "1,000,001-2,000,000", "2,000,001 and above"}, english)
+	assert.Equal(t, []string{"0-150,000", "150,001-500,000", "500,001-1,000,000", "1,000,001-2,000,000", "2,000,001 ขึ้นไป"}, thai)
+}
+
+func TestCSVHeader(t *testing.T) {
+	tests := map[string]string{
+		"totalIncome":        "totalIncome",
+		"\ufeffเงินได้รวม":   "totalIncome",
+		" ภาษีหัก ณ ที่จ่าย": "wht",
+		"เงินบริจาค":         "donation",
+		"เลขประจำตัวประชาชน": "nationalId",
+		"unknown": "unknown",
+	}
+	for heading, want := range tests {
+		assert.Equal(t, want, CSVHeader(heading), heading)
+	}
+}
@@ -0,0 +1,108 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/pphee/assessment-tax/module/problem"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// decimalPlaces is the extension marking amounts that are always written
+// with a fixed number of decimal places.
+const decimalPlaces = "x-decimal-places"
+
+// CheckResponse reports how a response to req differs from the document: a
+// status the operation does not list, a body that does not match its
+// schema, a property the schema does not declare, or an amount written with
+// other than its decimal places. It is stricter than clients need to be, so
+// that the document is kept up to date with the handlers.
+func (s *Spec) CheckResponse(req *http.Request, status int, header http.Header, body []byte) error {
+	route, pathParams, err := s.router.FindRoute(req)
+	if err != nil {
+		return fmt.Errorf("%s %s is not in the document: %w", req.Method, req.URL.Path, err)
+	}
+
+	err = openapi3filter.ValidateResponse(req.Context(), &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      route,
+		},
+		Status: status,
+		Header: header,
+		Body:   io.NopCloser(bytes.NewReader(body)),
+		Options: &openapi3filter.Options{
+			IncludeResponseStatus: true,
+			MultiError:            true,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("%s %s answered %d: %w", req.Method, req.URL.Path, status, err)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType != "application/json" && mediaType != problem.ContentType {
+		return nil
+	}
+	content := route.Operation.Responses.Status(status).Value.Content.Get(mediaType)
+	if content == nil {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	if err := checkStrict(content.Schema, value, ""); err != nil {
+		return fmt.Errorf("%s %s answered %d: %w", req.Method, req.URL.Path, status, err)
+	}
+	return nil
+}
+
+func checkStrict(ref *openapi3.SchemaRef, value interface{}, path string) error {
+	if ref == nil || ref.Value == nil {
+		return nil
+	}
+	schema := ref.Value
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		// Objects declared without properties, such as stored calculation
+		// documents, may hold anything.
+		if len(schema.Properties) == 0 {
+			return nil
+		}
+		for name, field := range value {
+			property, ok := schema.Properties[name]
+			if !ok {
+				return fmt.Errorf("%s/%s is not in the document", path, name)
+			}
+			if err := checkStrict(property, field, path+"/"+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for i, item := range value {
+			if err := checkStrict(schema.Items, item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+				return err
+			}
+		}
+	case json.Number:
+		places, ok := schema.Extensions[decimalPlaces].(float64)
+		if !ok {
+			return nil
+		}
+		_, fraction, _ := strings.Cut(value.String(), ".")
+		if len(fraction) != int(places) {
+			return fmt.Errorf("%s is %s, not written with %d decimal places", path, value, int(places))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package openapi
+
+import (
+	"github.com/pphee/assessment-tax/module/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckResponse(t *testing.T) {
+	spec, err := NewSpec()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		drift  string
+	}{
+		{"matches", http.StatusOK, `{"tax":29000.0,"taxLevel":[{"level":"0-150,000","tax":0.0}],"configVersion":1}`, ""},
+		{"masked national ID", http.StatusOK, `{"nationalId":"*********3450","tax":-1.0,"taxLevel":[],"configVersion":1}`, ""},
+		{"problem", http.StatusBadRequest, `{"type":"urn:ktax:problem:invalid_wht","title":"Bad Request","status":400,"code":"invalid_wht","detail":"invalid WHT value","field":"wht","instance":"/tax/calculations"}`, ""},
+		{"problem without code", http.StatusBadRequest, `{"type":"urn:ktax:problem:invalid_wht","title":"Bad Request","status":400,"detail":"invalid WHT value"}`, `property "code" is missing`},
+		{"undocumented status", http.StatusConflict, `{"type":"urn:ktax:problem:conflict","title":"Conflict","status":409,"code":"conflict","detail":"conflict"}`, "status is not supported"},
+		{"missing property", http.StatusOK, `{"tax":29000.0,"taxLevel":[]}`, `property "configVersion" is missing`},
+		{"undocumented property", http.StatusOK, `{"tax":29000.0,"taxLevel":[],"configVersion":1,"refund":0.0}`, "/refund is not in the document"},
+		{"amount format", http.StatusOK, `{"tax":29000,"taxLevel":[],"configVersion":1}`, "/tax is 29000, not written with 1 decimal places"},
+		{"nested amount format", http.StatusOK, `{"tax":29000.0,"taxLevel":[{"level":"0-150,000","tax":0.00}],"configVersion":1}`, "/taxLevel/0/tax is 0.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{"Content-Type": {"application/json"}}
+			if tt.status != http.StatusOK {
+				header.Set("Content-Type", problem.ContentType)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/tax/calculations", nil)
+			err := spec.CheckResponse(req, tt.status, header, []byte(tt.body))
+			if tt.drift == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.drift)
+			}
+		})
+	}
+}
+
+func TestCheckResponse_UndocumentedRoute(t *testing.T) {
+	spec, err := NewSpec()
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/tax/calculations", nil)
+	err = spec.CheckResponse(req, http.StatusOK, http.Header{}, nil)
+	assert.ErrorContains(t, err, "GET /tax/calculations is not in the document")
+}
+
+func TestCheckResponse_FreeFormObjects(t *testing.T) {
+	spec, err := NewSpec()
+	require.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodGet, "/tax/taxpayers/1/calculations/2", nil)
+	body := `{"id":2,"taxpayerId":1,"input":{"totalIncome":500000,"anything":true},"output":{"tax":29000.0},"configVersion":1,"createdAt":"2026-10-01T00:00:00Z"}`
+	assert.NoError(t, spec.CheckResponse(req, http.StatusOK, http.Header{"Content-Type": {"application/json"}}, []byte(body)))
+
+	purged := `{"id":2,"input":null,"output":{"tax":29000.0},"configVersion":1,"createdAt":"2026-10-01T00:00:00Z"}`
+	assert.NoError(t, spec.CheckResponse(req, http.StatusOK, http.Header{"Content-Type": {"application/json"}}, []byte(purged)))
+}
@@ -0,0 +1,175 @@
+// Package openapi publishes the OpenAPI document that describes the API and
+// holds requests and responses to it.
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/problem"
+	"github.com/pphee/assessment-tax/module/validate"
+	"mime"
+	"net/http"
+	"regexp"
+)
+
+//go:embed openapi.yaml
+var document []byte
+
+type Spec struct {
+	Doc    *openapi3.T
+	router routers.Router
+}
+
+// NewSpec loads the embedded document and checks that it is valid OpenAPI.
+func NewSpec() (*Spec, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(document)
+	if err != nil {
+		return nil, err
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, err
+	}
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, err
+	}
+	return &Spec{Doc: doc, router: router}, nil
+}
+
+func (s *Spec) ServeYAML(c echo.Context) error {
+	return c.Blob(http.StatusOK, "application/yaml", document)
+}
+
+func (s *Spec) ServeJSON(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.Doc)
+}
+
+// ValidateRequests answers 400 to requests whose parameters or body do not
+// match the document. Requests for paths it does not describe are left to
+// the router, and credentials to the authentication middleware. Uploads are
+// not read here: the CSV handler reports on its file itself, as do handlers
+// of bodies marked x-handler-validated.
+func (s *Spec) ValidateRequests(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+		route, pathParams, err := s.router.FindRoute(req)
+		if err != nil {
+			return next(c)
+		}
+
+		mediaType, _, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType))
+		err = openapi3filter.ValidateRequest(req.Context(), &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				ExcludeRequestBody: mediaType == echo.MIMEMultipartForm || handlerValidated(route.Operation),
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				MultiError:         true,
+			},
+		})
+		if err != nil {
+			return validate.Respond(c, violations(err))
+		}
+		return next(c)
+	}
+}
+
+// handlerValidated reports whether the handler of op checks its body
+// itself, reporting rules the schema cannot express along with the rest.
+func handlerValidated(op *openapi3.Operation) bool {
+	if op.RequestBody == nil || op.RequestBody.Value == nil {
+		return false
+	}
+	validated, _ := op.RequestBody.Value.Extensions["x-handler-validated"].(bool)
+	return validated
+}
+
+// violations lists every parameter or field a request got wrong and what is
+// wrong with it, without the schema kin-openapi otherwise prints.
+func violations(err error) validate.Errors {
+	var errs validate.Errors
+	var multi openapi3.MultiError
+	if !errors.As(err, &multi) {
+		multi = openapi3.MultiError{err}
+	}
+	for _, err := range multi {
+		var requestErr *openapi3filter.RequestError
+		if !errors.As(err, &requestErr) {
+			errs = append(errs, validate.Violation{
+				Rule:    "schema",
+				Message: i18n.Msg("validation.located", i18n.Msg("validation.request_body"), err.Error()),
+			})
+			continue
+		}
+		errs = append(errs, describe(requestErr)...)
+	}
+	return errs
+}
+
+// describe lists what is wrong with one parameter or with the body.
+func describe(requestErr *openapi3filter.RequestError) validate.Errors {
+	field, where := "", interface{}(i18n.Msg("validation.request_body"))
+	if requestErr.Parameter != nil {
+		field = requestErr.Parameter.Name
+		where = i18n.Msg("validation.parameter", requestErr.Parameter.In, field)
+	}
+
+	var schemaErrs openapi3.MultiError
+	if !errors.As(requestErr.Err, &schemaErrs) {
+		schemaErrs = openapi3.MultiError{requestErr.Err}
+	}
+	var errs validate.Errors
+	for _, err := range schemaErrs {
+		violation := validate.Violation{Field: field, Rule: "schema"}
+		reason := requestErr.Reason
+		var schemaErr *openapi3.SchemaError
+		switch {
+		case errors.As(err, &schemaErr):
+			violation.Rule = rules[schemaErr.SchemaField]
+			pointer := schemaErr.JSONPointer()
+			if name := unsupported.FindStringSubmatch(schemaErr.Reason); name != nil {
+				violation.Rule = "unknown_field"
+				pointer = append(pointer, name[1])
+			}
+			if len(pointer) > 0 && requestErr.Parameter == nil {
+				violation.Field = problem.FieldPath(pointer)
+			}
+			reason = schemaErr.Reason
+		case err != nil:
+			reason = err.Error()
+		case requestErr.Parameter == nil:
+			violation.Rule = "required"
+		}
+		if violation.Rule == "" {
+			violation.Rule = "schema"
+		}
+		location := where
+		if violation.Field != field {
+			location = violation.Field
+		}
+		violation.Message = i18n.Msg("validation.located", location, reason)
+		errs = append(errs, violation)
+	}
+	return errs
+}
+
+// rules are the violation codes of the schema keywords that have one.
+var rules = map[string]string{
+	"type":     "wrong_type",
+	"required": "required",
+	"minimum":  "min",
+	"maximum":  "max",
+	"enum":     "one_of",
+	"maxItems": "max_items",
+}
+
+var unsupported = regexp.MustCompile(`^property "(.+)" is unsupported$`)
@@ -0,0 +1,125 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/module/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func check(t *testing.T, req *http.Request) *httptest.ResponseRecorder {
+	spec, err := NewSpec()
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	require.NoError(t, spec.ValidateRequests(func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})(c))
+	return rec
+}
+
+func jsonRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	return req
+}
+
+func TestValidateRequests(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     *http.Request
+		status  int
+		message string
+	}{
+		{"valid body", jsonRequest(http.MethodPost, "/admin/recompute", `{"input": {"totalIncome": 500000, "allowances": [{"allowanceType": "donation", "amount": 0}]}}`), http.StatusNoContent, ""},
+		{"wrong type", jsonRequest(http.MethodPost, "/admin/recompute", `{"calculationId": "7"}`), http.StatusBadRequest, "Invalid request: calculationId: value must be an integer"},
+		{"nested field", jsonRequest(http.MethodPost, "/admin/recompute", `{"input": {"totalIncome": 1, "allowances": [{"allowanceType": "donation", "amount": "x"}]}}`), http.StatusBadRequest, "Invalid request: input.allowances[0].amount: value must be a number"},
+		{"missing body", jsonRequest(http.MethodPost, "/admin/recompute", ``), http.StatusBadRequest, "Invalid request: request body: value is required but missing"},
+		{"checked by the handler", jsonRequest(http.MethodPost, "/tax/calculations", `{"totalIncome": "500000"}`), http.StatusNoContent, ""},
+		{"bad query", httptest.NewRequest(http.MethodGet, "/admin/audit-events?limit=0", nil), http.StatusBadRequest, "Invalid request: query parameter limit: number must be at least 1"},
+		{"bad path", httptest.NewRequest(http.MethodGet, "/admin/tax-years/2567/schedules/x", nil), http.StatusBadRequest, "Invalid request: path parameter id"},
+		{"unknown enum", httptest.NewRequest(http.MethodGet, "/admin/change-requests?status=lost", nil), http.StatusBadRequest, "Invalid request: query parameter status"},
+		{"credentials left to auth", httptest.NewRequest(http.MethodGet, "/admin/settings", nil), http.StatusNoContent, ""},
+		{"undocumented path", httptest.NewRequest(http.MethodGet, "/nowhere", nil), http.StatusNoContent, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := check(t, tt.req)
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Contains(t, rec.Body.String(), tt.message)
+			if tt.status == http.StatusBadRequest {
+				assert.Equal(t, problem.ContentType, rec.Header().Get(echo.HeaderContentType))
+			}
+		})
+	}
+}
+
+func TestValidateRequests_Field(t *testing.T) {
+	rec := check(t, jsonRequest(http.MethodPost, "/admin/recompute", `{"input": {"totalIncome": 1, "allowances": [{"allowanceType": "donation", "amount": 0}, {"allowanceType": "personal", "amount": "x"}]}}`))
+
+	assert.Contains(t, rec.Body.String(), `"code":"invalid_request"`)
+	assert.Contains(t, rec.Body.String(), `"field":"input.allowances[1].amount"`)
+
+	rec = check(t, httptest.NewRequest(http.MethodGet, "/admin/audit-events?limit=0", nil))
+	assert.Contains(t, rec.Body.String(), `"field":"limit"`)
+}
+
+func TestValidateRequests_AllViolations(t *testing.T) {
+	rec := check(t, jsonRequest(http.MethodPost, "/admin/recompute", `{"input": {"wht": -1, "extra": 1, "allowances": [{"allowanceType": "gym", "amount": -5}]}}`))
+	require.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var body problem.Problem
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, problem.CodeInvalidRequest, body.Code)
+	got := map[string]string{}
+	for _, v := range body.Violations {
+		got[v.Field] = v.Code
+	}
+	assert.Equal(t, map[string]string{
+		"input.totalIncome":                 "required",
+		"input.wht":                         "min",
+		"input.extra":                       "unknown_field",
+		"input.allowances[0].allowanceType": "one_of",
+		"input.allowances[0].amount":        "min",
+	}, got)
+}
+
+func TestValidateRequests_Upload(t *testing.T) {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, err := form.CreateFormFile("taxes", "taxes.csv")
+	require.NoError(t, err)
+	_, _ = part.Write([]byte("totalIncome,wht,donation\n500000,0,0\n"))
+	require.NoError(t, form.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/tax/calculations/upload-csv", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	assert.Equal(t, http.StatusNoContent, check(t, req).Code)
+}
+
+func TestServe(t *testing.T) {
+	spec, err := NewSpec()
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/openapi.yaml", nil), rec)
+	require.NoError(t, spec.ServeYAML(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, strings.HasPrefix(rec.Body.String(), "openapi: 3.0.3"))
+
+	rec = httptest.NewRecorder()
+	c = echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/openapi.json", nil), rec)
+	require.NoError(t, spec.ServeJSON(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"openapi":"3.0.3"`)
+	assert.Contains(t, rec.Body.String(), `"/tax/calculations"`)
+}
@@ -0,0 +1,199 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of a problem document, from RFC 7807.
+const ContentType = "application/problem+json"
+
+// StatusClientClosedRequest is the non-standard status, from nginx, for a
+// client that went away before its response was ready.
+const StatusClientClosedRequest = 499
+
+// Codes shared by every package. Packages add their own for the mistakes
+// only they can report; a code never changes once published.
+const (
+	CodeInvalidBody      = "invalid_body"
+	CodeInvalidRequest   = "invalid_request"
+	CodeInternal         = "internal_error"
+	CodeTimeout          = "timeout"
+	CodeRequestCancelled = "request_cancelled"
+)
+
+// Problem is an RFC 7807 problem document. Code is stable for clients to
+// match on; Field, when set, is the path of the offending request field,
+// such as allowances[1].amount. Violations lists every mistake in a request
+// that broke more than one rule.
+type Problem struct {
+	Type       string      `json:"type"`
+	Title      string      `json:"title"`
+	Status     int         `json:"status"`
+	Code       string      `json:"code"`
+	Detail     string      `json:"detail"`
+	Field      string      `json:"field,omitempty"`
+	Instance   string      `json:"instance,omitempty"`
+	Violations []Violation `json:"violations,omitempty"`
+}
+
+// Violation is one rule a request field broke. Code names the rule, such
+// as required or max.
+type Violation struct {
+	Field  string `json:"field"`
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+// New returns the problem for code with the given status and detail.
+func New(status int, code, detail string) Problem {
+	title := http.StatusText(status)
+	if status == StatusClientClosedRequest {
+		title = "Client Closed Request"
+	}
+	return Problem{
+		Type:   "urn:ktax:problem:" + code,
+		Title:  title,
+		Status: status,
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// Write sends p as the response.
+func Write(c echo.Context, p Problem) error {
+	p.Instance = c.Request().URL.Path
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	return c.Blob(p.Status, ContentType, body)
+}
+
+// Respond sends a problem that is not about one field.
+func Respond(c echo.Context, status int, code, detail string) error {
+	return Write(c, New(status, code, detail))
+}
+
+// RespondField sends a problem about the request field at path field.
+func RespondField(c echo.Context, status int, code, field, detail string) error {
+	p := New(status, code, detail)
+	p.Field = field
+	return Write(c, p)
+}
+
+// RespondMessage sends a problem whose detail is msg in the caller's
+// language. field may be empty.
+func RespondMessage(c echo.Context, status int, code, field string, msg i18n.Message) error {
+	return RespondField(c, status, code, field, i18n.From(c).Text(msg))
+}
+
+// InvalidBody responds to a request body that could not be bound, naming
+// the field when the body decoded but a value had the wrong type.
+func InvalidBody(c echo.Context, err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		field := FieldPath(strings.Split(typeErr.Field, "."))
+		return RespondMessage(c, http.StatusBadRequest, CodeInvalidBody, field,
+			i18n.Msg("validation.wrong_type", field, i18n.Msg(JSONKind(typeErr.Type))))
+	}
+	return RespondMessage(c, http.StatusBadRequest, CodeInvalidBody, "", i18n.Msg("validation.invalid_body"))
+}
+
+// FieldPath joins the parts of a path to a field the way problems name it,
+// such as allowances[0].amount.
+func FieldPath(parts []string) string {
+	var b strings.Builder
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err == nil {
+			b.WriteString("[" + part + "]")
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteByte('.')
+		}
+		b.WriteString(part)
+	}
+	return b.String()
+}
+
+// JSONKind is the catalog key describing how a value of type t is written
+// in JSON, such as json.number.
+func JSONKind(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "json.boolean"
+	case reflect.String:
+		return "json.string"
+	case reflect.Slice, reflect.Array:
+		return "json.array"
+	case reflect.Map, reflect.Struct:
+		return "json.object"
+	case reflect.Pointer:
+		return JSONKind(t.Elem())
+	}
+	return "json.number"
+}
+
+// Internal responds to an unexpected error. Work abandoned because the
+// request's context ended is not a server fault: it is reported as 499 when
+// the client went away and 503 when an operation ran out of time. Anything
+// else is logged and answered with message alone, so that database and
+// other internal errors never reach the client.
+func Internal(c echo.Context, message string, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return Respond(c, StatusClientClosedRequest, CodeRequestCancelled, message+": request cancelled")
+	case errors.Is(err, context.DeadlineExceeded):
+		return Respond(c, http.StatusServiceUnavailable, CodeTimeout, message+": operation timed out")
+	}
+	log.Printf("%s %s: %s: %v", c.Request().Method, c.Request().URL.Path, message, err)
+	return Respond(c, http.StatusInternalServerError, CodeInternal, message)
+}
+
+// ErrorHandler is an echo.HTTPErrorHandler that answers errors no handler
+// responded to, such as unknown routes, with problem documents.
+func ErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	var he *echo.HTTPError
+	if !errors.As(err, &he) {
+		_ = Internal(c, "Request failed", err)
+		return
+	}
+	code := statusCode(he.Code)
+	detail := http.StatusText(he.Code)
+	if message, ok := he.Message.(string); ok {
+		detail = message
+	}
+	// echo's own errors carry only the reason phrase, which can be translated
+	if key := "status." + code; detail == http.StatusText(he.Code) && i18n.Has(key) {
+		detail = i18n.From(c).T(key)
+	}
+	p := New(he.Code, code, detail)
+	if c.Request().Method == http.MethodHead {
+		_ = c.NoContent(p.Status)
+		return
+	}
+	_ = Write(c, p)
+}
+
+// statusCode is the code for a status no handler chose one for, such as
+// not_found or method_not_allowed.
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "http_error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
@@ -0,0 +1,161 @@
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newContext(method, target, body string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	return echo.New().NewContext(req, rec), rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder) Problem {
+	assert.Equal(t, ContentType, rec.Header().Get(echo.HeaderContentType))
+	var p Problem
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	return p
+}
+
+func TestRespondField(t *testing.T) {
+	c, rec := newContext(http.MethodPost, "/tax/calculations?lang=en", "")
+
+	require.NoError(t, RespondField(c, http.StatusBadRequest, "invalid_wht", "wht", "invalid WHT value"))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, Problem{
+		Type:     "urn:ktax:problem:invalid_wht",
+		Title:    "Bad Request",
+		Status:   http.StatusBadRequest,
+		Code:     "invalid_wht",
+		Detail:   "invalid WHT value",
+		Field:    "wht",
+		Instance: "/tax/calculations",
+	}, decode(t, rec))
+}
+
+func TestRespond_OmitsField(t *testing.T) {
+	c, rec := newContext(http.MethodGet, "/", "")
+
+	require.NoError(t, Respond(c, http.StatusNotFound, "user_not_found", "admin user not found"))
+
+	assert.NotContains(t, rec.Body.String(), `"field"`)
+}
+
+func TestInvalidBody(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		field  string
+		detail string
+	}{
+		{"wrong type", `{"totalIncome": "500000"}`, "totalIncome", "totalIncome must be a number"},
+		{"nested wrong type", `{"allowances": [{"amount": true}]}`, "allowances[0].amount", "allowances[0].amount must be a number"},
+		{"not JSON", `{"totalIncome":`, "", "The request body is not valid JSON for this operation"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newContext(http.MethodPost, "/tax/calculations", tt.body)
+			var req struct {
+				TotalIncome float64 `json:"totalIncome"`
+				Allowances  []struct {
+					Amount float64 `json:"amount"`
+				} `json:"allowances"`
+			}
+			err := c.Bind(&req)
+			require.Error(t, err)
+
+			require.NoError(t, InvalidBody(c, err))
+
+			p := decode(t, rec)
+			assert.Equal(t, CodeInvalidBody, p.Code)
+			assert.Equal(t, tt.field, p.Field)
+			assert.Equal(t, tt.detail, p.Detail)
+		})
+	}
+}
+
+func TestInvalidBody_Thai(t *testing.T) {
+	c, rec := newContext(http.MethodPost, "/tax/calculations?lang=th", `{"totalIncome": "500000"}`)
+	var req struct {
+		TotalIncome float64 `json:"totalIncome"`
+	}
+
+	require.NoError(t, InvalidBody(c, c.Bind(&req)))
+
+	assert.Equal(t, "totalIncome ต้องเป็นตัวเลข", decode(t, rec).Detail)
+}
+
+func TestInternal(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"unexpected", errors.New("pq: relation \"allowance_gorms\" does not exist"), http.StatusInternalServerError, CodeInternal},
+		{"client gone", fmt.Errorf("query: %w", context.Canceled), StatusClientClosedRequest, CodeRequestCancelled},
+		{"timed out", fmt.Errorf("query: %w", context.DeadlineExceeded), http.StatusServiceUnavailable, CodeTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newContext(http.MethodGet, "/admin/settings", "")
+
+			require.NoError(t, Internal(c, "Failed to get allowance settings", tt.err))
+
+			assert.Equal(t, tt.status, rec.Code)
+			p := decode(t, rec)
+			assert.Equal(t, tt.code, p.Code)
+			assert.True(t, strings.HasPrefix(p.Detail, "Failed to get allowance settings"))
+			assert.NotContains(t, rec.Body.String(), "allowance_gorms")
+			assert.NotContains(t, rec.Body.String(), "query")
+		})
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	e := echo.New()
+	e.HTTPErrorHandler = ErrorHandler
+	e.GET("/health", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	e.GET("/boom", func(c echo.Context) error { return errors.New("secret internals") })
+
+	tests := []struct {
+		method, target string
+		status         int
+		code           string
+	}{
+		{http.MethodGet, "/nowhere", http.StatusNotFound, "not_found"},
+		{http.MethodPost, "/health", http.StatusMethodNotAllowed, "method_not_allowed"},
+		{http.MethodGet, "/boom", http.StatusInternalServerError, CodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.target, nil))
+
+			assert.Equal(t, tt.status, rec.Code)
+			assert.Equal(t, tt.code, decode(t, rec).Code)
+			assert.NotContains(t, rec.Body.String(), "secret")
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/nowhere", nil)
+	req.Header.Set("Accept-Language", "th")
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, "ไม่พบสิ่งที่ร้องขอ", decode(t, rec).Detail)
+}
@@ -0,0 +1,23 @@
+package retention
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/module/tax"
+	"net/http"
+)
+
+type RetentionHandler struct {
+	RetentionService RetentionServices
+}
+
+func NewRetentionHandler(service RetentionServices) *RetentionHandler {
+	return &RetentionHandler{RetentionService: service}
+}
+
+func (h *RetentionHandler) Preview(c echo.Context) error {
+	preview, err := h.RetentionService.Preview(c.Request().Context())
+	if err != nil {
+		return tax.InternalError(c, "Failed to preview purge", err)
+	}
+	return c.JSON(http.StatusOK, preview)
+}
@@ -0,0 +1,44 @@
+package retention
+
+import (
+	"context"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRetentionHandler_Preview(t *testing.T) {
+	before := daysAgo(30)
+	service := new(MockRetentionService)
+	service.On("Preview").Return(model.RetentionPreview{
+		GeneratedAt: testNow,
+		Classes:     []model.RetentionClass{{Class: ClassBatchInputs, Retention: "30d", Before: &before, Records: 12}},
+	}, nil)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	h := NewRetentionHandler(service)
+
+	if assert.NoError(t, h.Preview(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), `"class":"batchInputs","retention":"30d","before":"2026-09-01T12:00:00Z","records":12`)
+	}
+}
+
+func TestRetentionHandler_Preview_Error(t *testing.T) {
+	service := new(MockRetentionService)
+	service.On("Preview").Return(model.RetentionPreview{}, context.DeadlineExceeded)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+	h := NewRetentionHandler(service)
+
+	if assert.NoError(t, h.Preview(c)) {
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	}
+}
@@ -0,0 +1,176 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+	"time"
+)
+
+// purgeLockKey identifies the advisory lock held while purging, so only one
+// instance purges at a time.
+const purgeLockKey int64 = 0x726574656e74
+
+var ErrPurgeRunning = errors.New("another instance is purging")
+
+// Cutoffs holds, for each data class, the time before which its records are
+// purged. A zero time keeps the class forever.
+type Cutoffs struct {
+	BatchInputs  time.Time
+	BatchOutputs time.Time
+	Calculations time.Time
+	AuditEvents  time.Time
+}
+
+// Counts is the number of records purged, or due to be, in each class.
+// Batches counts the batches left without rows.
+type Counts struct {
+	BatchInputs  int64 `json:"batchInputs"`
+	BatchOutputs int64 `json:"batchOutputs"`
+	Calculations int64 `json:"calculations"`
+	AuditEvents  int64 `json:"auditEvents"`
+	Batches      int64 `json:"batches"`
+}
+
+func (c Counts) Total() int64 {
+	return c.BatchInputs + c.BatchOutputs + c.Calculations + c.AuditEvents + c.Batches
+}
+
+type RetentionRepositories interface {
+	CountExpired(ctx context.Context, cutoffs Cutoffs) (Counts, error)
+	Purge(ctx context.Context, cutoffs Cutoffs, audit func(Counts) (modelgorm.AuditEventGorm, error)) (Counts, error)
+}
+
+type RetentionRepository struct {
+	DB *gorm.DB
+}
+
+func NewRetentionRepository(db *gorm.DB) RetentionRepositories {
+	return &RetentionRepository{DB: db}
+}
+
+// batchOutputs are CSV rows past retention; purging deletes them.
+func batchOutputs(db *gorm.DB, cutoffs Cutoffs) *gorm.DB {
+	return db.Model(&modelgorm.CalculationGorm{}).
+		Where("batch_id IS NOT NULL AND created_at < ?", cutoffs.BatchOutputs)
+}
+
+// batchInputs are CSV rows whose input is past retention but whose output
+// is kept; purging clears the input.
+func batchInputs(db *gorm.DB, cutoffs Cutoffs) *gorm.DB {
+	query := db.Model(&modelgorm.CalculationGorm{}).
+		Where("batch_id IS NOT NULL AND input <> '' AND created_at < ?", cutoffs.BatchInputs)
+	if !cutoffs.BatchOutputs.IsZero() {
+		query = query.Where("created_at >= ?", cutoffs.BatchOutputs)
+	}
+	return query
+}
+
+// calculations are single calculations past retention.
+func calculations(db *gorm.DB, cutoffs Cutoffs) *gorm.DB {
+	return db.Model(&modelgorm.CalculationGorm{}).
+		Where("batch_id IS NULL AND created_at < ?", cutoffs.Calculations)
+}
+
+func auditEvents(db *gorm.DB, cutoffs Cutoffs) *gorm.DB {
+	return db.Model(&modelgorm.AuditEventGorm{}).Where("created_at < ?", cutoffs.AuditEvents)
+}
+
+// batches are batches past output retention with no row that is kept.
+func batches(db *gorm.DB, cutoffs Cutoffs) *gorm.DB {
+	return db.Model(&modelgorm.CalculationBatchGorm{}).
+		Where("created_at < ?", cutoffs.BatchOutputs).
+		Where("NOT EXISTS (SELECT 1 FROM calculation_gorms WHERE calculation_gorms.batch_id = calculation_batch_gorms.id AND calculation_gorms.created_at >= ?)", cutoffs.BatchOutputs)
+}
+
+// CountExpired counts what Purge would remove with the same cutoffs.
+func (repo *RetentionRepository) CountExpired(ctx context.Context, cutoffs Cutoffs) (Counts, error) {
+	db := repo.DB.WithContext(ctx)
+	var counts Counts
+	queries := []struct {
+		cutoff time.Time
+		query  func(*gorm.DB, Cutoffs) *gorm.DB
+		count  *int64
+	}{
+		{cutoffs.BatchOutputs, batchOutputs, &counts.BatchOutputs},
+		{cutoffs.BatchInputs, batchInputs, &counts.BatchInputs},
+		{cutoffs.Calculations, calculations, &counts.Calculations},
+		{cutoffs.AuditEvents, auditEvents, &counts.AuditEvents},
+		{cutoffs.BatchOutputs, batches, &counts.Batches},
+	}
+	for _, q := range queries {
+		if q.cutoff.IsZero() {
+			continue
+		}
+		if err := q.query(db, cutoffs).Count(q.count).Error; err != nil {
+			return Counts{}, err
+		}
+	}
+	return counts, nil
+}
+
+// Purge removes everything past its cutoff in one transaction and, if
+// anything was removed, records the event audit builds in it too. On
+// Postgres it returns ErrPurgeRunning while another instance is purging.
+func (repo *RetentionRepository) Purge(ctx context.Context, cutoffs Cutoffs, audit func(Counts) (modelgorm.AuditEventGorm, error)) (Counts, error) {
+	var counts Counts
+	err := repo.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			var locked bool
+			if err := tx.Raw("SELECT pg_try_advisory_xact_lock(?)", purgeLockKey).Scan(&locked).Error; err != nil {
+				return err
+			}
+			if !locked {
+				return ErrPurgeRunning
+			}
+		}
+
+		if !cutoffs.BatchOutputs.IsZero() {
+			result := batchOutputs(tx, cutoffs).Delete(&modelgorm.CalculationGorm{})
+			if result.Error != nil {
+				return result.Error
+			}
+			counts.BatchOutputs = result.RowsAffected
+			result = batches(tx, cutoffs).Delete(&modelgorm.CalculationBatchGorm{})
+			if result.Error != nil {
+				return result.Error
+			}
+			counts.Batches = result.RowsAffected
+		}
+		if !cutoffs.BatchInputs.IsZero() {
+			result := batchInputs(tx, cutoffs).Update("input", "")
+			if result.Error != nil {
+				return result.Error
+			}
+			counts.BatchInputs = result.RowsAffected
+		}
+		if !cutoffs.Calculations.IsZero() {
+			result := calculations(tx, cutoffs).Delete(&modelgorm.CalculationGorm{})
+			if result.Error != nil {
+				return result.Error
+			}
+			counts.Calculations = result.RowsAffected
+		}
+		if !cutoffs.AuditEvents.IsZero() {
+			result := auditEvents(tx, cutoffs).Delete(&modelgorm.AuditEventGorm{})
+			if result.Error != nil {
+				return result.Error
+			}
+			counts.AuditEvents = result.RowsAffected
+		}
+
+		if counts.Total() == 0 {
+			return nil
+		}
+		event, err := audit(counts)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&event).Error
+	})
+	if err != nil {
+		return Counts{}, err
+	}
+	return counts, nil
+}
@@ -0,0 +1,130 @@
+package retention
+
+import (
+	"context"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pphee/assessment-tax/store"
+	"github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+func newSQLiteDB(t *testing.T) *gorm.DB {
+	sqlite, err := store.NewSQLiteStore(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlite.Close() })
+	require.NoError(t, sqlite.Migrate(context.Background()))
+	return sqlite.Gorm()
+}
+
+func daysAgo(days int) time.Time {
+	return testNow.Add(-time.Duration(days) * 24 * time.Hour)
+}
+
+// seedData stores a batch and a single calculation at each age, and an audit
+// event at each age.
+func seedData(t *testing.T, db *gorm.DB, ages ...int) {
+	for _, age := range ages {
+		batch := modelgorm.CalculationBatchGorm{Rows: 2, ConfigVersion: 1, CreatedAt: daysAgo(age)}
+		require.NoError(t, db.Create(&batch).Error)
+		for i := 0; i < 2; i++ {
+			require.NoError(t, db.Create(&modelgorm.CalculationGorm{
+				BatchID: &batch.ID, Input: `{"totalIncome":500000}`, Output: `{"tax":29000}`, ConfigVersion: 1, CreatedAt: daysAgo(age),
+			}).Error)
+		}
+		require.NoError(t, db.Create(&modelgorm.CalculationGorm{
+			Input: `{"totalIncome":500000}`, Output: `{"tax":29000}`, ConfigVersion: 1, CreatedAt: daysAgo(age),
+		}).Error)
+		require.NoError(t, db.Create(&modelgorm.AuditEventGorm{
+			Actor: "alice", Action: "change_request.submitted", Entity: "change_request", CreatedAt: daysAgo(age),
+		}).Error)
+	}
+}
+
+func noAudit(t *testing.T) func(Counts) (modelgorm.AuditEventGorm, error) {
+	return func(Counts) (modelgorm.AuditEventGorm, error) {
+		t.Fatal("nothing was purged, so nothing should be audited")
+		return modelgorm.AuditEventGorm{}, nil
+	}
+}
+
+func TestRetentionRepository_Purge(t *testing.T) {
+	db := newSQLiteDB(t)
+	repo := NewRetentionRepository(db)
+	seedData(t, db, 10, 40, 400)
+	cutoffs := Cutoffs{
+		BatchInputs:  daysAgo(30),
+		BatchOutputs: daysAgo(365),
+		Calculations: daysAgo(90),
+		AuditEvents:  daysAgo(365),
+	}
+
+	preview, err := repo.CountExpired(context.Background(), cutoffs)
+	require.NoError(t, err)
+	want := Counts{BatchInputs: 2, BatchOutputs: 2, Calculations: 1, AuditEvents: 1, Batches: 1}
+	assert.Equal(t, want, preview)
+
+	var audited Counts
+	purged, err := repo.Purge(context.Background(), cutoffs, func(counts Counts) (modelgorm.AuditEventGorm, error) {
+		audited = counts
+		return modelgorm.AuditEventGorm{Actor: "system", Action: "retention.purged", Entity: "retention", CreatedAt: testNow}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, want, purged)
+	assert.Equal(t, want, audited)
+
+	var cleared, kept, single, batches, events int64
+	db.Model(&modelgorm.CalculationGorm{}).Where("batch_id IS NOT NULL AND input = ''").Count(&cleared)
+	db.Model(&modelgorm.CalculationGorm{}).Where("batch_id IS NOT NULL AND input <> ''").Count(&kept)
+	db.Model(&modelgorm.CalculationGorm{}).Where("batch_id IS NULL").Count(&single)
+	db.Model(&modelgorm.CalculationBatchGorm{}).Count(&batches)
+	db.Model(&modelgorm.AuditEventGorm{}).Where("action = ?", "retention.purged").Count(&events)
+	assert.Equal(t, int64(2), cleared, "rows from 40 days ago keep their output")
+	assert.Equal(t, int64(2), kept, "rows from 10 days ago are untouched")
+	assert.Equal(t, int64(2), single)
+	assert.Equal(t, int64(2), batches)
+	assert.Equal(t, int64(1), events)
+
+	again, err := repo.Purge(context.Background(), cutoffs, noAudit(t))
+	assert.NoError(t, err)
+	assert.Zero(t, again.Total())
+}
+
+func TestRetentionRepository_Purge_KeepForever(t *testing.T) {
+	db := newSQLiteDB(t)
+	repo := NewRetentionRepository(db)
+	seedData(t, db, 4000)
+
+	counts, err := repo.CountExpired(context.Background(), Cutoffs{})
+	require.NoError(t, err)
+	assert.Zero(t, counts.Total())
+
+	counts, err = repo.Purge(context.Background(), Cutoffs{}, noAudit(t))
+	assert.NoError(t, err)
+	assert.Zero(t, counts.Total())
+}
+
+func TestRetentionRepository_Purge_Locked(t *testing.T) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB, PreferSimpleProtocol: true}), &gorm.Config{})
+	require.NoError(t, err)
+	repo := NewRetentionRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT pg_try_advisory_xact_lock`).
+		WithArgs(purgeLockKey).
+		WillReturnRows(sqlmock.NewRows([]string{"pg_try_advisory_xact_lock"}).AddRow(false))
+	mock.ExpectRollback()
+
+	_, err = repo.Purge(context.Background(), Cutoffs{Calculations: daysAgo(90)}, noAudit(t))
+
+	assert.ErrorIs(t, err, ErrPurgeRunning)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,167 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/audit"
+	"github.com/pphee/assessment-tax/store/model"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ClassBatchInputs  = "batchInputs"
+	ClassBatchOutputs = "batchOutputs"
+	ClassCalculations = "calculations"
+	ClassAuditEvents  = "auditEvents"
+
+	DefaultInterval = time.Hour
+
+	auditEntity = "retention"
+	systemActor = "system"
+)
+
+// Policy is how long each class of data is kept; zero keeps it forever.
+// BatchInputs is the stored input of each CSV row and BatchOutputs the row
+// itself; Calculations covers single calculations.
+type Policy struct {
+	BatchInputs  time.Duration
+	BatchOutputs time.Duration
+	Calculations time.Duration
+	AuditEvents  time.Duration
+}
+
+func (p Policy) cutoffs(now time.Time) Cutoffs {
+	cutoff := func(period time.Duration) time.Time {
+		if period <= 0 {
+			return time.Time{}
+		}
+		return now.Add(-period)
+	}
+	return Cutoffs{
+		BatchInputs:  cutoff(p.BatchInputs),
+		BatchOutputs: cutoff(p.BatchOutputs),
+		Calculations: cutoff(p.Calculations),
+		AuditEvents:  cutoff(p.AuditEvents),
+	}
+}
+
+// ParsePeriod parses a retention period written as a number of days, such
+// as "90d", or as a Go duration. An empty string keeps data forever.
+func ParsePeriod(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	var period time.Duration
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid retention period %q", s)
+		}
+		period = time.Duration(n) * 24 * time.Hour
+	} else {
+		var err error
+		if period, err = time.ParseDuration(s); err != nil {
+			return 0, fmt.Errorf("invalid retention period %q", s)
+		}
+	}
+	if period < 0 {
+		return 0, fmt.Errorf("retention period %q is negative", s)
+	}
+	return period, nil
+}
+
+func formatPeriod(period time.Duration) string {
+	if period <= 0 {
+		return ""
+	}
+	if period%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", period/(24*time.Hour))
+	}
+	return period.String()
+}
+
+type RetentionServices interface {
+	Preview(ctx context.Context) (model.RetentionPreview, error)
+	Purge(ctx context.Context) (Counts, error)
+}
+
+type RetentionService struct {
+	Repo   RetentionRepositories
+	Policy Policy
+	now    func() time.Time
+}
+
+func NewRetentionService(repo RetentionRepositories, policy Policy) RetentionServices {
+	return &RetentionService{Repo: repo, Policy: policy, now: time.Now}
+}
+
+// Preview lists what a purge run now would remove.
+func (service *RetentionService) Preview(ctx context.Context) (model.RetentionPreview, error) {
+	now := service.now()
+	cutoffs := service.Policy.cutoffs(now)
+	counts, err := service.Repo.CountExpired(ctx, cutoffs)
+	if err != nil {
+		return model.RetentionPreview{}, fmt.Errorf("failed to count expired records: %w", err)
+	}
+
+	class := func(name string, period time.Duration, cutoff time.Time, records int64) model.RetentionClass {
+		c := model.RetentionClass{Class: name, Retention: formatPeriod(period), Records: records}
+		if !cutoff.IsZero() {
+			c.Before = &cutoff
+		}
+		return c
+	}
+	return model.RetentionPreview{
+		GeneratedAt: now,
+		Classes: []model.RetentionClass{
+			class(ClassBatchInputs, service.Policy.BatchInputs, cutoffs.BatchInputs, counts.BatchInputs),
+			class(ClassBatchOutputs, service.Policy.BatchOutputs, cutoffs.BatchOutputs, counts.BatchOutputs),
+			class(ClassCalculations, service.Policy.Calculations, cutoffs.Calculations, counts.Calculations),
+			class(ClassAuditEvents, service.Policy.AuditEvents, cutoffs.AuditEvents, counts.AuditEvents),
+		},
+	}, nil
+}
+
+// Purge removes everything past its retention period. A purge that removes
+// anything is recorded in the audit log in the same transaction.
+func (service *RetentionService) Purge(ctx context.Context) (Counts, error) {
+	if service.Policy == (Policy{}) {
+		return Counts{}, nil
+	}
+	counts, err := service.Repo.Purge(ctx, service.Policy.cutoffs(service.now()), func(counts Counts) (modelgorm.AuditEventGorm, error) {
+		return audit.NewEvent(systemActor, "retention.purged", auditEntity, "", counts)
+	})
+	if err != nil {
+		return Counts{}, fmt.Errorf("failed to purge expired records: %w", err)
+	}
+	return counts, nil
+}
+
+// RunPurger calls Purge every interval until ctx is cancelled. Runs skipped
+// because another instance holds the lock are not logged.
+func RunPurger(ctx context.Context, service RetentionServices, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			counts, err := service.Purge(ctx)
+			switch {
+			case errors.Is(err, ErrPurgeRunning):
+			case err != nil:
+				log.Printf("retention purge failed: %v", err)
+			case counts.Total() > 0:
+				log.Printf("retention purge removed %d batch inputs, %d batch rows, %d batches, %d calculations and %d audit events",
+					counts.BatchInputs, counts.BatchOutputs, counts.Batches, counts.Calculations, counts.AuditEvents)
+			}
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) CountExpired(ctx context.Context, cutoffs Cutoffs) (Counts, error) {
+	args := m.Called(cutoffs)
+	return args.Get(0).(Counts), args.Error(1)
+}
+
+func (m *MockRepo) Purge(ctx context.Context, cutoffs Cutoffs, audit func(Counts) (modelgorm.AuditEventGorm, error)) (Counts, error) {
+	args := m.Called(cutoffs)
+	counts := args.Get(0).(Counts)
+	if args.Error(1) == nil && counts.Total() > 0 {
+		if _, err := audit(counts); err != nil {
+			return Counts{}, err
+		}
+	}
+	return counts, args.Error(1)
+}
+
+type MockRetentionService struct {
+	mock.Mock
+}
+
+func (m *MockRetentionService) Preview(ctx context.Context) (model.RetentionPreview, error) {
+	args := m.Called()
+	return args.Get(0).(model.RetentionPreview), args.Error(1)
+}
+
+func (m *MockRetentionService) Purge(ctx context.Context) (Counts, error) {
+	args := m.Called()
+	return args.Get(0).(Counts), args.Error(1)
+}
+
+func newTestService(repo RetentionRepositories, policy Policy) *RetentionService {
+	return &RetentionService{Repo: repo, Policy: policy, now: func() time.Time { return testNow }}
+}
+
+func TestParsePeriod(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"90d", 90 * 24 * time.Hour, false},
+		{"0d", 0, false},
+		{"36h", 36 * time.Hour, false},
+		{"d", 0, true},
+		{"1.5d", 0, true},
+		{"-1d", 0, true},
+		{"forever", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePeriod(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestPreview(t *testing.T) {
+	repo := new(MockRepo)
+	repo.On("CountExpired", Cutoffs{BatchInputs: daysAgo(30), AuditEvents: testNow.Add(-36 * time.Hour)}).
+		Return(Counts{BatchInputs: 12, AuditEvents: 3}, nil)
+	service := newTestService(repo, Policy{BatchInputs: 30 * 24 * time.Hour, AuditEvents: 36 * time.Hour})
+
+	preview, err := service.Preview(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, testNow, preview.GeneratedAt)
+	require.Len(t, preview.Classes, 4)
+	inputs := preview.Classes[0]
+	assert.Equal(t, ClassBatchInputs, inputs.Class)
+	assert.Equal(t, "30d", inputs.Retention)
+	assert.Equal(t, daysAgo(30), *inputs.Before)
+	assert.Equal(t, int64(12), inputs.Records)
+	assert.Equal(t, model.RetentionClass{Class: ClassCalculations}, preview.Classes[2])
+	assert.Equal(t, "36h0m0s", preview.Classes[3].Retention)
+}
+
+func TestPurge(t *testing.T) {
+	t.Run("Audited", func(t *testing.T) {
+		repo := new(MockRepo)
+		repo.On("Purge", Cutoffs{Calculations: daysAgo(90)}).Return(Counts{Calculations: 4}, nil)
+		service := newTestService(repo, Policy{Calculations: 90 * 24 * time.Hour})
+
+		counts, err := service.Purge(context.Background())
+
+		assert.NoError(t, err)
+		assert.Equal(t, int64(4), counts.Calculations)
+		repo.AssertExpectations(t)
+	})
+
+	t.Run("No Policy", func(t *testing.T) {
+		repo := new(MockRepo)
+		service := newTestService(repo, Policy{})
+
+		counts, err := service.Purge(context.Background())
+
+		assert.NoError(t, err)
+		assert.Zero(t, counts.Total())
+		repo.AssertNotCalled(t, "Purge", mock.Anything)
+	})
+
+	t.Run("Locked", func(t *testing.T) {
+		repo := new(MockRepo)
+		repo.On("Purge", mock.Anything).Return(Counts{}, ErrPurgeRunning)
+		service := newTestService(repo, Policy{AuditEvents: time.Hour})
+
+		_, err := service.Purge(context.Background())
+
+		assert.ErrorIs(t, err, ErrPurgeRunning)
+	})
+}
+
+func TestRunPurger(t *testing.T) {
+	service := new(MockRetentionService)
+	purged := make(chan struct{}, 1)
+	service.On("Purge").Return(Counts{}, nil).Run(func(mock.Arguments) {
+		select {
+		case purged <- struct{}{}:
+		default:
+		}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		RunPurger(ctx, service, time.Millisecond)
+		close(done)
+	}()
+
+	select {
+	case <-purged:
+	case <-time.After(time.Second):
+		t.Fatal("purger did not run")
+	}
+	cancel()
+	<-done
+}
+
+func TestPreview_Error(t *testing.T) {
+	repo := new(MockRepo)
+	repo.On("CountExpired", mock.Anything).Return(Counts{}, errors.New("connection lost"))
+	service := newTestService(repo, Policy{AuditEvents: time.Hour})
+
+	_, err := service.Preview(context.Background())
+
+	assert.ErrorContains(t, err, "connection lost")
+}
@@ -0,0 +1,46 @@
+package tax_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/module/tax/taxtest"
+	"github.com/pphee/assessment-tax/store"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryTaxRepository_Conformance(t *testing.T) {
+	taxtest.RunConformance(t, func(t *testing.T) tax.TaxRepositories {
+		return tax.NewMemoryTaxRepository()
+	})
+}
+
+func TestSQLiteTaxRepository_Conformance(t *testing.T) {
+	taxtest.RunConformance(t, func(t *testing.T) tax.TaxRepositories {
+		sqliteStore, err := store.NewSQLiteStore(":memory:")
+		require.NoError(t, err)
+		t.Cleanup(func() { sqliteStore.Close() })
+		require.NoError(t, sqliteStore.Migrate(context.Background()))
+		return tax.NewTaxRepository(sqliteStore.DB)
+	})
+}
+
+// TEST_DATABASE_URL must point at a disposable database: the schema is
+// dropped before every test.
+func TestPostgresTaxRepository_Conformance(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set")
+	}
+	taxtest.RunConformance(t, func(t *testing.T) tax.TaxRepositories {
+		pgStore, err := store.NewPostgresStore(dsn, store.Pool{})
+		require.NoError(t, err)
+		t.Cleanup(func() { pgStore.Close() })
+		require.NoError(t, pgStore.Ping(context.Background()))
+		require.NoError(t, pgStore.DB.Exec("DROP SCHEMA IF EXISTS "+store.Schema+" CASCADE").Error)
+		require.NoError(t, pgStore.Migrate(context.Background()))
+		return tax.NewTaxRepository(pgStore.DB)
+	})
+}
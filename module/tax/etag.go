@@ -0,0 +1,62 @@
+package tax
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/problem"
+	"net/http"
+	"strings"
+)
+
+// SettingETag identifies one version of a setting.
+func SettingETag(setting model.AllowanceSetting) string {
+	return fmt.Sprintf(`"%s-%d"`, setting.Key, setting.Version)
+}
+
+// SettingsETag identifies the versions of all settings together, so it
+// changes whenever any one of them does.
+func SettingsETag(settings []model.AllowanceSetting) string {
+	hash := sha256.New()
+	for _, setting := range settings {
+		fmt.Fprintf(hash, "%s:%d\n", setting.Key, setting.Version)
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil))[:16] + `"`
+}
+
+// matchETag reports whether an If-Match value lists etag. If-Match compares
+// strongly, so weak tags never match. "*" does not match either: a write
+// must name the version it read, or it could overwrite one it never saw.
+func matchETag(ifMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkIfMatch responds with 428 or 412 unless the request's If-Match lists
+// etag, and reports whether the write may go ahead.
+func checkIfMatch(c echo.Context, etag string) (bool, error) {
+	ifMatch := c.Request().Header.Get("If-Match")
+	if ifMatch == "" {
+		return false, problem.Respond(c, http.StatusPreconditionRequired, "if_match_required", "If-Match is required; send the ETag from reading the setting")
+	}
+	if !matchETag(ifMatch, etag) {
+		c.Response().Header().Set("ETag", etag)
+		return false, problem.Respond(c, http.StatusPreconditionFailed, "version_conflict", ErrVersionConflict.Error())
+	}
+	return true, nil
+}
+
+func findSetting(settings []model.AllowanceSetting, key string) (model.AllowanceSetting, bool) {
+	for _, setting := range settings {
+		if setting.Key == key {
+			return setting, true
+		}
+	}
+	return model.AllowanceSetting{}, false
+}
@@ -1,15 +1,80 @@
 package tax
 
 import (
+	"errors"
 	"github.com/labstack/echo/v4"
 	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/problem"
+	"github.com/pphee/assessment-tax/module/validate"
+	"github.com/pphee/assessment-tax/store/model"
 	"github.com/pphee/assessment-tax/utils"
 	"mime/multipart"
 	"net/http"
+	"strconv"
 )
 
+var ErrRowQuotaExceeded = errors.New("daily row quota exceeded")
+
+// StatusClientClosedRequest is the non-standard status, from nginx, for a
+// client that went away before its response was ready.
+const StatusClientClosedRequest = problem.StatusClientClosedRequest
+
+// InternalError responds to an unexpected error. Work abandoned because the
+// request's context ended is not a server fault: it is reported as 499 when
+// the client went away and 503 when an operation ran out of time or the
+// database is down. Anything else is a 500 that hides err from the client.
+func InternalError(c echo.Context, message string, err error) error {
+	if errors.Is(err, ErrDatabaseUnavailable) {
+		return problem.Respond(c, http.StatusServiceUnavailable, "database_unavailable", message+": "+ErrDatabaseUnavailable.Error())
+	}
+	return problem.Internal(c, message, err)
+}
+
+// Problem responds with a problem whose detail is err, naming the field err
+// is about when it is a FieldError and translating it when the FieldError
+// has a message.
+func Problem(c echo.Context, status int, code string, err error) error {
+	var fieldErr *FieldError
+	if errors.As(err, &fieldErr) {
+		if fieldErr.Message.Key != "" {
+			return problem.RespondMessage(c, status, code, fieldErr.Field, fieldErr.Message)
+		}
+		return problem.RespondField(c, status, code, fieldErr.Field, err.Error())
+	}
+	return problem.Respond(c, status, code, err.Error())
+}
+
+// CalculationError responds to an error from calculating tax. Mistakes in
+// the request are 400s naming the field at fault.
+func CalculationError(c echo.Context, message string, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidNationalID):
+		return Problem(c, http.StatusBadRequest, "invalid_national_id", err)
+	case errors.Is(err, ErrNegativeAllowance):
+		return Problem(c, http.StatusBadRequest, "negative_allowance", err)
+	case errors.Is(err, ErrPersonalAllowanceOutOfRange):
+		return Problem(c, http.StatusBadRequest, "personal_allowance_out_of_range", err)
+	case errors.Is(err, ErrInvalidWHT):
+		return Problem(c, http.StatusBadRequest, "invalid_wht", err)
+	case errors.Is(err, ErrTaxpayerNotFound):
+		return problem.RespondMessage(c, http.StatusNotFound, "taxpayer_not_found", "", i18n.Msg("problem.taxpayer_not_found"))
+	case errors.Is(err, ErrScheduleNotFound):
+		return Problem(c, http.StatusNotFound, "schedule_not_found", err)
+	}
+	return InternalError(c, message, err)
+}
+
+// RowQuota limits how many CSV rows a caller may upload. release gives
+// reserved rows back when they end up not being calculated.
+type RowQuota interface {
+	ReserveRows(c echo.Context, rows int) (release func(), err error)
+}
+
 type TaxHandler struct {
 	TaxService TaxServices
+	RowQuota   RowQuota
 }
 
 func NewTaxHandler(service TaxServices) *TaxHandler {
@@ -17,75 +82,201 @@ func NewTaxHandler(service TaxServices) *TaxHandler {
 }
 
 func (h *TaxHandler) PostTaxCalculation(c echo.Context) error {
+	return h.calculate(c, func(res model.TaxResponse) error {
+		loc := i18n.From(c)
+		for i := range res.TaxLevels {
+			res.TaxLevels[i].Level = loc.TaxLevel(res.TaxLevels[i].Rate)
+		}
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+// calculate calculates the tax of the income in the body and passes the
+// result, with the national ID masked unless the caller may see it, to
+// respond, which writes it in the version of the API called.
+func (h *TaxHandler) calculate(c echo.Context, respond func(model.TaxResponse) error) error {
 	var req model.TaxRequest
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid request data: " + err.Error()})
+	if err := validate.Bind(c, &req); err != nil {
+		return validate.Respond(c, err)
 	}
 
-	tax, taxBrackets, err := h.TaxService.CalculateTax(req)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Tax calculation failed: " + err.Error()})
+	if principal, ok := auth.PrincipalFrom(c); ok {
+		req.Owner = principal.Username
+	}
+	var err error
+	if req.NationalID, err = NormalizeNationalID(req.NationalID); err != nil {
+		return CalculationError(c, "Tax calculation failed", err)
 	}
 
-	res := model.TaxResponse{
-		Tax:       tax,
-		TaxLevels: taxBrackets,
+	res, err := h.TaxService.CalculateTax(c.Request().Context(), req)
+	if err != nil {
+		return CalculationError(c, "Tax calculation failed", err)
 	}
 
-	return c.JSON(http.StatusOK, res)
+	if !auth.CanSeePersonalData(c) {
+		res.NationalID = utils.MaskNationalID(res.NationalID)
+	}
+	return respond(res)
 }
 
 func (h *TaxHandler) SetPersonalDeduction(c echo.Context) error {
 	var req model.AdminRequest
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format or data types"})
+	if err := validate.Bind(c, &req); err != nil {
+		return validate.Respond(c, err)
 	}
 
-	if req.Amount < 10000 || req.Amount > 100000 {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Amount must be between 10,000 and 100,000"})
+	return h.updateSetting(c, "Failed to set personal deduction", modelgorm.PersonalDefault, req.Amount, func(setting model.AllowanceSetting) error {
+		return c.JSON(http.StatusOK, model.AdminPersonalDeductionResponse{Amount: setting.Amount})
+	})
+}
+
+func (h *TaxHandler) SetKreceiptDeduction(c echo.Context) error {
+	var req model.AdminRequest
+	if err := validate.Bind(c, &req); err != nil {
+		return validate.Respond(c, err)
 	}
 
-	if err := h.TaxService.SetPersonalDeduction(req.Amount); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to set personal deduction: " + err.Error()})
+	return h.updateSetting(c, "Failed to set K-receipt deduction", modelgorm.KReceiptDefault, req.Amount, func(setting model.AllowanceSetting) error {
+		return c.JSON(http.StatusOK, model.AdminKReceiptDeductionResponse{Amount: setting.Amount})
+	})
+}
+
+func (h *TaxHandler) GetAllowanceSettings(c echo.Context) error {
+	settings, err := h.TaxService.GetAllowanceSettings(c.Request().Context())
+	if err != nil {
+		return InternalError(c, "Failed to get allowance settings", err)
 	}
 
-	res := model.AdminPersonalDeductionResponse{
-		Amount: req.Amount,
+	c.Response().Header().Set("ETag", SettingsETag(settings))
+	return c.JSON(http.StatusOK, model.AdminSettingsResponse{Settings: settings})
+}
+
+func (h *TaxHandler) GetAllowanceSetting(c echo.Context) error {
+	settings, err := h.TaxService.GetAllowanceSettings(c.Request().Context())
+	if err != nil {
+		return InternalError(c, "Failed to get allowance settings", err)
+	}
+
+	key := c.Param("key")
+	setting, ok := findSetting(settings, key)
+	if !ok {
+		return problem.Respond(c, http.StatusNotFound, "unknown_setting", "Unknown allowance setting: "+key)
 	}
 
-	return c.JSON(http.StatusOK, res)
+	c.Response().Header().Set("ETag", SettingETag(setting))
+	return c.JSON(http.StatusOK, setting)
 }
 
-func (h *TaxHandler) SetKreceiptDeduction(c echo.Context) error {
+func (h *TaxHandler) UpdateAllowanceSetting(c echo.Context) error {
 	var req model.AdminRequest
-	if err := c.Bind(&req); err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Invalid JSON format or data types"})
+	if err := validate.Bind(c, &req); err != nil {
+		return validate.Respond(c, err)
+	}
+
+	return h.updateSetting(c, "Failed to update allowance setting", c.Param("key"), req.Amount, func(setting model.AllowanceSetting) error {
+		return c.JSON(http.StatusOK, setting)
+	})
+}
+
+// updateSetting writes one setting, provided If-Match holds the ETag of its
+// current version, and passes the updated setting to respond.
+func (h *TaxHandler) updateSetting(c echo.Context, message, key string, amount float64, respond func(model.AllowanceSetting) error) error {
+	ctx := c.Request().Context()
+	if _, ok := modelgorm.LookupAllowanceSpec(key); !ok {
+		return problem.Respond(c, http.StatusNotFound, "unknown_setting", "Unknown allowance setting: "+key)
+	}
+	update := model.AllowanceSettingUpdate{Key: key, Amount: amount}
+	if err := h.TaxService.ValidateAllowanceSettings([]model.AllowanceSettingUpdate{update}); err != nil {
+		// The body holds the one amount, not a list of settings
+		return settingsError(c, message, &FieldError{Field: "amount", Err: err})
+	}
+
+	settings, err := h.TaxService.GetAllowanceSettings(ctx)
+	if err != nil {
+		return InternalError(c, message, err)
+	}
+	current, _ := findSetting(settings, key)
+	if ok, err := checkIfMatch(c, SettingETag(current)); !ok {
+		return err
 	}
 
-	if req.Amount < 0 || req.Amount > 100000 {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Amount must be between 0 and 100,000"})
+	update.Version = current.Version
+	settings, err = h.TaxService.UpdateAllowanceSettings(ctx, []model.AllowanceSettingUpdate{update})
+	if err != nil {
+		return settingsError(c, message, err)
+	}
+
+	updated, _ := findSetting(settings, key)
+	c.Response().Header().Set("ETag", SettingETag(updated))
+	return respond(updated)
+}
+
+// UpdateAllowanceSettings needs If-Match to hold the ETag of the whole
+// collection. Each setting written must still have the version it had when
+// that ETag was checked.
+func (h *TaxHandler) UpdateAllowanceSettings(c echo.Context) error {
+	var req model.AdminSettingsRequest
+	if err := validate.Bind(c, &req); err != nil {
+		return validate.Respond(c, err)
 	}
 
-	if err := h.TaxService.SetKReceiptDeduction(req.Amount); err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "Failed to set K-receipt deduction: " + err.Error()})
+	ctx := c.Request().Context()
+	if err := h.TaxService.ValidateAllowanceSettings(req.Settings); err != nil {
+		return settingsError(c, "Failed to update allowance settings", err)
 	}
 
-	res := model.AdminKReceiptDeductionResponse{
-		Amount: req.Amount,
+	settings, err := h.TaxService.GetAllowanceSettings(ctx)
+	if err != nil {
+		return InternalError(c, "Failed to update allowance settings", err)
+	}
+	if ok, err := checkIfMatch(c, SettingsETag(settings)); !ok {
+		return err
 	}
 
-	return c.JSON(http.StatusOK, res)
+	for i := range req.Settings {
+		current, _ := findSetting(settings, req.Settings[i].Key)
+		req.Settings[i].Version = current.Version
+	}
+	settings, err = h.TaxService.UpdateAllowanceSettings(ctx, req.Settings)
+	if err != nil {
+		return settingsError(c, "Failed to update allowance settings", err)
+	}
+
+	c.Response().Header().Set("ETag", SettingsETag(settings))
+	return c.JSON(http.StatusOK, model.AdminSettingsResponse{Settings: settings})
+}
+
+func settingsError(c echo.Context, message string, err error) error {
+	switch {
+	case errors.Is(err, ErrUnknownAllowance):
+		return Problem(c, http.StatusBadRequest, "unknown_setting", err)
+	case errors.Is(err, ErrAllowanceOutOfRange):
+		return Problem(c, http.StatusBadRequest, "setting_out_of_range", err)
+	case errors.Is(err, ErrInvalidSettings):
+		return Problem(c, http.StatusBadRequest, "invalid_settings", err)
+	case errors.Is(err, ErrVersionConflict):
+		return Problem(c, http.StatusPreconditionFailed, "version_conflict", err)
+	}
+	return InternalError(c, message, err)
 }
 
 func (h *TaxHandler) TaxCalculationsCSVHandler(c echo.Context) error {
+	return h.calculateFile(c, func(res model.TaxResponseCSV) error {
+		return c.JSON(http.StatusOK, res)
+	})
+}
+
+// calculateFile calculates the tax of every row of the uploaded CSV file and
+// passes the results, masked like calculate's, to respond.
+func (h *TaxHandler) calculateFile(c echo.Context, respond func(model.TaxResponseCSV) error) error {
 	file, err := c.FormFile("taxes")
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "No file uploaded"})
+		return problem.RespondMessage(c, http.StatusBadRequest, "missing_file", "taxes", i18n.Msg("validation.missing_file"))
 	}
 
 	src, err := file.Open()
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Error opening file"})
+		return problem.RespondMessage(c, http.StatusBadRequest, "invalid_file", "taxes", i18n.Msg("validation.invalid_file"))
 	}
 	defer func(src multipart.File) {
 		err := src.Close()
@@ -96,32 +287,148 @@ func (h *TaxHandler) TaxCalculationsCSVHandler(c echo.Context) error {
 
 	records, err := h.TaxService.TaxFromFile(src)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, echo.Map{"error": "Error reading file"})
+		return problem.RespondMessage(c, http.StatusBadRequest, "invalid_csv", "taxes", i18n.Msg("validation.invalid_csv"))
+	}
+	if principal, ok := auth.PrincipalFrom(c); ok {
+		for i := range records {
+			records[i].Owner = principal.Username
+		}
+	}
+
+	release := func() {}
+	if h.RowQuota != nil {
+		if release, err = h.RowQuota.ReserveRows(c, len(records)); err != nil {
+			if errors.Is(err, ErrRowQuotaExceeded) {
+				return Problem(c, http.StatusTooManyRequests, "row_quota_exceeded", err)
+			}
+			return InternalError(c, "Failed to check row quota", err)
+		}
 	}
 
-	var taxDetails []model.TaxDetail
-	for _, record := range records {
-		PersonalDeductionDefault := 60000.00
-		taxableIncome := record.TotalIncome - PersonalDeductionDefault - record.Donation
-		tax, _ := utils.CalculateIncomeTaxDetailed(taxableIncome)
-		netTax := tax - record.WHT
+	response, err := h.TaxService.CalculateBatch(c.Request().Context(), records)
+	if err != nil {
+		release()
+		return CalculationError(c, "Tax calculation failed", err)
+	}
 
-		taxRefund := 0.0
-		if netTax < 0 {
-			taxRefund = -netTax
-			netTax = 0
+	if !auth.CanSeePersonalData(c) {
+		for i := range response.Taxes {
+			response.Taxes[i].NationalID = utils.MaskNationalID(response.Taxes[i].NationalID)
 		}
+	}
+	return respond(response)
+}
+
+func (h *TaxHandler) ListTaxSchedules(c echo.Context) error {
+	taxYear, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "year", "Invalid tax year")
+	}
+
+	schedules, err := h.TaxService.ListTaxSchedules(c.Request().Context(), taxYear)
+	if err != nil {
+		return scheduleError(c, "Failed to list tax schedules", err)
+	}
+
+	for i := range schedules {
+		localizeSchedule(c, &schedules[i])
+	}
+	return c.JSON(http.StatusOK, echo.Map{"schedules": schedules})
+}
+
+func (h *TaxHandler) GetActiveTaxSchedule(c echo.Context) error {
+	taxYear, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "year", "Invalid tax year")
+	}
+
+	schedule, err := h.TaxService.GetActiveTaxSchedule(c.Request().Context(), taxYear)
+	if err != nil {
+		return scheduleError(c, "Failed to get tax schedule", err)
+	}
+
+	localizeSchedule(c, &schedule)
+	return c.JSON(http.StatusOK, schedule)
+}
+
+func (h *TaxHandler) GetTaxSchedule(c echo.Context) error {
+	taxYear, id, err := scheduleParams(c)
+	if err != nil {
+		return Problem(c, http.StatusBadRequest, "invalid_parameter", err)
+	}
 
-		taxDetails = append(taxDetails, model.TaxDetail{
-			TotalIncome: record.TotalIncome,
-			Tax:         netTax,
-			TaxRefund:   taxRefund,
-		})
+	schedule, err := h.TaxService.GetTaxSchedule(c.Request().Context(), taxYear, id)
+	if err != nil {
+		return scheduleError(c, "Failed to get tax schedule", err)
+	}
+
+	localizeSchedule(c, &schedule)
+	return c.JSON(http.StatusOK, schedule)
+}
+
+func (h *TaxHandler) ProposeTaxSchedule(c echo.Context) error {
+	taxYear, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "year", "Invalid tax year")
+	}
+
+	var req model.TaxScheduleProposal
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	schedule, err := h.TaxService.ProposeTaxSchedule(c.Request().Context(), taxYear, req)
+	if err != nil {
+		return scheduleError(c, "Failed to propose tax schedule", err)
+	}
+
+	localizeSchedule(c, &schedule)
+	return c.JSON(http.StatusCreated, schedule)
+}
+
+func (h *TaxHandler) ActivateTaxSchedule(c echo.Context) error {
+	taxYear, id, err := scheduleParams(c)
+	if err != nil {
+		return Problem(c, http.StatusBadRequest, "invalid_parameter", err)
+	}
+
+	schedule, err := h.TaxService.ActivateTaxSchedule(c.Request().Context(), taxYear, id)
+	if err != nil {
+		return scheduleError(c, "Failed to activate tax schedule", err)
 	}
 
-	response := model.TaxResponseCSV{
-		Taxes: taxDetails,
+	localizeSchedule(c, &schedule)
+	return c.JSON(http.StatusOK, schedule)
+}
+
+// localizeSchedule labels the brackets of schedule in the caller's language.
+func localizeSchedule(c echo.Context, schedule *model.TaxSchedule) {
+	loc := i18n.From(c)
+	for i := range schedule.Brackets {
+		schedule.Brackets[i].Level = loc.TaxLevel(schedule.Brackets[i])
 	}
+}
 
-	return c.JSON(http.StatusOK, response)
+func scheduleParams(c echo.Context) (int, uint, error) {
+	taxYear, err := strconv.Atoi(c.Param("year"))
+	if err != nil {
+		return 0, 0, &FieldError{Field: "year", Err: errors.New("Invalid tax year")}
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, 0, &FieldError{Field: "id", Err: errors.New("Invalid schedule id")}
+	}
+	return taxYear, uint(id), nil
+}
+
+func scheduleError(c echo.Context, message string, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidSchedule):
+		return Problem(c, http.StatusBadRequest, "invalid_schedule", err)
+	case errors.Is(err, ErrScheduleNotFound):
+		return Problem(c, http.StatusNotFound, "schedule_not_found", err)
+	case errors.Is(err, ErrScheduleNotProposed):
+		return Problem(c, http.StatusConflict, "schedule_not_proposed", err)
+	}
+	return InternalError(c, message, err)
 }
@@ -2,32 +2,35 @@ package tax
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/labstack/echo/v4"
 	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/problem"
+	"github.com/pphee/assessment-tax/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 type MockTaxService struct {
 	mock.Mock
 }
 
-func (m *MockTaxService) CalculateTax(req model.TaxRequest) (float64, []model.TaxBracket, error) {
+func (m *MockTaxService) CalculateTax(ctx context.Context, req model.TaxRequest) (model.TaxResponse, error) {
 	args := m.Called(req)
-	return args.Get(0).(float64), args.Get(1).([]model.TaxBracket), args.Error(2)
-}
-
-func (m *MockTaxService) SetPersonalDeduction(amount float64) error {
-	args := m.Called(amount)
-	return args.Error(0)
+	return args.Get(0).(model.TaxResponse), args.Error(1)
 }
 
 func (m *MockTaxService) TaxFromFile(file io.Reader) ([]model.TotalIncomeCsv, error) {
@@ -35,11 +38,66 @@ func (m *MockTaxService) TaxFromFile(file io.Reader) ([]model.TotalIncomeCsv, er
 	return args.Get(0).([]model.TotalIncomeCsv), args.Error(1)
 }
 
-func (m *MockTaxService) SetKReceiptDeduction(amount float64) error {
-	args := m.Called(amount)
+func (m *MockTaxService) GetAllowanceSettings(ctx context.Context) ([]model.AllowanceSetting, error) {
+	args := m.Called()
+	return args.Get(0).([]model.AllowanceSetting), args.Error(1)
+}
+
+func (m *MockTaxService) ValidateAllowanceSettings(updates []model.AllowanceSettingUpdate) error {
+	args := m.Called(updates)
 	return args.Error(0)
 }
 
+func (m *MockTaxService) UpdateAllowanceSettings(ctx context.Context, updates []model.AllowanceSettingUpdate) ([]model.AllowanceSetting, error) {
+	args := m.Called(updates)
+	return args.Get(0).([]model.AllowanceSetting), args.Error(1)
+}
+
+func (m *MockTaxService) CalculateBatch(ctx context.Context, records []model.TotalIncomeCsv) (model.TaxResponseCSV, error) {
+	args := m.Called(records)
+	return args.Get(0).(model.TaxResponseCSV), args.Error(1)
+}
+
+func (m *MockTaxService) ListTaxSchedules(ctx context.Context, taxYear int) ([]model.TaxSchedule, error) {
+	args := m.Called(taxYear)
+	return args.Get(0).([]model.TaxSchedule), args.Error(1)
+}
+
+func (m *MockTaxService) GetActiveTaxSchedule(ctx context.Context, taxYear int) (model.TaxSchedule, error) {
+	args := m.Called(taxYear)
+	return args.Get(0).(model.TaxSchedule), args.Error(1)
+}
+
+func (m *MockTaxService) GetTaxSchedule(ctx context.Context, taxYear int, id uint) (model.TaxSchedule, error) {
+	args := m.Called(taxYear, id)
+	return args.Get(0).(model.TaxSchedule), args.Error(1)
+}
+
+func (m *MockTaxService) ProposeTaxSchedule(ctx context.Context, taxYear int, proposal model.TaxScheduleProposal) (model.TaxSchedule, error) {
+	args := m.Called(taxYear, proposal)
+	return args.Get(0).(model.TaxSchedule), args.Error(1)
+}
+
+func (m *MockTaxService) ActivateTaxSchedule(ctx context.Context, taxYear int, id uint) (model.TaxSchedule, error) {
+	args := m.Called(taxYear, id)
+	return args.Get(0).(model.TaxSchedule), args.Error(1)
+}
+
+func (m *MockTaxService) ConfigVersionAt(ctx context.Context, asOf time.Time) (int64, error) {
+	args := m.Called(asOf)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockTaxService) CalculateTaxAt(ctx context.Context, req model.TaxRequest, version int64) (model.TaxResponse, error) {
+	args := m.Called(req, version)
+	return args.Get(0).(model.TaxResponse), args.Error(1)
+}
+
+func (m *MockTaxService) CalculateBatchAt(ctx context.Context, records []model.TotalIncomeCsv, version int64) (model.TaxResponseCSV, error) {
+	args := m.Called(records, version)
+	return args.Get(0).(model.TaxResponseCSV), args.Error(1)
+}
+
 func TestTaxHandler_PostTaxCalculation_Success(t *testing.T) {
 	e := echo.New()
 	requestBody := `{"totalIncome": 500000, "wht": 25000, "allowances":[{"allowanceType":"k-receipt","amount":50000}]}`
@@ -48,19 +106,130 @@ func TestTaxHandler_PostTaxCalculation_Success(t *testing.T) {
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 
-	expectedTax := 12345.67
-	expectedBrackets := []model.TaxBracket{{Level: "Low", Tax: 1000}}
+	expected := model.TaxResponse{
+		Tax:           12345.67,
+		TaxLevels:     []model.TaxBracket{{Level: "0-150,000", Tax: 1000, Rate: utils.DefaultTaxRates[0]}},
+		ConfigVersion: 4,
+	}
 	mockTaxService := new(MockTaxService)
-	mockTaxService.On("CalculateTax", mock.Anything).Return(expectedTax, expectedBrackets, nil)
+	mockTaxService.On("CalculateTax", mock.Anything).Return(expected, nil)
 
 	h := &TaxHandler{TaxService: mockTaxService}
 	if assert.NoError(t, h.PostTaxCalculation(c)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
-		expectedResponse := `{"tax":12345.7,"taxLevel":[{"level":"Low","tax":1000}]}`
+		expectedResponse := `{"tax":12345.7,"taxLevel":[{"level":"0-150,000","tax":1000}],"configVersion":4}`
 		assert.JSONEq(t, expectedResponse, rec.Body.String())
 	}
 }
 
+func TestTaxHandler_PostTaxCalculation_Localized(t *testing.T) {
+	levels := []model.TaxBracket{
+		{Level: "150,001-500,000", Tax: 35000, Rate: utils.DefaultTaxRates[1]},
+		{Level: "2,000,001 ขึ้นไป", Rate: utils.DefaultTaxRates[4]},
+	}
+	tests := []struct {
+		name, target, acceptLanguage string
+		want                         []string
+	}{
+		{"default", "/tax/calculations", "", []string{"150,001-500,000", "2,000,001 and above"}},
+		{"accept language", "/tax/calculations", "th-TH,th;q=0.9", []string{"150,001-500,000", "2,000,001 ขึ้นไป"}},
+		{"query", "/tax/calculations?lang=en", "th", []string{"150,001-500,000", "2,000,001 and above"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(`{"totalIncome": 500000}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("Accept-Language", tt.acceptLanguage)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			mockTaxService := new(MockTaxService)
+			mockTaxService.On("CalculateTax", mock.Anything).Return(model.TaxResponse{TaxLevels: append([]model.TaxBracket(nil), levels...)}, nil)
+
+			h := &TaxHandler{TaxService: mockTaxService}
+			require.NoError(t, h.PostTaxCalculation(c))
+
+			var body struct {
+				TaxLevel []model.TaxBracket `json:"taxLevel"`
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+			require.Len(t, body.TaxLevel, 2)
+			assert.Equal(t, tt.want, []string{body.TaxLevel[0].Level, body.TaxLevel[1].Level})
+		})
+	}
+}
+
+func TestTaxHandler_PostTaxCalculation_Taxpayer(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"Own Taxpayer", nil, http.StatusOK},
+		{"Unknown Taxpayer", ErrTaxpayerNotFound, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"totalIncome": 500000, "taxpayerId": 4}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			auth.SetPrincipal(c, auth.Principal{Username: "apikey:3"})
+
+			mockTaxService := new(MockTaxService)
+			mockTaxService.On("CalculateTax", model.TaxRequest{TotalIncome: 500000, TaxpayerID: 4, Owner: "apikey:3"}).
+				Return(model.TaxResponse{}, tt.err)
+
+			h := &TaxHandler{TaxService: mockTaxService}
+			if assert.NoError(t, h.PostTaxCalculation(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestTaxHandler_PostTaxCalculation_NationalID(t *testing.T) {
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		body      string
+		want      int
+		wantBody  string
+	}{
+		{"Anonymous", nil, `{"totalIncome": 500000, "nationalId": "1101700203450"}`, http.StatusOK, `"nationalId":"*********3450"`},
+		{"Editor", &auth.Principal{Username: "alice", Role: auth.RoleEditor}, `{"totalIncome": 500000, "nationalId": "1101700203450"}`, http.StatusOK, `"nationalId":"*********3450"`},
+		{"Approver", &auth.Principal{Username: "bob", Role: auth.RoleApprover}, `{"totalIncome": 500000, "nationalId": "1-1017-00203-45-0"}`, http.StatusOK, `"nationalId":"1101700203450"`},
+		{"Invalid", nil, `{"totalIncome": 500000, "nationalId": "1101700203451"}`, http.StatusBadRequest, "valid check digit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			owner := ""
+			if tt.principal != nil {
+				auth.SetPrincipal(c, *tt.principal)
+				owner = tt.principal.Username
+			}
+
+			mockTaxService := new(MockTaxService)
+			mockTaxService.On("CalculateTax", model.TaxRequest{TotalIncome: 500000, NationalID: "1101700203450", Owner: owner}).
+				Return(model.TaxResponse{Tax: 29000, NationalID: "1101700203450"}, nil)
+
+			h := &TaxHandler{TaxService: mockTaxService}
+			if assert.NoError(t, h.PostTaxCalculation(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+				assert.Contains(t, rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
 func TestTaxCalculationBindingError(t *testing.T) {
 	e := echo.New()
 	invalidJSON := `{"totalIncome": "not a float"}`
@@ -76,7 +245,82 @@ func TestTaxCalculationBindingError(t *testing.T) {
 	}
 
 	assert.Equal(t, http.StatusBadRequest, rec.Code)
-	assert.Contains(t, rec.Body.String(), "Invalid request data")
+	assert.Equal(t, problem.ContentType, rec.Header().Get(echo.HeaderContentType))
+	assert.Contains(t, rec.Body.String(), `"code":"invalid_request"`)
+	assert.Contains(t, rec.Body.String(), `"field":"totalIncome"`)
+	assert.Contains(t, rec.Body.String(), `"code":"wrong_type"`)
+}
+
+func TestTaxCalculationValidation(t *testing.T) {
+	e := echo.New()
+	body := `{"totalIncome": -1, "allowances": [{"allowanceType": "donation", "amount": 100}, {"allowanceType": "donation", "amount": -5}], "extra": 1}`
+	req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	mockTaxService := new(MockTaxService)
+	handler := &TaxHandler{TaxService: mockTaxService}
+
+	require.NoError(t, handler.PostTaxCalculation(c))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var problemBody problem.Problem
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problemBody))
+	assert.Equal(t, problem.CodeInvalidRequest, problemBody.Code)
+	var fields []string
+	for _, v := range problemBody.Violations {
+		fields = append(fields, v.Field+" "+v.Code)
+	}
+	assert.Equal(t, []string{
+		"totalIncome min",
+		"allowances[1].allowanceType unique",
+		"allowances[1].amount min",
+		"extra unknown_field",
+	}, fields)
+	mockTaxService.AssertNotCalled(t, "CalculateTax", mock.Anything)
+}
+
+func TestTaxCalculationRequestError(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		err    error
+		status int
+		code   string
+		field  string
+		detail string
+	}{
+		{"Negative Allowance", "/tax/calculations", &FieldError{Field: "allowances[1].amount", Err: ErrNegativeAllowance}, http.StatusBadRequest, "negative_allowance", "allowances[1].amount", ErrNegativeAllowance.Error()},
+		{"Personal Allowance", "/tax/calculations", &FieldError{Field: "allowances[0].amount", Err: ErrPersonalAllowanceOutOfRange}, http.StatusBadRequest, "personal_allowance_out_of_range", "allowances[0].amount", ErrPersonalAllowanceOutOfRange.Error()},
+		{"WHT", "/tax/calculations", &FieldError{Field: "wht", Err: ErrInvalidWHT}, http.StatusBadRequest, "invalid_wht", "wht", ErrInvalidWHT.Error()},
+		{"Taxpayer", "/tax/calculations", ErrTaxpayerNotFound, http.StatusNotFound, "taxpayer_not_found", "", "Taxpayer not found"},
+		{"Schedule", "/tax/calculations", fmt.Errorf("%w: no active schedule for 2568", ErrScheduleNotFound), http.StatusNotFound, "schedule_not_found", "", "tax schedule not found: no active schedule for 2568"},
+		{"Thai", "/tax/calculations?lang=th", &FieldError{Field: "wht", Err: ErrInvalidWHT, Message: i18n.Msg("validation.invalid_wht")}, http.StatusBadRequest, "invalid_wht", "wht", "ภาษีหัก ณ ที่จ่ายต้องอยู่ระหว่าง 0 ถึงเงินได้รวม"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(`{"totalIncome": 500000}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			mockTaxService := new(MockTaxService)
+			mockTaxService.On("CalculateTax", mock.Anything).Return(model.TaxResponse{}, tt.err)
+
+			handler := &TaxHandler{TaxService: mockTaxService}
+
+			if assert.NoError(t, handler.PostTaxCalculation(c)) {
+				assert.Equal(t, tt.status, rec.Code)
+				var body problem.Problem
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+				assert.Equal(t, tt.code, body.Code)
+				assert.Equal(t, tt.field, body.Field)
+				assert.Equal(t, tt.detail, body.Detail)
+			}
+		})
+	}
 }
 
 func TestTaxCalculationServiceError(t *testing.T) {
@@ -86,7 +330,7 @@ func TestTaxCalculationServiceError(t *testing.T) {
 		WHT:         5000,
 		Allowances: []model.Allowance{
 			{
-				AllowanceType: "Dummy",
+				AllowanceType: "donation",
 				Amount:        3000,
 			},
 		},
@@ -98,7 +342,7 @@ func TestTaxCalculationServiceError(t *testing.T) {
 	c := e.NewContext(req, rec)
 
 	mockTaxService := new(MockTaxService)
-	mockTaxService.On("CalculateTax", mock.Anything).Return(0.0, []model.TaxBracket{}, errors.New("calculation error"))
+	mockTaxService.On("CalculateTax", mock.Anything).Return(model.TaxResponse{}, errors.New("calculation error"))
 
 	handler := &TaxHandler{TaxService: mockTaxService}
 
@@ -108,19 +352,63 @@ func TestTaxCalculationServiceError(t *testing.T) {
 
 	assert.Equal(t, http.StatusInternalServerError, rec.Code)
 	assert.Contains(t, rec.Body.String(), "Tax calculation failed")
+	assert.NotContains(t, rec.Body.String(), "calculation error")
 
 	mockTaxService.AssertExpectations(t)
 }
 
+func TestTaxCalculationContextError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"ClientGone", context.Canceled, StatusClientClosedRequest},
+		{"TimedOut", fmt.Errorf("failed to retrieve configuration: %w", context.DeadlineExceeded), http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/tax/calculations", strings.NewReader(`{"totalIncome": 500000}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			mockTaxService := new(MockTaxService)
+			mockTaxService.On("CalculateTax", mock.Anything).Return(model.TaxResponse{}, tt.err)
+
+			handler := &TaxHandler{TaxService: mockTaxService}
+
+			if assert.NoError(t, handler.PostTaxCalculation(c)) {
+				assert.Equal(t, tt.status, rec.Code)
+				assert.NotContains(t, rec.Body.String(), "context")
+			}
+		})
+	}
+}
+
+func currentSettings(version int64) []model.AllowanceSetting {
+	return []model.AllowanceSetting{
+		{Key: "PersonalDefault", Amount: 60000, Min: 10000, Max: 100000, Version: version},
+		{Key: "DonationMax", Amount: 100000, Min: 0, Max: 100000, Version: version},
+		{Key: "KReceiptDefault", Amount: 50000, Min: 0, Max: 100000, Version: version},
+	}
+}
+
 func TestTaxHandler_SetPersonalDeduction(t *testing.T) {
 	e := echo.New()
 	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 50000}`))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("If-Match", `"PersonalDefault-3"`)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 
 	mockTaxService := new(MockTaxService)
-	mockTaxService.On("SetPersonalDeduction", mock.Anything).Return(nil)
+	mockTaxService.On("ValidateAllowanceSettings", mock.Anything).Return(nil)
+	mockTaxService.On("GetAllowanceSettings").Return(currentSettings(3), nil)
+	mockTaxService.On("UpdateAllowanceSettings", []model.AllowanceSettingUpdate{{Key: "PersonalDefault", Amount: 50000, Version: 3}}).Return([]model.AllowanceSetting{
+		{Key: "PersonalDefault", Amount: 50000, Version: 4},
+	}, nil)
 
 	h := &TaxHandler{
 		TaxService: mockTaxService,
@@ -128,6 +416,65 @@ func TestTaxHandler_SetPersonalDeduction(t *testing.T) {
 
 	if assert.NoError(t, h.SetPersonalDeduction(c)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"PersonalDefault-4"`, rec.Header().Get("ETag"))
+	}
+	mockTaxService.AssertExpectations(t)
+}
+
+func TestTaxHandler_SetPersonalDeduction_Precondition(t *testing.T) {
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    int
+	}{
+		{"Missing", "", http.StatusPreconditionRequired},
+		{"Stale", `"PersonalDefault-2"`, http.StatusPreconditionFailed},
+		{"Weak", `W/"PersonalDefault-3"`, http.StatusPreconditionFailed},
+		{"OtherKey", `"KReceiptDefault-3"`, http.StatusPreconditionFailed},
+		{"Any", "*", http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 50000}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if tt.ifMatch != "" {
+				req.Header.Set("If-Match", tt.ifMatch)
+			}
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			mockTaxService := new(MockTaxService)
+			mockTaxService.On("ValidateAllowanceSettings", mock.Anything).Return(nil)
+			mockTaxService.On("GetAllowanceSettings").Return(currentSettings(3), nil)
+
+			h := &TaxHandler{TaxService: mockTaxService}
+
+			if assert.NoError(t, h.SetPersonalDeduction(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+			}
+			mockTaxService.AssertNotCalled(t, "UpdateAllowanceSettings", mock.Anything)
+		})
+	}
+}
+
+func TestTaxHandler_SetPersonalDeduction_ConcurrentUpdate(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 50000}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("If-Match", `"PersonalDefault-3"`)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("ValidateAllowanceSettings", mock.Anything).Return(nil)
+	mockTaxService.On("GetAllowanceSettings").Return(currentSettings(3), nil)
+	mockTaxService.On("UpdateAllowanceSettings", mock.Anything).Return([]model.AllowanceSetting(nil), fmt.Errorf("failed to update allowance settings: %w: PersonalDefault", ErrVersionConflict))
+
+	h := &TaxHandler{TaxService: mockTaxService}
+
+	if assert.NoError(t, h.SetPersonalDeduction(c)) {
+		assert.Equal(t, http.StatusPreconditionFailed, rec.Code)
 	}
 }
 
@@ -156,6 +503,7 @@ func TestTaxHandler_SetPersonalDeduction_InvalidAmount(t *testing.T) {
 	c := e.NewContext(req, rec)
 
 	mockTaxService := new(MockTaxService)
+	mockTaxService.On("ValidateAllowanceSettings", mock.Anything).Return(fmt.Errorf("%w: PersonalDefault must be between 10,000 and 100,000", ErrAllowanceOutOfRange))
 	h := &TaxHandler{
 		TaxService: mockTaxService,
 	}
@@ -169,11 +517,16 @@ func TestTaxHandler_SetKreceiptDeduction(t *testing.T) {
 	e := echo.New()
 	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"amount": 50000}`))
 	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("If-Match", `"KReceiptDefault-1"`)
 	rec := httptest.NewRecorder()
 	c := e.NewContext(req, rec)
 
 	mockTaxService := new(MockTaxService)
-	mockTaxService.On("SetKReceiptDeduction", mock.Anything).Return(nil)
+	mockTaxService.On("ValidateAllowanceSettings", mock.Anything).Return(nil)
+	mockTaxService.On("GetAllowanceSettings").Return(currentSettings(1), nil)
+	mockTaxService.On("UpdateAllowanceSettings", []model.AllowanceSettingUpdate{{Key: "KReceiptDefault", Amount: 50000, Version: 1}}).Return([]model.AllowanceSetting{
+		{Key: "KReceiptDefault", Amount: 50000, Version: 2},
+	}, nil)
 
 	h := &TaxHandler{
 		TaxService: mockTaxService, // Inject the mocked service
@@ -210,6 +563,7 @@ func TestTaxHandler_SetKreceiptDeduction_InvalidAmount(t *testing.T) {
 	c := e.NewContext(req, rec)
 
 	mockTaxService := new(MockTaxService)
+	mockTaxService.On("ValidateAllowanceSettings", mock.Anything).Return(fmt.Errorf("%w: KReceiptDefault must be between 0 and 100,000", ErrAllowanceOutOfRange))
 	h := &TaxHandler{
 		TaxService: mockTaxService,
 	}
@@ -238,6 +592,7 @@ func TestTaxHandler_TaxCalculationsCSVHandler(t *testing.T) {
 
 	mockTaxService := new(MockTaxService)
 	mockTaxService.On("TaxFromFile", mock.Anything).Return([]model.TotalIncomeCsv{{TotalIncome: 500000, WHT: 25000, Donation: 1000}}, nil)
+	mockTaxService.On("CalculateBatch", []model.TotalIncomeCsv{{TotalIncome: 500000, WHT: 25000, Donation: 1000}}).Return(model.TaxResponseCSV{Taxes: []model.TaxDetail{{TotalIncome: 500000, Tax: 3900}}, ConfigVersion: 4}, nil)
 
 	h := &TaxHandler{
 		TaxService: mockTaxService,
@@ -245,5 +600,406 @@ func TestTaxHandler_TaxCalculationsCSVHandler(t *testing.T) {
 
 	if assert.NoError(t, h.TaxCalculationsCSVHandler(c)) {
 		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"taxes":[{"totalIncome":500000.0,"tax":3900.0,"taxRefund":0.0}],"configVersion":4}`, rec.Body.String())
+	}
+}
+
+func TestTaxHandler_TaxCalculationsCSVHandler_NationalID(t *testing.T) {
+	duplicate := NormalizeBatch([]model.TotalIncomeCsv{{TotalIncome: 500000, NationalID: "1101700203450"}, {TotalIncome: 1, NationalID: "1101700203450"}})
+	tests := []struct {
+		name     string
+		role     auth.Role
+		err      error
+		want     int
+		wantBody string
+	}{
+		{"Masked", auth.RoleViewer, nil, http.StatusOK, `"nationalId":"*********3450"`},
+		{"Superuser", auth.RoleSuperuser, nil, http.StatusOK, `"nationalId":"1101700203450"`},
+		{"Duplicate", auth.RoleSuperuser, duplicate, http.StatusBadRequest, "row 2: nationalId duplicates row 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			body := new(bytes.Buffer)
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("taxes", "testdata.csv")
+			if err != nil {
+				t.Fatal(err)
+			}
+			part.Write([]byte("totalIncome,wht,donation,nationalId\n500000,0,0,1101700203450"))
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/", body)
+			req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			auth.SetPrincipal(c, auth.Principal{Username: "alice", Role: tt.role})
+
+			mockTaxService := new(MockTaxService)
+			mockTaxService.On("TaxFromFile", mock.Anything).Return([]model.TotalIncomeCsv{{TotalIncome: 500000, NationalID: "1101700203450"}}, nil)
+			mockTaxService.On("CalculateBatch", mock.Anything).
+				Return(model.TaxResponseCSV{Taxes: []model.TaxDetail{{TotalIncome: 500000, Tax: 29000, NationalID: "1101700203450"}}}, tt.err)
+
+			h := &TaxHandler{TaxService: mockTaxService}
+			if assert.NoError(t, h.TaxCalculationsCSVHandler(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+				assert.Contains(t, rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+type stubRowQuota struct {
+	err      error
+	released int
+}
+
+func (q *stubRowQuota) ReserveRows(c echo.Context, rows int) (func(), error) {
+	if q.err != nil {
+		return nil, q.err
+	}
+	return func() { q.released += rows }, nil
+}
+
+func TestTaxHandler_TaxCalculationsCSVHandler_RowQuota(t *testing.T) {
+	e := echo.New()
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("taxes", "testdata.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write([]byte("totalIncome,wht,donation\n500000,25000,1000"))
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/", body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("TaxFromFile", mock.Anything).Return([]model.TotalIncomeCsv{{TotalIncome: 500000, WHT: 25000, Donation: 1000}}, nil)
+
+	h := &TaxHandler{
+		TaxService: mockTaxService,
+		RowQuota:   &stubRowQuota{err: fmt.Errorf("%w: 1 rows would exceed 0 per day", ErrRowQuotaExceeded)},
+	}
+
+	if assert.NoError(t, h.TaxCalculationsCSVHandler(c)) {
+		assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+		mockTaxService.AssertNotCalled(t, "CalculateBatch", mock.Anything)
+	}
+}
+
+func TestTaxHandler_TaxCalculationsCSVHandler_ReleasesRows(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		want     int
+		released int
+	}{
+		{"Calculated", nil, http.StatusOK, 0},
+		{"Schedule Not Found", fmt.Errorf("row 1: %w", ErrScheduleNotFound), http.StatusNotFound, 1},
+		{"Timed Out", context.DeadlineExceeded, http.StatusServiceUnavailable, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := new(bytes.Buffer)
+			writer := multipart.NewWriter(body)
+			part, err := writer.CreateFormFile("taxes", "testdata.csv")
+			require.NoError(t, err)
+			part.Write([]byte("totalIncome,wht,donation\n500000,25000,1000"))
+			writer.Close()
+
+			req := httptest.NewRequest(http.MethodPost, "/", body)
+			req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+
+			records := []model.TotalIncomeCsv{{TotalIncome: 500000, WHT: 25000, Donation: 1000}}
+			mockTaxService := new(MockTaxService)
+			mockTaxService.On("TaxFromFile", mock.Anything).Return(records, nil)
+			mockTaxService.On("CalculateBatch", records).Return(model.TaxResponseCSV{}, tt.err)
+			quota := &stubRowQuota{}
+
+			h := &TaxHandler{TaxService: mockTaxService, RowQuota: quota}
+
+			if assert.NoError(t, h.TaxCalculationsCSVHandler(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+				assert.Equal(t, tt.released, quota.released)
+			}
+		})
+	}
+}
+
+func TestTaxHandler_GetAllowanceSettings(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/admin/settings", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	mockTaxService := new(MockTaxService)
+	settings := []model.AllowanceSetting{
+		{Key: "PersonalDefault", Amount: 60000, Min: 10000, Max: 100000, Version: 2},
+	}
+	mockTaxService.On("GetAllowanceSettings").Return(settings, nil)
+
+	h := &TaxHandler{TaxService: mockTaxService}
+
+	if assert.NoError(t, h.GetAllowanceSettings(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"settings":[{"key":"PersonalDefault","amount":60000.0,"min":10000.0,"max":100000.0,"version":2}]}`, rec.Body.String())
+		assert.Equal(t, SettingsETag(settings), rec.Header().Get("ETag"))
+	}
+}
+
+func TestTaxHandler_GetAllowanceSetting(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("key")
+	c.SetParamValues("DonationMax")
+
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("GetAllowanceSettings").Return(currentSettings(5), nil)
+
+	h := &TaxHandler{TaxService: mockTaxService}
+
+	if assert.NoError(t, h.GetAllowanceSetting(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, `"DonationMax-5"`, rec.Header().Get("ETag"))
+	}
+}
+
+func TestTaxHandler_GetAllowanceSetting_Unknown(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("key")
+	c.SetParamValues("Unknown")
+
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("GetAllowanceSettings").Return([]model.AllowanceSetting{
+		{Key: "PersonalDefault", Amount: 60000, Min: 10000, Max: 100000},
+	}, nil)
+
+	h := &TaxHandler{TaxService: mockTaxService}
+
+	if assert.NoError(t, h.GetAllowanceSetting(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestTaxHandler_UpdateAllowanceSetting(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"amount": 80000}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.Header.Set("If-Match", `"PersonalDefault-1", "DonationMax-1"`)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("key")
+	c.SetParamValues("DonationMax")
+
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("ValidateAllowanceSettings", mock.Anything).Return(nil)
+	mockTaxService.On("GetAllowanceSettings").Return(currentSettings(1), nil)
+	mockTaxService.On("UpdateAllowanceSettings", []model.AllowanceSettingUpdate{{Key: "DonationMax", Amount: 80000, Version: 1}}).Return([]model.AllowanceSetting{
+		{Key: "DonationMax", Amount: 80000, Min: 0, Max: 100000, Version: 2},
+	}, nil)
+
+	h := &TaxHandler{TaxService: mockTaxService}
+
+	if assert.NoError(t, h.UpdateAllowanceSetting(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"key":"DonationMax","amount":80000.0,"min":0.0,"max":100000.0,"version":2}`, rec.Body.String())
+		assert.Equal(t, `"DonationMax-2"`, rec.Header().Get("ETag"))
+	}
+	mockTaxService.AssertExpectations(t)
+}
+
+func TestTaxHandler_UpdateAllowanceSettings_OutOfRange(t *testing.T) {
+	e := echo.New()
+	body := `{"settings":[{"key":"PersonalMax","amount":90000},{"key":"DonationMax","amount":200000}]}`
+	req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("ValidateAllowanceSettings", mock.Anything).Return(fmt.Errorf("%w: DonationMax must be between 0 and 100,000", ErrAllowanceOutOfRange))
+
+	h := &TaxHandler{TaxService: mockTaxService}
+
+	if assert.NoError(t, h.UpdateAllowanceSettings(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+		assert.Contains(t, rec.Body.String(), "DonationMax must be between 0 and 100,000")
+	}
+}
+
+func TestTaxHandler_UpdateAllowanceSettings_Validation(t *testing.T) {
+	e := echo.New()
+	body := `{"settings":[{"key":"DonationMax","amount":-1},{"key":"Bogus","amount":"90000"},{"key":"DonationMax","amount":1}],"force":true}`
+	req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+
+	mockTaxService := new(MockTaxService)
+	h := &TaxHandler{TaxService: mockTaxService}
+
+	require.NoError(t, h.UpdateAllowanceSettings(c))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var problemBody problem.Problem
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problemBody))
+	assert.Equal(t, problem.CodeInvalidRequest, problemBody.Code)
+	var fields []string
+	for _, v := range problemBody.Violations {
+		fields = append(fields, v.Field+" "+v.Code)
+	}
+	assert.Equal(t, []string{
+		"settings[2].key unique",
+		"settings[0].amount min",
+		"settings[1].key one_of",
+		"settings[1].amount wrong_type",
+		"force unknown_field",
+	}, fields)
+	mockTaxService.AssertNotCalled(t, "ValidateAllowanceSettings", mock.Anything)
+}
+
+func TestTaxHandler_UpdateAllowanceSettings_IfMatch(t *testing.T) {
+	current := currentSettings(2)
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    int
+	}{
+		{"Current", SettingsETag(current), http.StatusOK},
+		{"Stale", SettingsETag(currentSettings(1)), http.StatusPreconditionFailed},
+		{"SettingETag", `"DonationMax-2"`, http.StatusPreconditionFailed},
+		{"Any", "*", http.StatusPreconditionFailed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			body := `{"settings":[{"key":"DonationMax","amount":80000},{"key":"KReceiptDefault","amount":40000}]}`
+			req := httptest.NewRequest(http.MethodPatch, "/", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.Header.Set("If-Match", tt.ifMatch)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			mockTaxService := new(MockTaxService)
+			mockTaxService.On("ValidateAllowanceSettings", mock.Anything).Return(nil)
+			mockTaxService.On("GetAllowanceSettings").Return(current, nil)
+			mockTaxService.On("UpdateAllowanceSettings", []model.AllowanceSettingUpdate{
+				{Key: "DonationMax", Amount: 80000, Version: 2},
+				{Key: "KReceiptDefault", Amount: 40000, Version: 2},
+			}).Return(currentSettings(3), nil)
+
+			h := &TaxHandler{TaxService: mockTaxService}
+
+			if assert.NoError(t, h.UpdateAllowanceSettings(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+			}
+			if tt.want == http.StatusOK {
+				assert.Equal(t, SettingsETag(currentSettings(3)), rec.Header().Get("ETag"))
+			} else {
+				assert.Equal(t, SettingsETag(current), rec.Header().Get("ETag"))
+				mockTaxService.AssertNotCalled(t, "UpdateAllowanceSettings", mock.Anything)
+			}
+		})
+	}
+}
+
+func TestTaxHandler_ProposeTaxSchedule(t *testing.T) {
+	e := echo.New()
+	body := `{"brackets":[{"min":0,"max":200000,"rate":0},{"min":200000,"rate":0.1}],"referenceIncomes":[300000]}`
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("year")
+	c.SetParamValues("2567")
+
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("ProposeTaxSchedule", 2567, mock.Anything).Return(model.TaxSchedule{ID: 2, TaxYear: 2567, Status: "proposed"}, nil)
+
+	h := &TaxHandler{TaxService: mockTaxService}
+
+	if assert.NoError(t, h.ProposeTaxSchedule(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		proposal := mockTaxService.Calls[0].Arguments.Get(1).(model.TaxScheduleProposal)
+		assert.Len(t, proposal.Brackets, 2)
+		assert.Equal(t, []float64{300000}, proposal.ReferenceIncomes)
+	}
+}
+
+func TestTaxHandler_ProposeTaxSchedule_Invalid(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"brackets":[]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("year")
+	c.SetParamValues("2567")
+
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("ProposeTaxSchedule", 2567, mock.Anything).Return(model.TaxSchedule{}, fmt.Errorf("%w: at least one bracket is required", ErrInvalidSchedule))
+
+	h := &TaxHandler{TaxService: mockTaxService}
+
+	if assert.NoError(t, h.ProposeTaxSchedule(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestTaxHandler_ActivateTaxSchedule(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"Activated", nil, http.StatusOK},
+		{"Not Found", ErrScheduleNotFound, http.StatusNotFound},
+		{"Not Proposed", ErrScheduleNotProposed, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := echo.New()
+			req := httptest.NewRequest(http.MethodPost, "/", nil)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+			c.SetParamNames("year", "id")
+			c.SetParamValues("2567", "3")
+
+			mockTaxService := new(MockTaxService)
+			mockTaxService.On("ActivateTaxSchedule", 2567, uint(3)).Return(model.TaxSchedule{ID: 3}, tt.err)
+
+			h := &TaxHandler{TaxService: mockTaxService}
+
+			if assert.NoError(t, h.ActivateTaxSchedule(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestTaxHandler_GetTaxSchedule_InvalidID(t *testing.T) {
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	c.SetParamNames("year", "id")
+	c.SetParamValues("2567", "abc")
+
+	h := &TaxHandler{TaxService: new(MockTaxService)}
+
+	if assert.NoError(t, h.GetTaxSchedule(c)) {
+		assert.Equal(t, http.StatusBadRequest, rec.Code)
 	}
 }
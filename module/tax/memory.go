@@ -0,0 +1,294 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryTaxRepository keeps the configuration in process. It starts with the
+// same defaults the database is seeded with and loses every change on
+// restart, so it is meant for local runs and tests.
+type MemoryTaxRepository struct {
+	mu          sync.RWMutex
+	allowances  []modelgorm.AllowanceGorm
+	schedules   map[uint]modelgorm.TaxScheduleGorm
+	revisions   []modelgorm.ConfigRevisionGorm
+	nextID      uint
+	nextBracket uint
+	now         func() time.Time
+}
+
+func NewMemoryTaxRepository() TaxRepositories {
+	repo := &MemoryTaxRepository{
+		schedules: map[uint]modelgorm.TaxScheduleGorm{},
+		now:       time.Now,
+	}
+	for i, spec := range modelgorm.AllowanceSpecs {
+		repo.allowances = append(repo.allowances, modelgorm.AllowanceGorm{
+			ID:            uint(i + 1),
+			AllowanceType: spec.AllowanceType,
+			Amount:        spec.Default,
+			Version:       1,
+		})
+	}
+	schedule := modelgorm.DefaultTaxSchedule(repo.now())
+	repo.createScheduleLocked(&schedule)
+	// The seed cannot fail: the state is built from values defined above.
+	_ = repo.recordRevisionLocked("initial configuration")
+	return repo
+}
+
+func copySchedule(schedule modelgorm.TaxScheduleGorm) modelgorm.TaxScheduleGorm {
+	schedule.Brackets = append([]modelgorm.TaxBracketGorm(nil), schedule.Brackets...)
+	return schedule
+}
+
+func (repo *MemoryTaxRepository) GetAllowanceConfig(ctx context.Context) ([]modelgorm.AllowanceGorm, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	return append([]modelgorm.AllowanceGorm(nil), repo.allowances...), nil
+}
+
+func (repo *MemoryTaxRepository) UpdateAllowances(ctx context.Context, allowances []modelgorm.Allowance) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	updated := append([]modelgorm.AllowanceGorm(nil), repo.allowances...)
+	for _, allowance := range allowances {
+		found := false
+		for i := range updated {
+			if updated[i].AllowanceType != allowance.AllowanceType {
+				continue
+			}
+			if allowance.Version != 0 && updated[i].Version != allowance.Version {
+				return fmt.Errorf("%w: %s", ErrVersionConflict, allowance.AllowanceType)
+			}
+			updated[i].Amount = allowance.Amount
+			updated[i].Version++
+			found = true
+		}
+		if !found {
+			return fmt.Errorf("allowance %s is not configured", allowance.AllowanceType)
+		}
+	}
+
+	amounts := make(map[string]float64, len(updated))
+	for _, allowance := range updated {
+		amounts[allowance.AllowanceType] = allowance.Amount
+	}
+	if err := checkAllowanceBounds(amounts); err != nil {
+		return err
+	}
+
+	previous := repo.allowances
+	repo.allowances = updated
+	if err := repo.recordRevisionLocked("allowance settings updated"); err != nil {
+		repo.allowances = previous
+		return err
+	}
+	return nil
+}
+
+func (repo *MemoryTaxRepository) GetActiveSchedule(ctx context.Context, taxYear int) (modelgorm.TaxScheduleGorm, error) {
+	if err := ctx.Err(); err != nil {
+		return modelgorm.TaxScheduleGorm{}, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for _, schedule := range repo.sortedSchedulesLocked() {
+		if schedule.TaxYear == taxYear && schedule.Status == modelgorm.ScheduleActive {
+			return copySchedule(schedule), nil
+		}
+	}
+	return modelgorm.TaxScheduleGorm{}, gorm.ErrRecordNotFound
+}
+
+func (repo *MemoryTaxRepository) GetSchedule(ctx context.Context, id uint) (modelgorm.TaxScheduleGorm, error) {
+	if err := ctx.Err(); err != nil {
+		return modelgorm.TaxScheduleGorm{}, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	schedule, ok := repo.schedules[id]
+	if !ok {
+		return modelgorm.TaxScheduleGorm{}, gorm.ErrRecordNotFound
+	}
+	return copySchedule(schedule), nil
+}
+
+func (repo *MemoryTaxRepository) ListSchedules(ctx context.Context, taxYear int) ([]modelgorm.TaxScheduleGorm, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	schedules := []modelgorm.TaxScheduleGorm{}
+	for _, schedule := range repo.sortedSchedulesLocked() {
+		if schedule.TaxYear == taxYear {
+			schedules = append(schedules, copySchedule(schedule))
+		}
+	}
+	return schedules, nil
+}
+
+func (repo *MemoryTaxRepository) CreateSchedule(ctx context.Context, schedule *modelgorm.TaxScheduleGorm) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	repo.createScheduleLocked(schedule)
+	return nil
+}
+
+func (repo *MemoryTaxRepository) createScheduleLocked(schedule *modelgorm.TaxScheduleGorm) {
+	repo.nextID++
+	schedule.ID = repo.nextID
+	if schedule.CreatedAt.IsZero() {
+		schedule.CreatedAt = repo.now()
+	}
+	for i := range schedule.Brackets {
+		repo.nextBracket++
+		schedule.Brackets[i].ID = repo.nextBracket
+		schedule.Brackets[i].ScheduleID = schedule.ID
+	}
+	sort.SliceStable(schedule.Brackets, func(i, j int) bool { return schedule.Brackets[i].Position < schedule.Brackets[j].Position })
+	repo.schedules[schedule.ID] = copySchedule(*schedule)
+}
+
+func (repo *MemoryTaxRepository) ActivateSchedule(ctx context.Context, id uint) (modelgorm.TaxScheduleGorm, error) {
+	if err := ctx.Err(); err != nil {
+		return modelgorm.TaxScheduleGorm{}, err
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	schedule, ok := repo.schedules[id]
+	if !ok {
+		return modelgorm.TaxScheduleGorm{}, gorm.ErrRecordNotFound
+	}
+	if schedule.Status != modelgorm.ScheduleProposed {
+		return modelgorm.TaxScheduleGorm{}, ErrScheduleNotProposed
+	}
+
+	previous := make(map[uint]modelgorm.TaxScheduleGorm, len(repo.schedules))
+	for key, value := range repo.schedules {
+		previous[key] = value
+	}
+	for key, other := range repo.schedules {
+		if other.TaxYear == schedule.TaxYear && other.Status == modelgorm.ScheduleActive {
+			other.Status = modelgorm.ScheduleRetired
+			repo.schedules[key] = other
+		}
+	}
+	now := repo.now()
+	schedule.Status = modelgorm.ScheduleActive
+	schedule.ActivatedAt = &now
+	repo.schedules[id] = schedule
+
+	if err := repo.recordRevisionLocked(fmt.Sprintf("tax schedule %d activated for %d", schedule.ID, schedule.TaxYear)); err != nil {
+		repo.schedules = previous
+		return modelgorm.TaxScheduleGorm{}, err
+	}
+	return copySchedule(schedule), nil
+}
+
+func (repo *MemoryTaxRepository) sortedSchedulesLocked() []modelgorm.TaxScheduleGorm {
+	schedules := make([]modelgorm.TaxScheduleGorm, 0, len(repo.schedules))
+	for _, schedule := range repo.schedules {
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+	return schedules
+}
+
+// recordRevisionLocked mirrors modelgorm.RecordConfigRevision.
+func (repo *MemoryTaxRepository) recordRevisionLocked(reason string) error {
+	var state modelgorm.ConfigState
+	for _, allowance := range repo.allowances {
+		state.Allowances = append(state.Allowances, modelgorm.Allowance{
+			AllowanceType: allowance.AllowanceType,
+			Amount:        allowance.Amount,
+		})
+	}
+	active := []modelgorm.TaxScheduleGorm{}
+	for _, schedule := range repo.sortedSchedulesLocked() {
+		if schedule.Status == modelgorm.ScheduleActive {
+			active = append(active, schedule)
+		}
+	}
+	sort.SliceStable(active, func(i, j int) bool { return active[i].TaxYear < active[j].TaxYear })
+	for _, schedule := range active {
+		scheduleState := modelgorm.ScheduleState{TaxYear: schedule.TaxYear, ScheduleID: schedule.ID}
+		for _, bracket := range schedule.Brackets {
+			scheduleState.Brackets = append(scheduleState.Brackets, modelgorm.BracketState{
+				Min:  bracket.MinIncome,
+				Max:  bracket.MaxIncome,
+				Rate: bracket.Rate,
+			})
+		}
+		state.Schedules = append(state.Schedules, scheduleState)
+	}
+
+	snapshot, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	repo.revisions = append(repo.revisions, modelgorm.ConfigRevisionGorm{
+		ID:        int64(len(repo.revisions) + 1),
+		Reason:    reason,
+		Snapshot:  string(snapshot),
+		CreatedAt: repo.now(),
+	})
+	return nil
+}
+
+func (repo *MemoryTaxRepository) LatestConfigRevision(ctx context.Context) (modelgorm.ConfigRevisionGorm, error) {
+	if err := ctx.Err(); err != nil {
+		return modelgorm.ConfigRevisionGorm{}, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	if len(repo.revisions) == 0 {
+		return modelgorm.ConfigRevisionGorm{}, gorm.ErrRecordNotFound
+	}
+	return repo.revisions[len(repo.revisions)-1], nil
+}
+
+func (repo *MemoryTaxRepository) GetConfigRevision(ctx context.Context, version int64) (modelgorm.ConfigRevisionGorm, error) {
+	if err := ctx.Err(); err != nil {
+		return modelgorm.ConfigRevisionGorm{}, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	if version < 1 || version > int64(len(repo.revisions)) {
+		return modelgorm.ConfigRevisionGorm{}, gorm.ErrRecordNotFound
+	}
+	return repo.revisions[version-1], nil
+}
+
+func (repo *MemoryTaxRepository) ConfigRevisionAt(ctx context.Context, asOf time.Time) (modelgorm.ConfigRevisionGorm, error) {
+	if err := ctx.Err(); err != nil {
+		return modelgorm.ConfigRevisionGorm{}, err
+	}
+	repo.mu.RLock()
+	defer repo.mu.RUnlock()
+	for i := len(repo.revisions) - 1; i >= 0; i-- {
+		if !repo.revisions[i].CreatedAt.After(asOf) {
+			return repo.revisions[i], nil
+		}
+	}
+	return modelgorm.ConfigRevisionGorm{}, gorm.ErrRecordNotFound
+}
@@ -1,14 +1,31 @@
 package tax
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/pphee/assessment-tax/store/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+var (
+	ErrScheduleNotProposed = errors.New("only a proposed schedule can be activated")
+	ErrVersionConflict     = errors.New("allowance setting has been changed since it was read")
 )
 
 type TaxRepositories interface {
-	GetAllowanceConfig() ([]modelgorm.AllowanceGorm, error)
-	SetPersonalDeduction(amount float64) error
-	SetKreceiptDeduction(amount float64) error
+	GetAllowanceConfig(ctx context.Context) ([]modelgorm.AllowanceGorm, error)
+	UpdateAllowances(ctx context.Context, allowances []modelgorm.Allowance) error
+	GetActiveSchedule(ctx context.Context, taxYear int) (modelgorm.TaxScheduleGorm, error)
+	GetSchedule(ctx context.Context, id uint) (modelgorm.TaxScheduleGorm, error)
+	ListSchedules(ctx context.Context, taxYear int) ([]modelgorm.TaxScheduleGorm, error)
+	CreateSchedule(ctx context.Context, schedule *modelgorm.TaxScheduleGorm) error
+	ActivateSchedule(ctx context.Context, id uint) (modelgorm.TaxScheduleGorm, error)
+	LatestConfigRevision(ctx context.Context) (modelgorm.ConfigRevisionGorm, error)
+	GetConfigRevision(ctx context.Context, version int64) (modelgorm.ConfigRevisionGorm, error)
+	ConfigRevisionAt(ctx context.Context, asOf time.Time) (modelgorm.ConfigRevisionGorm, error)
 }
 
 type TaxRepository struct {
@@ -19,33 +36,151 @@ func NewTaxRepository(db *gorm.DB) TaxRepositories {
 	return &TaxRepository{DB: db}
 }
 
-func (repo *TaxRepository) GetAllowanceConfig() ([]modelgorm.AllowanceGorm, error) {
+func (repo *TaxRepository) GetAllowanceConfig(ctx context.Context) ([]modelgorm.AllowanceGorm, error) {
 	var allowances []modelgorm.AllowanceGorm
-	err := repo.DB.Find(&allowances).Error
+	err := repo.DB.WithContext(ctx).Find(&allowances).Error
 	if err != nil {
 		return nil, err
 	}
 	return allowances, nil
 }
 
-func (repo *TaxRepository) SetPersonalDeduction(amount float64) error {
-	allowance := modelgorm.AllowanceGorm{
-		AllowanceType: "personalDeduction",
-		Amount:        amount,
-	}
-	if err := repo.DB.Save(&allowance).Error; err != nil {
+// UpdateAllowances changes every allowance or none. An allowance given with a
+// Version is only changed if it still has that version; otherwise the whole
+// update fails with ErrVersionConflict. The update also fails if it leaves
+// the default personal allowance outside its bounds, counting changes other
+// writers have committed.
+func (repo *TaxRepository) UpdateAllowances(ctx context.Context, allowances []modelgorm.Allowance) error {
+	return repo.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, allowance := range allowances {
+			query := tx.Model(&modelgorm.AllowanceGorm{}).Where("allowance_type = ?", allowance.AllowanceType)
+			if allowance.Version != 0 {
+				query = query.Where("version = ?", allowance.Version)
+			}
+			result := query.Updates(map[string]interface{}{
+				"amount":  allowance.Amount,
+				"version": gorm.Expr("version + 1"),
+			})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				continue
+			}
+			var count int64
+			if err := tx.Model(&modelgorm.AllowanceGorm{}).Where("allowance_type = ?", allowance.AllowanceType).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return fmt.Errorf("%w: %s", ErrVersionConflict, allowance.AllowanceType)
+			}
+			return fmt.Errorf("allowance %s is not configured", allowance.AllowanceType)
+		}
+		if err := modelgorm.LockConfig(tx); err != nil {
+			return err
+		}
+		state, err := modelgorm.LoadConfigState(tx)
+		if err != nil {
+			return err
+		}
+		amounts := make(map[string]float64, len(state.Allowances))
+		for _, allowance := range state.Allowances {
+			amounts[allowance.AllowanceType] = allowance.Amount
+		}
+		if err := checkAllowanceBounds(amounts); err != nil {
+			return err
+		}
+		_, err = modelgorm.SaveConfigRevision(tx, "allowance settings updated", state)
 		return err
-	}
-	return nil
+	})
 }
 
-func (repo *TaxRepository) SetKreceiptDeduction(amount float64) error {
-	allowance := modelgorm.AllowanceGorm{
-		AllowanceType: "kReceipt",
-		Amount:        amount,
+func orderedBrackets(db *gorm.DB) *gorm.DB {
+	return db.Order("position")
+}
+
+func (repo *TaxRepository) GetActiveSchedule(ctx context.Context, taxYear int) (modelgorm.TaxScheduleGorm, error) {
+	var schedule modelgorm.TaxScheduleGorm
+	err := repo.DB.WithContext(ctx).Preload("Brackets", orderedBrackets).
+		Where("tax_year = ? AND status = ?", taxYear, modelgorm.ScheduleActive).
+		First(&schedule).Error
+	return schedule, err
+}
+
+func (repo *TaxRepository) GetSchedule(ctx context.Context, id uint) (modelgorm.TaxScheduleGorm, error) {
+	var schedule modelgorm.TaxScheduleGorm
+	err := repo.DB.WithContext(ctx).Preload("Brackets", orderedBrackets).First(&schedule, id).Error
+	return schedule, err
+}
+
+func (repo *TaxRepository) ListSchedules(ctx context.Context, taxYear int) ([]modelgorm.TaxScheduleGorm, error) {
+	var schedules []modelgorm.TaxScheduleGorm
+	err := repo.DB.WithContext(ctx).Preload("Brackets", orderedBrackets).
+		Where("tax_year = ?", taxYear).
+		Order("id").
+		Find(&schedules).Error
+	if err != nil {
+		return nil, err
 	}
-	if err := repo.DB.Save(&allowance).Error; err != nil {
+	return schedules, nil
+}
+
+func (repo *TaxRepository) CreateSchedule(ctx context.Context, schedule *modelgorm.TaxScheduleGorm) error {
+	return repo.DB.WithContext(ctx).Create(schedule).Error
+}
+
+func (repo *TaxRepository) ActivateSchedule(ctx context.Context, id uint) (modelgorm.TaxScheduleGorm, error) {
+	var schedule modelgorm.TaxScheduleGorm
+	err := repo.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&schedule, id).Error; err != nil {
+			return err
+		}
+		if schedule.Status != modelgorm.ScheduleProposed {
+			return ErrScheduleNotProposed
+		}
+
+		err := tx.Model(&modelgorm.TaxScheduleGorm{}).
+			Where("tax_year = ? AND status = ?", schedule.TaxYear, modelgorm.ScheduleActive).
+			Update("status", modelgorm.ScheduleRetired).Error
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		schedule.Status = modelgorm.ScheduleActive
+		schedule.ActivatedAt = &now
+		err = tx.Model(&schedule).Updates(map[string]interface{}{
+			"status":       schedule.Status,
+			"activated_at": schedule.ActivatedAt,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		_, err = modelgorm.RecordConfigRevision(tx, fmt.Sprintf("tax schedule %d activated for %d", schedule.ID, schedule.TaxYear))
 		return err
+	})
+	if err != nil {
+		return modelgorm.TaxScheduleGorm{}, err
 	}
-	return nil
+	return repo.GetSchedule(ctx, id)
+}
+
+func (repo *TaxRepository) LatestConfigRevision(ctx context.Context) (modelgorm.ConfigRevisionGorm, error) {
+	var revision modelgorm.ConfigRevisionGorm
+	err := repo.DB.WithContext(ctx).Order("id DESC").First(&revision).Error
+	return revision, err
+}
+
+func (repo *TaxRepository) GetConfigRevision(ctx context.Context, version int64) (modelgorm.ConfigRevisionGorm, error) {
+	var revision modelgorm.ConfigRevisionGorm
+	err := repo.DB.WithContext(ctx).First(&revision, version).Error
+	return revision, err
+}
+
+// ConfigRevisionAt returns the revision that was in force at asOf.
+func (repo *TaxRepository) ConfigRevisionAt(ctx context.Context, asOf time.Time) (modelgorm.ConfigRevisionGorm, error) {
+	var revision modelgorm.ConfigRevisionGorm
+	err := repo.DB.WithContext(ctx).Where("created_at <= ?", asOf).Order("id DESC").First(&revision).Error
+	return revision, err
 }
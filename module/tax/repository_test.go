@@ -1,8 +1,11 @@
 package tax
 
 import (
+	"context"
 	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/stretchr/testify/assert"
@@ -25,6 +28,21 @@ func setupMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
 	return gormDB, mock
 }
 
+func expectConfigRevision(mock sqlmock.Sqlmock, version int64) {
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT \* FROM "allowance_gorms" ORDER BY id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "allowance_type", "amount"}).AddRow(1, "PersonalDefault", 60000))
+	mock.ExpectQuery(`SELECT \* FROM "tax_schedule_gorms" WHERE status = \$1 ORDER BY tax_year`).
+		WithArgs("active").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tax_year", "status"}))
+	mock.ExpectQuery(`INSERT INTO "config_revision_gorms"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(version))
+	mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).
+		WithArgs("tax_config_changed", strconv.FormatInt(version, 10)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
 func TestGetAllowanceConfig(t *testing.T) {
 	db, mock := setupMockDB(t)
 	repo := NewTaxRepository(db)
@@ -35,39 +53,67 @@ func TestGetAllowanceConfig(t *testing.T) {
 
 	mock.ExpectQuery(`SELECT \* FROM "allowance_gorms"`).WillReturnRows(rows)
 
-	result, err := repo.GetAllowanceConfig()
+	result, err := repo.GetAllowanceConfig(context.Background())
 	assert.NoError(t, err)
 	assert.Equal(t, expected, result)
 	assert.NoError(t, mock.ExpectationsWereMet()) // Check if all expectations were met
 }
 
-func TestSetPersonalDeduction(t *testing.T) {
+func TestUpdateAllowances(t *testing.T) {
 	db, mock := setupMockDB(t)
 	repo := NewTaxRepository(db)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(`INSERT INTO "allowance_gorms"`).
-		WithArgs("personalDeduction", float64(30000)).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectExec(`UPDATE "allowance_gorms" SET "amount"=\$1,"version"=version \+ 1 WHERE allowance_type = \$2`).
+		WithArgs(float64(30000), "PersonalDefault").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "allowance_gorms" SET "amount"=\$1,"version"=version \+ 1 WHERE allowance_type = \$2`).
+		WithArgs(float64(15000), "KReceiptDefault").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectConfigRevision(mock, 2)
 	mock.ExpectCommit()
 
-	err := repo.SetPersonalDeduction(30000)
+	err := repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{
+		{AllowanceType: "PersonalDefault", Amount: 30000},
+		{AllowanceType: "KReceiptDefault", Amount: 15000},
+	})
 	assert.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestSetKreceiptDeduction(t *testing.T) {
+func TestUpdateAllowances_NotConfigured(t *testing.T) {
 	db, mock := setupMockDB(t)
 	repo := NewTaxRepository(db)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery(`INSERT INTO "allowance_gorms"`).
-		WithArgs("kReceipt", float64(15000)).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
-	mock.ExpectCommit()
+	mock.ExpectExec(`UPDATE "allowance_gorms"`).
+		WithArgs(float64(30000), "PersonalDefault").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "allowance_gorms" WHERE allowance_type = \$1`).
+		WithArgs("PersonalDefault").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectRollback()
 
-	err := repo.SetKreceiptDeduction(15000)
-	assert.NoError(t, err)
+	err := repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{{AllowanceType: "PersonalDefault", Amount: 30000}})
+	assert.ErrorContains(t, err, "PersonalDefault is not configured")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUpdateAllowances_VersionConflict(t *testing.T) {
+	db, mock := setupMockDB(t)
+	repo := NewTaxRepository(db)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "allowance_gorms" SET "amount"=\$1,"version"=version \+ 1 WHERE allowance_type = \$2 AND version = \$3`).
+		WithArgs(float64(30000), "PersonalDefault", int64(4)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "allowance_gorms" WHERE allowance_type = \$1`).
+		WithArgs("PersonalDefault").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	err := repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{{AllowanceType: "PersonalDefault", Amount: 30000, Version: 4}})
+	assert.ErrorIs(t, err, ErrVersionConflict)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -78,45 +124,118 @@ func TestGetAllowanceConfig_QueryError(t *testing.T) {
 	mock.ExpectQuery(`SELECT \* FROM "allowance_gorms"`).
 		WillReturnError(gorm.ErrInvalidData)
 
-	result, err := repo.GetAllowanceConfig()
+	result, err := repo.GetAllowanceConfig(context.Background())
 
 	assert.Nil(t, result)
 	assert.ErrorIs(t, err, gorm.ErrInvalidData)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestSetKreceiptDeduction_SaveError(t *testing.T) {
+func TestUpdateAllowances_SaveError(t *testing.T) {
 	db, mock := setupMockDB(t)
 	repo := NewTaxRepository(db)
 
 	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "allowance_gorms"`).
+		WithArgs(float64(30000), "PersonalDefault").
+		WillReturnError(gorm.ErrInvalidDB) // simulate an error
+	mock.ExpectRollback()
+
+	err := repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{{AllowanceType: "PersonalDefault", Amount: 30000}})
+
+	assert.ErrorIs(t, err, gorm.ErrInvalidDB)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
 
-	mock.ExpectQuery(`INSERT INTO "allowance_gorms"`).
-		WithArgs("kReceipt", float64(15000)).
-		WillReturnError(gorm.ErrInvalidDB) // Simulate a database error
+func TestActivateSchedule_NotProposed(t *testing.T) {
+	db, mock := setupMockDB(t)
+	repo := NewTaxRepository(db)
 
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "tax_schedule_gorms" WHERE "tax_schedule_gorms"\."id" = \$1 ORDER BY "tax_schedule_gorms"\."id" LIMIT \$2 FOR UPDATE`).
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tax_year", "status"}).AddRow(3, 2567, "active"))
 	mock.ExpectRollback()
 
-	err := repo.SetKreceiptDeduction(15000)
+	_, err := repo.ActivateSchedule(context.Background(), 3)
 
-	assert.ErrorIs(t, err, gorm.ErrInvalidDB)
+	assert.ErrorIs(t, err, ErrScheduleNotProposed)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestSetPersonalDeduction_SaveError(t *testing.T) {
+func TestActivateSchedule(t *testing.T) {
 	db, mock := setupMockDB(t)
 	repo := NewTaxRepository(db)
 
 	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT \* FROM "tax_schedule_gorms" WHERE "tax_schedule_gorms"\."id" = \$1`).
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tax_year", "status"}).AddRow(3, 2567, "proposed"))
+	mock.ExpectExec(`UPDATE "tax_schedule_gorms" SET "status"=\$1 WHERE tax_year = \$2 AND status = \$3`).
+		WithArgs("retired", 2567, "active").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE "tax_schedule_gorms" SET "activated_at"=\$1,"status"=\$2 WHERE "id" = \$3`).
+		WithArgs(sqlmock.AnyArg(), "active", 3).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectConfigRevision(mock, 5)
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT \* FROM "tax_schedule_gorms" WHERE "tax_schedule_gorms"\."id" = \$1`).
+		WithArgs(3, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tax_year", "status"}).AddRow(3, 2567, "active"))
+	mock.ExpectQuery(`SELECT \* FROM "tax_bracket_gorms" WHERE "tax_bracket_gorms"\."schedule_id" = \$1 ORDER BY position`).
+		WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "schedule_id", "position", "min_income", "max_income", "rate"}).
+			AddRow(1, 3, 0, 0, nil, 0.1))
 
-	mock.ExpectQuery(`INSERT INTO "allowance_gorms"`).
-		WithArgs("personalDeduction", float64(30000)).
-		WillReturnError(gorm.ErrInvalidDB) // simulate an error
+	schedule, err := repo.ActivateSchedule(context.Background(), 3)
 
-	mock.ExpectRollback()
+	assert.NoError(t, err)
+	assert.Equal(t, "active", schedule.Status)
+	assert.Len(t, schedule.Brackets, 1)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
 
-	err := repo.SetPersonalDeduction(30000)
+func TestLatestConfigRevision(t *testing.T) {
+	db, mock := setupMockDB(t)
+	repo := NewTaxRepository(db)
 
-	assert.ErrorIs(t, err, gorm.ErrInvalidDB)
+	mock.ExpectQuery(`SELECT \* FROM "config_revision_gorms" ORDER BY id DESC,"config_revision_gorms"\."id" LIMIT \$1`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "reason", "snapshot"}).AddRow(9, "allowance settings updated", `{"allowances":[]}`))
+
+	revision, err := repo.LatestConfigRevision(context.Background())
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9), revision.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestConfigRevisionAt(t *testing.T) {
+	db, mock := setupMockDB(t)
+	repo := NewTaxRepository(db)
+	asOf := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT \* FROM "config_revision_gorms" WHERE created_at <= \$1 ORDER BY id DESC,"config_revision_gorms"\."id" LIMIT \$2`).
+		WithArgs(asOf, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "reason", "snapshot"}).AddRow(4, "tax schedule 2 activated for 2567", `{"allowances":[]}`))
+
+	revision, err := repo.ConfigRevisionAt(context.Background(), asOf)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), revision.ID)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetConfigRevision_NotFound(t *testing.T) {
+	db, mock := setupMockDB(t)
+	repo := NewTaxRepository(db)
+
+	mock.ExpectQuery(`SELECT \* FROM "config_revision_gorms" WHERE "config_revision_gorms"\."id" = \$1 ORDER BY "config_revision_gorms"\."id" LIMIT \$2`).
+		WithArgs(7, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := repo.GetConfigRevision(context.Background(), 7)
+
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
@@ -0,0 +1,216 @@
+package tax
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/store/model"
+	"github.com/pphee/assessment-tax/utils"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+var ErrInvalidSchedule = errors.New("invalid tax schedule")
+
+var defaultReferenceIncomes = []float64{150000, 300000, 500000, 750000, 1000000, 1500000, 2000000, 3000000, 5000000}
+
+func toTaxRates(brackets []modelgorm.TaxBracketGorm) []model.TaxRate {
+	rates := make([]model.TaxRate, len(brackets))
+	for i, bracket := range brackets {
+		rates[i] = model.TaxRate{
+			Min:  bracket.MinIncome,
+			Max:  bracket.MaxIncome,
+			Rate: bracket.Rate,
+		}
+		rates[i].Level = utils.TaxLevelLabel(rates[i])
+	}
+	return rates
+}
+
+func toTaxSchedule(schedule modelgorm.TaxScheduleGorm) model.TaxSchedule {
+	return model.TaxSchedule{
+		ID:          schedule.ID,
+		TaxYear:     schedule.TaxYear,
+		Status:      schedule.Status,
+		Brackets:    toTaxRates(schedule.Brackets),
+		CreatedAt:   schedule.CreatedAt,
+		ActivatedAt: schedule.ActivatedAt,
+	}
+}
+
+// validateTaxRates checks that brackets start at zero, are ordered and
+// contiguous, end open-ended and use rates between 0 and 1.
+func validateTaxRates(rates []model.TaxRate) error {
+	if len(rates) == 0 {
+		return fmt.Errorf("%w: at least one bracket is required", ErrInvalidSchedule)
+	}
+
+	var problems []string
+	if rates[0].Min != 0 {
+		problems = append(problems, "the first bracket must start at 0")
+	}
+
+	for i, rate := range rates {
+		if rate.Rate < 0 || rate.Rate > 1 {
+			problems = append(problems, fmt.Sprintf("bracket %d: rate must be between 0 and 1", i+1))
+		}
+
+		last := i == len(rates)-1
+		if rate.Max == nil && !last {
+			problems = append(problems, fmt.Sprintf("bracket %d: only the last bracket may be open-ended", i+1))
+		}
+		if rate.Max != nil && last {
+			problems = append(problems, fmt.Sprintf("bracket %d: the last bracket must be open-ended", i+1))
+		}
+		if rate.Max != nil && *rate.Max <= rate.Min {
+			problems = append(problems, fmt.Sprintf("bracket %d: max must be greater than min", i+1))
+		}
+
+		if i == 0 {
+			continue
+		}
+		previous := rates[i-1]
+		if rate.Min <= previous.Min {
+			problems = append(problems, fmt.Sprintf("bracket %d: brackets must be ordered by min", i+1))
+		} else if previous.Max != nil && rate.Min != *previous.Max {
+			problems = append(problems, fmt.Sprintf("bracket %d: min must equal the max of bracket %d", i+1, i))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidSchedule, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func previewSchedule(current, proposed []model.TaxRate, incomes []float64) []model.SchedulePreview {
+	if len(incomes) == 0 {
+		incomes = defaultReferenceIncomes
+	}
+
+	preview := make([]model.SchedulePreview, len(incomes))
+	for i, income := range incomes {
+		currentTax, _ := utils.CalculateIncomeTaxDetailed(income, current)
+		proposedTax, _ := utils.CalculateIncomeTaxDetailed(income, proposed)
+		preview[i] = model.SchedulePreview{
+			Income:      income,
+			CurrentTax:  currentTax,
+			ProposedTax: proposedTax,
+			Difference:  proposedTax - currentTax,
+		}
+	}
+	return preview
+}
+
+func (service *TaxService) ListTaxSchedules(ctx context.Context, taxYear int) ([]model.TaxSchedule, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Read)
+	defer cancel()
+
+	schedules, err := service.Repo.ListSchedules(ctx, taxYear)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tax schedules: %w", err)
+	}
+
+	result := make([]model.TaxSchedule, len(schedules))
+	for i, schedule := range schedules {
+		result[i] = toTaxSchedule(schedule)
+	}
+	return result, nil
+}
+
+func (service *TaxService) GetActiveTaxSchedule(ctx context.Context, taxYear int) (model.TaxSchedule, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Read)
+	defer cancel()
+
+	taxYear = service.taxYear(taxYear)
+	schedule, err := service.Repo.GetActiveSchedule(ctx, taxYear)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.TaxSchedule{}, fmt.Errorf("%w: no active schedule for %d", ErrScheduleNotFound, taxYear)
+	}
+	if err != nil {
+		return model.TaxSchedule{}, fmt.Errorf("failed to retrieve tax schedule: %w", err)
+	}
+	return toTaxSchedule(schedule), nil
+}
+
+func (service *TaxService) GetTaxSchedule(ctx context.Context, taxYear int, id uint) (model.TaxSchedule, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Read)
+	defer cancel()
+
+	schedule, err := service.Repo.GetSchedule(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && schedule.TaxYear != taxYear) {
+		return model.TaxSchedule{}, fmt.Errorf("%w: schedule %d for %d", ErrScheduleNotFound, id, taxYear)
+	}
+	if err != nil {
+		return model.TaxSchedule{}, fmt.Errorf("failed to retrieve tax schedule: %w", err)
+	}
+
+	result := toTaxSchedule(schedule)
+	if schedule.Status == modelgorm.ScheduleProposed {
+		current, err := service.activeRates(ctx, taxYear)
+		if err != nil && !errors.Is(err, ErrScheduleNotFound) {
+			return model.TaxSchedule{}, err
+		}
+		result.Preview = previewSchedule(current, result.Brackets, nil)
+	}
+	return result, nil
+}
+
+func (service *TaxService) ProposeTaxSchedule(ctx context.Context, taxYear int, proposal model.TaxScheduleProposal) (model.TaxSchedule, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Write)
+	defer cancel()
+
+	if err := validateTaxRates(proposal.Brackets); err != nil {
+		return model.TaxSchedule{}, err
+	}
+	for _, income := range proposal.ReferenceIncomes {
+		if income < 0 {
+			return model.TaxSchedule{}, fmt.Errorf("%w: reference incomes cannot be negative", ErrInvalidSchedule)
+		}
+	}
+
+	current, err := service.activeRates(ctx, taxYear)
+	if err != nil && !errors.Is(err, ErrScheduleNotFound) {
+		return model.TaxSchedule{}, err
+	}
+
+	schedule := modelgorm.TaxScheduleGorm{
+		TaxYear:   taxYear,
+		Status:    modelgorm.ScheduleProposed,
+		CreatedAt: time.Now(),
+	}
+	for i, rate := range proposal.Brackets {
+		schedule.Brackets = append(schedule.Brackets, modelgorm.TaxBracketGorm{
+			Position:  i,
+			MinIncome: rate.Min,
+			MaxIncome: rate.Max,
+			Rate:      rate.Rate,
+		})
+	}
+
+	if err := service.Repo.CreateSchedule(ctx, &schedule); err != nil {
+		return model.TaxSchedule{}, fmt.Errorf("failed to create tax schedule: %w", err)
+	}
+
+	result := toTaxSchedule(schedule)
+	result.Preview = previewSchedule(current, result.Brackets, proposal.ReferenceIncomes)
+	return result, nil
+}
+
+func (service *TaxService) ActivateTaxSchedule(ctx context.Context, taxYear int, id uint) (model.TaxSchedule, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Write)
+	defer cancel()
+
+	if _, err := service.GetTaxSchedule(ctx, taxYear, id); err != nil {
+		return model.TaxSchedule{}, err
+	}
+
+	schedule, err := service.Repo.ActivateSchedule(ctx, id)
+	if err != nil {
+		return model.TaxSchedule{}, fmt.Errorf("failed to activate tax schedule: %w", err)
+	}
+	service.refreshConfig(ctx)
+	return toTaxSchedule(schedule), nil
+}
@@ -0,0 +1,143 @@
+package tax
+
+import (
+	"context"
+	"github.com/pphee/assessment-tax/internal/model"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/pphee/assessment-tax/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func amount(v float64) *float64 {
+	return &v
+}
+
+func TestValidateTaxRates(t *testing.T) {
+	tests := []struct {
+		name    string
+		rates   []model.TaxRate
+		wantErr string
+	}{
+		{"Default", utils.DefaultTaxRates, ""},
+		{"Empty", nil, "at least one bracket is required"},
+		{"Not From Zero", []model.TaxRate{{Min: 100, Rate: 0.1}}, "the first bracket must start at 0"},
+		{"Gap", []model.TaxRate{{Min: 0, Max: amount(100000), Rate: 0}, {Min: 150000, Rate: 0.1}}, "min must equal the max of bracket 1"},
+		{"Out Of Order", []model.TaxRate{{Min: 0, Max: amount(100000), Rate: 0}, {Min: 0, Rate: 0.1}}, "brackets must be ordered by min"},
+		{"Rate Too High", []model.TaxRate{{Min: 0, Max: amount(100000), Rate: 0}, {Min: 100000, Rate: 1.5}}, "rate must be between 0 and 1"},
+		{"Closed Last Bracket", []model.TaxRate{{Min: 0, Max: amount(100000), Rate: 0}}, "the last bracket must be open-ended"},
+		{"Open Middle Bracket", []model.TaxRate{{Min: 0, Rate: 0}, {Min: 100000, Rate: 0.1}}, "only the last bracket may be open-ended"},
+		{"Empty Bracket", []model.TaxRate{{Min: 0, Max: amount(0), Rate: 0}, {Min: 0, Rate: 0.1}}, "max must be greater than min"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateTaxRates(tt.rates)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidSchedule)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestPreviewSchedule(t *testing.T) {
+	proposed := []model.TaxRate{
+		{Min: 0, Max: amount(200000), Rate: 0},
+		{Min: 200000, Rate: 0.1},
+	}
+
+	preview := previewSchedule(utils.DefaultTaxRates, proposed, []float64{440000})
+
+	assert.Equal(t, []model.SchedulePreview{
+		{Income: 440000, CurrentTax: 29000, ProposedTax: 24000, Difference: -5000},
+	}, preview)
+	assert.Len(t, previewSchedule(utils.DefaultTaxRates, proposed, nil), len(defaultReferenceIncomes))
+}
+
+func TestProposeTaxSchedule(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("GetActiveSchedule", 2567).Return(defaultSchedule(), nil)
+	mockRepo.On("CreateSchedule", mock.MatchedBy(func(s *modelgorm.TaxScheduleGorm) bool {
+		return s.TaxYear == 2567 && s.Status == modelgorm.ScheduleProposed && len(s.Brackets) == 2
+	})).Run(func(args mock.Arguments) {
+		args.Get(0).(*modelgorm.TaxScheduleGorm).ID = 7
+	}).Return(nil)
+
+	schedule, err := service.ProposeTaxSchedule(context.Background(), 2567, model.TaxScheduleProposal{
+		Brackets: []model.TaxRate{
+			{Min: 0, Max: amount(200000), Rate: 0},
+			{Min: 200000, Rate: 0.1},
+		},
+		ReferenceIncomes: []float64{440000},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(7), schedule.ID)
+	assert.Equal(t, "0-200,000", schedule.Brackets[0].Level)
+	assert.Equal(t, "200,001 ขึ้นไป", schedule.Brackets[1].Level)
+	assert.Equal(t, -5000.0, schedule.Preview[0].Difference)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestProposeTaxSchedule_Invalid(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	_, err := service.ProposeTaxSchedule(context.Background(), 2567, model.TaxScheduleProposal{
+		Brackets: []model.TaxRate{{Min: 0, Rate: 2}},
+	})
+
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+	mockRepo.AssertNotCalled(t, "CreateSchedule", mock.Anything)
+}
+
+func TestActivateTaxSchedule(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	proposed := defaultSchedule()
+	proposed.ID = 7
+	proposed.Status = modelgorm.ScheduleProposed
+	activated := proposed
+	activated.Status = modelgorm.ScheduleActive
+
+	mockRepo.On("GetSchedule", uint(7)).Return(proposed, nil)
+	mockRepo.On("GetActiveSchedule", 2567).Return(defaultSchedule(), nil)
+	mockRepo.On("ActivateSchedule", uint(7)).Return(activated, nil)
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
+
+	schedule, err := service.ActivateTaxSchedule(context.Background(), 2567, 7)
+
+	assert.NoError(t, err)
+	assert.Equal(t, modelgorm.ScheduleActive, schedule.Status)
+}
+
+func TestActivateTaxSchedule_WrongYear(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("GetSchedule", uint(7)).Return(modelgorm.TaxScheduleGorm{ID: 7, TaxYear: 2568}, nil)
+
+	_, err := service.ActivateTaxSchedule(context.Background(), 2567, 7)
+
+	assert.ErrorIs(t, err, ErrScheduleNotFound)
+	mockRepo.AssertNotCalled(t, "ActivateSchedule", mock.Anything)
+}
+
+func TestGetTaxSchedule_NotFound(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("GetSchedule", uint(9)).Return(modelgorm.TaxScheduleGorm{}, gorm.ErrRecordNotFound)
+
+	_, err := service.GetTaxSchedule(context.Background(), 2567, 9)
+
+	assert.ErrorIs(t, err, ErrScheduleNotFound)
+}
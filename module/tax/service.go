@@ -1,52 +1,247 @@
 package tax
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/gocarina/gocsv"
 	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/store/model"
 	"github.com/pphee/assessment-tax/utils"
+	"gorm.io/gorm"
 	"io"
 	"log"
+	"math"
+	"slices"
+	"strings"
+	"time"
 )
 
+var (
+	ErrUnknownAllowance    = errors.New("unknown allowance setting")
+	ErrAllowanceOutOfRange = errors.New("allowance amount out of range")
+	ErrInvalidSettings     = errors.New("invalid settings update")
+	ErrScheduleNotFound    = errors.New("tax schedule not found")
+	ErrTaxpayerNotFound    = errors.New("taxpayer not found")
+	ErrConfigNotFound      = errors.New("configuration version not found")
+	ErrInvalidNationalID   = errors.New("invalid national ID")
+	ErrDatabaseUnavailable = errors.New("the database is unavailable")
+
+	ErrNegativeAllowance           = errors.New("allowance amount cannot be negative")
+	ErrPersonalAllowanceOutOfRange = errors.New("personal allowance amount out of range")
+	ErrInvalidWHT                  = errors.New("invalid WHT value")
+)
+
+// Warning codes, which are as stable as problem codes.
+const (
+	// WarningAllowanceCapped is an allowance claimed above its maximum,
+	// which was taken off the income at the maximum instead.
+	WarningAllowanceCapped = "allowance_capped"
+	// WarningNotRecorded is a calculation served while the database was
+	// unavailable, which is missing from the caller's history.
+	WarningNotRecorded = "not_recorded"
+)
+
+// FieldError is a mistake the caller made in one field of a request. Field
+// is the field's path, such as allowances[1].amount; Err says what is wrong
+// with it. Message, when set, says so in the caller's language.
+type FieldError struct {
+	Field   string
+	Err     error
+	Message i18n.Message
+}
+
+func (e *FieldError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
 type TaxServices interface {
-	CalculateTax(req model.TaxRequest) (float64, []model.TaxBracket, error)
-	SetPersonalDeduction(amount float64) error
+	CalculateTax(ctx context.Context, req model.TaxRequest) (model.TaxResponse, error)
 	TaxFromFile(file io.Reader) ([]model.TotalIncomeCsv, error)
-	SetKReceiptDeduction(amount float64) error
+	GetAllowanceSettings(ctx context.Context) ([]model.AllowanceSetting, error)
+	ValidateAllowanceSettings(updates []model.AllowanceSettingUpdate) error
+	UpdateAllowanceSettings(ctx context.Context, updates []model.AllowanceSettingUpdate) ([]model.AllowanceSetting, error)
+	CalculateBatch(ctx context.Context, records []model.TotalIncomeCsv) (model.TaxResponseCSV, error)
+	ListTaxSchedules(ctx context.Context, taxYear int) ([]model.TaxSchedule, error)
+	GetActiveTaxSchedule(ctx context.Context, taxYear int) (model.TaxSchedule, error)
+	GetTaxSchedule(ctx context.Context, taxYear int, id uint) (model.TaxSchedule, error)
+	ProposeTaxSchedule(ctx context.Context, taxYear int, proposal model.TaxScheduleProposal) (model.TaxSchedule, error)
+	ActivateTaxSchedule(ctx context.Context, taxYear int, id uint) (model.TaxSchedule, error)
+	ConfigVersionAt(ctx context.Context, asOf time.Time) (int64, error)
+	CalculateTaxAt(ctx context.Context, req model.TaxRequest, version int64) (model.TaxResponse, error)
+	CalculateBatchAt(ctx context.Context, records []model.TotalIncomeCsv, version int64) (model.TaxResponseCSV, error)
 }
 
 type TaxService struct {
-	Repo TaxRepositories
+	Repo     TaxRepositories
+	Config   *ConfigCache
+	Timeouts Timeouts
+	// TaxYear is the Buddhist Era year calculations are made for when the
+	// request names none; zero means modelgorm.DefaultTaxYear.
+	TaxYear int
+}
+
+func NewTaxService(repo TaxRepositories, config *ConfigCache, timeouts Timeouts) TaxServices {
+	return &TaxService{Repo: repo, Config: config, Timeouts: timeouts}
+}
+
+func (service *TaxService) allowanceConfig(ctx context.Context) (map[string]modelgorm.AllowanceGorm, error) {
+	allowances, err := service.Repo.GetAllowanceConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve allowance configuration: %w", err)
+	}
+
+	config := make(map[string]modelgorm.AllowanceGorm, len(allowances))
+	for _, allowance := range allowances {
+		config[allowance.AllowanceType] = allowance
+	}
+
+	for _, spec := range modelgorm.AllowanceSpecs {
+		if _, ok := config[spec.AllowanceType]; !ok {
+			return nil, fmt.Errorf("allowance configuration is missing %s", spec.AllowanceType)
+		}
+	}
+
+	return config, nil
+}
+
+// taxYear returns requested, or the configured current year if it is zero.
+func (service *TaxService) taxYear(requested int) int {
+	switch {
+	case requested != 0:
+		return requested
+	case service.TaxYear != 0:
+		return service.TaxYear
+	}
+	return modelgorm.DefaultTaxYear
+}
+
+func (service *TaxService) activeRates(ctx context.Context, taxYear int) ([]model.TaxRate, error) {
+	schedule, err := service.Repo.GetActiveSchedule(ctx, taxYear)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: no active schedule for %d", ErrScheduleNotFound, taxYear)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve tax schedule: %w", err)
+	}
+	return toTaxRates(schedule.Brackets), nil
+}
+
+// NormalizeNationalID returns id without separators, or ErrInvalidNationalID
+// if it is not a valid national ID. An empty id is allowed.
+func NormalizeNationalID(id string) (string, error) {
+	if id == "" {
+		return "", nil
+	}
+	id = utils.NormalizeNationalID(id)
+	if !utils.ValidNationalID(id) {
+		return "", &FieldError{
+			Field:   "nationalId",
+			Err:     fmt.Errorf("%w: nationalId must be 13 digits with a valid check digit", ErrInvalidNationalID),
+			Message: i18n.Msg("validation.invalid_national_id"),
+		}
+	}
+	return id, nil
+}
+
+// NormalizeBatch normalizes the national ID of every row in place and
+// rejects the batch if any ID is invalid or appears on more than one row.
+// Errors name rows, counted from 1, and never the IDs themselves.
+// CalculateBatch runs it, so handlers pass rows through as read.
+func NormalizeBatch(records []model.TotalIncomeCsv) error {
+	var problems []string
+	var messages []i18n.Message
+	seen := map[string]int{}
+	for i := range records {
+		row := i + 1
+		if records[i].NationalID == "" {
+			continue
+		}
+		id := utils.NormalizeNationalID(records[i].NationalID)
+		if !utils.ValidNationalID(id) {
+			problems = append(problems, fmt.Sprintf("row %d: nationalId must be 13 digits with a valid check digit", row))
+			messages = append(messages, i18n.Msg("validation.row_invalid_national_id", row))
+			continue
+		}
+		records[i].NationalID = id
+		if first, ok := seen[id]; ok {
+			problems = append(problems, fmt.Sprintf("row %d: nationalId duplicates row %d", row, first))
+			messages = append(messages, i18n.Msg("validation.row_duplicate_national_id", row, first))
+			continue
+		}
+		seen[id] = row
+	}
+	if len(problems) > 0 {
+		return &FieldError{
+			Field:   "taxes",
+			Err:     fmt.Errorf("%w: %s", ErrInvalidNationalID, strings.Join(problems, "; ")),
+			Message: i18n.Msg("validation.invalid_national_ids", messages),
+		}
+	}
+	return nil
 }
 
-func NewTaxService(repo TaxRepositories) TaxServices {
-	return &TaxService{Repo: repo}
+func (service *TaxService) CalculateTax(ctx context.Context, req model.TaxRequest) (model.TaxResponse, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Calculation)
+	defer cancel()
+
+	var err error
+	if req.NationalID, err = NormalizeNationalID(req.NationalID); err != nil {
+		return model.TaxResponse{}, err
+	}
+
+	snapshot, err := service.Config.Snapshot(ctx)
+	if err != nil {
+		return model.TaxResponse{}, err
+	}
+	req.TaxYear = service.taxYear(req.TaxYear)
+	return calculateTax(req, snapshot)
 }
 
-func (service *TaxService) CalculateTax(req model.TaxRequest) (float64, []model.TaxBracket, error) {
-	allowances, err := service.Repo.GetAllowanceConfig()
+func calculateTax(req model.TaxRequest, snapshot *ConfigSnapshot) (model.TaxResponse, error) {
+	rates, err := snapshot.Rates(req.TaxYear)
 	if err != nil {
-		return 0, nil, fmt.Errorf("failed to retrieve allowance configuration: %w", err)
+		return model.TaxResponse{}, err
 	}
 
-	personalDefault := allowances[0].Amount
-	personalMax := allowances[1].Amount
-	donationMax := allowances[2].Amount
-	kReceiptDefault := allowances[3].Amount
-	kReceiptMax := allowances[4].Amount
+	personalDefault := snapshot.Allowances[modelgorm.PersonalDefault]
+	personalMax := snapshot.Allowances[modelgorm.PersonalMax]
+	donationMax := snapshot.Allowances[modelgorm.DonationMax]
+	kReceiptDefault := snapshot.Allowances[modelgorm.KReceiptDefault]
+	kReceiptMax := snapshot.Allowances[modelgorm.KReceiptMax]
+
+	personalSpec, _ := modelgorm.LookupAllowanceSpec(modelgorm.PersonalDefault)
 
+	breakdown := model.TaxBreakdown{
+		TotalIncome: req.TotalIncome,
+		Deductions:  []model.Deduction{{Type: "personal", Amount: personalDefault}},
+		WHT:         req.WHT,
+	}
 	var totalDeductions float64
-	for _, allowance := range req.Allowances {
+	for i, allowance := range req.Allowances {
+		field := fmt.Sprintf("allowances[%d].amount", i)
+		claimed := allowance.Amount
 		if allowance.Amount < 0 {
-			return 0, nil, errors.New("allowance amount cannot be negative")
+			return model.TaxResponse{}, &FieldError{
+				Field:   field,
+				Err:     ErrNegativeAllowance,
+				Message: i18n.Msg("validation.negative_allowance", i18n.AllowanceName(allowance.AllowanceType)),
+			}
 		}
 
 		switch allowance.AllowanceType {
 		case "personal":
-			if allowance.Amount > personalMax || allowance.Amount < 10000 {
-				return 0, nil, fmt.Errorf("personal allowance amount must be between 10000 and %f", personalMax)
+			if allowance.Amount > personalMax || allowance.Amount < personalSpec.Min {
+				return model.TaxResponse{}, &FieldError{
+					Field:   field,
+					Err:     fmt.Errorf("%w: must be between %s and %s", ErrPersonalAllowanceOutOfRange, utils.FormatAmount(personalSpec.Min), utils.FormatAmount(personalMax)),
+					Message: i18n.Msg("validation.personal_allowance_range", personalSpec.Min, personalMax),
+				}
 			}
 		case "donation":
 			if allowance.Amount > donationMax {
@@ -61,15 +256,33 @@ func (service *TaxService) CalculateTax(req model.TaxRequest) (float64, []model.
 				allowance.Amount = kReceiptMax
 			}
 		}
+		if allowance.Amount < claimed {
+			breakdown.Warnings = append(breakdown.Warnings, model.Warning{
+				Code:      WarningAllowanceCapped,
+				Field:     field,
+				Allowance: allowance.AllowanceType,
+				Limit:     allowance.Amount,
+			})
+		}
+		breakdown.Deductions = append(breakdown.Deductions, model.Deduction{Type: allowance.AllowanceType, Amount: allowance.Amount})
 		totalDeductions += allowance.Amount
 	}
 
 	if req.WHT < 0 || req.WHT > req.TotalIncome {
-		return 0, nil, errors.New("invalid WHT value")
+		return model.TaxResponse{}, &FieldError{
+			Field:   "wht",
+			Err:     fmt.Errorf("%w: wht must be between 0 and the total income", ErrInvalidWHT),
+			Message: i18n.Msg("validation.invalid_wht"),
+		}
 	}
 
 	taxableIncome := req.TotalIncome - totalDeductions - personalDefault
-	tax, taxBrackets := utils.CalculateIncomeTaxDetailed(taxableIncome)
+	tax, taxBrackets := utils.CalculateIncomeTaxDetailed(taxableIncome, rates)
+	breakdown.TaxableIncome = math.Max(taxableIncome, 0)
+	breakdown.GrossTax = tax
+	breakdown.Brackets = slices.Clone(taxBrackets)
+
+	// v1 reports a refund as a negative tax, and takes WHT off every bracket
 	tax -= req.WHT
 	for i := range taxBrackets {
 		taxBrackets[i].Tax -= req.WHT
@@ -78,40 +291,296 @@ func (service *TaxService) CalculateTax(req model.TaxRequest) (float64, []model.
 		}
 	}
 
-	return tax, taxBrackets, nil
+	return model.TaxResponse{
+		NationalID:    req.NationalID,
+		Tax:           tax,
+		TaxLevels:     taxBrackets,
+		ConfigVersion: snapshot.Version,
+		Breakdown:     breakdown,
+	}, nil
+}
+
+// TaxFromFile reads the rows of a CSV upload. Column headings may be in
+// any supported language, such as เงินได้รวม for totalIncome.
+func (service *TaxService) TaxFromFile(file io.Reader) ([]model.TotalIncomeCsv, error) {
+	var totalIncomeCsv []model.TotalIncomeCsv
+	if err := gocsv.UnmarshalCSV(&localizedHeadings{CSVReader: gocsv.DefaultCSVReader(file)}, &totalIncomeCsv); err != nil {
+		log.Println("Failed to unmarshal CSV: ", err)
+		return nil, err
+	}
+	return totalIncomeCsv, nil
+}
+
+// localizedHeadings reads a CSV whose first row names its columns in any
+// supported language and returns that row with the field names instead.
+type localizedHeadings struct {
+	gocsv.CSVReader
+	read bool
+}
+
+func (r *localizedHeadings) Read() ([]string, error) {
+	row, err := r.CSVReader.Read()
+	if err != nil || r.read {
+		return row, err
+	}
+	r.read = true
+	for i := range row {
+		row[i] = i18n.CSVHeader(row[i])
+	}
+	return row, nil
+}
+
+func (r *localizedHeadings) ReadAll() ([][]string, error) {
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+}
+
+func (service *TaxService) CalculateBatch(ctx context.Context, records []model.TotalIncomeCsv) (model.TaxResponseCSV, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Batch)
+	defer cancel()
+
+	if err := NormalizeBatch(records); err != nil {
+		return model.TaxResponseCSV{}, err
+	}
+
+	snapshot, err := service.Config.Snapshot(ctx)
+	if err != nil {
+		return model.TaxResponseCSV{}, err
+	}
+	return calculateBatch(ctx, records, snapshot, service.taxYear(0))
+}
+
+const (
+	// batchCheckInterval is how many rows are calculated between checks
+	// that the caller is still waiting.
+	batchCheckInterval = 1000
+	// batchPersonalAllowance is deducted from every CSV row. Rows are
+	// calculated as they always have been: with this fixed allowance and
+	// their whole donation, whatever the allowance settings.
+	batchPersonalAllowance = 60000.00
+)
+
+// calculateBatch calculates each row for the tax year it names, or for
+// taxYear if it names none.
+func calculateBatch(ctx context.Context, records []model.TotalIncomeCsv, snapshot *ConfigSnapshot, taxYear int) (model.TaxResponseCSV, error) {
+	taxDetails := make([]model.TaxDetail, 0, len(records))
+	for i, record := range records {
+		if i%batchCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return model.TaxResponseCSV{}, err
+			}
+		}
+
+		year := record.TaxYear
+		if year == 0 {
+			year = taxYear
+		}
+		rates, err := snapshot.Rates(year)
+		if err != nil {
+			return model.TaxResponseCSV{}, fmt.Errorf("row %d: %w", i+1, err)
+		}
+
+		breakdown := model.TaxBreakdown{
+			TotalIncome: record.TotalIncome,
+			Deductions: []model.Deduction{
+				{Type: "personal", Amount: batchPersonalAllowance},
+				{Type: "donation", Amount: record.Donation},
+			},
+			WHT: record.WHT,
+		}
+
+		taxableIncome := record.TotalIncome - batchPersonalAllowance - record.Donation
+		breakdown.TaxableIncome = math.Max(taxableIncome, 0)
+		breakdown.GrossTax, breakdown.Brackets = utils.CalculateIncomeTaxDetailed(taxableIncome, rates)
+		netTax, taxRefund := breakdown.Net()
+
+		taxDetails = append(taxDetails, model.TaxDetail{
+			NationalID:  record.NationalID,
+			TotalIncome: record.TotalIncome,
+			Tax:         netTax,
+			TaxRefund:   taxRefund,
+			Breakdown:   breakdown,
+		})
+	}
+
+	return model.TaxResponseCSV{
+		Taxes:         taxDetails,
+		ConfigVersion: snapshot.Version,
+	}, nil
 }
 
-func (service *TaxService) SetPersonalDeduction(amount float64) error {
-	if amount < 10000 || amount > 100000 {
-		return errors.New("amount must be between 10,000 and 100,000")
+// snapshotAt rebuilds the configuration as it was at version, reusing the
+// cached snapshot when it is still current.
+func (service *TaxService) snapshotAt(ctx context.Context, version int64) (*ConfigSnapshot, error) {
+	if current, err := service.Config.Snapshot(ctx); err == nil && current.Version == version {
+		return current, nil
 	}
 
-	err := service.Repo.SetPersonalDeduction(amount)
+	revision, err := service.Repo.GetConfigRevision(ctx, version)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %d", ErrConfigNotFound, version)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to set personal deduction: %w", err)
+		return nil, fmt.Errorf("failed to retrieve configuration version %d: %w", version, err)
 	}
+	return newConfigSnapshot(revision)
+}
 
-	return nil
+func (service *TaxService) ConfigVersionAt(ctx context.Context, asOf time.Time) (int64, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Read)
+	defer cancel()
+
+	revision, err := service.Repo.ConfigRevisionAt(ctx, asOf)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return 0, fmt.Errorf("%w: none in force at %s", ErrConfigNotFound, asOf.Format(time.RFC3339))
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to retrieve configuration: %w", err)
+	}
+	return revision.ID, nil
 }
 
-func (service *TaxService) TaxFromFile(file io.Reader) ([]model.TotalIncomeCsv, error) {
-	var totalIncomeCsv []model.TotalIncomeCsv
-	if err := gocsv.Unmarshal(file, &totalIncomeCsv); err != nil {
-		log.Println("Failed to unmarshal CSV: ", err)
-		return nil, err
+func (service *TaxService) CalculateTaxAt(ctx context.Context, req model.TaxRequest, version int64) (model.TaxResponse, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Calculation)
+	defer cancel()
+
+	snapshot, err := service.snapshotAt(ctx, version)
+	if err != nil {
+		return model.TaxResponse{}, err
 	}
-	return totalIncomeCsv, nil
+	req.TaxYear = service.taxYear(req.TaxYear)
+	return calculateTax(req, snapshot)
 }
 
-func (service *TaxService) SetKReceiptDeduction(amount float64) error {
-	if amount < 0 || amount > 100000 {
-		return errors.New("amount must be between 0 and 100,000")
+func (service *TaxService) CalculateBatchAt(ctx context.Context, records []model.TotalIncomeCsv, version int64) (model.TaxResponseCSV, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Batch)
+	defer cancel()
+
+	snapshot, err := service.snapshotAt(ctx, version)
+	if err != nil {
+		return model.TaxResponseCSV{}, err
 	}
+	return calculateBatch(ctx, records, snapshot, service.taxYear(0))
+}
 
-	err := service.Repo.SetKreceiptDeduction(amount)
+func (service *TaxService) GetAllowanceSettings(ctx context.Context) ([]model.AllowanceSetting, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Read)
+	defer cancel()
+
+	config, err := service.allowanceConfig(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to set K-receipt deduction: %w", err)
+		return nil, err
+	}
+
+	settings := make([]model.AllowanceSetting, 0, len(modelgorm.AllowanceSpecs))
+	for _, spec := range modelgorm.AllowanceSpecs {
+		settings = append(settings, model.AllowanceSetting{
+			Key:     spec.AllowanceType,
+			Amount:  config[spec.AllowanceType].Amount,
+			Min:     spec.Min,
+			Max:     spec.Max,
+			Version: config[spec.AllowanceType].Version,
+		})
+	}
+	return settings, nil
+}
+
+func (service *TaxService) ValidateAllowanceSettings(updates []model.AllowanceSettingUpdate) error {
+	if len(updates) == 0 {
+		return fmt.Errorf("%w: at least one setting is required", ErrInvalidSettings)
+	}
+
+	seen := make(map[string]bool, len(updates))
+	for i, update := range updates {
+		key := fmt.Sprintf("settings[%d].key", i)
+		if seen[update.Key] {
+			return &FieldError{Field: key, Err: fmt.Errorf("%w: %s is set more than once", ErrInvalidSettings, update.Key)}
+		}
+		seen[update.Key] = true
+
+		if err := validateAllowanceSetting(update.Key, update.Amount); err != nil {
+			if errors.Is(err, ErrUnknownAllowance) {
+				return &FieldError{Field: key, Err: err}
+			}
+			return &FieldError{Field: fmt.Sprintf("settings[%d].amount", i), Err: err}
+		}
+	}
+	return nil
+}
+
+func (service *TaxService) UpdateAllowanceSettings(ctx context.Context, updates []model.AllowanceSettingUpdate) ([]model.AllowanceSetting, error) {
+	ctx, cancel := withTimeout(ctx, service.Timeouts.Write)
+	defer cancel()
+
+	if err := service.ValidateAllowanceSettings(updates); err != nil {
+		return nil, err
 	}
 
+	allowances := make([]modelgorm.Allowance, len(updates))
+	for i, update := range updates {
+		allowances[i] = modelgorm.Allowance{
+			AllowanceType: update.Key,
+			Amount:        update.Amount,
+			Version:       update.Version,
+		}
+	}
+
+	if err := service.Repo.UpdateAllowances(ctx, allowances); err != nil {
+		return nil, fmt.Errorf("failed to update allowance settings: %w", err)
+	}
+	service.refreshConfig(ctx)
+
+	return service.GetAllowanceSettings(ctx)
+}
+
+func validateAllowanceSetting(key string, amount float64) error {
+	spec, ok := modelgorm.LookupAllowanceSpec(key)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownAllowance, key)
+	}
+	if amount < spec.Min || amount > spec.Max {
+		return fmt.Errorf("%w: %s must be between %s and %s", ErrAllowanceOutOfRange, key, utils.FormatAmount(spec.Min), utils.FormatAmount(spec.Max))
+	}
 	return nil
 }
+
+// checkAllowanceBounds rejects a configuration whose default personal
+// allowance lies outside the range a taxpayer may claim, which PersonalMax
+// bounds from above. Repositories run it on the state a write leaves, so an
+// update to either setting is checked against the other.
+func checkAllowanceBounds(amounts map[string]float64) error {
+	personalDefault, ok := amounts[modelgorm.PersonalDefault]
+	if !ok {
+		return nil
+	}
+	spec, _ := modelgorm.LookupAllowanceSpec(modelgorm.PersonalDefault)
+	upper := spec.Max
+	if personalMax, ok := amounts[modelgorm.PersonalMax]; ok {
+		upper = personalMax
+	}
+	if personalDefault < spec.Min || personalDefault > upper {
+		return fmt.Errorf("%w: %s must be between %s and %s", ErrAllowanceOutOfRange, modelgorm.PersonalDefault, utils.FormatAmount(spec.Min), utils.FormatAmount(upper))
+	}
+	return nil
+}
+
+// refreshConfig reloads the snapshot after a write. The write has already
+// committed, so the reload outlives a cancelled request and a failure here is
+// only logged; the periodic refresh catches up.
+func (service *TaxService) refreshConfig(ctx context.Context) {
+	ctx, cancel := withTimeout(context.WithoutCancel(ctx), service.Timeouts.Read)
+	defer cancel()
+
+	if _, err := service.Config.Refresh(ctx); err != nil {
+		log.Println("Failed to refresh configuration: ", err)
+	}
+}
@@ -2,45 +2,127 @@ package tax
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
 	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/i18n"
 	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/pphee/assessment-tax/utils"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
 	"testing"
+	"time"
 )
 
 type MockRepo struct {
 	mock.Mock
 }
 
-func (m *MockRepo) GetAllowanceConfig() ([]modelgorm.AllowanceGorm, error) {
+func (m *MockRepo) GetAllowanceConfig(ctx context.Context) ([]modelgorm.AllowanceGorm, error) {
 	args := m.Called()
 	return args.Get(0).([]modelgorm.AllowanceGorm), args.Error(1)
 }
 
-func (m *MockRepo) SetPersonalDeduction(amount float64) error {
-	args := m.Called(amount)
+func (m *MockRepo) UpdateAllowances(ctx context.Context, allowances []modelgorm.Allowance) error {
+	args := m.Called(allowances)
 	return args.Error(0)
 }
 
-func (m *MockRepo) SetKreceiptDeduction(amount float64) error {
-	args := m.Called(amount)
+func (m *MockRepo) GetActiveSchedule(ctx context.Context, taxYear int) (modelgorm.TaxScheduleGorm, error) {
+	args := m.Called(taxYear)
+	return args.Get(0).(modelgorm.TaxScheduleGorm), args.Error(1)
+}
+
+func (m *MockRepo) GetSchedule(ctx context.Context, id uint) (modelgorm.TaxScheduleGorm, error) {
+	args := m.Called(id)
+	return args.Get(0).(modelgorm.TaxScheduleGorm), args.Error(1)
+}
+
+func (m *MockRepo) ListSchedules(ctx context.Context, taxYear int) ([]modelgorm.TaxScheduleGorm, error) {
+	args := m.Called(taxYear)
+	return args.Get(0).([]modelgorm.TaxScheduleGorm), args.Error(1)
+}
+
+func (m *MockRepo) CreateSchedule(ctx context.Context, schedule *modelgorm.TaxScheduleGorm) error {
+	args := m.Called(schedule)
 	return args.Error(0)
 }
 
-func TestCalculateTax(t *testing.T) {
-	mockRepo := new(MockRepo)
-	service := NewTaxService(mockRepo)
+func (m *MockRepo) ActivateSchedule(ctx context.Context, id uint) (modelgorm.TaxScheduleGorm, error) {
+	args := m.Called(id)
+	return args.Get(0).(modelgorm.TaxScheduleGorm), args.Error(1)
+}
+
+func (m *MockRepo) LatestConfigRevision(ctx context.Context) (modelgorm.ConfigRevisionGorm, error) {
+	args := m.Called()
+	return args.Get(0).(modelgorm.ConfigRevisionGorm), args.Error(1)
+}
+
+func (m *MockRepo) GetConfigRevision(ctx context.Context, version int64) (modelgorm.ConfigRevisionGorm, error) {
+	args := m.Called(version)
+	return args.Get(0).(modelgorm.ConfigRevisionGorm), args.Error(1)
+}
+
+func (m *MockRepo) ConfigRevisionAt(ctx context.Context, asOf time.Time) (modelgorm.ConfigRevisionGorm, error) {
+	args := m.Called(asOf)
+	return args.Get(0).(modelgorm.ConfigRevisionGorm), args.Error(1)
+}
+
+func newTestService(repo *MockRepo) TaxServices {
+	return NewTaxService(repo, NewConfigCache(repo), DefaultTimeouts)
+}
+
+func configRevision(version int64, allowances []modelgorm.AllowanceGorm, schedules ...modelgorm.TaxScheduleGorm) modelgorm.ConfigRevisionGorm {
+	var state modelgorm.ConfigState
+	for _, allowance := range allowances {
+		state.Allowances = append(state.Allowances, modelgorm.Allowance{AllowanceType: allowance.AllowanceType, Amount: allowance.Amount})
+	}
+	for _, schedule := range schedules {
+		scheduleState := modelgorm.ScheduleState{TaxYear: schedule.TaxYear, ScheduleID: schedule.ID}
+		for _, bracket := range schedule.Brackets {
+			scheduleState.Brackets = append(scheduleState.Brackets, modelgorm.BracketState{Min: bracket.MinIncome, Max: bracket.MaxIncome, Rate: bracket.Rate})
+		}
+		state.Schedules = append(state.Schedules, scheduleState)
+	}
+
+	snapshot, _ := json.Marshal(state)
+	return modelgorm.ConfigRevisionGorm{ID: version, Snapshot: string(snapshot)}
+}
+
+func defaultRevision() modelgorm.ConfigRevisionGorm {
+	return configRevision(1, defaultAllowanceConfig(), defaultSchedule())
+}
 
-	allowances := []modelgorm.AllowanceGorm{
-		{Amount: 60000.00},  // personalDefault
-		{Amount: 100000.00}, // personalMax
-		{Amount: 100000.00}, // donationMax
-		{Amount: 50000.00},  // kReceiptDefault
-		{Amount: 100000.00}, // kReceiptMax
+func defaultSchedule() modelgorm.TaxScheduleGorm {
+	schedule := modelgorm.TaxScheduleGorm{ID: 1, TaxYear: 2567, Status: "active"}
+	for i, rate := range utils.DefaultTaxRates {
+		schedule.Brackets = append(schedule.Brackets, modelgorm.TaxBracketGorm{
+			Position:  i,
+			MinIncome: rate.Min,
+			MaxIncome: rate.Max,
+			Rate:      rate.Rate,
+		})
 	}
+	return schedule
+}
+
+func defaultAllowanceConfig() []modelgorm.AllowanceGorm {
+	return []modelgorm.AllowanceGorm{
+		{AllowanceType: "PersonalDefault", Amount: 60000.00},
+		{AllowanceType: "PersonalMax", Amount: 100000.00},
+		{AllowanceType: "DonationMax", Amount: 100000.00},
+		{AllowanceType: "KReceiptDefault", Amount: 50000.00},
+		{AllowanceType: "KReceiptMax", Amount: 100000.00},
+	}
+}
+
+func TestCalculateTax(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
 
-	mockRepo.On("GetAllowanceConfig").Return(allowances, nil)
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
 
 	req := model.TaxRequest{
 		TotalIncome: 500000.0,
@@ -51,41 +133,150 @@ func TestCalculateTax(t *testing.T) {
 	}
 
 	expectedTax := 4000.0
+	rates := toTaxRates(defaultSchedule().Brackets)
 	expectedBrackets := []model.TaxBracket{
-		{Level: "0-150,000", Tax: 0.0},
-		{Level: "150,001-500,000", Tax: 4000.0},
-		{Level: "500,001-1,000,000", Tax: 0.0},
-		{Level: "1,000,001-2,000,000", Tax: 0.0},
-		{Level: "2,000,001 ขึ้นไป", Tax: 0.0},
+		{Level: "0-150,000", Tax: 0.0, Rate: rates[0]},
+		{Level: "150,001-500,000", Tax: 4000.0, Rate: rates[1]},
+		{Level: "500,001-1,000,000", Tax: 0.0, Rate: rates[2]},
+		{Level: "1,000,001-2,000,000", Tax: 0.0, Rate: rates[3]},
+		{Level: "2,000,001 ขึ้นไป", Tax: 0.0, Rate: rates[4]},
 	}
 
-	tax, taxBrackets, err := service.CalculateTax(req)
+	res, err := service.CalculateTax(context.Background(), req)
 
 	assert.Nil(t, err)
-	assert.Equal(t, expectedTax, tax)
-	assert.ElementsMatch(t, expectedBrackets, taxBrackets)
+	assert.Equal(t, expectedTax, res.Tax)
+	assert.ElementsMatch(t, expectedBrackets, res.TaxLevels)
+	assert.Equal(t, int64(1), res.ConfigVersion)
 }
 
-func TestSettPersonalDeduction(t *testing.T) {
+// The breakdown is what v2 reports; v1 reports the same tax, less WHT,
+// as a negative tax when it is a refund.
+func TestCalculateTax_Breakdown(t *testing.T) {
 	mockRepo := new(MockRepo)
-	service := NewTaxService(mockRepo)
-	amount := 50000.0
+	service := newTestService(mockRepo)
 
-	mockRepo.On("SetPersonalDeduction", amount).Return(nil)
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
 
-	err := service.SetPersonalDeduction(amount)
-	assert.Nil(t, err)
+	res, err := service.CalculateTax(context.Background(), model.TaxRequest{
+		TotalIncome: 500000,
+		WHT:         25000,
+		Allowances: []model.Allowance{
+			{AllowanceType: "donation", Amount: 200000},
+			{AllowanceType: "k-receipt", Amount: 60000},
+		},
+	})
+
+	require.NoError(t, err)
+	breakdown := res.Breakdown
+	assert.Equal(t, 500000.0, breakdown.TotalIncome)
+	assert.Equal(t, []model.Deduction{
+		{Type: "personal", Amount: 60000},
+		{Type: "donation", Amount: 100000},
+		{Type: "k-receipt", Amount: 50000},
+	}, breakdown.Deductions)
+	assert.Equal(t, 290000.0, breakdown.TaxableIncome)
+	assert.Equal(t, 14000.0, breakdown.GrossTax)
+	assert.Equal(t, model.TaxBracket{Level: "150,001-500,000", Tax: 14000, Rate: toTaxRates(defaultSchedule().Brackets)[1]}, breakdown.Brackets[1])
+	assert.Equal(t, 25000.0, breakdown.WHT)
+	assert.Equal(t, []model.Warning{
+		{Code: WarningAllowanceCapped, Field: "allowances[0].amount", Allowance: "donation", Limit: 100000},
+		{Code: WarningAllowanceCapped, Field: "allowances[1].amount", Allowance: "k-receipt", Limit: 50000},
+	}, breakdown.Warnings)
+
+	tax, refund := breakdown.Net()
+	assert.Equal(t, 0.0, tax)
+	assert.Equal(t, 11000.0, refund)
+	assert.Equal(t, tax-refund, res.Tax)
+	assert.Equal(t, 0.0, res.TaxLevels[1].Tax, "v1 takes WHT off every bracket")
 }
 
-func TestSetKReceiptDeduction(t *testing.T) {
+func TestCalculateTax_FieldErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		req   model.TaxRequest
+		want  error
+		field string
+	}{
+		{"Negative Allowance", model.TaxRequest{TotalIncome: 500000, Allowances: []model.Allowance{{AllowanceType: "donation", Amount: 0}, {AllowanceType: "donation", Amount: -1}}}, ErrNegativeAllowance, "allowances[1].amount"},
+		{"Personal Too Low", model.TaxRequest{TotalIncome: 500000, Allowances: []model.Allowance{{AllowanceType: "personal", Amount: 5000}}}, ErrPersonalAllowanceOutOfRange, "allowances[0].amount"},
+		{"Negative WHT", model.TaxRequest{TotalIncome: 500000, WHT: -1}, ErrInvalidWHT, "wht"},
+		{"WHT Above Income", model.TaxRequest{TotalIncome: 500000, WHT: 500001}, ErrInvalidWHT, "wht"},
+		{"National ID", model.TaxRequest{TotalIncome: 500000, NationalID: "1101700203451"}, ErrInvalidNationalID, "nationalId"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			service := newTestService(mockRepo)
+			mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
+
+			_, err := service.CalculateTax(context.Background(), tt.req)
+
+			assert.ErrorIs(t, err, tt.want)
+			var fieldErr *FieldError
+			if assert.ErrorAs(t, err, &fieldErr) {
+				assert.Equal(t, tt.field, fieldErr.Field)
+			}
+		})
+	}
+}
+
+func TestUpdateAllowanceSettings(t *testing.T) {
 	mockRepo := new(MockRepo)
-	service := NewTaxService(mockRepo)
-	amount := 40000.0
+	service := newTestService(mockRepo)
+
+	mockRepo.On("UpdateAllowances", []modelgorm.Allowance{
+		{AllowanceType: "PersonalDefault", Amount: 50000},
+		{AllowanceType: "KReceiptMax", Amount: 40000},
+	}).Return(nil)
+	mockRepo.On("GetAllowanceConfig").Return(defaultAllowanceConfig(), nil)
+	mockRepo.On("LatestConfigRevision").Return(configRevision(2, defaultAllowanceConfig(), defaultSchedule()), nil)
 
-	mockRepo.On("SetKreceiptDeduction", amount).Return(nil)
+	settings, err := service.UpdateAllowanceSettings(context.Background(), []model.AllowanceSettingUpdate{
+		{Key: "PersonalDefault", Amount: 50000},
+		{Key: "KReceiptMax", Amount: 40000},
+	})
 
-	err := service.SetKReceiptDeduction(amount)
 	assert.Nil(t, err)
+	assert.Len(t, settings, 5)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestUpdateAllowanceSettings_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		updates []model.AllowanceSettingUpdate
+		want    error
+	}{
+		{"Empty", nil, ErrInvalidSettings},
+		{"Unknown Key", []model.AllowanceSettingUpdate{{Key: "Bogus", Amount: 1}}, ErrUnknownAllowance},
+		{"Below Min", []model.AllowanceSettingUpdate{{Key: "PersonalDefault", Amount: 9999}}, ErrAllowanceOutOfRange},
+		{"Above Max", []model.AllowanceSettingUpdate{{Key: "KReceiptDefault", Amount: 100001}}, ErrAllowanceOutOfRange},
+		{"Duplicate", []model.AllowanceSettingUpdate{{Key: "DonationMax", Amount: 1}, {Key: "DonationMax", Amount: 2}}, ErrInvalidSettings},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			service := newTestService(mockRepo)
+
+			_, err := service.UpdateAllowanceSettings(context.Background(), tt.updates)
+
+			assert.ErrorIs(t, err, tt.want)
+			mockRepo.AssertNotCalled(t, "UpdateAllowances", mock.Anything)
+		})
+	}
+}
+
+func TestGetAllowanceSettings_MissingKey(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("GetAllowanceConfig").Return(defaultAllowanceConfig()[:4], nil)
+
+	_, err := service.GetAllowanceSettings(context.Background())
+	assert.ErrorContains(t, err, "KReceiptMax")
 }
 
 func TestTaxFromFileSuccess(t *testing.T) {
@@ -110,6 +301,76 @@ func TestTaxFromFileSuccess(t *testing.T) {
 	assert.Equal(t, expected, result)
 }
 
+func TestTaxFromFile_NationalID(t *testing.T) {
+	csvContent := `totalIncome,wht,donation,nationalId
+50000,5000,200,1-1017-00203-45-0
+60000,6000,300,`
+
+	service := TaxService{}
+	result, err := service.TaxFromFile(bytes.NewBufferString(csvContent))
+
+	assert.NoError(t, err)
+	assert.NoError(t, NormalizeBatch(result))
+	assert.Equal(t, []model.TotalIncomeCsv{
+		{TotalIncome: 50000, WHT: 5000, Donation: 200, NationalID: "1101700203450"},
+		{TotalIncome: 60000, WHT: 6000, Donation: 300},
+	}, result)
+}
+
+func TestTaxFromFile_ThaiHeadings(t *testing.T) {
+	csvContent := "\ufeffเงินได้รวม,ภาษีหัก ณ ที่จ่าย,เงินบริจาค,เลขประจำตัวประชาชน,ปีภาษี\n" +
+		"500000,25000,1000,1101700203450,2568\n"
+
+	service := TaxService{}
+	result, err := service.TaxFromFile(bytes.NewBufferString(csvContent))
+
+	assert.NoError(t, err)
+	assert.Equal(t, []model.TotalIncomeCsv{
+		{TotalIncome: 500000, WHT: 25000, Donation: 1000, NationalID: "1101700203450", TaxYear: 2568},
+	}, result)
+}
+
+func TestNormalizeBatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		ids     []string
+		wantErr string
+	}{
+		{"Valid", []string{"1101700203450", "", "3101200123453", ""}, ""},
+		{"Bad Check Digit", []string{"1101700203450", "1101700203451"}, "row 2: nationalId must be 13 digits with a valid check digit"},
+		{"Too Short", []string{"12345"}, "row 1: nationalId must be 13 digits"},
+		{"Duplicate", []string{"1101700203450", "3101200123453", "1 1017 00203 45 0"}, "row 3: nationalId duplicates row 1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := make([]model.TotalIncomeCsv, len(tt.ids))
+			for i, id := range tt.ids {
+				records[i].NationalID = id
+			}
+
+			err := NormalizeBatch(records)
+
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidNationalID)
+			assert.Contains(t, err.Error(), tt.wantErr)
+			var fieldErr *FieldError
+			if assert.ErrorAs(t, err, &fieldErr) {
+				assert.Equal(t, "taxes", fieldErr.Field)
+				assert.Contains(t, i18n.New(i18n.Supported[0]).Text(fieldErr.Message), tt.wantErr)
+			}
+			for _, id := range tt.ids {
+				if id != "" {
+					assert.NotContains(t, err.Error(), utils.NormalizeNationalID(id))
+				}
+			}
+		})
+	}
+}
+
 func TestTaxFromFileError(t *testing.T) {
 	csvContent := `totalIncome,wht,donation
 50000,notanumber,200`
@@ -122,3 +383,240 @@ func TestTaxFromFileError(t *testing.T) {
 	assert.NotNil(t, err)
 	assert.Contains(t, err.Error(), "strconv.ParseFloat: parsing \"notanumber\"")
 }
+
+func TestCalculateBatch(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
+
+	res, err := service.CalculateBatch(context.Background(), []model.TotalIncomeCsv{
+		{TotalIncome: 500000, WHT: 0, Donation: 0},
+		{TotalIncome: 600000, WHT: 40000, Donation: 20000},
+		{TotalIncome: 500000, WHT: 0, Donation: 200000},
+		{TotalIncome: 300000, WHT: 20000, Donation: 0},
+	})
+
+	assert.Nil(t, err)
+	// Breakdowns are checked by TestCalculateBatch_Breakdown
+	for i := range res.Taxes {
+		res.Taxes[i].Breakdown = model.TaxBreakdown{}
+	}
+	assert.Equal(t, []model.TaxDetail{
+		{TotalIncome: 500000, Tax: 29000},
+		{TotalIncome: 600000, Tax: 0, TaxRefund: 2000},
+		{TotalIncome: 500000, Tax: 9000},
+		{TotalIncome: 300000, Tax: 0, TaxRefund: 11000},
+	}, res.Taxes)
+	assert.Equal(t, int64(1), res.ConfigVersion)
+}
+
+func TestCalculateBatch_Breakdown(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
+
+	res, err := service.CalculateBatch(context.Background(), []model.TotalIncomeCsv{
+		{TotalIncome: 500000, WHT: 25000, Donation: 200000},
+	})
+
+	require.NoError(t, err)
+	breakdown := res.Taxes[0].Breakdown
+	assert.Equal(t, []model.Deduction{{Type: "personal", Amount: 60000}, {Type: "donation", Amount: 200000}}, breakdown.Deductions)
+	assert.Equal(t, 240000.0, breakdown.TaxableIncome)
+	assert.Equal(t, 9000.0, breakdown.GrossTax)
+	assert.Equal(t, 9000.0, breakdown.Brackets[1].Tax)
+	assert.Empty(t, breakdown.Warnings)
+	assert.Equal(t, 0.0, res.Taxes[0].Tax)
+	assert.Equal(t, 16000.0, res.Taxes[0].TaxRefund)
+}
+
+func TestCalculateBatch_IgnoresAllowanceSettings(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	settings := defaultAllowanceConfig()
+	settings[0].Amount = 30000.00
+	settings[2].Amount = 50000.00
+	mockRepo.On("LatestConfigRevision").Return(configRevision(1, settings, defaultSchedule()), nil)
+
+	res, err := service.CalculateBatch(context.Background(), []model.TotalIncomeCsv{
+		{TotalIncome: 500000, Donation: 200000},
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []model.Deduction{{Type: "personal", Amount: 60000}, {Type: "donation", Amount: 200000}}, res.Taxes[0].Breakdown.Deductions)
+	assert.Equal(t, 9000.0, res.Taxes[0].Tax)
+}
+
+func TestCalculateTax_NationalID(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
+
+	res, err := service.CalculateTax(context.Background(), model.TaxRequest{TotalIncome: 500000, NationalID: "1-1017-00203-45-0"})
+	assert.NoError(t, err)
+	assert.Equal(t, "1101700203450", res.NationalID)
+
+	_, err = service.CalculateTax(context.Background(), model.TaxRequest{TotalIncome: 500000, NationalID: "1101700203451"})
+	assert.ErrorIs(t, err, ErrInvalidNationalID)
+	assert.NotContains(t, err.Error(), "1101700203451")
+}
+
+func TestCalculateTax_NoActiveSchedule(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("LatestConfigRevision").Return(configRevision(1, defaultAllowanceConfig(), modelgorm.TaxScheduleGorm{TaxYear: 2566}), nil)
+
+	_, err := service.CalculateTax(context.Background(), model.TaxRequest{TotalIncome: 500000})
+	assert.ErrorIs(t, err, ErrScheduleNotFound)
+}
+
+// flatSchedule is a schedule for taxYear taxing all income at 10%.
+func flatSchedule(taxYear int) modelgorm.TaxScheduleGorm {
+	return modelgorm.TaxScheduleGorm{ID: 2, TaxYear: taxYear, Status: "active", Brackets: []modelgorm.TaxBracketGorm{{Rate: 0.1}}}
+}
+
+func TestCalculateTax_TaxYear(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("LatestConfigRevision").Return(configRevision(1, defaultAllowanceConfig(), defaultSchedule(), flatSchedule(2568)), nil)
+	configCache := NewConfigCache(mockRepo)
+
+	tests := []struct {
+		name       string
+		configured int
+		requested  int
+		want       float64
+	}{
+		{"Default Year", 0, 0, 29000},
+		{"Requested Year", 0, 2568, 44000},
+		{"Configured Year", 2568, 0, 44000},
+		{"Requested Over Configured", 2568, 2567, 29000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &TaxService{Repo: mockRepo, Config: configCache, Timeouts: DefaultTimeouts, TaxYear: tt.configured}
+
+			res, err := service.CalculateTax(context.Background(), model.TaxRequest{TotalIncome: 500000, TaxYear: tt.requested})
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, res.Tax)
+		})
+	}
+
+	t.Run("No Schedule", func(t *testing.T) {
+		service := &TaxService{Repo: mockRepo, Config: configCache, Timeouts: DefaultTimeouts}
+
+		_, err := service.CalculateTax(context.Background(), model.TaxRequest{TotalIncome: 500000, TaxYear: 2570})
+
+		assert.ErrorIs(t, err, ErrScheduleNotFound)
+		assert.Contains(t, err.Error(), "2570")
+	})
+}
+
+func TestCalculateBatch_TaxYear(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("LatestConfigRevision").Return(configRevision(1, defaultAllowanceConfig(), defaultSchedule(), flatSchedule(2568)), nil)
+	service := &TaxService{Repo: mockRepo, Config: NewConfigCache(mockRepo), Timeouts: DefaultTimeouts, TaxYear: 2568}
+
+	res, err := service.CalculateBatch(context.Background(), []model.TotalIncomeCsv{
+		{TotalIncome: 500000},
+		{TotalIncome: 500000, TaxYear: 2567},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 44000.0, res.Taxes[0].Tax)
+	assert.Equal(t, 29000.0, res.Taxes[1].Tax)
+
+	_, err = service.CalculateBatch(context.Background(), []model.TotalIncomeCsv{
+		{TotalIncome: 500000},
+		{TotalIncome: 500000, TaxYear: 2570},
+	})
+	assert.ErrorIs(t, err, ErrScheduleNotFound)
+	assert.Contains(t, err.Error(), "row 2")
+}
+
+func TestCalculateTaxAt_HistoricalVersion(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	older := defaultAllowanceConfig()
+	older[0].Amount = 50000.00
+	mockRepo.On("LatestConfigRevision").Return(configRevision(2, defaultAllowanceConfig(), defaultSchedule()), nil)
+	mockRepo.On("GetConfigRevision", int64(1)).Return(configRevision(1, older, defaultSchedule()), nil)
+
+	req := model.TaxRequest{TotalIncome: 500000.0}
+
+	current, err := service.CalculateTax(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, 29000.0, current.Tax)
+
+	res, err := service.CalculateTaxAt(context.Background(), req, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 30000.0, res.Tax)
+	assert.Equal(t, int64(1), res.ConfigVersion)
+}
+
+func TestCalculateTaxAt_CurrentVersionUsesCache(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
+
+	res, err := service.CalculateTaxAt(context.Background(), model.TaxRequest{TotalIncome: 500000.0}, 1)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), res.ConfigVersion)
+	mockRepo.AssertNotCalled(t, "GetConfigRevision", mock.Anything)
+}
+
+func TestCalculateBatchAt_UnknownVersion(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
+	mockRepo.On("GetConfigRevision", int64(9)).Return(modelgorm.ConfigRevisionGorm{}, gorm.ErrRecordNotFound)
+
+	_, err := service.CalculateBatchAt(context.Background(), []model.TotalIncomeCsv{{TotalIncome: 500000.0}}, 9)
+
+	assert.ErrorIs(t, err, ErrConfigNotFound)
+}
+
+func TestConfigVersionAt(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+	asOf := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	mockRepo.On("ConfigRevisionAt", asOf).Return(configRevision(3, defaultAllowanceConfig(), defaultSchedule()), nil).Once()
+	version, err := service.ConfigVersionAt(context.Background(), asOf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), version)
+
+	mockRepo.On("ConfigRevisionAt", asOf).Return(modelgorm.ConfigRevisionGorm{}, gorm.ErrRecordNotFound).Once()
+	_, err = service.ConfigVersionAt(context.Background(), asOf)
+	assert.ErrorIs(t, err, ErrConfigNotFound)
+}
+
+func TestCalculateBatch_Cancelled(t *testing.T) {
+	mockRepo := new(MockRepo)
+	service := newTestService(mockRepo)
+
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := service.CalculateBatch(ctx, []model.TotalIncomeCsv{{TotalIncome: 500000}})
+
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestGetAllowanceSettings_ReadTimeout(t *testing.T) {
+	repo := NewMemoryTaxRepository()
+	service := NewTaxService(repo, NewConfigCache(repo), Timeouts{Read: time.Nanosecond})
+
+	_, err := service.GetAllowanceSettings(context.Background())
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,214 @@
+package tax
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ConfigSnapshot is an immutable view of the allowances and active bracket
+// schedules at one configuration version. It must not be modified once built.
+type ConfigSnapshot struct {
+	Version    int64
+	CreatedAt  time.Time
+	Allowances map[string]float64
+	Schedules  map[int][]model.TaxRate
+}
+
+func newConfigSnapshot(revision modelgorm.ConfigRevisionGorm) (*ConfigSnapshot, error) {
+	state, err := revision.State()
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := &ConfigSnapshot{
+		Version:    revision.ID,
+		CreatedAt:  revision.CreatedAt,
+		Allowances: make(map[string]float64, len(state.Allowances)),
+		Schedules:  make(map[int][]model.TaxRate, len(state.Schedules)),
+	}
+	for _, allowance := range state.Allowances {
+		snapshot.Allowances[allowance.AllowanceType] = allowance.Amount
+	}
+	for _, spec := range modelgorm.AllowanceSpecs {
+		if _, ok := snapshot.Allowances[spec.AllowanceType]; !ok {
+			return nil, fmt.Errorf("configuration version %d is missing %s", revision.ID, spec.AllowanceType)
+		}
+	}
+
+	for _, schedule := range state.Schedules {
+		brackets := make([]modelgorm.TaxBracketGorm, len(schedule.Brackets))
+		for i, bracket := range schedule.Brackets {
+			brackets[i] = modelgorm.TaxBracketGorm{MinIncome: bracket.Min, MaxIncome: bracket.Max, Rate: bracket.Rate}
+		}
+		snapshot.Schedules[schedule.TaxYear] = toTaxRates(brackets)
+	}
+
+	return snapshot, nil
+}
+
+func (s *ConfigSnapshot) Rates(taxYear int) ([]model.TaxRate, error) {
+	rates, ok := s.Schedules[taxYear]
+	if !ok {
+		return nil, fmt.Errorf("%w: no active schedule for %d", ErrScheduleNotFound, taxYear)
+	}
+	return rates, nil
+}
+
+// ConfigCache holds the current ConfigSnapshot in process so calculations do
+// not query the database. It is refreshed after local admin writes, when
+// another replica announces a new version, and periodically as a fallback.
+// When File is set, each loaded version is also written there, so the
+// calculator can start from it while the database is down.
+type ConfigCache struct {
+	Repo    TaxRepositories
+	File    string
+	current atomic.Pointer[ConfigSnapshot]
+	mu      sync.Mutex
+}
+
+// snapshotFile is the layout of ConfigCache.File: the configuration
+// revision the snapshot was built from.
+type snapshotFile struct {
+	Version   int64           `json:"version"`
+	Reason    string          `json:"reason"`
+	CreatedAt time.Time       `json:"createdAt"`
+	State     json.RawMessage `json:"state"`
+}
+
+func NewConfigCache(repo TaxRepositories) *ConfigCache {
+	return &ConfigCache{Repo: repo}
+}
+
+func (c *ConfigCache) Snapshot(ctx context.Context) (*ConfigSnapshot, error) {
+	if snapshot := c.current.Load(); snapshot != nil {
+		return snapshot, nil
+	}
+	return c.Refresh(ctx)
+}
+
+func (c *ConfigCache) Refresh(ctx context.Context) (*ConfigSnapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	revision, err := c.Repo.LatestConfigRevision(ctx)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("no configuration revision has been recorded")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to retrieve configuration: %w", err)
+	}
+
+	current := c.current.Load()
+	if current != nil && current.Version >= revision.ID {
+		return current, nil
+	}
+
+	snapshot, err := newConfigSnapshot(revision)
+	if err != nil {
+		return nil, err
+	}
+	c.current.Store(snapshot)
+	log.Printf("Loaded configuration version %d", snapshot.Version)
+	if c.File != "" {
+		if err := writeSnapshotFile(c.File, revision); err != nil {
+			log.Printf("Failed to save configuration snapshot: %v", err)
+		}
+	}
+	return snapshot, nil
+}
+
+// LoadFile loads the snapshot saved in File, unless the cache already holds
+// that version or newer.
+func (c *ConfigCache) LoadFile() (*ConfigSnapshot, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, err := os.ReadFile(c.File)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read configuration snapshot: %w", err)
+	}
+	var saved snapshotFile
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("failed to parse configuration snapshot: %w", err)
+	}
+
+	current := c.current.Load()
+	if current != nil && current.Version >= saved.Version {
+		return current, nil
+	}
+	snapshot, err := newConfigSnapshot(modelgorm.ConfigRevisionGorm{
+		ID:        saved.Version,
+		Reason:    saved.Reason,
+		Snapshot:  string(saved.State),
+		CreatedAt: saved.CreatedAt,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.current.Store(snapshot)
+	log.Printf("Loaded configuration version %d from %s", snapshot.Version, c.File)
+	return snapshot, nil
+}
+
+// writeSnapshotFile replaces path so a crash never leaves half a snapshot.
+func writeSnapshotFile(path string, revision modelgorm.ConfigRevisionGorm) error {
+	data, err := json.MarshalIndent(snapshotFile{
+		Version:   revision.ID,
+		Reason:    revision.Reason,
+		CreatedAt: revision.CreatedAt,
+		State:     json.RawMessage(revision.Snapshot),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-snapshot-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Invalidate refreshes the cache unless it already holds version or newer.
+func (c *ConfigCache) Invalidate(version int64) {
+	if current := c.current.Load(); current != nil && current.Version >= version {
+		return
+	}
+	if _, err := c.Refresh(context.Background()); err != nil {
+		log.Println("Failed to refresh configuration: ", err)
+	}
+}
+
+func (c *ConfigCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := c.Refresh(ctx); err != nil {
+				log.Println("Failed to refresh configuration: ", err)
+			}
+		}
+	}
+}
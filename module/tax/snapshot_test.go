@@ -0,0 +1,144 @@
+package tax
+
+import (
+	"context"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestConfigCache_SnapshotIsCached(t *testing.T) {
+	mockRepo := new(MockRepo)
+	cache := NewConfigCache(mockRepo)
+
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil).Once()
+
+	first, err := cache.Snapshot(context.Background())
+	assert.NoError(t, err)
+	second, err := cache.Snapshot(context.Background())
+	assert.NoError(t, err)
+
+	assert.Same(t, first, second)
+	assert.Equal(t, 60000.0, first.Allowances[modelgorm.PersonalDefault])
+	assert.Len(t, first.Schedules[2567], 5)
+	assert.Equal(t, "2,000,001 ขึ้นไป", first.Schedules[2567][4].Level)
+	mockRepo.AssertNumberOfCalls(t, "LatestConfigRevision", 1)
+}
+
+func TestConfigCache_Invalidate(t *testing.T) {
+	mockRepo := new(MockRepo)
+	cache := NewConfigCache(mockRepo)
+
+	updated := defaultAllowanceConfig()
+	updated[0].Amount = 70000
+
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil).Once()
+	mockRepo.On("LatestConfigRevision").Return(configRevision(2, updated, defaultSchedule()), nil).Once()
+
+	_, err := cache.Snapshot(context.Background())
+	assert.NoError(t, err)
+
+	cache.Invalidate(1)
+	mockRepo.AssertNumberOfCalls(t, "LatestConfigRevision", 1)
+
+	cache.Invalidate(2)
+	snapshot, err := cache.Snapshot(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), snapshot.Version)
+	assert.Equal(t, 70000.0, snapshot.Allowances[modelgorm.PersonalDefault])
+}
+
+func TestConfigCache_RefreshKeepsNewerSnapshot(t *testing.T) {
+	mockRepo := new(MockRepo)
+	cache := NewConfigCache(mockRepo)
+
+	mockRepo.On("LatestConfigRevision").Return(configRevision(3, defaultAllowanceConfig(), defaultSchedule()), nil).Once()
+	mockRepo.On("LatestConfigRevision").Return(configRevision(2, defaultAllowanceConfig(), defaultSchedule()), nil).Once()
+
+	first, err := cache.Refresh(context.Background())
+	assert.NoError(t, err)
+	second, err := cache.Refresh(context.Background())
+	assert.NoError(t, err)
+
+	assert.Same(t, first, second)
+	assert.Equal(t, int64(3), second.Version)
+}
+
+func TestConfigCache_RefreshErrors(t *testing.T) {
+	t.Run("No Revision", func(t *testing.T) {
+		mockRepo := new(MockRepo)
+		mockRepo.On("LatestConfigRevision").Return(modelgorm.ConfigRevisionGorm{}, gorm.ErrRecordNotFound)
+
+		_, err := NewConfigCache(mockRepo).Snapshot(context.Background())
+		assert.ErrorContains(t, err, "no configuration revision")
+	})
+
+	t.Run("Missing Allowance", func(t *testing.T) {
+		mockRepo := new(MockRepo)
+		mockRepo.On("LatestConfigRevision").Return(configRevision(4, defaultAllowanceConfig()[1:], defaultSchedule()), nil)
+
+		_, err := NewConfigCache(mockRepo).Snapshot(context.Background())
+		assert.ErrorContains(t, err, "configuration version 4 is missing PersonalDefault")
+	})
+}
+
+func TestConfigCache_Run(t *testing.T) {
+	mockRepo := new(MockRepo)
+	cache := NewConfigCache(mockRepo)
+
+	mockRepo.On("LatestConfigRevision").Return(defaultRevision(), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cache.Run(ctx, time.Millisecond)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return cache.current.Load() != nil }, time.Second, time.Millisecond)
+	cancel()
+	<-done
+}
+
+func TestConfigCache_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config-snapshot.json")
+	updated := defaultAllowanceConfig()
+	updated[0].Amount = 70000
+
+	mockRepo := new(MockRepo)
+	mockRepo.On("LatestConfigRevision").Return(configRevision(4, updated, defaultSchedule()), nil).Once()
+	cache := NewConfigCache(mockRepo)
+	cache.File = path
+	_, err := cache.Refresh(context.Background())
+	assert.NoError(t, err)
+
+	// A new process whose database is down starts from the saved snapshot.
+	restarted := NewConfigCache(new(MockRepo))
+	restarted.File = path
+	snapshot, err := restarted.LoadFile()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), snapshot.Version)
+	assert.Equal(t, 70000.0, snapshot.Allowances[modelgorm.PersonalDefault])
+	assert.Len(t, snapshot.Schedules[2567], 5)
+
+	cached, err := restarted.Snapshot(context.Background())
+	assert.NoError(t, err)
+	assert.Same(t, snapshot, cached)
+}
+
+func TestConfigCache_LoadFileErrors(t *testing.T) {
+	dir := t.TempDir()
+	corrupt := filepath.Join(dir, "corrupt.json")
+	assert.NoError(t, os.WriteFile(corrupt, []byte("{"), 0o600))
+
+	for _, path := range []string{filepath.Join(dir, "missing.json"), corrupt} {
+		cache := NewConfigCache(new(MockRepo))
+		cache.File = path
+		_, err := cache.LoadFile()
+		assert.Error(t, err, path)
+	}
+}
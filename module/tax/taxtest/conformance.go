@@ -0,0 +1,341 @@
+// Package taxtest holds the behaviour every tax.TaxRepositories
+// implementation must share.
+package taxtest
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pphee/assessment-tax/module/tax"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// RunConformance runs the suite against repositories from newRepo. Each call
+// to newRepo must return a repository holding only the seeded defaults.
+func RunConformance(t *testing.T, newRepo func(t *testing.T) tax.TaxRepositories) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repo tax.TaxRepositories)
+	}{
+		{"SeededDefaults", testSeededDefaults},
+		{"UpdateAllowances", testUpdateAllowances},
+		{"UpdateAllowancesUnknown", testUpdateAllowancesUnknown},
+		{"UpdateAllowancesVersion", testUpdateAllowancesVersion},
+		{"UpdateAllowancesBounds", testUpdateAllowancesBounds},
+		{"ConcurrentUpdates", testConcurrentUpdates},
+		{"SchedulesNotFound", testSchedulesNotFound},
+		{"CreateAndListSchedules", testCreateAndListSchedules},
+		{"ActivateSchedule", testActivateSchedule},
+		{"ActivateScheduleNotProposed", testActivateScheduleNotProposed},
+		{"ConfigRevisions", testConfigRevisions},
+		{"CancelledContext", testCancelledContext},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newRepo(t))
+		})
+	}
+}
+
+func allowanceAmounts(t *testing.T, repo tax.TaxRepositories) map[string]float64 {
+	allowances, err := repo.GetAllowanceConfig(context.Background())
+	require.NoError(t, err)
+	amounts := map[string]float64{}
+	for _, allowance := range allowances {
+		amounts[allowance.AllowanceType] = allowance.Amount
+	}
+	return amounts
+}
+
+func latestState(t *testing.T, repo tax.TaxRepositories) (modelgorm.ConfigRevisionGorm, modelgorm.ConfigState) {
+	revision, err := repo.LatestConfigRevision(context.Background())
+	require.NoError(t, err)
+	state, err := revision.State()
+	require.NoError(t, err)
+	return revision, state
+}
+
+func proposal(taxYear int, rates ...float64) modelgorm.TaxScheduleGorm {
+	schedule := modelgorm.TaxScheduleGorm{TaxYear: taxYear, Status: modelgorm.ScheduleProposed, CreatedAt: time.Now()}
+	min := 0.0
+	for i, rate := range rates {
+		bracket := modelgorm.TaxBracketGorm{Position: i, MinIncome: min, Rate: rate}
+		if i < len(rates)-1 {
+			max := min + 500000
+			bracket.MaxIncome = &max
+			min = max
+		}
+		schedule.Brackets = append(schedule.Brackets, bracket)
+	}
+	return schedule
+}
+
+func testSeededDefaults(t *testing.T, repo tax.TaxRepositories) {
+	amounts := allowanceAmounts(t, repo)
+	require.Len(t, amounts, len(modelgorm.AllowanceSpecs))
+	for _, spec := range modelgorm.AllowanceSpecs {
+		assert.Equal(t, spec.Default, amounts[spec.AllowanceType], spec.AllowanceType)
+	}
+
+	schedule, err := repo.GetActiveSchedule(context.Background(), modelgorm.DefaultTaxYear)
+	require.NoError(t, err)
+	assert.Equal(t, modelgorm.ScheduleActive, schedule.Status)
+	require.Len(t, schedule.Brackets, 5)
+	for i, bracket := range schedule.Brackets {
+		assert.Equal(t, i, bracket.Position)
+	}
+	assert.Nil(t, schedule.Brackets[4].MaxIncome)
+
+	_, state := latestState(t, repo)
+	assert.Len(t, state.Allowances, len(modelgorm.AllowanceSpecs))
+	require.Len(t, state.Schedules, 1)
+	assert.Equal(t, schedule.ID, state.Schedules[0].ScheduleID)
+}
+
+func testUpdateAllowances(t *testing.T, repo tax.TaxRepositories) {
+	before, _ := latestState(t, repo)
+
+	err := repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{
+		{AllowanceType: modelgorm.PersonalDefault, Amount: 70000},
+		{AllowanceType: modelgorm.KReceiptMax, Amount: 80000},
+	})
+	require.NoError(t, err)
+
+	amounts := allowanceAmounts(t, repo)
+	assert.Equal(t, 70000.0, amounts[modelgorm.PersonalDefault])
+	assert.Equal(t, 80000.0, amounts[modelgorm.KReceiptMax])
+	assert.Equal(t, 100000.0, amounts[modelgorm.DonationMax])
+
+	after, state := latestState(t, repo)
+	assert.Greater(t, after.ID, before.ID)
+	assert.Contains(t, state.Allowances, modelgorm.Allowance{AllowanceType: modelgorm.PersonalDefault, Amount: 70000})
+}
+
+func testUpdateAllowancesUnknown(t *testing.T, repo tax.TaxRepositories) {
+	before, _ := latestState(t, repo)
+
+	err := repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{
+		{AllowanceType: modelgorm.PersonalDefault, Amount: 70000},
+		{AllowanceType: "Unknown", Amount: 1},
+	})
+	assert.Error(t, err)
+
+	assert.Equal(t, 60000.0, allowanceAmounts(t, repo)[modelgorm.PersonalDefault], "a failed update must change nothing")
+	after, _ := latestState(t, repo)
+	assert.Equal(t, before.ID, after.ID)
+}
+
+func testUpdateAllowancesVersion(t *testing.T, repo tax.TaxRepositories) {
+	versions := func() map[string]int64 {
+		allowances, err := repo.GetAllowanceConfig(context.Background())
+		require.NoError(t, err)
+		versions := map[string]int64{}
+		for _, allowance := range allowances {
+			versions[allowance.AllowanceType] = allowance.Version
+		}
+		return versions
+	}
+	before := versions()
+	assert.Equal(t, int64(1), before[modelgorm.PersonalDefault])
+
+	err := repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{
+		{AllowanceType: modelgorm.PersonalDefault, Amount: 70000, Version: 1},
+	})
+	require.NoError(t, err)
+	after := versions()
+	assert.Equal(t, int64(2), after[modelgorm.PersonalDefault])
+	assert.Equal(t, before[modelgorm.KReceiptMax], after[modelgorm.KReceiptMax])
+
+	latest, _ := latestState(t, repo)
+	err = repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{
+		{AllowanceType: modelgorm.KReceiptMax, Amount: 80000, Version: 1},
+		{AllowanceType: modelgorm.PersonalDefault, Amount: 80000, Version: 1},
+	})
+	assert.ErrorIs(t, err, tax.ErrVersionConflict)
+	amounts := allowanceAmounts(t, repo)
+	assert.Equal(t, 70000.0, amounts[modelgorm.PersonalDefault], "a conflicting update must change nothing")
+	assert.Equal(t, 100000.0, amounts[modelgorm.KReceiptMax], "a conflicting update must change nothing")
+	assert.Equal(t, after, versions())
+	unchanged, _ := latestState(t, repo)
+	assert.Equal(t, latest.ID, unchanged.ID)
+}
+
+// testUpdateAllowancesBounds lowers PersonalMax below the default personal
+// allowance, alone and together with the default.
+func testUpdateAllowancesBounds(t *testing.T, repo tax.TaxRepositories) {
+	before, _ := latestState(t, repo)
+
+	err := repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{
+		{AllowanceType: modelgorm.PersonalMax, Amount: 50000},
+	})
+	assert.ErrorIs(t, err, tax.ErrAllowanceOutOfRange)
+	assert.Equal(t, 100000.0, allowanceAmounts(t, repo)[modelgorm.PersonalMax], "a rejected update must change nothing")
+	unchanged, _ := latestState(t, repo)
+	assert.Equal(t, before.ID, unchanged.ID)
+
+	err = repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{
+		{AllowanceType: modelgorm.PersonalDefault, Amount: 40000},
+		{AllowanceType: modelgorm.PersonalMax, Amount: 50000},
+	})
+	require.NoError(t, err)
+
+	err = repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{
+		{AllowanceType: modelgorm.PersonalDefault, Amount: 60000},
+	})
+	assert.ErrorIs(t, err, tax.ErrAllowanceOutOfRange)
+	amounts := allowanceAmounts(t, repo)
+	assert.Equal(t, 40000.0, amounts[modelgorm.PersonalDefault])
+	assert.Equal(t, 50000.0, amounts[modelgorm.PersonalMax])
+}
+
+// testConcurrentUpdates writes two settings at the same time, many times
+// over, and expects the latest revision to hold both writes each time.
+func testConcurrentUpdates(t *testing.T, repo tax.TaxRepositories) {
+	keys := []string{modelgorm.DonationMax, modelgorm.KReceiptMax}
+	for round := 1; round <= 20; round++ {
+		amount := float64(50000 + round)
+		var wg sync.WaitGroup
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			wg.Add(1)
+			go func(i int, key string) {
+				defer wg.Done()
+				errs[i] = repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{{AllowanceType: key, Amount: amount}})
+			}(i, key)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+
+		_, state := latestState(t, repo)
+		for _, key := range keys {
+			assert.Contains(t, state.Allowances, modelgorm.Allowance{AllowanceType: key, Amount: amount}, "round %d", round)
+		}
+	}
+}
+
+func testSchedulesNotFound(t *testing.T, repo tax.TaxRepositories) {
+	_, err := repo.GetActiveSchedule(context.Background(), 2500)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	_, err = repo.GetSchedule(context.Background(), 9999)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	_, err = repo.ActivateSchedule(context.Background(), 9999)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	schedules, err := repo.ListSchedules(context.Background(), 2500)
+	assert.NoError(t, err)
+	assert.Empty(t, schedules)
+}
+
+func testCreateAndListSchedules(t *testing.T, repo tax.TaxRepositories) {
+	first := proposal(2568, 0, 0.1, 0.2)
+	require.NoError(t, repo.CreateSchedule(context.Background(), &first))
+	second := proposal(2568, 0, 0.15)
+	require.NoError(t, repo.CreateSchedule(context.Background(), &second))
+
+	assert.NotZero(t, first.ID)
+	assert.Greater(t, second.ID, first.ID)
+	for _, bracket := range first.Brackets {
+		assert.NotZero(t, bracket.ID)
+		assert.Equal(t, first.ID, bracket.ScheduleID)
+	}
+
+	stored, err := repo.GetSchedule(context.Background(), first.ID)
+	require.NoError(t, err)
+	assert.Equal(t, modelgorm.ScheduleProposed, stored.Status)
+	require.Len(t, stored.Brackets, 3)
+	assert.Equal(t, 0.2, stored.Brackets[2].Rate)
+
+	schedules, err := repo.ListSchedules(context.Background(), 2568)
+	require.NoError(t, err)
+	require.Len(t, schedules, 2)
+	assert.Equal(t, first.ID, schedules[0].ID)
+	assert.Len(t, schedules[1].Brackets, 2)
+
+	_, err = repo.GetActiveSchedule(context.Background(), 2568)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func testActivateSchedule(t *testing.T, repo tax.TaxRepositories) {
+	seeded, err := repo.GetActiveSchedule(context.Background(), modelgorm.DefaultTaxYear)
+	require.NoError(t, err)
+	before, _ := latestState(t, repo)
+
+	next := proposal(modelgorm.DefaultTaxYear, 0, 0.1)
+	require.NoError(t, repo.CreateSchedule(context.Background(), &next))
+
+	activated, err := repo.ActivateSchedule(context.Background(), next.ID)
+	require.NoError(t, err)
+	assert.Equal(t, modelgorm.ScheduleActive, activated.Status)
+	assert.NotNil(t, activated.ActivatedAt)
+	assert.Len(t, activated.Brackets, 2)
+
+	retired, err := repo.GetSchedule(context.Background(), seeded.ID)
+	require.NoError(t, err)
+	assert.Equal(t, modelgorm.ScheduleRetired, retired.Status)
+
+	active, err := repo.GetActiveSchedule(context.Background(), modelgorm.DefaultTaxYear)
+	require.NoError(t, err)
+	assert.Equal(t, next.ID, active.ID)
+
+	after, state := latestState(t, repo)
+	assert.Greater(t, after.ID, before.ID)
+	require.Len(t, state.Schedules, 1)
+	assert.Equal(t, next.ID, state.Schedules[0].ScheduleID)
+	assert.Len(t, state.Schedules[0].Brackets, 2)
+}
+
+func testActivateScheduleNotProposed(t *testing.T, repo tax.TaxRepositories) {
+	seeded, err := repo.GetActiveSchedule(context.Background(), modelgorm.DefaultTaxYear)
+	require.NoError(t, err)
+
+	_, err = repo.ActivateSchedule(context.Background(), seeded.ID)
+
+	assert.ErrorIs(t, err, tax.ErrScheduleNotProposed)
+}
+
+func testConfigRevisions(t *testing.T, repo tax.TaxRepositories) {
+	initial, _ := latestState(t, repo)
+	require.NoError(t, repo.UpdateAllowances(context.Background(), []modelgorm.Allowance{{AllowanceType: modelgorm.DonationMax, Amount: 50000}}))
+	updated, _ := latestState(t, repo)
+
+	got, err := repo.GetConfigRevision(context.Background(), initial.ID)
+	require.NoError(t, err)
+	state, err := got.State()
+	require.NoError(t, err)
+	assert.Contains(t, state.Allowances, modelgorm.Allowance{AllowanceType: modelgorm.DonationMax, Amount: 100000})
+
+	_, err = repo.GetConfigRevision(context.Background(), updated.ID+100)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+
+	at, err := repo.ConfigRevisionAt(context.Background(), updated.CreatedAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, updated.ID, at.ID)
+
+	at, err = repo.ConfigRevisionAt(context.Background(), initial.CreatedAt)
+	require.NoError(t, err)
+	assert.Equal(t, initial.ID, at.ID)
+
+	_, err = repo.ConfigRevisionAt(context.Background(), initial.CreatedAt.Add(-time.Hour))
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+}
+
+func testCancelledContext(t *testing.T, repo tax.TaxRepositories) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := repo.GetAllowanceConfig(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+
+	err = repo.UpdateAllowances(ctx, []modelgorm.Allowance{{AllowanceType: modelgorm.PersonalDefault, Amount: 70000}})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 60000.0, allowanceAmounts(t, repo)[modelgorm.PersonalDefault])
+}
@@ -0,0 +1,29 @@
+package tax
+
+import (
+	"context"
+	"time"
+)
+
+// Timeouts bound each kind of operation, including the queries it makes. A
+// zero value leaves the operation to the deadline of its caller's context.
+type Timeouts struct {
+	Calculation time.Duration
+	Batch       time.Duration
+	Read        time.Duration
+	Write       time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Calculation: 5 * time.Second,
+	Batch:       30 * time.Second,
+	Read:        5 * time.Second,
+	Write:       10 * time.Second,
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
@@ -0,0 +1,82 @@
+package tax
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"net/http"
+)
+
+// PostTaxCalculationV2 calculates like PostTaxCalculation and answers with
+// the v2 envelope, holding one result.
+func (h *TaxHandler) PostTaxCalculationV2(c echo.Context) error {
+	return h.calculate(c, func(res model.TaxResponse) error {
+		loc := i18n.From(c)
+		return c.JSON(http.StatusOK, model.TaxCalculationsV2{
+			ConfigVersion: res.ConfigVersion,
+			Results:       []model.TaxCalculationV2{CalculationV2(loc, res.NationalID, res.Breakdown)},
+			Warnings:      WarningsV2(loc, res.Warnings),
+		})
+	})
+}
+
+// TaxCalculationsCSVV2 calculates like TaxCalculationsCSVHandler and answers
+// with the v2 envelope, holding a result per row.
+func (h *TaxHandler) TaxCalculationsCSVV2(c echo.Context) error {
+	return h.calculateFile(c, func(res model.TaxResponseCSV) error {
+		loc := i18n.From(c)
+		results := make([]model.TaxCalculationV2, len(res.Taxes))
+		for i, detail := range res.Taxes {
+			results[i] = CalculationV2(loc, detail.NationalID, detail.Breakdown)
+		}
+		return c.JSON(http.StatusOK, model.TaxCalculationsV2{
+			ConfigVersion: res.ConfigVersion,
+			Results:       results,
+			Warnings:      WarningsV2(loc, res.Warnings),
+		})
+	})
+}
+
+// calculationV2 writes a breakdown as a v2 result, labelled in the
+// caller's language.
+func CalculationV2(loc *i18n.Localizer, nationalID string, breakdown model.TaxBreakdown) model.TaxCalculationV2 {
+	deductions := make([]model.DeductionV2, len(breakdown.Deductions))
+	for i, d := range breakdown.Deductions {
+		deductions[i] = model.DeductionV2{Type: d.Type, Amount: model.Money(d.Amount)}
+	}
+	brackets := make([]model.TaxBracketV2, len(breakdown.Brackets))
+	for i, b := range breakdown.Brackets {
+		brackets[i] = model.TaxBracketV2{Level: loc.TaxLevel(b.Rate), Tax: model.Money(b.Tax)}
+	}
+
+	tax, refund := breakdown.Net()
+	return model.TaxCalculationV2{
+		NationalID:    nationalID,
+		TotalIncome:   model.Money(breakdown.TotalIncome),
+		Deductions:    deductions,
+		TaxableIncome: model.Money(breakdown.TaxableIncome),
+		Brackets:      brackets,
+		GrossTax:      model.Money(breakdown.GrossTax),
+		WHT:           model.Money(breakdown.WHT),
+		Tax:           model.Money(tax),
+		Refund:        model.Money(refund),
+		Warnings:      WarningsV2(loc, breakdown.Warnings),
+	}
+}
+
+// warningsV2 explains warnings in the caller's language. It never returns
+// nil, so that an envelope without warnings has an empty list.
+func WarningsV2(loc *i18n.Localizer, warnings []model.Warning) []model.WarningV2 {
+	shown := make([]model.WarningV2, len(warnings))
+	for i, w := range warnings {
+		shown[i] = model.WarningV2{Code: w.Code, Field: w.Field, Detail: loc.Text(warningMessage(w))}
+	}
+	return shown
+}
+
+func warningMessage(w model.Warning) i18n.Message {
+	if w.Code == WarningAllowanceCapped {
+		return i18n.Msg("warning.allowance_capped", i18n.AllowanceName(w.Allowance), i18n.Amount(w.Limit))
+	}
+	return i18n.Msg("warning." + w.Code)
+}
@@ -0,0 +1,143 @@
+package tax
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTaxHandler_PostTaxCalculationV2(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v2/tax/calculations", strings.NewReader(`{"totalIncome": 500000, "wht": 25000, "allowances": [{"allowanceType": "donation", "amount": 200000}]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("CalculateTax", mock.Anything).Return(model.TaxResponse{
+		NationalID:    "1101700203450",
+		Tax:           -6000,
+		TaxLevels:     []model.TaxBracket{{Level: "0-150,000", Rate: utils.DefaultTaxRates[0]}, {Level: "150,001-500,000", Rate: utils.DefaultTaxRates[1]}},
+		ConfigVersion: 4,
+		Breakdown: model.TaxBreakdown{
+			TotalIncome:   500000,
+			Deductions:    []model.Deduction{{Type: "personal", Amount: 60000}, {Type: "donation", Amount: 100000}},
+			TaxableIncome: 340000,
+			Brackets: []model.TaxBracket{
+				{Level: "0-150,000", Rate: utils.DefaultTaxRates[0]},
+				{Level: "150,001-500,000", Tax: 19000, Rate: utils.DefaultTaxRates[1]},
+			},
+			GrossTax: 19000,
+			WHT:      25000,
+			Warnings: []model.Warning{{Code: WarningAllowanceCapped, Field: "allowances[0].amount", Allowance: "donation", Limit: 100000}},
+		},
+	}, nil)
+
+	h := &TaxHandler{TaxService: mockTaxService}
+	require.NoError(t, h.PostTaxCalculationV2(c))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{
+		"configVersion": 4,
+		"results": [{
+			"nationalId": "*********3450",
+			"totalIncome": "500000.00",
+			"deductions": [{"type": "personal", "amount": "60000.00"}, {"type": "donation", "amount": "100000.00"}],
+			"taxableIncome": "340000.00",
+			"brackets": [{"level": "0-150,000", "tax": "0.00"}, {"level": "150,001-500,000", "tax": "19000.00"}],
+			"grossTax": "19000.00",
+			"wht": "25000.00",
+			"tax": "0.00",
+			"refund": "6000.00",
+			"warnings": [{"code": "allowance_capped", "field": "allowances[0].amount", "detail": "Donation was capped at 100,000"}]
+		}],
+		"warnings": []
+	}`, rec.Body.String())
+}
+
+func TestTaxHandler_PostTaxCalculationV2_Localized(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v2/tax/calculations?lang=th", strings.NewReader(`{"totalIncome": 3000000}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("CalculateTax", mock.Anything).Return(model.TaxResponse{
+		Breakdown: model.TaxBreakdown{Brackets: []model.TaxBracket{{Level: "2,000,001 ขึ้นไป", Tax: 329000, Rate: utils.DefaultTaxRates[4]}}, GrossTax: 329000},
+		Warnings:  []model.Warning{{Code: WarningNotRecorded}},
+	}, nil)
+
+	h := &TaxHandler{TaxService: mockTaxService}
+	require.NoError(t, h.PostTaxCalculationV2(c))
+
+	var body model.TaxCalculationsV2
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	require.Len(t, body.Results, 1)
+	assert.Equal(t, "2,000,001 ขึ้นไป", body.Results[0].Brackets[0].Level)
+	assert.Equal(t, model.Money(329000), body.Results[0].Tax)
+	assert.Equal(t, []model.WarningV2{{Code: "not_recorded", Detail: "ฐานข้อมูลไม่พร้อมใช้งาน การคำนวณนี้จึงไม่ได้บันทึกไว้ในประวัติ"}}, body.Warnings)
+}
+
+func TestTaxHandler_PostTaxCalculationV2_Invalid(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/v2/tax/calculations", strings.NewReader(`{"totalIncome": -1}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	mockTaxService := new(MockTaxService)
+	h := &TaxHandler{TaxService: mockTaxService}
+	require.NoError(t, h.PostTaxCalculationV2(c))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"code":"invalid_request"`)
+	mockTaxService.AssertNotCalled(t, "CalculateTax", mock.Anything)
+}
+
+func TestTaxHandler_TaxCalculationsCSVV2(t *testing.T) {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("taxes", "taxes.csv")
+	require.NoError(t, err)
+	_, _ = part.Write([]byte("totalIncome,wht,donation\n500000,0,0\n100000,1000.125,0"))
+	require.NoError(t, writer.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/v2/tax/calculations/upload-csv", body)
+	req.Header.Set(echo.HeaderContentType, writer.FormDataContentType())
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+
+	records := []model.TotalIncomeCsv{{TotalIncome: 500000}, {TotalIncome: 100000, WHT: 1000.125}}
+	mockTaxService := new(MockTaxService)
+	mockTaxService.On("TaxFromFile", mock.Anything).Return(records, nil)
+	mockTaxService.On("CalculateBatch", records).Return(model.TaxResponseCSV{
+		Taxes: []model.TaxDetail{
+			{TotalIncome: 500000, Tax: 29000, Breakdown: model.TaxBreakdown{TotalIncome: 500000, TaxableIncome: 440000, GrossTax: 29000}},
+			{TotalIncome: 100000, TaxRefund: 1000.125, Breakdown: model.TaxBreakdown{TotalIncome: 100000, WHT: 1000.125}},
+		},
+		ConfigVersion: 4,
+	}, nil)
+
+	h := &TaxHandler{TaxService: mockTaxService}
+	require.NoError(t, h.TaxCalculationsCSVV2(c))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var got model.TaxCalculationsV2
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, int64(4), got.ConfigVersion)
+	require.Len(t, got.Results, 2)
+	assert.Equal(t, model.Money(29000), got.Results[0].Tax)
+	assert.Equal(t, model.Money(440000), got.Results[0].TaxableIncome)
+	assert.Contains(t, rec.Body.String(), `"refund":"1000.13"`)
+	assert.Contains(t, rec.Body.String(), `"tax":"0.00"`)
+	assert.NotContains(t, rec.Body.String(), "-0.00")
+	assert.Equal(t, []model.WarningV2{}, got.Results[1].Warnings)
+}
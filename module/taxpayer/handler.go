@@ -0,0 +1,262 @@
+package taxpayer
+
+import (
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/problem"
+	"github.com/pphee/assessment-tax/module/tax"
+	"net/http"
+	"strconv"
+)
+
+type TaxpayerHandler struct {
+	TaxpayerService TaxpayerServices
+}
+
+func NewTaxpayerHandler(service TaxpayerServices) *TaxpayerHandler {
+	return &TaxpayerHandler{TaxpayerService: service}
+}
+
+// RequireOwner rejects anonymous callers; profiles belong to the API key or
+// user that created them.
+func RequireOwner(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, ok := auth.PrincipalFrom(c); !ok {
+			return problem.RespondMessage(c, http.StatusUnauthorized, "unauthenticated", "", i18n.Msg("problem.owner_required"))
+		}
+		return next(c)
+	}
+}
+
+func owner(c echo.Context) string {
+	principal, _ := auth.PrincipalFrom(c)
+	return principal.Username
+}
+
+func idParam(c echo.Context, name string) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param(name), 10, 64)
+	return uint(id), err == nil
+}
+
+func (h *TaxpayerHandler) CreateTaxpayer(c echo.Context) error {
+	var req model.TaxpayerRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	taxpayer, err := h.TaxpayerService.CreateTaxpayer(c.Request().Context(), owner(c), req)
+	if err != nil {
+		return taxpayerError(c, "Failed to create taxpayer", err)
+	}
+
+	return c.JSON(http.StatusCreated, maskTaxpayer(c, taxpayer))
+}
+
+func (h *TaxpayerHandler) GetTaxpayer(c echo.Context) error {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "id", "Invalid taxpayer id")
+	}
+
+	taxpayer, err := h.TaxpayerService.GetTaxpayer(c.Request().Context(), owner(c), id)
+	if err != nil {
+		return taxpayerError(c, "Failed to get taxpayer", err)
+	}
+
+	return c.JSON(http.StatusOK, maskTaxpayer(c, taxpayer))
+}
+
+func (h *TaxpayerHandler) FindTaxpayer(c echo.Context) error {
+	var req model.TaxpayerLookup
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	taxpayer, err := h.TaxpayerService.FindTaxpayer(c.Request().Context(), owner(c), req.NationalID)
+	if err != nil {
+		return taxpayerError(c, "Failed to find taxpayer", err)
+	}
+
+	return c.JSON(http.StatusOK, maskTaxpayer(c, taxpayer))
+}
+
+func (h *TaxpayerHandler) UpdateTaxpayer(c echo.Context) error {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "id", "Invalid taxpayer id")
+	}
+	var req model.TaxpayerRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	taxpayer, err := h.TaxpayerService.UpdateTaxpayer(c.Request().Context(), owner(c), id, req)
+	if err != nil {
+		return taxpayerError(c, "Failed to update taxpayer", err)
+	}
+
+	return c.JSON(http.StatusOK, maskTaxpayer(c, taxpayer))
+}
+
+func (h *TaxpayerHandler) ListCalculations(c echo.Context) error {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "id", "Invalid taxpayer id")
+	}
+	var limit, offset int
+	var err error
+	if v := c.QueryParam("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "limit", "limit must be a positive integer")
+		}
+	}
+	if v := c.QueryParam("offset"); v != "" {
+		if offset, err = strconv.Atoi(v); err != nil || offset < 0 {
+			return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "offset", "offset must be a non-negative integer")
+		}
+	}
+
+	calculations, err := h.TaxpayerService.ListCalculations(c.Request().Context(), owner(c), id, limit, offset)
+	if err != nil {
+		return taxpayerError(c, "Failed to list calculations", err)
+	}
+	for i := range calculations {
+		if calculations[i], err = maskCalculation(c, calculations[i]); err != nil {
+			return tax.InternalError(c, "Failed to list calculations", err)
+		}
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{"calculations": calculations})
+}
+
+func (h *TaxpayerHandler) GetCalculation(c echo.Context) error {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "id", "Invalid taxpayer id")
+	}
+	calculationID, ok := idParam(c, "calculationId")
+	if !ok {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "calculationId", "Invalid calculation id")
+	}
+
+	calculation, err := h.TaxpayerService.GetCalculation(c.Request().Context(), owner(c), id, calculationID)
+	if err != nil {
+		return taxpayerError(c, "Failed to get calculation", err)
+	}
+	if calculation, err = maskCalculation(c, calculation); err != nil {
+		return tax.InternalError(c, "Failed to get calculation", err)
+	}
+
+	return c.JSON(http.StatusOK, calculation)
+}
+
+func (h *TaxpayerHandler) DeleteCalculation(c echo.Context) error {
+	id, ok := idParam(c, "id")
+	if !ok {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "id", "Invalid taxpayer id")
+	}
+	calculationID, ok := idParam(c, "calculationId")
+	if !ok {
+		return problem.RespondField(c, http.StatusBadRequest, "invalid_parameter", "calculationId", "Invalid calculation id")
+	}
+
+	if err := h.TaxpayerService.DeleteCalculation(c.Request().Context(), owner(c), id, calculationID); err != nil {
+		return taxpayerError(c, "Failed to delete calculation", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+type RecomputeHandler struct {
+	RecomputeService RecomputeServices
+}
+
+func NewRecomputeHandler(service RecomputeServices) *RecomputeHandler {
+	return &RecomputeHandler{RecomputeService: service}
+}
+
+func (h *RecomputeHandler) Recompute(c echo.Context) error {
+	var req model.RecomputeRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	result, err := h.RecomputeService.Recompute(c.Request().Context(), req)
+	if err != nil {
+		return taxpayerError(c, "Failed to recompute calculation", err)
+	}
+	if result.Original, err = maskDocument(c, result.Original); err != nil {
+		return tax.InternalError(c, "Failed to recompute calculation", err)
+	}
+	if result.Recomputed, err = maskDocument(c, result.Recomputed); err != nil {
+		return tax.InternalError(c, "Failed to recompute calculation", err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+type SubjectHandler struct {
+	SubjectService SubjectServices
+}
+
+func NewSubjectHandler(service SubjectServices) *SubjectHandler {
+	return &SubjectHandler{SubjectService: service}
+}
+
+func (h *SubjectHandler) ExportSubject(c echo.Context) error {
+	var req model.SubjectRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	export, err := h.SubjectService.ExportSubject(c.Request().Context(), owner(c), req)
+	if err != nil {
+		return taxpayerError(c, "Failed to export subject data", err)
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	if req.Format == ExportZIP {
+		bundle, err := ZipExport(export)
+		if err != nil {
+			return tax.InternalError(c, "Failed to export subject data", err)
+		}
+		c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="data-subject-export.zip"`)
+		return c.Blob(http.StatusOK, "application/zip", bundle)
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="data-subject-export.json"`)
+	return c.JSON(http.StatusOK, export)
+}
+
+func (h *SubjectHandler) EraseSubject(c echo.Context) error {
+	var req model.SubjectRequest
+	if err := c.Bind(&req); err != nil {
+		return problem.InvalidBody(c, err)
+	}
+
+	erased, err := h.SubjectService.EraseSubject(c.Request().Context(), owner(c), req)
+	if err != nil {
+		return taxpayerError(c, "Failed to erase subject data", err)
+	}
+
+	return c.JSON(http.StatusOK, erased)
+}
+
+func taxpayerError(c echo.Context, message string, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidTaxpayer):
+		return tax.Problem(c, http.StatusBadRequest, "invalid_taxpayer", err)
+	case errors.Is(err, ErrInvalidRecompute):
+		return tax.Problem(c, http.StatusBadRequest, "invalid_recompute", err)
+	case errors.Is(err, ErrCalculationNotFound):
+		return problem.RespondMessage(c, http.StatusNotFound, "calculation_not_found", "", i18n.Msg("problem.calculation_not_found"))
+	case errors.Is(err, tax.ErrConfigNotFound):
+		return tax.Problem(c, http.StatusNotFound, "config_not_found", err)
+	case errors.Is(err, ErrTaxpayerExists):
+		return tax.Problem(c, http.StatusConflict, "taxpayer_exists", err)
+	}
+	// Recomputing runs the calculation again, with the same mistakes to report
+	return tax.CalculationError(c, message, err)
+}
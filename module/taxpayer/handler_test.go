@@ -0,0 +1,249 @@
+package taxpayer
+
+import (
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type MockTaxpayerService struct {
+	mock.Mock
+}
+
+func (m *MockTaxpayerService) CreateTaxpayer(ctx context.Context, owner string, req model.TaxpayerRequest) (model.Taxpayer, error) {
+	args := m.Called(owner, req)
+	return args.Get(0).(model.Taxpayer), args.Error(1)
+}
+
+func (m *MockTaxpayerService) GetTaxpayer(ctx context.Context, owner string, id uint) (model.Taxpayer, error) {
+	args := m.Called(owner, id)
+	return args.Get(0).(model.Taxpayer), args.Error(1)
+}
+
+func (m *MockTaxpayerService) FindTaxpayer(ctx context.Context, owner, nationalID string) (model.Taxpayer, error) {
+	args := m.Called(owner, nationalID)
+	return args.Get(0).(model.Taxpayer), args.Error(1)
+}
+
+func (m *MockTaxpayerService) UpdateTaxpayer(ctx context.Context, owner string, id uint, req model.TaxpayerRequest) (model.Taxpayer, error) {
+	args := m.Called(owner, id, req)
+	return args.Get(0).(model.Taxpayer), args.Error(1)
+}
+
+func (m *MockTaxpayerService) ListCalculations(ctx context.Context, owner string, taxpayerID uint, limit, offset int) ([]model.Calculation, error) {
+	args := m.Called(owner, taxpayerID, limit, offset)
+	return args.Get(0).([]model.Calculation), args.Error(1)
+}
+
+func (m *MockTaxpayerService) GetCalculation(ctx context.Context, owner string, taxpayerID, id uint) (model.Calculation, error) {
+	args := m.Called(owner, taxpayerID, id)
+	return args.Get(0).(model.Calculation), args.Error(1)
+}
+
+func (m *MockTaxpayerService) DeleteCalculation(ctx context.Context, owner string, taxpayerID, id uint) error {
+	args := m.Called(owner, taxpayerID, id)
+	return args.Error(0)
+}
+
+type MockRecomputeService struct {
+	mock.Mock
+}
+
+func (m *MockRecomputeService) Recompute(ctx context.Context, req model.RecomputeRequest) (model.RecomputeResult, error) {
+	args := m.Called(req)
+	return args.Get(0).(model.RecomputeResult), args.Error(1)
+}
+
+func newContext(method, target, body string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	auth.SetPrincipal(c, auth.Principal{Username: "apikey:3"})
+	return c, rec
+}
+
+func TestTaxpayerHandler_CreateTaxpayer(t *testing.T) {
+	c, rec := newContext(http.MethodPost, "/tax/taxpayers", `{"nationalId":"1234567890121","name":"Somchai","dependents":1}`)
+
+	mockService := new(MockTaxpayerService)
+	mockService.On("CreateTaxpayer", "apikey:3", model.TaxpayerRequest{NationalID: "1234567890121", Name: "Somchai", Dependents: 1}).
+		Return(model.Taxpayer{ID: 4, Name: "Somchai"}, nil)
+	h := &TaxpayerHandler{TaxpayerService: mockService}
+
+	if assert.NoError(t, h.CreateTaxpayer(c)) {
+		assert.Equal(t, http.StatusCreated, rec.Code)
+	}
+}
+
+func TestTaxpayerHandler_GetTaxpayer_MasksNationalID(t *testing.T) {
+	tests := []struct {
+		name string
+		role auth.Role
+		want string
+	}{
+		{"API Key", "", "*********0121"},
+		{"Viewer", auth.RoleViewer, "*********0121"},
+		{"Approver", auth.RoleApprover, "1234567890121"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newContext(http.MethodGet, "/", "")
+			auth.SetPrincipal(c, auth.Principal{Username: "apikey:3", Role: tt.role})
+			c.SetParamNames("id")
+			c.SetParamValues("4")
+
+			mockService := new(MockTaxpayerService)
+			mockService.On("GetTaxpayer", "apikey:3", uint(4)).
+				Return(model.Taxpayer{ID: 4, NationalID: "1234567890121", Name: "Somchai"}, nil)
+			h := &TaxpayerHandler{TaxpayerService: mockService}
+
+			if assert.NoError(t, h.GetTaxpayer(c)) {
+				assert.Equal(t, http.StatusOK, rec.Code)
+				assert.Contains(t, rec.Body.String(), `"nationalId":"`+tt.want+`"`)
+			}
+		})
+	}
+}
+
+func TestTaxpayerHandler_GetCalculation_MasksDocuments(t *testing.T) {
+	c, rec := newContext(http.MethodGet, "/", "")
+	c.SetParamNames("id", "calculationId")
+	c.SetParamValues("4", "9")
+
+	mockService := new(MockTaxpayerService)
+	mockService.On("GetCalculation", "apikey:3", uint(4), uint(9)).Return(model.Calculation{
+		ID:     9,
+		Input:  []byte(`{"totalIncome":500000.10,"nationalId":"1234567890121"}`),
+		Output: []byte(`{"taxes":[{"nationalId":"1101700203450","tax":29000}]}`),
+	}, nil)
+	h := &TaxpayerHandler{TaxpayerService: mockService}
+
+	if assert.NoError(t, h.GetCalculation(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		body := rec.Body.String()
+		assert.Contains(t, body, `"totalIncome":500000.10`)
+		assert.Contains(t, body, `"nationalId":"*********0121"`)
+		assert.Contains(t, body, `"nationalId":"*********3450"`)
+		assert.NotContains(t, body, "1101700203450")
+	}
+}
+
+func TestTaxpayerHandler_GetCalculation_NotFound(t *testing.T) {
+	c, rec := newContext(http.MethodGet, "/", "")
+	c.SetParamNames("id", "calculationId")
+	c.SetParamValues("4", "9")
+
+	mockService := new(MockTaxpayerService)
+	mockService.On("GetCalculation", "apikey:3", uint(4), uint(9)).Return(model.Calculation{}, ErrCalculationNotFound)
+	h := &TaxpayerHandler{TaxpayerService: mockService}
+
+	if assert.NoError(t, h.GetCalculation(c)) {
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	}
+}
+
+func TestTaxpayerHandler_ListCalculations(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		err    error
+		want   int
+	}{
+		{"Success", "/?limit=10&offset=20", nil, http.StatusOK},
+		{"Bad Limit", "/?limit=0", nil, http.StatusBadRequest},
+		{"Other Owner", "/?limit=10&offset=20", tax.ErrTaxpayerNotFound, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newContext(http.MethodGet, tt.target, "")
+			c.SetParamNames("id")
+			c.SetParamValues("4")
+
+			mockService := new(MockTaxpayerService)
+			mockService.On("ListCalculations", "apikey:3", uint(4), 10, 20).Return([]model.Calculation{}, tt.err)
+			h := &TaxpayerHandler{TaxpayerService: mockService}
+
+			if assert.NoError(t, h.ListCalculations(c)) {
+				assert.Equal(t, tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestTaxpayerHandler_DeleteCalculation(t *testing.T) {
+	c, rec := newContext(http.MethodDelete, "/", "")
+	c.SetParamNames("id", "calculationId")
+	c.SetParamValues("4", "9")
+
+	mockService := new(MockTaxpayerService)
+	mockService.On("DeleteCalculation", "apikey:3", uint(4), uint(9)).Return(nil)
+	h := &TaxpayerHandler{TaxpayerService: mockService}
+
+	if assert.NoError(t, h.DeleteCalculation(c)) {
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+}
+
+func TestRequireOwner(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+
+	if assert.NoError(t, RequireOwner(func(c echo.Context) error { return c.NoContent(http.StatusOK) })(c)) {
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	}
+}
+
+func TestRecomputeHandler_Recompute(t *testing.T) {
+	matches := true
+	mockService := new(MockRecomputeService)
+	mockService.On("Recompute", model.RecomputeRequest{CalculationID: 9}).
+		Return(model.RecomputeResult{CalculationID: 9, ConfigVersion: 2, Recomputed: []byte(`{"tax":29000.0}`), Matches: &matches}, nil)
+	h := NewRecomputeHandler(mockService)
+
+	c, rec := newContext(http.MethodPost, "/admin/recompute", `{"calculationId":9}`)
+	if assert.NoError(t, h.Recompute(c)) {
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.JSONEq(t, `{"calculationId":9,"configVersion":2,"recomputed":{"tax":29000.0},"matches":true}`, rec.Body.String())
+	}
+}
+
+func TestRecomputeHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"invalid request", ErrInvalidRecompute, http.StatusBadRequest, "invalid_recompute"},
+		{"unknown calculation", ErrCalculationNotFound, http.StatusNotFound, "calculation_not_found"},
+		{"unknown configuration", tax.ErrConfigNotFound, http.StatusNotFound, "config_not_found"},
+		{"invalid input", &tax.FieldError{Field: "wht", Err: tax.ErrInvalidWHT}, http.StatusBadRequest, "invalid_wht"},
+		{"database", errors.New("relation \"calculation_gorms\" does not exist"), http.StatusInternalServerError, "internal_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockService := new(MockRecomputeService)
+			mockService.On("Recompute", mock.Anything).Return(model.RecomputeResult{}, tt.err)
+			h := NewRecomputeHandler(mockService)
+
+			c, rec := newContext(http.MethodPost, "/admin/recompute", `{"calculationId":9}`)
+			if assert.NoError(t, h.Recompute(c)) {
+				assert.Equal(t, tt.status, rec.Code)
+				assert.Contains(t, rec.Body.String(), `"code":"`+tt.code+`"`)
+				assert.NotContains(t, rec.Body.String(), "calculation_gorms")
+			}
+		})
+	}
+}
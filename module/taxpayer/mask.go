@@ -0,0 +1,97 @@
+package taxpayer
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/utils"
+	"strings"
+)
+
+// maskTaxpayer hides the national ID from callers who may not see it.
+func maskTaxpayer(c echo.Context, taxpayer model.Taxpayer) model.Taxpayer {
+	if !auth.CanSeePersonalData(c) {
+		taxpayer.NationalID = utils.MaskNationalID(taxpayer.NationalID)
+	}
+	return taxpayer
+}
+
+// maskDocument masks every "nationalId" string in a stored request or
+// response document. Numbers are kept as written.
+func maskDocument(c echo.Context, raw json.RawMessage) (json.RawMessage, error) {
+	if len(raw) == 0 || auth.CanSeePersonalData(c) {
+		return raw, nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+	return json.Marshal(maskValue(document))
+}
+
+func maskValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if id, ok := field.(string); ok && key == "nationalId" {
+				v[key] = utils.MaskNationalID(id)
+			} else {
+				v[key] = maskValue(field)
+			}
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = maskValue(v[i])
+		}
+	}
+	return value
+}
+
+// identifierKeys are the document fields that tie a calculation to a
+// person.
+var identifierKeys = []string{"nationalId", "taxpayerId"}
+
+// anonymizeDocument removes identifierKeys from a stored document.
+func anonymizeDocument(raw string) (string, error) {
+	if raw == "" {
+		return raw, nil
+	}
+	decoder := json.NewDecoder(strings.NewReader(raw))
+	decoder.UseNumber()
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return "", err
+	}
+	encoded, err := json.Marshal(removeIdentifiers(document))
+	return string(encoded), err
+}
+
+func removeIdentifiers(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, key := range identifierKeys {
+			delete(v, key)
+		}
+		for key, field := range v {
+			v[key] = removeIdentifiers(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = removeIdentifiers(v[i])
+		}
+	}
+	return value
+}
+
+func maskCalculation(c echo.Context, calculation model.Calculation) (model.Calculation, error) {
+	var err error
+	if calculation.Input, err = maskDocument(c, calculation.Input); err != nil {
+		return calculation, err
+	}
+	calculation.Output, err = maskDocument(c, calculation.Output)
+	return calculation, err
+}
@@ -0,0 +1,133 @@
+package taxpayer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+	"reflect"
+)
+
+var ErrInvalidRecompute = errors.New("invalid recompute request")
+
+type RecomputeServices interface {
+	Recompute(ctx context.Context, req model.RecomputeRequest) (model.RecomputeResult, error)
+}
+
+// RecomputeService repeats calculations under the configuration version that
+// was in force when they were made. It must wrap the plain tax service, not
+// the recording one, so recomputed results are not stored again.
+type RecomputeService struct {
+	Repo       TaxpayerRepositories
+	TaxService tax.TaxServices
+}
+
+func NewRecomputeService(repo TaxpayerRepositories, taxService tax.TaxServices) RecomputeServices {
+	return &RecomputeService{Repo: repo, TaxService: taxService}
+}
+
+func (service *RecomputeService) Recompute(ctx context.Context, req model.RecomputeRequest) (model.RecomputeResult, error) {
+	switch {
+	case req.CalculationID != 0 && (req.Input != nil || req.AsOf != nil):
+		return model.RecomputeResult{}, fmt.Errorf("%w: give either calculationId or input with asOf", ErrInvalidRecompute)
+	case req.CalculationID != 0:
+		return service.recomputeStored(ctx, req.CalculationID)
+	case req.Input == nil || req.AsOf == nil:
+		return model.RecomputeResult{}, fmt.Errorf("%w: input and asOf are required without calculationId", ErrInvalidRecompute)
+	}
+
+	version, err := service.TaxService.ConfigVersionAt(ctx, *req.AsOf)
+	if err != nil {
+		return model.RecomputeResult{}, err
+	}
+	res, err := service.TaxService.CalculateTaxAt(ctx, *req.Input, version)
+	if err != nil {
+		return model.RecomputeResult{}, err
+	}
+	return newRecomputeResult(0, version, req.Original, res)
+}
+
+func (service *RecomputeService) recomputeStored(ctx context.Context, id uint) (model.RecomputeResult, error) {
+	calculation, err := service.Repo.GetCalculationByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.RecomputeResult{}, fmt.Errorf("%w: %d", ErrCalculationNotFound, id)
+	}
+	if err != nil {
+		return model.RecomputeResult{}, fmt.Errorf("failed to get calculation: %w", err)
+	}
+	if calculation.Input == "" {
+		return model.RecomputeResult{}, fmt.Errorf("%w: the input of calculation %d has been purged", ErrInvalidRecompute, id)
+	}
+
+	var recomputed interface{}
+	if calculation.BatchID != nil {
+		recomputed, err = service.recomputeBatchRow(ctx, calculation)
+	} else {
+		recomputed, err = service.recomputeSingle(ctx, calculation)
+	}
+	if err != nil {
+		return model.RecomputeResult{}, err
+	}
+	return newRecomputeResult(calculation.ID, calculation.ConfigVersion, json.RawMessage(calculation.Output), recomputed)
+}
+
+func (service *RecomputeService) recomputeSingle(ctx context.Context, calculation modelgorm.CalculationGorm) (interface{}, error) {
+	var req model.TaxRequest
+	if err := json.Unmarshal([]byte(calculation.Input), &req); err != nil {
+		return nil, fmt.Errorf("failed to decode calculation %d input: %w", calculation.ID, err)
+	}
+	return service.TaxService.CalculateTaxAt(ctx, req, calculation.ConfigVersion)
+}
+
+// recomputeBatchRow repeats one CSV row on its own; rows in a batch do not
+// depend on each other.
+func (service *RecomputeService) recomputeBatchRow(ctx context.Context, calculation modelgorm.CalculationGorm) (interface{}, error) {
+	var record model.TotalIncomeCsv
+	if err := json.Unmarshal([]byte(calculation.Input), &record); err != nil {
+		return nil, fmt.Errorf("failed to decode calculation %d input: %w", calculation.ID, err)
+	}
+	res, err := service.TaxService.CalculateBatchAt(ctx, []model.TotalIncomeCsv{record}, calculation.ConfigVersion)
+	if err != nil {
+		return nil, err
+	}
+	return res.Taxes[0], nil
+}
+
+func newRecomputeResult(id uint, version int64, original json.RawMessage, recomputed interface{}) (model.RecomputeResult, error) {
+	encoded, err := json.Marshal(recomputed)
+	if err != nil {
+		return model.RecomputeResult{}, fmt.Errorf("failed to encode recomputed result: %w", err)
+	}
+
+	result := model.RecomputeResult{
+		CalculationID: id,
+		ConfigVersion: version,
+		Original:      original,
+		Recomputed:    encoded,
+	}
+	if len(original) > 0 {
+		matches, err := sameJSON(original, encoded)
+		if err != nil {
+			return model.RecomputeResult{}, fmt.Errorf("%w: original is not valid JSON", ErrInvalidRecompute)
+		}
+		result.Matches = &matches
+	}
+	return result, nil
+}
+
+// sameJSON compares documents by value, so key order and spacing do not
+// count as differences.
+func sameJSON(a, b []byte) (bool, error) {
+	var left, right interface{}
+	if err := json.Unmarshal(a, &left); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &right); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(left, right), nil
+}
@@ -0,0 +1,132 @@
+package taxpayer
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/tax"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func storedCalculation(t *testing.T, batchID *uint, input, output interface{}) modelgorm.CalculationGorm {
+	calculation, err := newCalculation("apikey:3", nil, "", input, output, 0, 2, testNow)
+	assert.NoError(t, err)
+	calculation.ID = 9
+	calculation.BatchID = batchID
+	return calculation
+}
+
+func TestRecompute_StoredCalculationMatches(t *testing.T) {
+	req := model.TaxRequest{TotalIncome: 500000, TaxpayerID: 4}
+	res := model.TaxResponse{Tax: 29000, ConfigVersion: 2}
+
+	repo := new(MockRepo)
+	inner := new(MockTaxService)
+	repo.On("GetCalculationByID", uint(9)).Return(storedCalculation(t, nil, req, res), nil)
+	inner.On("CalculateTaxAt", req, int64(2)).Return(res, nil)
+
+	result, err := NewRecomputeService(repo, inner).Recompute(context.Background(), model.RecomputeRequest{CalculationID: 9})
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(9), result.CalculationID)
+	assert.Equal(t, int64(2), result.ConfigVersion)
+	if assert.NotNil(t, result.Matches) {
+		assert.True(t, *result.Matches)
+	}
+	inner.AssertNotCalled(t, "CalculateTax", mock.Anything)
+}
+
+func TestRecompute_StoredCalculationDiffers(t *testing.T) {
+	req := model.TaxRequest{TotalIncome: 500000}
+
+	repo := new(MockRepo)
+	inner := new(MockTaxService)
+	repo.On("GetCalculationByID", uint(9)).Return(storedCalculation(t, nil, req, model.TaxResponse{Tax: 29000, ConfigVersion: 2}), nil)
+	inner.On("CalculateTaxAt", req, int64(2)).Return(model.TaxResponse{Tax: 30000, ConfigVersion: 2}, nil)
+
+	result, err := NewRecomputeService(repo, inner).Recompute(context.Background(), model.RecomputeRequest{CalculationID: 9})
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, result.Matches) {
+		assert.False(t, *result.Matches)
+	}
+	assert.JSONEq(t, `{"tax":29000.0,"taxLevel":[],"configVersion":2}`, string(result.Original))
+	assert.JSONEq(t, `{"tax":30000.0,"taxLevel":[],"configVersion":2}`, string(result.Recomputed))
+}
+
+func TestRecompute_BatchRow(t *testing.T) {
+	batchID := uint(5)
+	record := model.TotalIncomeCsv{TotalIncome: 500000, Donation: 0}
+	detail := model.TaxDetail{TotalIncome: 500000, Tax: 29000}
+
+	repo := new(MockRepo)
+	inner := new(MockTaxService)
+	repo.On("GetCalculationByID", uint(9)).Return(storedCalculation(t, &batchID, record, detail), nil)
+	inner.On("CalculateBatchAt", []model.TotalIncomeCsv{record}, int64(2)).
+		Return(model.TaxResponseCSV{Taxes: []model.TaxDetail{detail}, ConfigVersion: 2}, nil)
+
+	result, err := NewRecomputeService(repo, inner).Recompute(context.Background(), model.RecomputeRequest{CalculationID: 9})
+
+	assert.NoError(t, err)
+	if assert.NotNil(t, result.Matches) {
+		assert.True(t, *result.Matches)
+	}
+}
+
+func TestRecompute_InputAsOf(t *testing.T) {
+	asOf := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	req := model.TaxRequest{TotalIncome: 500000}
+
+	inner := new(MockTaxService)
+	inner.On("ConfigVersionAt", asOf).Return(int64(1), nil)
+	inner.On("CalculateTaxAt", req, int64(1)).Return(model.TaxResponse{Tax: 29000, ConfigVersion: 1}, nil)
+	service := NewRecomputeService(new(MockRepo), inner)
+
+	result, err := service.Recompute(context.Background(), model.RecomputeRequest{Input: &req, AsOf: &asOf})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), result.ConfigVersion)
+	assert.Nil(t, result.Matches)
+
+	original := json.RawMessage(`{"configVersion": 1, "taxLevel": [], "tax": 29000.0}`)
+	result, err = service.Recompute(context.Background(), model.RecomputeRequest{Input: &req, AsOf: &asOf, Original: original})
+	assert.NoError(t, err)
+	if assert.NotNil(t, result.Matches) {
+		assert.True(t, *result.Matches)
+	}
+}
+
+func TestRecompute_Errors(t *testing.T) {
+	asOf := testNow
+	input := model.TaxRequest{TotalIncome: 500000}
+
+	repo := new(MockRepo)
+	inner := new(MockTaxService)
+	repo.On("GetCalculationByID", uint(7)).Return(modelgorm.CalculationGorm{}, gorm.ErrRecordNotFound)
+	repo.On("GetCalculationByID", uint(8)).Return(modelgorm.CalculationGorm{ID: 8, Output: `{"tax":0}`, ConfigVersion: 1}, nil)
+	inner.On("ConfigVersionAt", asOf).Return(int64(0), tax.ErrConfigNotFound)
+	service := NewRecomputeService(repo, inner)
+
+	tests := []struct {
+		name string
+		req  model.RecomputeRequest
+		err  error
+	}{
+		{"empty", model.RecomputeRequest{}, ErrInvalidRecompute},
+		{"input without asOf", model.RecomputeRequest{Input: &input}, ErrInvalidRecompute},
+		{"both forms", model.RecomputeRequest{CalculationID: 7, Input: &input, AsOf: &asOf}, ErrInvalidRecompute},
+		{"unknown calculation", model.RecomputeRequest{CalculationID: 7}, ErrCalculationNotFound},
+		{"purged input", model.RecomputeRequest{CalculationID: 8}, ErrInvalidRecompute},
+		{"before first configuration", model.RecomputeRequest{Input: &input, AsOf: &asOf}, tax.ErrConfigNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := service.Recompute(context.Background(), tt.req)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
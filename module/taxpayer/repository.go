@@ -0,0 +1,473 @@
+package taxpayer
+
+import (
+	"context"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/store/envelope"
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+)
+
+// Fields name the encrypted columns; each is authenticated with its values.
+const (
+	fieldNationalID = "taxpayer.national_id"
+	fieldName       = "taxpayer.name"
+	fieldInput      = "calculation.input"
+	fieldOutput     = "calculation.output"
+)
+
+const (
+	reencryptBatchSize = 500
+	// lookupBatchSize is how many national IDs one query looks up.
+	lookupBatchSize = 500
+)
+
+// subjectAuditEntity is the audit entity of data subject requests. Their
+// entity ID is the blind index of the subject's national ID.
+const subjectAuditEntity = "data_subject"
+
+type TaxpayerRepositories interface {
+	CreateTaxpayer(ctx context.Context, taxpayer *modelgorm.TaxpayerGorm) error
+	GetTaxpayer(ctx context.Context, owner string, id uint) (modelgorm.TaxpayerGorm, error)
+	UpdateTaxpayer(ctx context.Context, taxpayer *modelgorm.TaxpayerGorm) error
+	CreateCalculation(ctx context.Context, calculation *modelgorm.CalculationGorm) error
+	CreateBatch(ctx context.Context, batch *modelgorm.CalculationBatchGorm, calculations []modelgorm.CalculationGorm) error
+	ListCalculations(ctx context.Context, owner string, taxpayerID uint, limit, offset int) ([]modelgorm.CalculationGorm, error)
+	GetCalculation(ctx context.Context, owner string, taxpayerID, id uint) (modelgorm.CalculationGorm, error)
+	DeleteCalculation(ctx context.Context, owner string, taxpayerID, id uint) (int64, error)
+	GetCalculationByID(ctx context.Context, id uint) (modelgorm.CalculationGorm, error)
+	FindTaxpayerByNationalID(ctx context.Context, owner, nationalID string) (modelgorm.TaxpayerGorm, error)
+	FindTaxpayerIDs(ctx context.Context, owner string, nationalIDs []string) (map[string]uint, error)
+	GetSubjectData(ctx context.Context, nationalID string) (SubjectData, error)
+	EraseSubject(ctx context.Context, nationalID string, audit func(erased model.SubjectErasure) (modelgorm.AuditEventGorm, error)) (model.SubjectErasure, error)
+	SubjectReference(nationalID string) string
+}
+
+// SubjectData is everything stored about one national ID, across owners.
+type SubjectData struct {
+	Taxpayers    []modelgorm.TaxpayerGorm
+	Calculations []modelgorm.CalculationGorm
+	Batches      []modelgorm.CalculationBatchGorm
+	AuditEvents  []modelgorm.AuditEventGorm
+}
+
+// TaxpayerRepository encrypts national IDs, names and calculation documents
+// before they are written and decrypts them as they are read, so the rest
+// of the service only sees plaintext.
+type TaxpayerRepository struct {
+	DB   *gorm.DB
+	Keys *envelope.Keyring
+}
+
+func NewTaxpayerRepository(db *gorm.DB, keys *envelope.Keyring) TaxpayerRepositories {
+	return &TaxpayerRepository{DB: db, Keys: keys}
+}
+
+func (repo *TaxpayerRepository) sealTaxpayer(taxpayer modelgorm.TaxpayerGorm) (modelgorm.TaxpayerGorm, error) {
+	var err error
+	taxpayer.NationalIDIndex = repo.Keys.BlindIndex(taxpayer.NationalID)
+	if taxpayer.NationalID, err = repo.Keys.Encrypt(fieldNationalID, taxpayer.NationalID); err != nil {
+		return taxpayer, fmt.Errorf("failed to encrypt taxpayer: %w", err)
+	}
+	if taxpayer.Name, err = repo.Keys.Encrypt(fieldName, taxpayer.Name); err != nil {
+		return taxpayer, fmt.Errorf("failed to encrypt taxpayer: %w", err)
+	}
+	return taxpayer, nil
+}
+
+func (repo *TaxpayerRepository) openTaxpayer(taxpayer modelgorm.TaxpayerGorm) (modelgorm.TaxpayerGorm, error) {
+	var err error
+	if taxpayer.NationalID, err = repo.Keys.Decrypt(fieldNationalID, taxpayer.NationalID); err != nil {
+		return taxpayer, fmt.Errorf("failed to decrypt taxpayer %d: %w", taxpayer.ID, err)
+	}
+	if taxpayer.Name, err = repo.Keys.Decrypt(fieldName, taxpayer.Name); err != nil {
+		return taxpayer, fmt.Errorf("failed to decrypt taxpayer %d: %w", taxpayer.ID, err)
+	}
+	return taxpayer, nil
+}
+
+func (repo *TaxpayerRepository) sealCalculation(calculation modelgorm.CalculationGorm) (modelgorm.CalculationGorm, error) {
+	var err error
+	if calculation.NationalID != "" {
+		calculation.NationalIDIndex = repo.Keys.BlindIndex(calculation.NationalID)
+		calculation.NationalID = ""
+	}
+	if calculation.Input, err = repo.Keys.Encrypt(fieldInput, calculation.Input); err != nil {
+		return calculation, fmt.Errorf("failed to encrypt calculation: %w", err)
+	}
+	if calculation.Output, err = repo.Keys.Encrypt(fieldOutput, calculation.Output); err != nil {
+		return calculation, fmt.Errorf("failed to encrypt calculation: %w", err)
+	}
+	return calculation, nil
+}
+
+func (repo *TaxpayerRepository) openCalculation(calculation modelgorm.CalculationGorm) (modelgorm.CalculationGorm, error) {
+	var err error
+	if calculation.Input, err = repo.Keys.Decrypt(fieldInput, calculation.Input); err != nil {
+		return calculation, fmt.Errorf("failed to decrypt calculation %d: %w", calculation.ID, err)
+	}
+	if calculation.Output, err = repo.Keys.Decrypt(fieldOutput, calculation.Output); err != nil {
+		return calculation, fmt.Errorf("failed to decrypt calculation %d: %w", calculation.ID, err)
+	}
+	return calculation, nil
+}
+
+// writeTaxpayer stores a sealed copy of taxpayer and copies back what the database
+// assigned.
+func (repo *TaxpayerRepository) writeTaxpayer(taxpayer *modelgorm.TaxpayerGorm, write func(*modelgorm.TaxpayerGorm) error) error {
+	sealed, err := repo.sealTaxpayer(*taxpayer)
+	if err != nil {
+		return err
+	}
+	if err := write(&sealed); err != nil {
+		return err
+	}
+	taxpayer.ID = sealed.ID
+	taxpayer.NationalIDIndex = sealed.NationalIDIndex
+	taxpayer.CreatedAt = sealed.CreatedAt
+	taxpayer.UpdatedAt = sealed.UpdatedAt
+	return nil
+}
+
+func (repo *TaxpayerRepository) CreateTaxpayer(ctx context.Context, taxpayer *modelgorm.TaxpayerGorm) error {
+	return repo.writeTaxpayer(taxpayer, func(sealed *modelgorm.TaxpayerGorm) error {
+		return repo.DB.WithContext(ctx).Create(sealed).Error
+	})
+}
+
+func (repo *TaxpayerRepository) GetTaxpayer(ctx context.Context, owner string, id uint) (modelgorm.TaxpayerGorm, error) {
+	var taxpayer modelgorm.TaxpayerGorm
+	if err := repo.DB.WithContext(ctx).Where("id = ? AND owner = ?", id, owner).First(&taxpayer).Error; err != nil {
+		return taxpayer, err
+	}
+	return repo.openTaxpayer(taxpayer)
+}
+
+// FindTaxpayerByNationalID looks the profile up by the blind index of
+// nationalID, which must already be normalized.
+func (repo *TaxpayerRepository) FindTaxpayerByNationalID(ctx context.Context, owner, nationalID string) (modelgorm.TaxpayerGorm, error) {
+	var taxpayer modelgorm.TaxpayerGorm
+	err := repo.DB.WithContext(ctx).Where("owner = ? AND national_id_index = ?", owner, repo.Keys.BlindIndex(nationalID)).First(&taxpayer).Error
+	if err != nil {
+		return taxpayer, err
+	}
+	return repo.openTaxpayer(taxpayer)
+}
+
+// FindTaxpayerIDs returns the IDs of owner's profiles with any of
+// nationalIDs, which must already be normalized, by national ID. IDs
+// without a profile are left out.
+func (repo *TaxpayerRepository) FindTaxpayerIDs(ctx context.Context, owner string, nationalIDs []string) (map[string]uint, error) {
+	byIndex := make(map[string]string, len(nationalIDs))
+	indexes := make([]string, 0, len(nationalIDs))
+	for _, nationalID := range nationalIDs {
+		index := repo.Keys.BlindIndex(nationalID)
+		byIndex[index] = nationalID
+		indexes = append(indexes, index)
+	}
+
+	ids := make(map[string]uint)
+	for start := 0; start < len(indexes); start += lookupBatchSize {
+		var taxpayers []modelgorm.TaxpayerGorm
+		err := repo.DB.WithContext(ctx).Select("id", "national_id_index").
+			Where("owner = ? AND national_id_index IN ?", owner, indexes[start:min(start+lookupBatchSize, len(indexes))]).
+			Find(&taxpayers).Error
+		if err != nil {
+			return nil, err
+		}
+		for _, taxpayer := range taxpayers {
+			ids[byIndex[taxpayer.NationalIDIndex]] = taxpayer.ID
+		}
+	}
+	return ids, nil
+}
+
+func (repo *TaxpayerRepository) UpdateTaxpayer(ctx context.Context, taxpayer *modelgorm.TaxpayerGorm) error {
+	return repo.writeTaxpayer(taxpayer, func(sealed *modelgorm.TaxpayerGorm) error {
+		return repo.DB.WithContext(ctx).Save(sealed).Error
+	})
+}
+
+func (repo *TaxpayerRepository) CreateCalculation(ctx context.Context, calculation *modelgorm.CalculationGorm) error {
+	sealed, err := repo.sealCalculation(*calculation)
+	if err != nil {
+		return err
+	}
+	if err := repo.DB.WithContext(ctx).Create(&sealed).Error; err != nil {
+		return err
+	}
+	calculation.ID = sealed.ID
+	calculation.NationalIDIndex = sealed.NationalIDIndex
+	return nil
+}
+
+// CreateBatch stores the batch and its rows together, so a batch is either
+// recorded in full or not at all.
+func (repo *TaxpayerRepository) CreateBatch(ctx context.Context, batch *modelgorm.CalculationBatchGorm, calculations []modelgorm.CalculationGorm) error {
+	sealed := make([]modelgorm.CalculationGorm, len(calculations))
+	for i := range calculations {
+		var err error
+		if sealed[i], err = repo.sealCalculation(calculations[i]); err != nil {
+			return err
+		}
+	}
+	return repo.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(batch).Error; err != nil {
+			return err
+		}
+		if len(calculations) == 0 {
+			return nil
+		}
+		for i := range sealed {
+			sealed[i].BatchID = &batch.ID
+		}
+		if err := tx.CreateInBatches(sealed, 500).Error; err != nil {
+			return err
+		}
+		for i := range calculations {
+			calculations[i].ID = sealed[i].ID
+			calculations[i].BatchID = &batch.ID
+			calculations[i].NationalIDIndex = sealed[i].NationalIDIndex
+		}
+		return nil
+	})
+}
+
+func (repo *TaxpayerRepository) ListCalculations(ctx context.Context, owner string, taxpayerID uint, limit, offset int) ([]modelgorm.CalculationGorm, error) {
+	var calculations []modelgorm.CalculationGorm
+	err := repo.DB.WithContext(ctx).Where("owner = ? AND taxpayer_id = ?", owner, taxpayerID).
+		Order("id DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&calculations).Error
+	if err != nil {
+		return nil, err
+	}
+	for i := range calculations {
+		if calculations[i], err = repo.openCalculation(calculations[i]); err != nil {
+			return nil, err
+		}
+	}
+	return calculations, nil
+}
+
+func (repo *TaxpayerRepository) GetCalculation(ctx context.Context, owner string, taxpayerID, id uint) (modelgorm.CalculationGorm, error) {
+	var calculation modelgorm.CalculationGorm
+	if err := repo.DB.WithContext(ctx).Where("id = ? AND owner = ? AND taxpayer_id = ?", id, owner, taxpayerID).First(&calculation).Error; err != nil {
+		return calculation, err
+	}
+	return repo.openCalculation(calculation)
+}
+
+func (repo *TaxpayerRepository) DeleteCalculation(ctx context.Context, owner string, taxpayerID, id uint) (int64, error) {
+	result := repo.DB.WithContext(ctx).Where("id = ? AND owner = ? AND taxpayer_id = ?", id, owner, taxpayerID).Delete(&modelgorm.CalculationGorm{})
+	return result.RowsAffected, result.Error
+}
+
+// GetCalculationByID is not scoped to an owner and is only used by admin
+// endpoints.
+func (repo *TaxpayerRepository) GetCalculationByID(ctx context.Context, id uint) (modelgorm.CalculationGorm, error) {
+	var calculation modelgorm.CalculationGorm
+	if err := repo.DB.WithContext(ctx).First(&calculation, id).Error; err != nil {
+		return calculation, err
+	}
+	return repo.openCalculation(calculation)
+}
+
+// SubjectReference identifies a data subject in the audit log without
+// recording the national ID.
+func (repo *TaxpayerRepository) SubjectReference(nationalID string) string {
+	return repo.Keys.BlindIndex(nationalID)
+}
+
+func subjectCalculations(tx *gorm.DB, index string, taxpayers []modelgorm.TaxpayerGorm) *gorm.DB {
+	query := tx.Where("national_id_index = ?", index)
+	if len(taxpayers) > 0 {
+		ids := make([]uint, len(taxpayers))
+		for i, taxpayer := range taxpayers {
+			ids[i] = taxpayer.ID
+		}
+		query = query.Or("taxpayer_id IN ?", ids)
+	}
+	return query.Order("id")
+}
+
+// GetSubjectData finds the profiles with nationalID under any owner, the
+// calculations filed under them or made with the ID, the batches those
+// calculations came from, and earlier data subject requests for the ID.
+func (repo *TaxpayerRepository) GetSubjectData(ctx context.Context, nationalID string) (SubjectData, error) {
+	var data SubjectData
+	index := repo.Keys.BlindIndex(nationalID)
+	db := repo.DB.WithContext(ctx)
+
+	if err := db.Where("national_id_index = ?", index).Order("id").Find(&data.Taxpayers).Error; err != nil {
+		return data, err
+	}
+	for i := range data.Taxpayers {
+		var err error
+		if data.Taxpayers[i], err = repo.openTaxpayer(data.Taxpayers[i]); err != nil {
+			return data, err
+		}
+	}
+
+	if err := subjectCalculations(db, index, data.Taxpayers).Find(&data.Calculations).Error; err != nil {
+		return data, err
+	}
+	var batchIDs []uint
+	seen := map[uint]bool{}
+	for i := range data.Calculations {
+		var err error
+		if data.Calculations[i], err = repo.openCalculation(data.Calculations[i]); err != nil {
+			return data, err
+		}
+		if id := data.Calculations[i].BatchID; id != nil && !seen[*id] {
+			seen[*id] = true
+			batchIDs = append(batchIDs, *id)
+		}
+	}
+	if len(batchIDs) > 0 {
+		if err := db.Where("id IN ?", batchIDs).Order("id").Find(&data.Batches).Error; err != nil {
+			return data, err
+		}
+	}
+
+	err := db.Where("entity = ? AND entity_id = ?", subjectAuditEntity, index).Order("id").Find(&data.AuditEvents).Error
+	return data, err
+}
+
+// EraseSubject deletes the profiles with nationalID and anonymizes the
+// calculations GetSubjectData would return: they lose their taxpayer, their
+// blind index and every identifier in their documents, but keep their
+// amounts so totals are unchanged. The event returned by audit is recorded
+// in the same transaction.
+func (repo *TaxpayerRepository) EraseSubject(ctx context.Context, nationalID string, audit func(erased model.SubjectErasure) (modelgorm.AuditEventGorm, error)) (model.SubjectErasure, error) {
+	var erased model.SubjectErasure
+	index := repo.Keys.BlindIndex(nationalID)
+	err := repo.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var taxpayers []modelgorm.TaxpayerGorm
+		if err := tx.Where("national_id_index = ?", index).Find(&taxpayers).Error; err != nil {
+			return err
+		}
+		var calculations []modelgorm.CalculationGorm
+		if err := subjectCalculations(tx, index, taxpayers).Find(&calculations).Error; err != nil {
+			return err
+		}
+
+		for _, calculation := range calculations {
+			opened, err := repo.openCalculation(calculation)
+			if err != nil {
+				return err
+			}
+			if opened.Input, err = anonymizeDocument(opened.Input); err != nil {
+				return fmt.Errorf("calculation %d: %w", calculation.ID, err)
+			}
+			if opened.Output, err = anonymizeDocument(opened.Output); err != nil {
+				return fmt.Errorf("calculation %d: %w", calculation.ID, err)
+			}
+			sealed, err := repo.sealCalculation(opened)
+			if err != nil {
+				return err
+			}
+			err = tx.Model(&modelgorm.CalculationGorm{}).Where("id = ?", calculation.ID).UpdateColumns(map[string]interface{}{
+				"taxpayer_id":       nil,
+				"national_id_index": "",
+				"input":             sealed.Input,
+				"output":            sealed.Output,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		for _, taxpayer := range taxpayers {
+			if err := tx.Delete(&modelgorm.TaxpayerGorm{}, taxpayer.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		erased = model.SubjectErasure{Profiles: len(taxpayers), Calculations: len(calculations)}
+		event, err := audit(erased)
+		if err != nil {
+			return err
+		}
+		event.Entity = subjectAuditEntity
+		event.EntityID = index
+		return tx.Create(&event).Error
+	})
+	return erased, err
+}
+
+// Reencrypt brings every stored value under the keyfile's primary key and
+// fills in missing blind indexes. It is run after a key is added to the
+// keyfile, or after upgrading a database written without encryption, and
+// can be stopped and run again. It returns the number of rows rewritten.
+func (repo *TaxpayerRepository) Reencrypt(ctx context.Context) (int, error) {
+	taxpayers, err := repo.reencryptTaxpayers(ctx)
+	if err != nil {
+		return taxpayers, err
+	}
+	calculations, err := repo.reencryptCalculations(ctx)
+	return taxpayers + calculations, err
+}
+
+func (repo *TaxpayerRepository) reencryptTaxpayers(ctx context.Context) (int, error) {
+	rewritten := 0
+	var lastID uint
+	for {
+		var taxpayers []modelgorm.TaxpayerGorm
+		err := repo.DB.WithContext(ctx).Where("id > ?", lastID).Order("id").Limit(reencryptBatchSize).Find(&taxpayers).Error
+		if err != nil || len(taxpayers) == 0 {
+			return rewritten, err
+		}
+		for _, taxpayer := range taxpayers {
+			lastID = taxpayer.ID
+			if repo.Keys.Current(taxpayer.NationalID) && repo.Keys.Current(taxpayer.Name) && taxpayer.NationalIDIndex != "" {
+				continue
+			}
+			opened, err := repo.openTaxpayer(taxpayer)
+			if err != nil {
+				return rewritten, err
+			}
+			updates := map[string]interface{}{"national_id_index": repo.Keys.BlindIndex(opened.NationalID)}
+			if updates["national_id"], err = repo.Keys.Reencrypt(fieldNationalID, taxpayer.NationalID); err != nil {
+				return rewritten, fmt.Errorf("taxpayer %d: %w", taxpayer.ID, err)
+			}
+			if updates["name"], err = repo.Keys.Reencrypt(fieldName, taxpayer.Name); err != nil {
+				return rewritten, fmt.Errorf("taxpayer %d: %w", taxpayer.ID, err)
+			}
+			if err := repo.DB.WithContext(ctx).Model(&modelgorm.TaxpayerGorm{}).Where("id = ?", taxpayer.ID).UpdateColumns(updates).Error; err != nil {
+				return rewritten, err
+			}
+			rewritten++
+		}
+	}
+}
+
+func (repo *TaxpayerRepository) reencryptCalculations(ctx context.Context) (int, error) {
+	rewritten := 0
+	var lastID uint
+	for {
+		var calculations []modelgorm.CalculationGorm
+		err := repo.DB.WithContext(ctx).Where("id > ?", lastID).Order("id").Limit(reencryptBatchSize).Find(&calculations).Error
+		if err != nil || len(calculations) == 0 {
+			return rewritten, err
+		}
+		for _, calculation := range calculations {
+			lastID = calculation.ID
+			if repo.Keys.Current(calculation.Input) && repo.Keys.Current(calculation.Output) {
+				continue
+			}
+			updates := map[string]interface{}{}
+			if updates["input"], err = repo.Keys.Reencrypt(fieldInput, calculation.Input); err != nil {
+				return rewritten, fmt.Errorf("calculation %d: %w", calculation.ID, err)
+			}
+			if updates["output"], err = repo.Keys.Reencrypt(fieldOutput, calculation.Output); err != nil {
+				return rewritten, fmt.Errorf("calculation %d: %w", calculation.ID, err)
+			}
+			if err := repo.DB.WithContext(ctx).Model(&modelgorm.CalculationGorm{}).Where("id = ?", calculation.ID).UpdateColumns(updates).Error; err != nil {
+				return rewritten, err
+			}
+			rewritten++
+		}
+	}
+}
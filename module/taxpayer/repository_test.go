@@ -0,0 +1,284 @@
+package taxpayer
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pphee/assessment-tax/store"
+	"github.com/pphee/assessment-tax/store/envelope"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testNationalID = "1101700203450"
+	testName       = "Somchai Jaidee"
+	testIncome     = "987654.32"
+)
+
+func newTestKeyring(t *testing.T, keyfile envelope.Keyfile) *envelope.Keyring {
+	keys, err := envelope.NewKeyring(keyfile)
+	require.NoError(t, err)
+	return keys
+}
+
+func newKeyfile(t *testing.T) envelope.Keyfile {
+	keyfile, err := envelope.GenerateKeyfile("k1")
+	require.NoError(t, err)
+	return keyfile
+}
+
+func newMockRepository(t *testing.T) (TaxpayerRepositories, sqlmock.Sqlmock) {
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB, PreferSimpleProtocol: true}), &gorm.Config{})
+	require.NoError(t, err)
+	return NewTaxpayerRepository(db, newTestKeyring(t, newKeyfile(t))), mock
+}
+
+func newSQLiteRepository(t *testing.T, keyfile envelope.Keyfile) *TaxpayerRepository {
+	sqlite, err := store.NewSQLiteStore(":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { sqlite.Close() })
+	require.NoError(t, sqlite.Migrate(context.Background()))
+	return &TaxpayerRepository{DB: sqlite.DB, Keys: newTestKeyring(t, keyfile)}
+}
+
+// sealedArg matches any query argument that does not contain plaintext.
+type sealedArg struct{}
+
+func (sealedArg) Match(v driver.Value) bool {
+	s, ok := v.(string)
+	return !ok || !containsPlaintext(s)
+}
+
+func containsPlaintext(s string) bool {
+	return strings.Contains(s, testNationalID) || strings.Contains(s, testName) || strings.Contains(s, testIncome)
+}
+
+// rawRows reads every column of table as the database stores it.
+func rawRows(t *testing.T, db *gorm.DB, table string) [][]string {
+	rows, err := db.Raw("SELECT * FROM " + table).Rows()
+	require.NoError(t, err)
+	defer rows.Close()
+	columns, err := rows.Columns()
+	require.NoError(t, err)
+
+	var result [][]string
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		require.NoError(t, rows.Scan(pointers...))
+		row := make([]string, len(values))
+		for i, value := range values {
+			if b, ok := value.([]byte); ok {
+				value = string(b)
+			}
+			row[i] = fmt.Sprint(value)
+		}
+		result = append(result, row)
+	}
+	require.NoError(t, rows.Err())
+	return result
+}
+
+func assertNoPlaintext(t *testing.T, db *gorm.DB) {
+	for _, table := range []string{"taxpayer_gorms", "calculation_gorms"} {
+		rows := rawRows(t, db, table)
+		require.NotEmpty(t, rows, table)
+		for _, row := range rows {
+			for _, value := range row {
+				assert.False(t, containsPlaintext(value), "%s stores plaintext: %s", table, value)
+			}
+		}
+	}
+}
+
+func TestCreateTaxpayer_Repository_Encrypted(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "taxpayer_gorms" \("owner","national_id","national_id_index","name","dependents","created_at","updated_at"\)`).
+		WithArgs("apikey:3", sealedArg{}, sqlmock.AnyArg(), sealedArg{}, 1, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(4))
+	mock.ExpectCommit()
+
+	taxpayer := modelgorm.TaxpayerGorm{Owner: "apikey:3", NationalID: testNationalID, Name: testName, Dependents: 1}
+	err := repo.CreateTaxpayer(context.Background(), &taxpayer)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(4), taxpayer.ID)
+	assert.Equal(t, testNationalID, taxpayer.NationalID)
+	assert.Equal(t, testName, taxpayer.Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestFindTaxpayerByNationalID_Repository_BlindIndex(t *testing.T) {
+	keyfile := newKeyfile(t)
+	mockDB, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: mockDB, PreferSimpleProtocol: true}), &gorm.Config{})
+	require.NoError(t, err)
+	keys := newTestKeyring(t, keyfile)
+	repo := NewTaxpayerRepository(db, keys)
+
+	name, err := keys.Encrypt(fieldName, testName)
+	require.NoError(t, err)
+	mock.ExpectQuery(`SELECT \* FROM "taxpayer_gorms" WHERE owner = \$1 AND national_id_index = \$2`).
+		WithArgs("apikey:3", keys.BlindIndex(testNationalID), 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(4, name))
+
+	taxpayer, err := repo.FindTaxpayerByNationalID(context.Background(), "apikey:3", testNationalID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testName, taxpayer.Name)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestTaxpayerRepository_NoPlaintextInDatabase(t *testing.T) {
+	repo := newSQLiteRepository(t, newKeyfile(t))
+	ctx := context.Background()
+
+	taxpayer := modelgorm.TaxpayerGorm{Owner: "apikey:3", NationalID: testNationalID, Name: testName}
+	require.NoError(t, repo.CreateTaxpayer(ctx, &taxpayer))
+	taxpayer.Dependents = 2
+	require.NoError(t, repo.UpdateTaxpayer(ctx, &taxpayer))
+	input := `{"totalIncome":` + testIncome + `,"nationalId":"` + testNationalID + `"}`
+	require.NoError(t, repo.CreateCalculation(ctx, &modelgorm.CalculationGorm{Owner: "apikey:3", TaxpayerID: &taxpayer.ID, Input: input, Output: `{"tax":1}`}))
+	require.NoError(t, repo.CreateBatch(ctx, &modelgorm.CalculationBatchGorm{Rows: 1}, []modelgorm.CalculationGorm{{Owner: "apikey:3", Input: input, Output: input}}))
+
+	assertNoPlaintext(t, repo.DB)
+
+	found, err := repo.FindTaxpayerByNationalID(ctx, "apikey:3", testNationalID)
+	require.NoError(t, err)
+	assert.Equal(t, taxpayer.ID, found.ID)
+	assert.Equal(t, testName, found.Name)
+	assert.Equal(t, 2, found.Dependents)
+
+	calculations, err := repo.ListCalculations(ctx, "apikey:3", taxpayer.ID, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, calculations, 1)
+	assert.Equal(t, input, calculations[0].Input)
+
+	_, err = repo.FindTaxpayerByNationalID(ctx, "apikey:4", testNationalID)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	err = repo.CreateTaxpayer(ctx, &modelgorm.TaxpayerGorm{Owner: "apikey:3", NationalID: testNationalID, Name: "Other"})
+	assert.ErrorIs(t, err, gorm.ErrDuplicatedKey)
+}
+
+func TestFindTaxpayerIDs_Repository(t *testing.T) {
+	repo := newSQLiteRepository(t, newKeyfile(t))
+	ctx := context.Background()
+
+	own := modelgorm.TaxpayerGorm{Owner: "apikey:3", NationalID: testNationalID, Name: testName}
+	require.NoError(t, repo.CreateTaxpayer(ctx, &own))
+	require.NoError(t, repo.CreateTaxpayer(ctx, &modelgorm.TaxpayerGorm{Owner: "apikey:4", NationalID: "3101200123453", Name: testName}))
+
+	ids, err := repo.FindTaxpayerIDs(ctx, "apikey:3", []string{testNationalID, "3101200123453"})
+
+	require.NoError(t, err)
+	assert.Equal(t, map[string]uint{testNationalID: own.ID}, ids)
+}
+
+func TestTaxpayerRepository_Reencrypt(t *testing.T) {
+	keyfile := newKeyfile(t)
+	repo := newSQLiteRepository(t, keyfile)
+	ctx := context.Background()
+
+	taxpayer := modelgorm.TaxpayerGorm{Owner: "apikey:3", NationalID: testNationalID, Name: testName}
+	require.NoError(t, repo.CreateTaxpayer(ctx, &taxpayer))
+	input := `{"totalIncome":` + testIncome + `}`
+	require.NoError(t, repo.CreateCalculation(ctx, &modelgorm.CalculationGorm{Owner: "apikey:3", TaxpayerID: &taxpayer.ID, Input: input, Output: "{}"}))
+	// A row written before encryption was enabled.
+	require.NoError(t, repo.DB.Create(&modelgorm.TaxpayerGorm{Owner: "apikey:3", NationalID: "3101200123453", Name: "Legacy"}).Error)
+
+	require.NoError(t, keyfile.Rotate("k2"))
+	repo.Keys = newTestKeyring(t, keyfile)
+	rewritten, err := repo.Reencrypt(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, rewritten)
+
+	for _, table := range []string{"taxpayer_gorms", "calculation_gorms"} {
+		for _, row := range rawRows(t, repo.DB, table) {
+			for _, value := range row {
+				assert.False(t, strings.HasPrefix(value, "enc:v1:k1:"), "%s still uses the old key", table)
+			}
+		}
+	}
+	assertNoPlaintext(t, repo.DB)
+
+	legacy, err := repo.FindTaxpayerByNationalID(ctx, "apikey:3", "3101200123453")
+	require.NoError(t, err)
+	assert.Equal(t, "Legacy", legacy.Name)
+
+	delete(keyfile.Keys, "k1")
+	repo.Keys = newTestKeyring(t, keyfile)
+	calculations, err := repo.ListCalculations(ctx, "apikey:3", taxpayer.ID, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, input, calculations[0].Input)
+
+	rewritten, err = repo.Reencrypt(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, rewritten)
+}
+
+func TestCreateBatch_Repository(t *testing.T) {
+	repo, mock := newMockRepository(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "calculation_batch_gorms"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+	mock.ExpectQuery(`INSERT INTO "calculation_gorms" .* VALUES \(.*\),\(.*\)`).
+		WithArgs("", nil, 5, "", sealedArg{}, sealedArg{}, 0.0, 0, sqlmock.AnyArg(), "", nil, 5, "", sealedArg{}, sealedArg{}, 0.0, 0, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2))
+	mock.ExpectCommit()
+
+	input := `{"totalIncome":` + testIncome + `}`
+	calculations := []modelgorm.CalculationGorm{{Input: input, Output: "{}", CreatedAt: now}, {Input: input, Output: "{}", CreatedAt: now}}
+	err := repo.CreateBatch(context.Background(), &modelgorm.CalculationBatchGorm{Rows: 2, CreatedAt: now}, calculations)
+
+	assert.NoError(t, err)
+	assert.Equal(t, uint(5), *calculations[1].BatchID)
+	assert.Equal(t, uint(2), calculations[1].ID)
+	assert.Equal(t, input, calculations[1].Input)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetCalculation_Repository_ScopedToOwner(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "calculation_gorms" WHERE id = \$1 AND owner = \$2 AND taxpayer_id = \$3`).
+		WithArgs(9, "apikey:3", 4, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := repo.GetCalculation(context.Background(), "apikey:3", 4, 9)
+
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestGetCalculationByID_Repository(t *testing.T) {
+	repo, mock := newMockRepository(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "calculation_gorms" WHERE "calculation_gorms"\."id" = \$1 ORDER BY "calculation_gorms"\."id" LIMIT \$2`).
+		WithArgs(9, 1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "owner", "config_version"}).AddRow(9, "apikey:3", 2))
+
+	calculation, err := repo.GetCalculationByID(context.Background(), 9)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "apikey:3", calculation.Owner)
+	assert.Equal(t, int64(2), calculation.ConfigVersion)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
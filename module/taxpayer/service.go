@@ -0,0 +1,361 @@
+package taxpayer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/health"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/store/model"
+	"github.com/pphee/assessment-tax/utils"
+	"gorm.io/gorm"
+	"strings"
+	"time"
+)
+
+const (
+	maxDependents = 100
+
+	defaultListLimit = 50
+	maxListLimit     = 500
+)
+
+var (
+	ErrInvalidTaxpayer     = errors.New("invalid taxpayer")
+	ErrTaxpayerExists      = errors.New("taxpayer already exists")
+	ErrCalculationNotFound = errors.New("calculation not found")
+)
+
+type TaxpayerServices interface {
+	CreateTaxpayer(ctx context.Context, owner string, req model.TaxpayerRequest) (model.Taxpayer, error)
+	GetTaxpayer(ctx context.Context, owner string, id uint) (model.Taxpayer, error)
+	FindTaxpayer(ctx context.Context, owner, nationalID string) (model.Taxpayer, error)
+	UpdateTaxpayer(ctx context.Context, owner string, id uint, req model.TaxpayerRequest) (model.Taxpayer, error)
+	ListCalculations(ctx context.Context, owner string, taxpayerID uint, limit, offset int) ([]model.Calculation, error)
+	GetCalculation(ctx context.Context, owner string, taxpayerID, id uint) (model.Calculation, error)
+	DeleteCalculation(ctx context.Context, owner string, taxpayerID, id uint) error
+}
+
+type TaxpayerService struct {
+	Repo TaxpayerRepositories
+}
+
+func NewTaxpayerService(repo TaxpayerRepositories) TaxpayerServices {
+	return &TaxpayerService{Repo: repo}
+}
+
+func toTaxpayer(taxpayer modelgorm.TaxpayerGorm) model.Taxpayer {
+	return model.Taxpayer{
+		ID:         taxpayer.ID,
+		NationalID: taxpayer.NationalID,
+		Name:       taxpayer.Name,
+		Dependents: taxpayer.Dependents,
+		CreatedAt:  taxpayer.CreatedAt,
+		UpdatedAt:  taxpayer.UpdatedAt,
+	}
+}
+
+// toCalculation leaves Input nil when it has been purged under the
+// retention policy.
+func toCalculation(calculation modelgorm.CalculationGorm) model.Calculation {
+	result := model.Calculation{
+		ID:            calculation.ID,
+		TaxpayerID:    calculation.TaxpayerID,
+		BatchID:       calculation.BatchID,
+		Output:        json.RawMessage(calculation.Output),
+		ConfigVersion: calculation.ConfigVersion,
+		CreatedAt:     calculation.CreatedAt,
+	}
+	if calculation.Input != "" {
+		result.Input = json.RawMessage(calculation.Input)
+	}
+	return result
+}
+
+func validateTaxpayer(req model.TaxpayerRequest) (model.TaxpayerRequest, error) {
+	req.NationalID = utils.NormalizeNationalID(req.NationalID)
+	req.Name = strings.TrimSpace(req.Name)
+
+	// The error names the first field at fault and describes them all
+	var fields, problems []string
+	var messages []i18n.Message
+	if !utils.ValidNationalID(req.NationalID) {
+		fields = append(fields, "nationalId")
+		problems = append(problems, "nationalId must be 13 digits with a valid check digit")
+		messages = append(messages, i18n.Msg("validation.invalid_national_id"))
+	}
+	if req.Name == "" || len(req.Name) > 200 {
+		fields = append(fields, "name")
+		problems = append(problems, "name must be 1-200 characters")
+		messages = append(messages, i18n.Msg("validation.name_length"))
+	}
+	if req.Dependents < 0 || req.Dependents > maxDependents {
+		fields = append(fields, "dependents")
+		problems = append(problems, fmt.Sprintf("dependents must be between 0 and %d", maxDependents))
+		messages = append(messages, i18n.Msg("validation.dependents_range", maxDependents))
+	}
+	if len(problems) > 0 {
+		return req, &tax.FieldError{
+			Field:   fields[0],
+			Err:     fmt.Errorf("%w: %s", ErrInvalidTaxpayer, strings.Join(problems, "; ")),
+			Message: i18n.Msg("validation.invalid_taxpayer", messages),
+		}
+	}
+	return req, nil
+}
+
+func (service *TaxpayerService) CreateTaxpayer(ctx context.Context, owner string, req model.TaxpayerRequest) (model.Taxpayer, error) {
+	req, err := validateTaxpayer(req)
+	if err != nil {
+		return model.Taxpayer{}, err
+	}
+
+	taxpayer := modelgorm.TaxpayerGorm{
+		Owner:      owner,
+		NationalID: req.NationalID,
+		Name:       req.Name,
+		Dependents: req.Dependents,
+	}
+	err = service.Repo.CreateTaxpayer(ctx, &taxpayer)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return model.Taxpayer{}, ErrTaxpayerExists
+	}
+	if err != nil {
+		return model.Taxpayer{}, fmt.Errorf("failed to create taxpayer: %w", err)
+	}
+	return toTaxpayer(taxpayer), nil
+}
+
+func (service *TaxpayerService) getTaxpayer(ctx context.Context, owner string, id uint) (modelgorm.TaxpayerGorm, error) {
+	taxpayer, err := service.Repo.GetTaxpayer(ctx, owner, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return modelgorm.TaxpayerGorm{}, fmt.Errorf("%w: %d", tax.ErrTaxpayerNotFound, id)
+	}
+	if err != nil {
+		return modelgorm.TaxpayerGorm{}, fmt.Errorf("failed to get taxpayer: %w", err)
+	}
+	return taxpayer, nil
+}
+
+func (service *TaxpayerService) GetTaxpayer(ctx context.Context, owner string, id uint) (model.Taxpayer, error) {
+	taxpayer, err := service.getTaxpayer(ctx, owner, id)
+	if err != nil {
+		return model.Taxpayer{}, err
+	}
+	return toTaxpayer(taxpayer), nil
+}
+
+func (service *TaxpayerService) FindTaxpayer(ctx context.Context, owner, nationalID string) (model.Taxpayer, error) {
+	nationalID = utils.NormalizeNationalID(nationalID)
+	if !utils.ValidNationalID(nationalID) {
+		return model.Taxpayer{}, &tax.FieldError{Field: "nationalId", Err: fmt.Errorf("%w: nationalId must be 13 digits with a valid check digit", ErrInvalidTaxpayer)}
+	}
+	taxpayer, err := service.Repo.FindTaxpayerByNationalID(ctx, owner, nationalID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.Taxpayer{}, tax.ErrTaxpayerNotFound
+	}
+	if err != nil {
+		return model.Taxpayer{}, fmt.Errorf("failed to find taxpayer: %w", err)
+	}
+	return toTaxpayer(taxpayer), nil
+}
+
+func (service *TaxpayerService) UpdateTaxpayer(ctx context.Context, owner string, id uint, req model.TaxpayerRequest) (model.Taxpayer, error) {
+	req, err := validateTaxpayer(req)
+	if err != nil {
+		return model.Taxpayer{}, err
+	}
+	taxpayer, err := service.getTaxpayer(ctx, owner, id)
+	if err != nil {
+		return model.Taxpayer{}, err
+	}
+
+	taxpayer.NationalID = req.NationalID
+	taxpayer.Name = req.Name
+	taxpayer.Dependents = req.Dependents
+	err = service.Repo.UpdateTaxpayer(ctx, &taxpayer)
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return model.Taxpayer{}, ErrTaxpayerExists
+	}
+	if err != nil {
+		return model.Taxpayer{}, fmt.Errorf("failed to update taxpayer: %w", err)
+	}
+	return toTaxpayer(taxpayer), nil
+}
+
+func (service *TaxpayerService) ListCalculations(ctx context.Context, owner string, taxpayerID uint, limit, offset int) ([]model.Calculation, error) {
+	if limit <= 0 {
+		limit = defaultListLimit
+	}
+	if limit > maxListLimit {
+		limit = maxListLimit
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	if _, err := service.getTaxpayer(ctx, owner, taxpayerID); err != nil {
+		return nil, err
+	}
+
+	calculations, err := service.Repo.ListCalculations(ctx, owner, taxpayerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list calculations: %w", err)
+	}
+	result := make([]model.Calculation, len(calculations))
+	for i, calculation := range calculations {
+		result[i] = toCalculation(calculation)
+	}
+	return result, nil
+}
+
+func (service *TaxpayerService) GetCalculation(ctx context.Context, owner string, taxpayerID, id uint) (model.Calculation, error) {
+	calculation, err := service.Repo.GetCalculation(ctx, owner, taxpayerID, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return model.Calculation{}, fmt.Errorf("%w: %d", ErrCalculationNotFound, id)
+	}
+	if err != nil {
+		return model.Calculation{}, fmt.Errorf("failed to get calculation: %w", err)
+	}
+	return toCalculation(calculation), nil
+}
+
+func (service *TaxpayerService) DeleteCalculation(ctx context.Context, owner string, taxpayerID, id uint) error {
+	deleted, err := service.Repo.DeleteCalculation(ctx, owner, taxpayerID, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete calculation: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w: %d", ErrCalculationNotFound, id)
+	}
+	return nil
+}
+
+// RecordingTaxService stores every calculation made through the wrapped
+// service. A calculation that cannot be stored fails, so the history has no
+// gaps, except while Health is degraded: calculations are then served
+// without being stored, with a not_recorded warning.
+type RecordingTaxService struct {
+	tax.TaxServices
+	Repo   TaxpayerRepositories
+	Health *health.Monitor
+	now    func() time.Time
+}
+
+func NewRecordingTaxService(inner tax.TaxServices, repo TaxpayerRepositories, monitor *health.Monitor) tax.TaxServices {
+	return &RecordingTaxService{TaxServices: inner, Repo: repo, Health: monitor, now: time.Now}
+}
+
+func (service *RecordingTaxService) CalculateTax(ctx context.Context, req model.TaxRequest) (model.TaxResponse, error) {
+	degraded := service.Health.Degraded()
+	var taxpayerID *uint
+	if req.TaxpayerID != 0 {
+		if degraded {
+			return model.TaxResponse{}, fmt.Errorf("%w: taxpayer profiles cannot be read", tax.ErrDatabaseUnavailable)
+		}
+		_, err := service.Repo.GetTaxpayer(ctx, req.Owner, req.TaxpayerID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return model.TaxResponse{}, fmt.Errorf("%w: %d", tax.ErrTaxpayerNotFound, req.TaxpayerID)
+		}
+		if err != nil {
+			return model.TaxResponse{}, fmt.Errorf("failed to get taxpayer: %w", err)
+		}
+		taxpayerID = &req.TaxpayerID
+	}
+
+	res, err := service.TaxServices.CalculateTax(ctx, req)
+	if err != nil {
+		return res, err
+	}
+	if degraded {
+		res.Warnings = append(res.Warnings, model.Warning{Code: tax.WarningNotRecorded})
+		return res, nil
+	}
+
+	calculation, err := newCalculation(req.Owner, taxpayerID, req.NationalID, req, res, res.Tax, res.ConfigVersion, service.now())
+	if err != nil {
+		return model.TaxResponse{}, err
+	}
+	if err := service.Repo.CreateCalculation(ctx, &calculation); err != nil {
+		return model.TaxResponse{}, fmt.Errorf("failed to store calculation: %w", err)
+	}
+	return res, nil
+}
+
+func (service *RecordingTaxService) CalculateBatch(ctx context.Context, records []model.TotalIncomeCsv) (model.TaxResponseCSV, error) {
+	res, err := service.TaxServices.CalculateBatch(ctx, records)
+	if err != nil {
+		return res, err
+	}
+	if service.Health.Degraded() {
+		res.Warnings = append(res.Warnings, model.Warning{Code: tax.WarningNotRecorded})
+		return res, nil
+	}
+
+	taxpayers, err := service.batchTaxpayers(ctx, records)
+	if err != nil {
+		return model.TaxResponseCSV{}, err
+	}
+	now := service.now()
+	calculations := make([]modelgorm.CalculationGorm, len(records))
+	for i, record := range records {
+		var taxpayerID *uint
+		if id, ok := taxpayers[record.Owner][record.NationalID]; ok {
+			taxpayerID = &id
+		}
+		calculations[i], err = newCalculation(record.Owner, taxpayerID, record.NationalID, record, res.Taxes[i], res.Taxes[i].Tax, res.ConfigVersion, now)
+		if err != nil {
+			return model.TaxResponseCSV{}, err
+		}
+	}
+	batch := modelgorm.CalculationBatchGorm{Rows: len(records), ConfigVersion: res.ConfigVersion, CreatedAt: now}
+	if err := service.Repo.CreateBatch(ctx, &batch, calculations); err != nil {
+		return model.TaxResponseCSV{}, fmt.Errorf("failed to store calculations: %w", err)
+	}
+	return res, nil
+}
+
+// batchTaxpayers returns, by owner and national ID, the profiles the rows
+// of a batch are filed under: those their owner keeps for their national
+// ID. Anonymous rows and rows without a national ID have none.
+func (service *RecordingTaxService) batchTaxpayers(ctx context.Context, records []model.TotalIncomeCsv) (map[string]map[string]uint, error) {
+	nationalIDs := make(map[string][]string)
+	for _, record := range records {
+		if record.Owner != "" && record.NationalID != "" {
+			nationalIDs[record.Owner] = append(nationalIDs[record.Owner], record.NationalID)
+		}
+	}
+
+	taxpayers := make(map[string]map[string]uint, len(nationalIDs))
+	for owner, ids := range nationalIDs {
+		found, err := service.Repo.FindTaxpayerIDs(ctx, owner, ids)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find taxpayers: %w", err)
+		}
+		taxpayers[owner] = found
+	}
+	return taxpayers, nil
+}
+
+func newCalculation(owner string, taxpayerID *uint, nationalID string, input, output interface{}, taxDue float64, version int64, at time.Time) (modelgorm.CalculationGorm, error) {
+	encodedInput, err := json.Marshal(input)
+	if err != nil {
+		return modelgorm.CalculationGorm{}, fmt.Errorf("failed to encode calculation input: %w", err)
+	}
+	encodedOutput, err := json.Marshal(output)
+	if err != nil {
+		return modelgorm.CalculationGorm{}, fmt.Errorf("failed to encode calculation output: %w", err)
+	}
+	return modelgorm.CalculationGorm{
+		Owner:         owner,
+		TaxpayerID:    taxpayerID,
+		NationalID:    nationalID,
+		Input:         string(encodedInput),
+		Output:        string(encodedOutput),
+		Tax:           taxDue,
+		ConfigVersion: version,
+		CreatedAt:     at,
+	}, nil
+}
@@ -0,0 +1,321 @@
+package taxpayer
+
+import (
+	"context"
+	"errors"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/health"
+	"github.com/pphee/assessment-tax/module/tax"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+type MockRepo struct {
+	mock.Mock
+}
+
+func (m *MockRepo) CreateTaxpayer(ctx context.Context, taxpayer *modelgorm.TaxpayerGorm) error {
+	args := m.Called(taxpayer)
+	return args.Error(0)
+}
+
+func (m *MockRepo) GetTaxpayer(ctx context.Context, owner string, id uint) (modelgorm.TaxpayerGorm, error) {
+	args := m.Called(owner, id)
+	return args.Get(0).(modelgorm.TaxpayerGorm), args.Error(1)
+}
+
+func (m *MockRepo) FindTaxpayerByNationalID(ctx context.Context, owner, nationalID string) (modelgorm.TaxpayerGorm, error) {
+	args := m.Called(owner, nationalID)
+	return args.Get(0).(modelgorm.TaxpayerGorm), args.Error(1)
+}
+
+func (m *MockRepo) GetSubjectData(ctx context.Context, nationalID string) (SubjectData, error) {
+	args := m.Called(nationalID)
+	return args.Get(0).(SubjectData), args.Error(1)
+}
+
+func (m *MockRepo) EraseSubject(ctx context.Context, nationalID string, audit func(erased model.SubjectErasure) (modelgorm.AuditEventGorm, error)) (model.SubjectErasure, error) {
+	args := m.Called(nationalID)
+	erased := args.Get(0).(model.SubjectErasure)
+	if args.Error(1) != nil {
+		return model.SubjectErasure{}, args.Error(1)
+	}
+	if _, err := audit(erased); err != nil {
+		return model.SubjectErasure{}, err
+	}
+	return erased, nil
+}
+
+func (m *MockRepo) SubjectReference(nationalID string) string {
+	return "ref:" + nationalID[len(nationalID)-4:]
+}
+
+func (m *MockRepo) UpdateTaxpayer(ctx context.Context, taxpayer *modelgorm.TaxpayerGorm) error {
+	args := m.Called(taxpayer)
+	return args.Error(0)
+}
+
+func (m *MockRepo) CreateCalculation(ctx context.Context, calculation *modelgorm.CalculationGorm) error {
+	args := m.Called(calculation)
+	return args.Error(0)
+}
+
+func (m *MockRepo) FindTaxpayerIDs(ctx context.Context, owner string, nationalIDs []string) (map[string]uint, error) {
+	args := m.Called(owner, nationalIDs)
+	return args.Get(0).(map[string]uint), args.Error(1)
+}
+
+func (m *MockRepo) CreateBatch(ctx context.Context, batch *modelgorm.CalculationBatchGorm, calculations []modelgorm.CalculationGorm) error {
+	args := m.Called(batch, calculations)
+	return args.Error(0)
+}
+
+func (m *MockRepo) ListCalculations(ctx context.Context, owner string, taxpayerID uint, limit, offset int) ([]modelgorm.CalculationGorm, error) {
+	args := m.Called(owner, taxpayerID, limit, offset)
+	return args.Get(0).([]modelgorm.CalculationGorm), args.Error(1)
+}
+
+func (m *MockRepo) GetCalculation(ctx context.Context, owner string, taxpayerID, id uint) (modelgorm.CalculationGorm, error) {
+	args := m.Called(owner, taxpayerID, id)
+	return args.Get(0).(modelgorm.CalculationGorm), args.Error(1)
+}
+
+func (m *MockRepo) DeleteCalculation(ctx context.Context, owner string, taxpayerID, id uint) (int64, error) {
+	args := m.Called(owner, taxpayerID, id)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockRepo) GetCalculationByID(ctx context.Context, id uint) (modelgorm.CalculationGorm, error) {
+	args := m.Called(id)
+	return args.Get(0).(modelgorm.CalculationGorm), args.Error(1)
+}
+
+// MockTaxService only implements the calculations the recorder and
+// recompute service use.
+type MockTaxService struct {
+	tax.TaxServices
+	mock.Mock
+}
+
+func (m *MockTaxService) CalculateTax(ctx context.Context, req model.TaxRequest) (model.TaxResponse, error) {
+	args := m.Called(req)
+	return args.Get(0).(model.TaxResponse), args.Error(1)
+}
+
+func (m *MockTaxService) CalculateBatch(ctx context.Context, records []model.TotalIncomeCsv) (model.TaxResponseCSV, error) {
+	args := m.Called(records)
+	return args.Get(0).(model.TaxResponseCSV), args.Error(1)
+}
+
+func (m *MockTaxService) ConfigVersionAt(ctx context.Context, asOf time.Time) (int64, error) {
+	args := m.Called(asOf)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (m *MockTaxService) CalculateTaxAt(ctx context.Context, req model.TaxRequest, version int64) (model.TaxResponse, error) {
+	args := m.Called(req, version)
+	return args.Get(0).(model.TaxResponse), args.Error(1)
+}
+
+func (m *MockTaxService) CalculateBatchAt(ctx context.Context, records []model.TotalIncomeCsv, version int64) (model.TaxResponseCSV, error) {
+	args := m.Called(records, version)
+	return args.Get(0).(model.TaxResponseCSV), args.Error(1)
+}
+
+var testNow = time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func newRecorder(inner *MockTaxService, repo *MockRepo) *RecordingTaxService {
+	return &RecordingTaxService{TaxServices: inner, Repo: repo, now: func() time.Time { return testNow }}
+}
+
+func TestRecordingTaxService_CalculateTax(t *testing.T) {
+	req := model.TaxRequest{TotalIncome: 500000, TaxpayerID: 4, Owner: "apikey:3"}
+	res := model.TaxResponse{Tax: 29000, ConfigVersion: 7}
+
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetTaxpayer", "apikey:3", uint(4)).Return(modelgorm.TaxpayerGorm{ID: 4}, nil)
+	mockRepo.On("CreateCalculation", mock.MatchedBy(func(c *modelgorm.CalculationGorm) bool {
+		return c.Owner == "apikey:3" && *c.TaxpayerID == 4 && c.ConfigVersion == 7 && c.Tax == 29000 &&
+			c.Input == `{"totalIncome":500000,"wht":0,"allowances":null,"taxpayerId":4}` &&
+			c.Output == `{"tax":29000.0,"taxLevel":[],"configVersion":7}` && c.CreatedAt.Equal(testNow)
+	})).Return(nil)
+	mockTax := new(MockTaxService)
+	mockTax.On("CalculateTax", req).Return(model.TaxResponse{Tax: 29000, TaxLevels: []model.TaxBracket{}, ConfigVersion: 7}, nil)
+
+	got, err := newRecorder(mockTax, mockRepo).CalculateTax(context.Background(), req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, res.Tax, got.Tax)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRecordingTaxService_CalculateTax_UnknownTaxpayer(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetTaxpayer", "", uint(4)).Return(modelgorm.TaxpayerGorm{}, gorm.ErrRecordNotFound)
+	mockTax := new(MockTaxService)
+
+	_, err := newRecorder(mockTax, mockRepo).CalculateTax(context.Background(), model.TaxRequest{TaxpayerID: 4})
+
+	assert.ErrorIs(t, err, tax.ErrTaxpayerNotFound)
+	mockTax.AssertNotCalled(t, "CalculateTax", mock.Anything)
+}
+
+func TestRecordingTaxService_CalculateTax_StoreFailure(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("CreateCalculation", mock.Anything).Return(errors.New("disk full"))
+	mockTax := new(MockTaxService)
+	mockTax.On("CalculateTax", mock.Anything).Return(model.TaxResponse{Tax: 1}, nil)
+
+	_, err := newRecorder(mockTax, mockRepo).CalculateTax(context.Background(), model.TaxRequest{TotalIncome: 500000})
+
+	assert.ErrorContains(t, err, "failed to store calculation")
+}
+
+func TestRecordingTaxService_CalculateBatch(t *testing.T) {
+	records := []model.TotalIncomeCsv{
+		{TotalIncome: 500000, WHT: 25000, NationalID: "1101700203450", Owner: "apikey:3"},
+		{TotalIncome: 600000, Donation: 20000, Owner: "apikey:3"},
+		{TotalIncome: 700000, NationalID: "3101200123453", Owner: "apikey:3"},
+	}
+	res := model.TaxResponseCSV{Taxes: []model.TaxDetail{{TotalIncome: 500000, Tax: 4000}, {TotalIncome: 600000, Tax: 39000}, {TotalIncome: 700000, Tax: 54000}}, ConfigVersion: 7}
+
+	mockRepo := new(MockRepo)
+	mockRepo.On("FindTaxpayerIDs", "apikey:3", []string{"1101700203450", "3101200123453"}).Return(map[string]uint{"1101700203450": 4}, nil)
+	mockRepo.On("CreateBatch", mock.MatchedBy(func(b *modelgorm.CalculationBatchGorm) bool {
+		return b.Rows == 3 && b.ConfigVersion == 7
+	}), mock.MatchedBy(func(calculations []modelgorm.CalculationGorm) bool {
+		for _, c := range calculations {
+			if c.Owner != "apikey:3" {
+				return false
+			}
+		}
+		return len(calculations) == 3 && calculations[1].Tax == 39000 &&
+			calculations[1].Input == `{"totalIncome":600000,"wht":0,"donation":20000}` &&
+			calculations[0].TaxpayerID != nil && *calculations[0].TaxpayerID == 4 &&
+			calculations[1].TaxpayerID == nil && calculations[2].TaxpayerID == nil
+	})).Return(nil)
+	mockTax := new(MockTaxService)
+	mockTax.On("CalculateBatch", records).Return(res, nil)
+
+	got, err := newRecorder(mockTax, mockRepo).CalculateBatch(context.Background(), records)
+
+	assert.NoError(t, err)
+	assert.Equal(t, res, got)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestRecordingTaxService_Degraded(t *testing.T) {
+	monitor := health.NewMonitor(nil)
+	monitor.MarkDown()
+	mockRepo := new(MockRepo)
+	mockTax := new(MockTaxService)
+	mockTax.On("CalculateTax", mock.Anything).Return(model.TaxResponse{Tax: 29000}, nil)
+	mockTax.On("CalculateBatch", mock.Anything).Return(model.TaxResponseCSV{Taxes: []model.TaxDetail{{Tax: 4000}}}, nil)
+	recorder := newRecorder(mockTax, mockRepo)
+	recorder.Health = monitor
+
+	got, err := recorder.CalculateTax(context.Background(), model.TaxRequest{TotalIncome: 500000})
+	assert.NoError(t, err)
+	assert.Equal(t, 29000.0, got.Tax)
+	assert.Equal(t, []model.Warning{{Code: tax.WarningNotRecorded}}, got.Warnings)
+
+	batch, err := recorder.CalculateBatch(context.Background(), []model.TotalIncomeCsv{{TotalIncome: 500000}})
+	assert.NoError(t, err)
+	assert.Len(t, batch.Taxes, 1)
+	assert.Equal(t, []model.Warning{{Code: tax.WarningNotRecorded}}, batch.Warnings)
+
+	_, err = recorder.CalculateTax(context.Background(), model.TaxRequest{TotalIncome: 500000, TaxpayerID: 4})
+	assert.ErrorIs(t, err, tax.ErrDatabaseUnavailable)
+
+	mockRepo.AssertNotCalled(t, "CreateCalculation", mock.Anything)
+	mockRepo.AssertNotCalled(t, "CreateBatch", mock.Anything, mock.Anything)
+	mockRepo.AssertNotCalled(t, "GetTaxpayer", mock.Anything, mock.Anything)
+}
+
+func TestCreateTaxpayer(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     model.TaxpayerRequest
+		repoErr error
+		want    error
+	}{
+		{"Valid", model.TaxpayerRequest{NationalID: "1234567890121", Name: " Somchai ", Dependents: 2}, nil, nil},
+		{"Bad National ID", model.TaxpayerRequest{NationalID: "12345", Name: "Somchai"}, nil, ErrInvalidTaxpayer},
+		{"Bad Check Digit", model.TaxpayerRequest{NationalID: "1234567890123", Name: "Somchai"}, nil, ErrInvalidTaxpayer},
+		{"Dashed National ID", model.TaxpayerRequest{NationalID: "1-2345-67890-12-1", Name: "Somchai"}, nil, nil},
+		{"Negative Dependents", model.TaxpayerRequest{NationalID: "1234567890121", Name: "Somchai", Dependents: -1}, nil, ErrInvalidTaxpayer},
+		{"Duplicate", model.TaxpayerRequest{NationalID: "1234567890121", Name: "Somchai"}, gorm.ErrDuplicatedKey, ErrTaxpayerExists},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			mockRepo.On("CreateTaxpayer", mock.Anything).Return(tt.repoErr)
+
+			taxpayer, err := NewTaxpayerService(mockRepo).CreateTaxpayer(context.Background(), "apikey:3", tt.req)
+
+			if tt.want != nil {
+				assert.ErrorIs(t, err, tt.want)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "Somchai", taxpayer.Name)
+		})
+	}
+}
+
+func TestFindTaxpayer(t *testing.T) {
+	tests := []struct {
+		name       string
+		nationalID string
+		repoErr    error
+		want       error
+	}{
+		{"Found", "1-1017-00203-45-0", nil, nil},
+		{"Not Found", "1101700203450", gorm.ErrRecordNotFound, tax.ErrTaxpayerNotFound},
+		{"Invalid", "1101700203451", nil, ErrInvalidTaxpayer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			mockRepo.On("FindTaxpayerByNationalID", "apikey:3", "1101700203450").
+				Return(modelgorm.TaxpayerGorm{ID: 4, NationalID: "1101700203450", Name: "Somchai"}, tt.repoErr)
+
+			taxpayer, err := NewTaxpayerService(mockRepo).FindTaxpayer(context.Background(), "apikey:3", tt.nationalID)
+
+			if tt.want != nil {
+				assert.ErrorIs(t, err, tt.want)
+				assert.NotContains(t, err.Error(), tt.nationalID)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, uint(4), taxpayer.ID)
+		})
+	}
+}
+
+func TestListCalculations(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetTaxpayer", "apikey:3", uint(4)).Return(modelgorm.TaxpayerGorm{ID: 4}, nil)
+	mockRepo.On("ListCalculations", "apikey:3", uint(4), defaultListLimit, 0).
+		Return([]modelgorm.CalculationGorm{{ID: 9, Input: `{}`, Output: `{"tax":1.0}`, ConfigVersion: 7}}, nil)
+
+	calculations, err := NewTaxpayerService(mockRepo).ListCalculations(context.Background(), "apikey:3", 4, 0, 0)
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"tax":1.0}`, string(calculations[0].Output))
+}
+
+func TestDeleteCalculation_NotFound(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("DeleteCalculation", "apikey:3", uint(4), uint(9)).Return(int64(0), nil)
+
+	err := NewTaxpayerService(mockRepo).DeleteCalculation(context.Background(), "apikey:3", 4, 9)
+
+	assert.ErrorIs(t, err, ErrCalculationNotFound)
+}
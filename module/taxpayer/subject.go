@@ -0,0 +1,178 @@
+package taxpayer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/audit"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/store/model"
+	"github.com/pphee/assessment-tax/utils"
+	"time"
+)
+
+const (
+	ExportJSON = "json"
+	ExportZIP  = "zip"
+
+	maxReferenceLength = 200
+)
+
+type SubjectServices interface {
+	ExportSubject(ctx context.Context, actor string, req model.SubjectRequest) (model.SubjectExport, error)
+	EraseSubject(ctx context.Context, actor string, req model.SubjectRequest) (model.SubjectErasure, error)
+}
+
+// SubjectService answers PDPA access and erasure requests for the person
+// behind a national ID. Every request is recorded in the audit log under
+// the ID's blind index, never the ID itself.
+type SubjectService struct {
+	Repo  TaxpayerRepositories
+	Audit audit.AuditServices
+	now   func() time.Time
+}
+
+func NewSubjectService(repo TaxpayerRepositories, auditService audit.AuditServices) SubjectServices {
+	return &SubjectService{Repo: repo, Audit: auditService, now: time.Now}
+}
+
+func validateSubjectRequest(req model.SubjectRequest) (model.SubjectRequest, error) {
+	req.NationalID = utils.NormalizeNationalID(req.NationalID)
+	if !utils.ValidNationalID(req.NationalID) {
+		return req, &tax.FieldError{Field: "nationalId", Err: fmt.Errorf("%w: nationalId must be 13 digits with a valid check digit", ErrInvalidTaxpayer)}
+	}
+	if len(req.Reference) > maxReferenceLength {
+		return req, &tax.FieldError{Field: "reference", Err: fmt.Errorf("%w: reference must be at most %d characters", ErrInvalidTaxpayer, maxReferenceLength)}
+	}
+	return req, nil
+}
+
+func subjectAuditDetail(req model.SubjectRequest, erased *model.SubjectErasure) map[string]interface{} {
+	detail := map[string]interface{}{}
+	if req.Reference != "" {
+		detail["reference"] = req.Reference
+	}
+	if req.Format != "" {
+		detail["format"] = req.Format
+	}
+	if erased != nil {
+		detail["profiles"] = erased.Profiles
+		detail["calculations"] = erased.Calculations
+	}
+	return detail
+}
+
+// ExportSubject collects what is held about the subject and records the
+// export. Nothing is returned unless the export has been audited.
+func (service *SubjectService) ExportSubject(ctx context.Context, actor string, req model.SubjectRequest) (model.SubjectExport, error) {
+	req, err := validateSubjectRequest(req)
+	if err != nil {
+		return model.SubjectExport{}, err
+	}
+	if req.Format == "" {
+		req.Format = ExportJSON
+	}
+	if req.Format != ExportJSON && req.Format != ExportZIP {
+		return model.SubjectExport{}, &tax.FieldError{Field: "format", Err: fmt.Errorf("%w: format must be %q or %q", ErrInvalidTaxpayer, ExportJSON, ExportZIP)}
+	}
+
+	data, err := service.Repo.GetSubjectData(ctx, req.NationalID)
+	if err != nil {
+		return model.SubjectExport{}, fmt.Errorf("failed to collect subject data: %w", err)
+	}
+	export := model.SubjectExport{
+		GeneratedAt:  service.now(),
+		Profiles:     make([]model.SubjectProfile, len(data.Taxpayers)),
+		Calculations: make([]model.Calculation, len(data.Calculations)),
+		Batches:      make([]model.SubjectBatch, len(data.Batches)),
+		AuditEvents:  make([]model.AuditEvent, len(data.AuditEvents)),
+	}
+	for i, taxpayer := range data.Taxpayers {
+		export.Profiles[i] = model.SubjectProfile{Taxpayer: toTaxpayer(taxpayer), Owner: taxpayer.Owner}
+	}
+	for i, calculation := range data.Calculations {
+		export.Calculations[i] = toCalculation(calculation)
+	}
+	for i, batch := range data.Batches {
+		export.Batches[i] = model.SubjectBatch{ID: batch.ID, Rows: batch.Rows, ConfigVersion: batch.ConfigVersion, CreatedAt: batch.CreatedAt}
+	}
+	for i, event := range data.AuditEvents {
+		export.AuditEvents[i] = toAuditEvent(event)
+	}
+
+	err = service.Audit.Record(actor, "data_subject.exported", subjectAuditEntity, service.Repo.SubjectReference(req.NationalID), subjectAuditDetail(req, nil))
+	if err != nil {
+		return model.SubjectExport{}, err
+	}
+	return export, nil
+}
+
+// EraseSubject deletes the subject's profiles and anonymizes their
+// calculations; see TaxpayerRepository.EraseSubject.
+func (service *SubjectService) EraseSubject(ctx context.Context, actor string, req model.SubjectRequest) (model.SubjectErasure, error) {
+	req, err := validateSubjectRequest(req)
+	if err != nil {
+		return model.SubjectErasure{}, err
+	}
+	req.Format = ""
+
+	erased, err := service.Repo.EraseSubject(ctx, req.NationalID, func(erased model.SubjectErasure) (modelgorm.AuditEventGorm, error) {
+		return audit.NewEvent(actor, "data_subject.erased", subjectAuditEntity, "", subjectAuditDetail(req, &erased))
+	})
+	if err != nil {
+		return model.SubjectErasure{}, fmt.Errorf("failed to erase subject data: %w", err)
+	}
+	return erased, nil
+}
+
+func toAuditEvent(event modelgorm.AuditEventGorm) model.AuditEvent {
+	return model.AuditEvent{
+		ID:        event.ID,
+		Actor:     event.Actor,
+		Action:    event.Action,
+		Entity:    event.Entity,
+		EntityID:  event.EntityID,
+		Detail:    event.Detail,
+		CreatedAt: event.CreatedAt,
+	}
+}
+
+// ZipExport packs an export as one JSON file per section.
+func ZipExport(export model.SubjectExport) ([]byte, error) {
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"manifest.json", map[string]interface{}{
+			"generatedAt":  export.GeneratedAt,
+			"profiles":     len(export.Profiles),
+			"calculations": len(export.Calculations),
+			"batches":      len(export.Batches),
+			"auditEvents":  len(export.AuditEvents),
+		}},
+		{"profiles.json", export.Profiles},
+		{"calculations.json", export.Calculations},
+		{"batches.json", export.Batches},
+		{"audit-events.json", export.AuditEvents},
+	}
+	for _, file := range files {
+		w, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: export.GeneratedAt})
+		if err != nil {
+			return nil, err
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.content); err != nil {
+			return nil, err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,251 @@
+package taxpayer
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/auth"
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+type MockAuditService struct {
+	mock.Mock
+}
+
+func (m *MockAuditService) Record(actor, action, entity, entityID string, detail interface{}) error {
+	args := m.Called(actor, action, entity, entityID, detail)
+	return args.Error(0)
+}
+
+func (m *MockAuditService) ListEvents(filter model.AuditFilter) ([]model.AuditEvent, error) {
+	args := m.Called(filter)
+	return args.Get(0).([]model.AuditEvent), args.Error(1)
+}
+
+type MockSubjectService struct {
+	mock.Mock
+}
+
+func (m *MockSubjectService) ExportSubject(ctx context.Context, actor string, req model.SubjectRequest) (model.SubjectExport, error) {
+	args := m.Called(actor, req)
+	return args.Get(0).(model.SubjectExport), args.Error(1)
+}
+
+func (m *MockSubjectService) EraseSubject(ctx context.Context, actor string, req model.SubjectRequest) (model.SubjectErasure, error) {
+	args := m.Called(actor, req)
+	return args.Get(0).(model.SubjectErasure), args.Error(1)
+}
+
+var subjectNow = time.Date(2026, 10, 1, 9, 0, 0, 0, time.UTC)
+
+func newTestSubjectService(repo *MockRepo, auditService *MockAuditService) *SubjectService {
+	return &SubjectService{Repo: repo, Audit: auditService, now: func() time.Time { return subjectNow }}
+}
+
+func TestExportSubject(t *testing.T) {
+	batchID := uint(5)
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetSubjectData", testNationalID).Return(SubjectData{
+		Taxpayers:    []modelgorm.TaxpayerGorm{{ID: 4, Owner: "apikey:3", NationalID: testNationalID, Name: testName}},
+		Calculations: []modelgorm.CalculationGorm{{ID: 9, BatchID: &batchID, Input: `{"totalIncome":500000}`, Output: `{"tax":29000}`}},
+		Batches:      []modelgorm.CalculationBatchGorm{{ID: 5, Rows: 10}},
+		AuditEvents:  []modelgorm.AuditEventGorm{{ID: 1, Action: "data_subject.exported"}},
+	}, nil)
+	mockAudit := new(MockAuditService)
+	mockAudit.On("Record", "alice", "data_subject.exported", "data_subject", "ref:3450", map[string]interface{}{"reference": "PDPA-7", "format": "json"}).Return(nil)
+
+	export, err := newTestSubjectService(mockRepo, mockAudit).ExportSubject(context.Background(), "alice",
+		model.SubjectRequest{NationalID: "1-1017-00203-45-0", Reference: "PDPA-7"})
+
+	require.NoError(t, err)
+	assert.Equal(t, subjectNow, export.GeneratedAt)
+	require.Len(t, export.Profiles, 1)
+	assert.Equal(t, "apikey:3", export.Profiles[0].Owner)
+	assert.Equal(t, testName, export.Profiles[0].Name)
+	assert.Equal(t, uint(5), *export.Calculations[0].BatchID)
+	assert.Equal(t, 10, export.Batches[0].Rows)
+	assert.Len(t, export.AuditEvents, 1)
+	mockAudit.AssertExpectations(t)
+}
+
+func TestExportSubject_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		req  model.SubjectRequest
+	}{
+		{"Bad Check Digit", model.SubjectRequest{NationalID: "1101700203451"}},
+		{"Unknown Format", model.SubjectRequest{NationalID: testNationalID, Format: "xml"}},
+		{"Long Reference", model.SubjectRequest{NationalID: testNationalID, Reference: strings.Repeat("x", 201)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockRepo := new(MockRepo)
+			mockAudit := new(MockAuditService)
+
+			_, err := newTestSubjectService(mockRepo, mockAudit).ExportSubject(context.Background(), "alice", tt.req)
+
+			assert.ErrorIs(t, err, ErrInvalidTaxpayer)
+			mockRepo.AssertNotCalled(t, "GetSubjectData", mock.Anything)
+		})
+	}
+}
+
+func TestExportSubject_AuditFailure(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("GetSubjectData", testNationalID).Return(SubjectData{}, nil)
+	mockAudit := new(MockAuditService)
+	mockAudit.On("Record", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(errors.New("audit unavailable"))
+
+	_, err := newTestSubjectService(mockRepo, mockAudit).ExportSubject(context.Background(), "alice", model.SubjectRequest{NationalID: testNationalID})
+
+	assert.Error(t, err)
+}
+
+func TestEraseSubject(t *testing.T) {
+	mockRepo := new(MockRepo)
+	mockRepo.On("EraseSubject", testNationalID).Return(model.SubjectErasure{Profiles: 1, Calculations: 3}, nil)
+
+	erased, err := newTestSubjectService(mockRepo, new(MockAuditService)).EraseSubject(context.Background(), "root",
+		model.SubjectRequest{NationalID: testNationalID, Format: "zip"})
+
+	assert.NoError(t, err)
+	assert.Equal(t, model.SubjectErasure{Profiles: 1, Calculations: 3}, erased)
+}
+
+func TestTaxpayerRepository_EraseSubject(t *testing.T) {
+	repo := newSQLiteRepository(t, newKeyfile(t))
+	ctx := context.Background()
+
+	taxpayer := modelgorm.TaxpayerGorm{Owner: "apikey:3", NationalID: testNationalID, Name: testName}
+	require.NoError(t, repo.CreateTaxpayer(ctx, &taxpayer))
+	other := modelgorm.TaxpayerGorm{Owner: "apikey:3", NationalID: "3101200123453", Name: "Other"}
+	require.NoError(t, repo.CreateTaxpayer(ctx, &other))
+	input := `{"totalIncome":` + testIncome + `,"taxpayerId":1,"nationalId":"` + testNationalID + `"}`
+	require.NoError(t, repo.CreateCalculation(ctx, &modelgorm.CalculationGorm{Owner: "apikey:3", TaxpayerID: &taxpayer.ID, Input: input, Output: `{"tax":100}`, Tax: 100}))
+	require.NoError(t, repo.CreateBatch(ctx, &modelgorm.CalculationBatchGorm{Rows: 2}, []modelgorm.CalculationGorm{
+		{NationalID: testNationalID, Input: `{"totalIncome":1,"nationalId":"` + testNationalID + `"}`, Output: `{"tax":50,"nationalId":"` + testNationalID + `"}`, Tax: 50},
+		{NationalID: "3101200123453", Input: `{"totalIncome":2}`, Output: `{"tax":70}`, Tax: 70},
+	}))
+
+	data, err := repo.GetSubjectData(ctx, testNationalID)
+	require.NoError(t, err)
+	assert.Len(t, data.Taxpayers, 1)
+	assert.Len(t, data.Calculations, 2)
+	require.Len(t, data.Batches, 1)
+	assert.Equal(t, 2, data.Batches[0].Rows)
+
+	erased, err := repo.EraseSubject(ctx, testNationalID, func(erased model.SubjectErasure) (modelgorm.AuditEventGorm, error) {
+		return modelgorm.AuditEventGorm{Actor: "root", Action: "data_subject.erased", CreatedAt: subjectNow}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, model.SubjectErasure{Profiles: 1, Calculations: 2}, erased)
+
+	data, err = repo.GetSubjectData(ctx, testNationalID)
+	require.NoError(t, err)
+	assert.Empty(t, data.Taxpayers)
+	assert.Empty(t, data.Calculations)
+	require.Len(t, data.AuditEvents, 1)
+	assert.Equal(t, "data_subject.erased", data.AuditEvents[0].Action)
+	assert.Equal(t, repo.SubjectReference(testNationalID), data.AuditEvents[0].EntityID)
+
+	var total float64
+	require.NoError(t, repo.DB.Model(&modelgorm.CalculationGorm{}).Select("SUM(tax)").Scan(&total).Error)
+	assert.Equal(t, 220.0, total)
+	for _, table := range []string{"taxpayer_gorms", "calculation_gorms", "audit_event_gorms"} {
+		for _, row := range rawRows(t, repo.DB, table) {
+			for _, value := range row {
+				assert.NotContains(t, value, testNationalID)
+			}
+		}
+	}
+	var calculations []modelgorm.CalculationGorm
+	require.NoError(t, repo.DB.Order("id").Find(&calculations).Error)
+	for _, calculation := range calculations[:2] {
+		opened, err := repo.openCalculation(calculation)
+		require.NoError(t, err)
+		assert.Nil(t, opened.TaxpayerID)
+		assert.NotContains(t, opened.Input, "nationalId")
+		assert.NotContains(t, opened.Input, "taxpayerId")
+		assert.NotContains(t, opened.Output, "nationalId")
+	}
+	assert.Equal(t, `{"totalIncome":`+testIncome+`}`, mustOpen(t, repo, calculations[0]).Input)
+
+	_, err = repo.GetTaxpayer(ctx, "apikey:3", other.ID)
+	assert.NoError(t, err)
+}
+
+func mustOpen(t *testing.T, repo *TaxpayerRepository, calculation modelgorm.CalculationGorm) modelgorm.CalculationGorm {
+	opened, err := repo.openCalculation(calculation)
+	require.NoError(t, err)
+	return opened
+}
+
+func TestTaxpayerRepository_EraseSubject_AuditFailureRollsBack(t *testing.T) {
+	repo := newSQLiteRepository(t, newKeyfile(t))
+	ctx := context.Background()
+	require.NoError(t, repo.CreateTaxpayer(ctx, &modelgorm.TaxpayerGorm{Owner: "apikey:3", NationalID: testNationalID, Name: testName}))
+
+	_, err := repo.EraseSubject(ctx, testNationalID, func(model.SubjectErasure) (modelgorm.AuditEventGorm, error) {
+		return modelgorm.AuditEventGorm{}, errors.New("audit unavailable")
+	})
+	assert.Error(t, err)
+
+	data, err := repo.GetSubjectData(ctx, testNationalID)
+	require.NoError(t, err)
+	assert.Len(t, data.Taxpayers, 1)
+}
+
+func TestSubjectHandler_ExportSubject_Zip(t *testing.T) {
+	c, rec := newContext(http.MethodPost, "/admin/data-subjects/export", `{"nationalId":"1101700203450","format":"zip"}`)
+	auth.SetPrincipal(c, auth.Principal{Username: "alice", Role: auth.RoleApprover})
+
+	mockService := new(MockSubjectService)
+	mockService.On("ExportSubject", "alice", model.SubjectRequest{NationalID: testNationalID, Format: "zip"}).Return(model.SubjectExport{
+		GeneratedAt: subjectNow,
+		Profiles:    []model.SubjectProfile{{Taxpayer: model.Taxpayer{ID: 4, NationalID: testNationalID}, Owner: "apikey:3"}},
+	}, nil)
+	h := NewSubjectHandler(mockService)
+
+	require.NoError(t, h.ExportSubject(c))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/zip", rec.Header().Get(echo.HeaderContentType))
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentDisposition), "data-subject-export.zip")
+
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	require.NoError(t, err)
+	var names []string
+	for _, file := range archive.File {
+		names = append(names, file.Name)
+		if file.Name == "profiles.json" {
+			r, err := file.Open()
+			require.NoError(t, err)
+			content, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Contains(t, string(content), testNationalID)
+		}
+	}
+	assert.Equal(t, []string{"manifest.json", "profiles.json", "calculations.json", "batches.json", "audit-events.json"}, names)
+}
+
+func TestSubjectHandler_EraseSubject_Invalid(t *testing.T) {
+	c, rec := newContext(http.MethodPost, "/admin/data-subjects/erase", `{"nationalId":"123"}`)
+
+	mockService := new(MockSubjectService)
+	mockService.On("EraseSubject", "apikey:3", model.SubjectRequest{NationalID: "123"}).Return(model.SubjectErasure{}, ErrInvalidTaxpayer)
+	h := NewSubjectHandler(mockService)
+
+	require.NoError(t, h.EraseSubject(c))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,191 @@
+package taxrpc
+
+import (
+	"context"
+	"errors"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/apikey"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/module/validate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"log"
+	"net"
+)
+
+const (
+	// KeyMetadata carries the caller's API key, as apikey.Header does over
+	// HTTP. gRPC metadata keys are lower case.
+	KeyMetadata = "x-api-key"
+	// LanguageMetadata carries an Accept-Language value.
+	LanguageMetadata = "accept-language"
+)
+
+// Keys admits callers by API key, or anonymous ones by address, and counts
+// batch rows against their quota, giving them back if the batch fails;
+// apikey.Guard is one, shared with the HTTP
+// API so that a caller has one rate limit whichever port it calls.
+type Keys interface {
+	Admit(plaintext, client string) (model.APIKey, bool, error)
+	ReserveKeyRows(key model.APIKey, rows int) (release func(), err error)
+	ReserveClientRows(client string, rows int) (release func(), err error)
+}
+
+type keyContextKey struct{}
+
+func keyFrom(ctx context.Context) (model.APIKey, bool) {
+	key, ok := ctx.Value(keyContextKey{}).(model.APIKey)
+	return key, ok
+}
+
+// metadataValue returns the first value of name in the call's metadata.
+func metadataValue(ctx context.Context, name string) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// clientAddress returns the IP address of the caller, which anonymous
+// callers are limited by.
+func clientAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+		return host
+	}
+	return p.Addr.String()
+}
+
+func localizer(ctx context.Context) *i18n.Localizer {
+	return i18n.Accept(metadataValue(ctx, LanguageMetadata))
+}
+
+// admit checks the call's API key and returns ctx carrying the key it was
+// admitted with, if any.
+func (s *Server) admit(ctx context.Context) (context.Context, error) {
+	plaintext := metadataValue(ctx, KeyMetadata)
+	loc := localizer(ctx)
+	if plaintext == "" && s.RequireKey {
+		return nil, status.Error(codes.Unauthenticated, loc.Text(i18n.Msg("problem.api_key_required", KeyMetadata)))
+	}
+	if s.Keys == nil {
+		return ctx, nil
+	}
+
+	key, ok, err := s.Keys.Admit(plaintext, clientAddress(ctx))
+	var limited *apikey.RateLimitError
+	switch {
+	case errors.Is(err, apikey.ErrAPIKeyRequired):
+		return nil, status.Error(codes.Unauthenticated, loc.Text(i18n.Msg("problem.api_key_required", KeyMetadata)))
+	case errors.Is(err, apikey.ErrAPIKeyRejected):
+		return nil, status.Error(codes.Unauthenticated, loc.Text(i18n.Msg("problem.api_key_rejected")))
+	case errors.Is(err, apikey.ErrKeysUnavailable):
+		return nil, status.Error(codes.Unavailable, err.Error())
+	case errors.As(err, &limited):
+		st := status.New(codes.ResourceExhausted, loc.Text(i18n.Msg("problem.rate_limited", limited.PerMinute)))
+		return nil, withDetails(st, &errdetails.RetryInfo{RetryDelay: durationpb.New(limited.Delay)})
+	case err != nil:
+		return nil, internal("Failed to check API key", err)
+	case !ok && s.RequireKey:
+		// The key could not be checked, so it cannot stand in for one
+		return nil, status.Error(codes.Unavailable, apikey.ErrKeysUnavailable.Error())
+	case !ok:
+		return ctx, nil
+	}
+	return context.WithValue(ctx, keyContextKey{}, key), nil
+}
+
+func (s *Server) admitUnary(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := s.admit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (s *Server) admitStream(srv interface{}, stream grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := s.admit(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &admittedStream{ServerStream: stream, ctx: ctx})
+}
+
+// admittedStream is a stream whose context carries the caller's key.
+type admittedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *admittedStream) Context() context.Context {
+	return s.ctx
+}
+
+// statusError converts an error from the tax service to the status the
+// caller gets, as tax.CalculationError does to a problem over HTTP.
+// Mistakes in the request are INVALID_ARGUMENT with a BadRequest detail
+// naming each field at fault.
+func statusError(loc *i18n.Localizer, message string, err error) error {
+	var errs validate.Errors
+	var fieldErr *tax.FieldError
+	switch {
+	case errors.As(err, &errs):
+		messages := make([]i18n.Message, len(errs))
+		violations := make([]*errdetails.BadRequest_FieldViolation, len(errs))
+		for i, v := range errs {
+			messages[i] = v.Message
+			violations[i] = &errdetails.BadRequest_FieldViolation{Field: v.Field, Description: loc.Text(v.Message), Reason: v.Rule}
+		}
+		st := status.New(codes.InvalidArgument, loc.Text(i18n.Msg("validation.failed", messages)))
+		return withDetails(st, &errdetails.BadRequest{FieldViolations: violations})
+	case errors.As(err, &fieldErr):
+		detail := err.Error()
+		if fieldErr.Message.Key != "" {
+			detail = loc.Text(fieldErr.Message)
+		}
+		st := status.New(codes.InvalidArgument, detail)
+		return withDetails(st, &errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{{Field: fieldErr.Field, Description: detail}}})
+	case errors.Is(err, tax.ErrTaxpayerNotFound):
+		return status.Error(codes.NotFound, loc.Text(i18n.Msg("problem.taxpayer_not_found")))
+	case errors.Is(err, tax.ErrScheduleNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, tax.ErrRowQuotaExceeded):
+		return status.Error(codes.ResourceExhausted, err.Error())
+	case errors.Is(err, tax.ErrDatabaseUnavailable):
+		return status.Error(codes.Unavailable, message+": "+tax.ErrDatabaseUnavailable.Error())
+	}
+	return internal(message, err)
+}
+
+// internal reports an unexpected error without revealing it, unless the
+// call's context ended, which is not a server fault.
+func internal(message string, err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, message+": request cancelled")
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, message+": operation timed out")
+	}
+	log.Printf("grpc: %s: %v", message, err)
+	return status.Error(codes.Internal, message)
+}
+
+// withDetails attaches details to st, falling back to st alone if they
+// cannot be encoded.
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	if detailed, err := st.WithDetails(details...); err == nil {
+		return detailed.Err()
+	}
+	return st.Err()
+}
@@ -0,0 +1,171 @@
+package taxrpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/apikey"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/module/taxrpc/taxpb"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"testing"
+	"time"
+)
+
+type MockKeys struct {
+	mock.Mock
+}
+
+func (m *MockKeys) Admit(plaintext, client string) (model.APIKey, bool, error) {
+	args := m.Called(plaintext, client)
+	return args.Get(0).(model.APIKey), args.Bool(1), args.Error(2)
+}
+
+func (m *MockKeys) ReserveKeyRows(key model.APIKey, rows int) (func(), error) {
+	args := m.Called(key, rows)
+	release, _ := args.Get(0).(func())
+	return release, args.Error(1)
+}
+
+func (m *MockKeys) ReserveClientRows(client string, rows int) (func(), error) {
+	args := m.Called(client, rows)
+	release, _ := args.Get(0).(func())
+	return release, args.Error(1)
+}
+
+func withKey(key string) context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), KeyMetadata, key)
+}
+
+func TestServer_Keys(t *testing.T) {
+	payroll := model.APIKey{ID: 3, Name: "payroll", DailyRowQuota: 2}
+	keys := new(MockKeys)
+	keys.On("Admit", "", mock.Anything).Return(model.APIKey{}, false, nil)
+	keys.On("Admit", "atx_good", mock.Anything).Return(payroll, true, nil)
+	keys.On("Admit", "atx_bad", mock.Anything).Return(model.APIKey{}, false, apikey.ErrAPIKeyRejected)
+	keys.On("Admit", "atx_busy", mock.Anything).Return(model.APIKey{}, false, &apikey.RateLimitError{PerMinute: 60, Delay: 2 * time.Second})
+	bulk := model.APIKey{ID: 4, Name: "bulk", DailyRowQuota: 1500}
+	keys.On("Admit", "atx_bulk", mock.Anything).Return(bulk, true, nil)
+	bulkReleased := 0
+	keys.On("ReserveKeyRows", bulk, quotaChunkRows).Return(func() { bulkReleased++ }, nil).Once()
+	keys.On("ReserveKeyRows", bulk, quotaChunkRows).Return(nil, fmt.Errorf("%w: 1000 rows would exceed 1500 per day", tax.ErrRowQuotaExceeded))
+	released := 0
+	keys.On("ReserveKeyRows", payroll, 1).Return(func() { released++ }, nil)
+	keys.On("ReserveKeyRows", payroll, 3).Return(nil, fmt.Errorf("%w: 3 rows would exceed 2 per day", tax.ErrRowQuotaExceeded))
+	keys.On("ReserveClientRows", mock.Anything, 1).Return(func() {}, nil)
+	keys.On("ReserveClientRows", mock.Anything, 2).Return(nil, fmt.Errorf("%w: 2 rows would exceed 1 per day", tax.ErrRowQuotaExceeded))
+
+	server := NewServer(newTaxService(t))
+	server.Keys = keys
+	client := newClient(t, server)
+	req := &taxpb.CalculateRequest{TotalIncome: 500000}
+
+	t.Run("Anonymous", func(t *testing.T) {
+		_, err := client.Calculate(context.Background(), req)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Admitted", func(t *testing.T) {
+		_, err := client.Calculate(withKey("atx_good"), req)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Rejected", func(t *testing.T) {
+		_, err := client.Calculate(withKey("atx_bad"), req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("Rate Limited", func(t *testing.T) {
+		_, err := client.Calculate(withKey("atx_busy"), req)
+
+		st := status.Convert(err)
+		assert.Equal(t, codes.ResourceExhausted, st.Code())
+		require.Len(t, st.Details(), 1)
+		assert.Equal(t, 2*time.Second, st.Details()[0].(*errdetails.RetryInfo).GetRetryDelay().AsDuration())
+	})
+
+	t.Run("Stream Rejected", func(t *testing.T) {
+		_, err := calculateBatch(withKey("atx_bad"), client, &taxpb.CalculateBatchRequest{TotalIncome: 500000})
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	})
+
+	t.Run("Within Row Quota", func(t *testing.T) {
+		results, err := calculateBatch(withKey("atx_good"), client, &taxpb.CalculateBatchRequest{TotalIncome: 500000})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+		assert.Zero(t, released)
+	})
+
+	t.Run("Failed Batch Releases Rows", func(t *testing.T) {
+		_, err := calculateBatch(withKey("atx_good"), client, &taxpb.CalculateBatchRequest{TotalIncome: 500000, TaxYear: 2500})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		assert.Equal(t, 1, released)
+	})
+
+	t.Run("Over Row Quota", func(t *testing.T) {
+		row := &taxpb.CalculateBatchRequest{TotalIncome: 500000}
+		_, err := calculateBatch(withKey("atx_good"), client, row, row, row)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+
+	t.Run("Refused While Sending", func(t *testing.T) {
+		rows := make([]*taxpb.CalculateBatchRequest, 3*quotaChunkRows)
+		for i := range rows {
+			rows[i] = &taxpb.CalculateBatchRequest{TotalIncome: 500000}
+		}
+		_, err := calculateBatch(withKey("atx_bulk"), client, rows...)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, 1, bulkReleased)
+		keys.AssertNumberOfCalls(t, "ReserveKeyRows", 5)
+	})
+
+	t.Run("Anonymous Row Quota", func(t *testing.T) {
+		row := &taxpb.CalculateBatchRequest{TotalIncome: 500000}
+		results, err := calculateBatch(context.Background(), client, row)
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+
+		_, err = calculateBatch(context.Background(), client, row, row)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	})
+}
+
+func TestServer_RequireKey(t *testing.T) {
+	server := NewServer(newTaxService(t))
+	server.RequireKey = true
+	client := newClient(t, server)
+
+	_, err := client.GetConfig(context.Background(), &taxpb.GetConfigRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), KeyMetadata)
+}
+
+func TestStatusError(t *testing.T) {
+	loc := localizer(context.Background())
+	cases := []struct {
+		name string
+		err  error
+		code codes.Code
+	}{
+		{"Taxpayer Not Found", fmt.Errorf("%w: 7", tax.ErrTaxpayerNotFound), codes.NotFound},
+		{"Schedule Not Found", tax.ErrScheduleNotFound, codes.NotFound},
+		{"Database Unavailable", fmt.Errorf("failed to load: %w", tax.ErrDatabaseUnavailable), codes.Unavailable},
+		{"Timed Out", fmt.Errorf("failed to load: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+		{"Cancelled", context.Canceled, codes.Canceled},
+		{"Unexpected", fmt.Errorf("disk on fire"), codes.Internal},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := statusError(loc, "Tax calculation failed", tc.err)
+			assert.Equal(t, tc.code, status.Code(err))
+		})
+	}
+
+	assert.NotContains(t, status.Convert(statusError(loc, "Tax calculation failed", fmt.Errorf("disk on fire"))).Message(), "disk")
+}
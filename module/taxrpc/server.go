@@ -0,0 +1,296 @@
+// Package taxrpc serves tax calculations over gRPC, for backend services
+// that do not speak HTTP. It answers from the same TaxServices as the HTTP
+// API, in the shape of the v2 JSON; the service is defined in
+// proto/ktax/v1/tax.proto and generated into taxpb with "buf generate".
+package taxrpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/apikey"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/module/taxrpc/taxpb"
+	"github.com/pphee/assessment-tax/module/validate"
+	"github.com/pphee/assessment-tax/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"io"
+	"math"
+)
+
+const (
+	// MaxBatchRows is the most rows one CalculateBatch call may send.
+	MaxBatchRows = 100000
+	// quotaChunkRows is how many rows CalculateBatch counts against the
+	// caller's quota at a time while they arrive.
+	quotaChunkRows = 1000
+)
+
+type Server struct {
+	taxpb.UnimplementedTaxServiceServer
+
+	TaxService tax.TaxServices
+	// Keys admits callers by API key; without it every call is anonymous.
+	Keys Keys
+	// RequireKey refuses calls without a key even when Keys does not, as
+	// the HTTP tax API does when it authenticates its callers.
+	RequireKey bool
+}
+
+func NewServer(service tax.TaxServices) *Server {
+	return &Server{TaxService: service}
+}
+
+// Register serves s on g, which must have been created with s.Options().
+func (s *Server) Register(g *grpc.Server) {
+	taxpb.RegisterTaxServiceServer(g, s)
+}
+
+// Options install the interceptors that check the API key of every call.
+func (s *Server) Options() []grpc.ServerOption {
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(s.admitUnary),
+		grpc.ChainStreamInterceptor(s.admitStream),
+	}
+}
+
+func (s *Server) Calculate(ctx context.Context, in *taxpb.CalculateRequest) (*taxpb.CalculateResponse, error) {
+	loc := localizer(ctx)
+	req := model.TaxRequest{
+		TotalIncome: in.GetTotalIncome(),
+		WHT:         in.GetWht(),
+		TaxpayerID:  uint(in.GetTaxpayerId()),
+		NationalID:  in.GetNationalId(),
+		TaxYear:     int(in.GetTaxYear()),
+	}
+	for _, a := range in.GetAllowances() {
+		req.Allowances = append(req.Allowances, model.Allowance{AllowanceType: a.GetAllowanceType(), Amount: a.GetAmount()})
+	}
+	if err := validateRequest(req); err != nil {
+		return nil, statusError(loc, "Tax calculation failed", err)
+	}
+	if key, ok := keyFrom(ctx); ok {
+		req.Owner = apikey.Principal(key).Username
+	}
+
+	var err error
+	if req.NationalID, err = tax.NormalizeNationalID(req.NationalID); err != nil {
+		return nil, statusError(loc, "Tax calculation failed", err)
+	}
+	res, err := s.TaxService.CalculateTax(ctx, req)
+	if err != nil {
+		return nil, statusError(loc, "Tax calculation failed", err)
+	}
+
+	return &taxpb.CalculateResponse{
+		ConfigVersion: res.ConfigVersion,
+		Calculation:   calculation(tax.CalculationV2(loc, utils.MaskNationalID(res.NationalID), res.Breakdown)),
+		Warnings:      warnings(tax.WarningsV2(loc, res.Warnings)),
+	}, nil
+}
+
+func (s *Server) CalculateBatch(stream taxpb.TaxService_CalculateBatchServer) error {
+	ctx := stream.Context()
+	loc := localizer(ctx)
+
+	// Rows are checked, and counted against the caller's quota, as they
+	// arrive, so that a batch bound to be refused is not held to the end
+	var records []model.TotalIncomeCsv
+	var releases []func()
+	release := func() {
+		for _, giveBack := range releases {
+			giveBack()
+		}
+	}
+	reserved := 0
+	reserve := func() error {
+		giveBack, err := s.reserveRows(ctx, len(records)-reserved)
+		if err != nil {
+			release()
+			return statusError(loc, "Failed to check row quota", err)
+		}
+		releases = append(releases, giveBack)
+		reserved = len(records)
+		return nil
+	}
+	for {
+		row, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			release()
+			return err
+		}
+		if len(records) == MaxBatchRows {
+			release()
+			return status.Errorf(codes.ResourceExhausted, "a batch may hold at most %d rows", MaxBatchRows)
+		}
+		record := model.TotalIncomeCsv{
+			TotalIncome: row.GetTotalIncome(),
+			WHT:         row.GetWht(),
+			Donation:    row.GetDonation(),
+			NationalID:  row.GetNationalId(),
+			TaxYear:     int(row.GetTaxYear()),
+		}
+		if err := validateRow(len(records)+1, record); err != nil {
+			release()
+			return statusError(loc, "Batch calculation failed", err)
+		}
+		records = append(records, record)
+		if len(records)-reserved == quotaChunkRows {
+			if err := reserve(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(records) > reserved {
+		if err := reserve(); err != nil {
+			return err
+		}
+	}
+
+	if key, ok := keyFrom(ctx); ok {
+		for i := range records {
+			records[i].Owner = apikey.Principal(key).Username
+		}
+	}
+	res, err := s.TaxService.CalculateBatch(ctx, records)
+	if err != nil {
+		release()
+		return statusError(loc, "Batch calculation failed", err)
+	}
+
+	batchWarnings := warnings(tax.WarningsV2(loc, res.Warnings))
+	for i, detail := range res.Taxes {
+		err := stream.Send(&taxpb.CalculateBatchResponse{
+			Row:           int32(i),
+			ConfigVersion: res.ConfigVersion,
+			Calculation:   calculation(tax.CalculationV2(loc, utils.MaskNationalID(detail.NationalID), detail.Breakdown)),
+			Warnings:      batchWarnings,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Server) GetConfig(ctx context.Context, in *taxpb.GetConfigRequest) (*taxpb.GetConfigResponse, error) {
+	loc := localizer(ctx)
+
+	settings, err := s.TaxService.GetAllowanceSettings(ctx)
+	if err != nil {
+		return nil, statusError(loc, "Failed to get allowance settings", err)
+	}
+	schedule, err := s.TaxService.GetActiveTaxSchedule(ctx, int(in.GetTaxYear()))
+	if err != nil {
+		return nil, statusError(loc, "Failed to get tax schedule", err)
+	}
+
+	res := &taxpb.GetConfigResponse{
+		Schedule: &taxpb.TaxSchedule{Id: uint64(schedule.ID), TaxYear: int32(schedule.TaxYear)},
+	}
+	for _, setting := range settings {
+		res.Allowances = append(res.Allowances, &taxpb.AllowanceSetting{
+			Key:     setting.Key,
+			Amount:  model.Money(setting.Amount).String(),
+			Min:     model.Money(setting.Min).String(),
+			Max:     model.Money(setting.Max).String(),
+			Version: setting.Version,
+		})
+	}
+	for _, rate := range schedule.Brackets {
+		bracket := &taxpb.TaxRate{Level: loc.TaxLevel(rate), Min: model.Money(rate.Min).String(), Rate: rate.Rate}
+		if rate.Max != nil {
+			bracket.Max = model.Money(*rate.Max).String()
+		}
+		res.Schedule.Brackets = append(res.Schedule.Brackets, bracket)
+	}
+	if schedule.ActivatedAt != nil {
+		res.Schedule.ActivatedAt = timestamppb.New(*schedule.ActivatedAt)
+	}
+	return res, nil
+}
+
+// validateRequest checks req against the rules of the HTTP request body.
+// Amounts JSON cannot hold, which a request body therefore never has, are
+// refused here before they reach the validator.
+func validateRequest(req model.TaxRequest) error {
+	fields := []string{"totalIncome", "wht"}
+	amounts := []float64{req.TotalIncome, req.WHT}
+	for i, a := range req.Allowances {
+		fields = append(fields, fmt.Sprintf("allowances[%d].amount", i))
+		amounts = append(amounts, a.Amount)
+	}
+	for i, amount := range amounts {
+		if err := checkFinite(fields[i], amount); err != nil {
+			return &tax.FieldError{Field: fields[i], Err: err}
+		}
+	}
+	return validate.Struct(req)
+}
+
+// validateRow checks the amounts of the batch row numbered row, from 1, as
+// validateRequest does those of a request.
+func validateRow(row int, record model.TotalIncomeCsv) error {
+	fields := []string{"totalIncome", "wht", "donation"}
+	amounts := []float64{record.TotalIncome, record.WHT, record.Donation}
+	for i, amount := range amounts {
+		if err := checkFinite(fields[i], amount); err != nil {
+			return &tax.FieldError{Field: "taxes", Err: fmt.Errorf("row %d: %w", row, err)}
+		}
+	}
+	return nil
+}
+
+func checkFinite(field string, amount float64) error {
+	if math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return fmt.Errorf("%s must be a finite number", field)
+	}
+	return nil
+}
+
+// reserveRows counts rows against the quota of the caller's key or, for an
+// anonymous caller, of its address.
+func (s *Server) reserveRows(ctx context.Context, rows int) (release func(), err error) {
+	if s.Keys == nil {
+		return func() {}, nil
+	}
+	if key, ok := keyFrom(ctx); ok {
+		return s.Keys.ReserveKeyRows(key, rows)
+	}
+	return s.Keys.ReserveClientRows(clientAddress(ctx), rows)
+}
+
+func calculation(c model.TaxCalculationV2) *taxpb.Calculation {
+	out := &taxpb.Calculation{
+		NationalId:    c.NationalID,
+		TotalIncome:   c.TotalIncome.String(),
+		TaxableIncome: c.TaxableIncome.String(),
+		GrossTax:      c.GrossTax.String(),
+		Wht:           c.WHT.String(),
+		Tax:           c.Tax.String(),
+		Refund:        c.Refund.String(),
+		Warnings:      warnings(c.Warnings),
+	}
+	for _, d := range c.Deductions {
+		out.Deductions = append(out.Deductions, &taxpb.Deduction{Type: d.Type, Amount: d.Amount.String()})
+	}
+	for _, b := range c.Brackets {
+		out.Brackets = append(out.Brackets, &taxpb.Bracket{Level: b.Level, Tax: b.Tax.String()})
+	}
+	return out
+}
+
+func warnings(shown []model.WarningV2) []*taxpb.Warning {
+	out := make([]*taxpb.Warning, len(shown))
+	for i, w := range shown {
+		out[i] = &taxpb.Warning{Code: w.Code, Field: w.Field, Detail: w.Detail}
+	}
+	return out
+}
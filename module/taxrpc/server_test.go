@@ -0,0 +1,246 @@
+package taxrpc
+
+import (
+	"context"
+	"errors"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/module/taxrpc/taxpb"
+	"github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"io"
+	"math"
+	"net"
+	"testing"
+)
+
+// newTaxService calculates with the default configuration, from memory.
+func newTaxService(t *testing.T) tax.TaxServices {
+	repo := tax.NewMemoryTaxRepository()
+	configCache := tax.NewConfigCache(repo)
+	_, err := configCache.Refresh(context.Background())
+	require.NoError(t, err)
+	return tax.NewTaxService(repo, configCache, tax.DefaultTimeouts)
+}
+
+// newClient serves s over an in-process connection and returns a client
+// of it.
+func newClient(t *testing.T, s *Server) taxpb.TaxServiceClient {
+	listener := bufconn.Listen(1 << 20)
+	g := grpc.NewServer(s.Options()...)
+	s.Register(g)
+	go func() { _ = g.Serve(listener) }()
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return taxpb.NewTaxServiceClient(conn)
+}
+
+// calculateBatch sends rows on one CalculateBatch call and returns what
+// came back. The server may end the call before every row is sent.
+func calculateBatch(ctx context.Context, client taxpb.TaxServiceClient, rows ...*taxpb.CalculateBatchRequest) ([]*taxpb.CalculateBatchResponse, error) {
+	stream, err := client.CalculateBatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		err := stream.Send(row)
+		if errors.Is(err, io.EOF) {
+			// The call has ended; Recv reports its status
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+	var results []*taxpb.CalculateBatchResponse
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return results, nil
+		}
+		if err != nil {
+			return results, err
+		}
+		results = append(results, res)
+	}
+}
+
+// fieldViolations returns the rule each field named in err's BadRequest
+// detail broke.
+func fieldViolations(t *testing.T, err error) map[string]string {
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code(), st.Message())
+	violations := map[string]string{}
+	for _, detail := range st.Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, v := range badRequest.GetFieldViolations() {
+				violations[v.GetField()] = v.GetReason()
+			}
+		}
+	}
+	return violations
+}
+
+func TestServer_Calculate(t *testing.T) {
+	client := newClient(t, NewServer(newTaxService(t)))
+
+	res, err := client.Calculate(context.Background(), &taxpb.CalculateRequest{
+		TotalIncome: 500000,
+		Wht:         25000,
+		Allowances:  []*taxpb.Allowance{{AllowanceType: "donation", Amount: 200000}},
+		NationalId:  "1-1017-00203-45-0",
+	})
+	require.NoError(t, err)
+
+	calculation := res.GetCalculation()
+	assert.NotZero(t, res.GetConfigVersion())
+	assert.Empty(t, res.GetWarnings())
+	assert.Equal(t, "*********3450", calculation.GetNationalId())
+	assert.Equal(t, "500000.00", calculation.GetTotalIncome())
+	assert.Equal(t, "340000.00", calculation.GetTaxableIncome())
+	assert.Equal(t, "19000.00", calculation.GetGrossTax())
+	assert.Equal(t, "0.00", calculation.GetTax())
+	assert.Equal(t, "6000.00", calculation.GetRefund())
+	require.Len(t, calculation.GetDeductions(), 2)
+	assert.Equal(t, "donation", calculation.GetDeductions()[1].GetType())
+	assert.Equal(t, "100000.00", calculation.GetDeductions()[1].GetAmount())
+	require.Len(t, calculation.GetBrackets(), 5)
+	assert.Equal(t, "150,001-500,000", calculation.GetBrackets()[1].GetLevel())
+	require.Len(t, calculation.GetWarnings(), 1)
+	assert.Equal(t, "allowance_capped", calculation.GetWarnings()[0].GetCode())
+	assert.Equal(t, "allowances[0].amount", calculation.GetWarnings()[0].GetField())
+	assert.Equal(t, "Donation was capped at 100,000", calculation.GetWarnings()[0].GetDetail())
+}
+
+func TestServer_Calculate_Localized(t *testing.T) {
+	client := newClient(t, NewServer(newTaxService(t)))
+	ctx := metadata.AppendToOutgoingContext(context.Background(), LanguageMetadata, "th-TH,th;q=0.9")
+
+	res, err := client.Calculate(ctx, &taxpb.CalculateRequest{TotalIncome: 3000000})
+	require.NoError(t, err)
+
+	brackets := res.GetCalculation().GetBrackets()
+	require.Len(t, brackets, 5)
+	assert.Equal(t, "2,000,001 ขึ้นไป", brackets[4].GetLevel())
+}
+
+func TestServer_Calculate_Invalid(t *testing.T) {
+	client := newClient(t, NewServer(newTaxService(t)))
+
+	_, err := client.Calculate(context.Background(), &taxpb.CalculateRequest{
+		TotalIncome: 2e12,
+		Allowances:  []*taxpb.Allowance{{AllowanceType: "rent", Amount: 1}},
+	})
+
+	assert.Equal(t, map[string]string{"totalIncome": "max", "allowances[0].allowanceType": "one_of"}, fieldViolations(t, err))
+}
+
+func TestServer_Calculate_InvalidNationalID(t *testing.T) {
+	client := newClient(t, NewServer(newTaxService(t)))
+
+	_, err := client.Calculate(context.Background(), &taxpb.CalculateRequest{TotalIncome: 500000, NationalId: "1101700203451"})
+
+	assert.Contains(t, fieldViolations(t, err), "nationalId")
+}
+
+func TestServer_Calculate_UnknownYear(t *testing.T) {
+	client := newClient(t, NewServer(newTaxService(t)))
+
+	_, err := client.Calculate(context.Background(), &taxpb.CalculateRequest{TotalIncome: 500000, TaxYear: 2500})
+
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "2500")
+}
+
+func TestServer_CalculateBatch(t *testing.T) {
+	client := newClient(t, NewServer(newTaxService(t)))
+
+	results, err := calculateBatch(context.Background(), client,
+		&taxpb.CalculateBatchRequest{TotalIncome: 500000},
+		&taxpb.CalculateBatchRequest{TotalIncome: 600000, Wht: 40000, Donation: 20000, NationalId: "1101700203450"},
+		&taxpb.CalculateBatchRequest{TotalIncome: 750000, Wht: 50000, Donation: 15000},
+	)
+	require.NoError(t, err)
+
+	require.Len(t, results, 3)
+	for i, res := range results {
+		assert.Equal(t, int32(i), res.GetRow())
+		assert.NotZero(t, res.GetConfigVersion())
+	}
+	assert.Equal(t, "29000.00", results[0].GetCalculation().GetTax())
+	assert.Equal(t, "*********3450", results[1].GetCalculation().GetNationalId())
+	assert.Equal(t, "2000.00", results[1].GetCalculation().GetRefund())
+	assert.Equal(t, "11250.00", results[2].GetCalculation().GetTax())
+}
+
+func TestServer_CalculateBatch_NotFinite(t *testing.T) {
+	client := newClient(t, NewServer(newTaxService(t)))
+
+	results, err := calculateBatch(context.Background(), client,
+		&taxpb.CalculateBatchRequest{TotalIncome: 500000},
+		&taxpb.CalculateBatchRequest{TotalIncome: math.NaN()},
+	)
+
+	assert.Empty(t, results)
+	assert.Contains(t, fieldViolations(t, err), "taxes")
+	assert.Contains(t, status.Convert(err).Message(), "row 2: totalIncome must be a finite number")
+}
+
+func TestServer_CalculateBatch_DuplicateNationalID(t *testing.T) {
+	client := newClient(t, NewServer(newTaxService(t)))
+
+	results, err := calculateBatch(context.Background(), client,
+		&taxpb.CalculateBatchRequest{TotalIncome: 500000, NationalId: "1101700203450"},
+		&taxpb.CalculateBatchRequest{TotalIncome: 600000, NationalId: "1-1017-00203-45-0"},
+	)
+
+	assert.Empty(t, results)
+	assert.Contains(t, fieldViolations(t, err), "taxes")
+	assert.Contains(t, status.Convert(err).Message(), "row 2")
+}
+
+func TestServer_GetConfig(t *testing.T) {
+	client := newClient(t, NewServer(newTaxService(t)))
+
+	res, err := client.GetConfig(context.Background(), &taxpb.GetConfigRequest{})
+	require.NoError(t, err)
+
+	settings := map[string]string{}
+	for _, setting := range res.GetAllowances() {
+		settings[setting.GetKey()] = setting.GetAmount()
+	}
+	assert.Equal(t, "60000.00", settings[modelgorm.PersonalDefault])
+	assert.Equal(t, "100000.00", settings[modelgorm.DonationMax])
+
+	schedule := res.GetSchedule()
+	assert.Equal(t, int32(2567), schedule.GetTaxYear())
+	require.Len(t, schedule.GetBrackets(), 5)
+	assert.Equal(t, "150,001-500,000", schedule.GetBrackets()[1].GetLevel())
+	assert.Equal(t, "500000.00", schedule.GetBrackets()[1].GetMax())
+	assert.Equal(t, 0.1, schedule.GetBrackets()[1].GetRate())
+	assert.Empty(t, schedule.GetBrackets()[4].GetMax())
+	assert.NotNil(t, schedule.GetActivatedAt())
+}
+
+func TestServer_GetConfig_UnknownYear(t *testing.T) {
+	client := newClient(t, NewServer(newTaxService(t)))
+
+	_, err := client.GetConfig(context.Background(), &taxpb.GetConfigRequest{TaxYear: 2500})
+
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
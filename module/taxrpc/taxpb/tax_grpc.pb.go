@@ -0,0 +1,232 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: ktax/v1/tax.proto
+
+package taxpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	TaxService_Calculate_FullMethodName      = "/ktax.v1.TaxService/Calculate"
+	TaxService_CalculateBatch_FullMethodName = "/ktax.v1.TaxService/CalculateBatch"
+	TaxService_GetConfig_FullMethodName      = "/ktax.v1.TaxService/GetConfig"
+)
+
+// TaxServiceClient is the client API for TaxService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// TaxService calculates Thai personal income tax with the same rules and
+// configuration as the HTTP API. Callers present their API key in the
+// x-api-key metadata, as they would the X-API-Key header, and may ask for
+// bracket labels and warnings in Thai with accept-language.
+//
+// Errors are gRPC statuses: INVALID_ARGUMENT for a request that breaks a
+// rule, with a google.rpc.BadRequest detail naming each field at fault,
+// UNAUTHENTICATED for a missing or rejected key, RESOURCE_EXHAUSTED for a
+// key, or the address of a caller without one, over its rate limit or row
+// quota, NOT_FOUND for an unknown taxpayer or schedule and UNAVAILABLE
+// while the database is down.
+type TaxServiceClient interface {
+	// Calculate calculates the tax of one income, like
+	// POST /v2/tax/calculations.
+	Calculate(ctx context.Context, in *CalculateRequest, opts ...grpc.CallOption) (*CalculateResponse, error)
+	// CalculateBatch calculates the tax of every row the client sends, like
+	// POST /v2/tax/calculations/upload-csv. The rows are calculated together
+	// once the client closes its side of the stream; a response per row is
+	// then streamed back in the order the rows were sent.
+	CalculateBatch(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[CalculateBatchRequest, CalculateBatchResponse], error)
+	// GetConfig returns the allowance settings and the active tax schedule
+	// calculations use. It cannot change them: that is left to the admin API.
+	GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*GetConfigResponse, error)
+}
+
+type taxServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewTaxServiceClient(cc grpc.ClientConnInterface) TaxServiceClient {
+	return &taxServiceClient{cc}
+}
+
+func (c *taxServiceClient) Calculate(ctx context.Context, in *CalculateRequest, opts ...grpc.CallOption) (*CalculateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(CalculateResponse)
+	err := c.cc.Invoke(ctx, TaxService_Calculate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *taxServiceClient) CalculateBatch(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[CalculateBatchRequest, CalculateBatchResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &TaxService_ServiceDesc.Streams[0], TaxService_CalculateBatch_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[CalculateBatchRequest, CalculateBatchResponse]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TaxService_CalculateBatchClient = grpc.BidiStreamingClient[CalculateBatchRequest, CalculateBatchResponse]
+
+func (c *taxServiceClient) GetConfig(ctx context.Context, in *GetConfigRequest, opts ...grpc.CallOption) (*GetConfigResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetConfigResponse)
+	err := c.cc.Invoke(ctx, TaxService_GetConfig_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// TaxServiceServer is the server API for TaxService service.
+// All implementations must embed UnimplementedTaxServiceServer
+// for forward compatibility.
+//
+// TaxService calculates Thai personal income tax with the same rules and
+// configuration as the HTTP API. Callers present their API key in the
+// x-api-key metadata, as they would the X-API-Key header, and may ask for
+// bracket labels and warnings in Thai with accept-language.
+//
+// Errors are gRPC statuses: INVALID_ARGUMENT for a request that breaks a
+// rule, with a google.rpc.BadRequest detail naming each field at fault,
+// UNAUTHENTICATED for a missing or rejected key, RESOURCE_EXHAUSTED for a
+// key, or the address of a caller without one, over its rate limit or row
+// quota, NOT_FOUND for an unknown taxpayer or schedule and UNAVAILABLE
+// while the database is down.
+type TaxServiceServer interface {
+	// Calculate calculates the tax of one income, like
+	// POST /v2/tax/calculations.
+	Calculate(context.Context, *CalculateRequest) (*CalculateResponse, error)
+	// CalculateBatch calculates the tax of every row the client sends, like
+	// POST /v2/tax/calculations/upload-csv. The rows are calculated together
+	// once the client closes its side of the stream; a response per row is
+	// then streamed back in the order the rows were sent.
+	CalculateBatch(grpc.BidiStreamingServer[CalculateBatchRequest, CalculateBatchResponse]) error
+	// GetConfig returns the allowance settings and the active tax schedule
+	// calculations use. It cannot change them: that is left to the admin API.
+	GetConfig(context.Context, *GetConfigRequest) (*GetConfigResponse, error)
+	mustEmbedUnimplementedTaxServiceServer()
+}
+
+// UnimplementedTaxServiceServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedTaxServiceServer struct{}
+
+func (UnimplementedTaxServiceServer) Calculate(context.Context, *CalculateRequest) (*CalculateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Calculate not implemented")
+}
+func (UnimplementedTaxServiceServer) CalculateBatch(grpc.BidiStreamingServer[CalculateBatchRequest, CalculateBatchResponse]) error {
+	return status.Errorf(codes.Unimplemented, "method CalculateBatch not implemented")
+}
+func (UnimplementedTaxServiceServer) GetConfig(context.Context, *GetConfigRequest) (*GetConfigResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetConfig not implemented")
+}
+func (UnimplementedTaxServiceServer) mustEmbedUnimplementedTaxServiceServer() {}
+func (UnimplementedTaxServiceServer) testEmbeddedByValue()                    {}
+
+// UnsafeTaxServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to TaxServiceServer will
+// result in compilation errors.
+type UnsafeTaxServiceServer interface {
+	mustEmbedUnimplementedTaxServiceServer()
+}
+
+func RegisterTaxServiceServer(s grpc.ServiceRegistrar, srv TaxServiceServer) {
+	// If the following call pancis, it indicates UnimplementedTaxServiceServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&TaxService_ServiceDesc, srv)
+}
+
+func _TaxService_Calculate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(CalculateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaxServiceServer).Calculate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaxService_Calculate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaxServiceServer).Calculate(ctx, req.(*CalculateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _TaxService_CalculateBatch_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(TaxServiceServer).CalculateBatch(&grpc.GenericServerStream[CalculateBatchRequest, CalculateBatchResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type TaxService_CalculateBatchServer = grpc.BidiStreamingServer[CalculateBatchRequest, CalculateBatchResponse]
+
+func _TaxService_GetConfig_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetConfigRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(TaxServiceServer).GetConfig(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: TaxService_GetConfig_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(TaxServiceServer).GetConfig(ctx, req.(*GetConfigRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// TaxService_ServiceDesc is the grpc.ServiceDesc for TaxService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var TaxService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ktax.v1.TaxService",
+	HandlerType: (*TaxServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Calculate",
+			Handler:    _TaxService_Calculate_Handler,
+		},
+		{
+			MethodName: "GetConfig",
+			Handler:    _TaxService_GetConfig_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "CalculateBatch",
+			Handler:       _TaxService_CalculateBatch_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "ktax/v1/tax.proto",
+}
@@ -0,0 +1,371 @@
+// Package validate checks request bodies against rules declared in the
+// `validate` tags of the structs they are bound to, and reports every rule
+// a body breaks rather than the first.
+//
+// A tag lists rules separated by commas:
+//
+//	required      the field must be present and not null
+//	min=N, max=N  a number must be between N and N
+//	oneof=a b c   a string must be one of the values
+//	maxitems=N    a list may have at most N entries
+//	unique=f      no two entries of a list may have the same f
+//	ltefield=f    a number must not exceed the field f beside it
+//
+// Fields the struct does not have are rejected, as are values of the wrong
+// JSON type.
+package validate
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/problem"
+	"io"
+	"math"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Violation is one rule a field broke. Rule is the rule's stable code, such
+// as required or max.
+type Violation struct {
+	Field   string
+	Rule    string
+	Message i18n.Message
+}
+
+// Errors are the rules a request body broke, in the order of its fields.
+type Errors []Violation
+
+func (e Errors) Error() string {
+	english := i18n.New(i18n.Supported[0])
+	texts := make([]string, len(e))
+	for i, v := range e {
+		texts[i] = english.Text(v.Message)
+	}
+	return strings.Join(texts, "; ")
+}
+
+// Bind decodes the JSON body of c into dst, a pointer to a struct, after
+// checking it against the rules of dst's type. It returns Errors when the
+// body broke rules; any other error means the body could not be read as
+// JSON at all.
+func Bind(c echo.Context, dst interface{}) error {
+	req := c.Request()
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		body = []byte("{}")
+	} else if ctype := req.Header.Get(echo.HeaderContentType); !strings.HasPrefix(ctype, echo.MIMEApplicationJSON) {
+		return echo.ErrUnsupportedMediaType
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return errors.New("the body holds more than one JSON value")
+	}
+
+	var errs Errors
+	checkStruct(&errs, nil, value, reflect.TypeOf(dst).Elem())
+	if len(errs) > 0 {
+		return errs
+	}
+	return json.Unmarshal(body, dst)
+}
+
+// Struct checks v, a struct or a pointer to one, against the rules of its
+// type as Bind checks a body, for requests that do not arrive as JSON. Every
+// field of v counts as present, so required only rejects nil.
+func Struct(v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	var errs Errors
+	checkStruct(&errs, nil, value, reflect.Indirect(reflect.ValueOf(v)).Type())
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Respond answers a body Bind rejected: with an invalid_request problem
+// listing every violation when it broke rules, and as a body that is not
+// valid JSON otherwise.
+func Respond(c echo.Context, err error) error {
+	var errs Errors
+	if !errors.As(err, &errs) {
+		return problem.InvalidBody(c, err)
+	}
+
+	loc := i18n.From(c)
+	messages := make([]i18n.Message, len(errs))
+	p := problem.New(http.StatusBadRequest, problem.CodeInvalidRequest, "")
+	for i, v := range errs {
+		messages[i] = v.Message
+		p.Violations = append(p.Violations, problem.Violation{Field: v.Field, Code: v.Rule, Detail: loc.Text(v.Message)})
+	}
+	p.Detail = loc.Text(i18n.Msg("validation.failed", messages))
+	p.Field = errs[0].Field
+	return problem.Write(c, p)
+}
+
+type rule struct {
+	name, arg string
+}
+
+func parseRules(tag string) []rule {
+	var rules []rule
+	for _, part := range strings.Split(tag, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(part, "=")
+		rules = append(rules, rule{name: name, arg: arg})
+	}
+	return rules
+}
+
+// jsonName is the key a field is written under, or "" if it is never read
+// from JSON.
+func jsonName(field reflect.StructField) string {
+	if !field.IsExported() {
+		return ""
+	}
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	switch name {
+	case "-":
+		return ""
+	case "":
+		return field.Name
+	}
+	return name
+}
+
+var unmarshaler = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+
+func checkStruct(errs *Errors, path []string, value interface{}, t reflect.Type) {
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		errs.add(path, "wrong_type", i18n.Msg("validation.wrong_type", fieldPath(path), i18n.Msg("json.object")))
+		return
+	}
+
+	known := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := jsonName(field)
+		if name == "" {
+			continue
+		}
+		known[name] = true
+		fieldValue, present := object[name]
+		rules := parseRules(field.Tag.Get("validate"))
+		if !present || fieldValue == nil {
+			if hasRule(rules, "required") {
+				errs.add(append(path, name), "required", i18n.Msg("validation.required", fieldPath(append(path, name))))
+			}
+			continue
+		}
+		checkValue(errs, append(path, name), fieldValue, field.Type, rules, object)
+	}
+
+	// Report unknown fields in a stable order, whatever order the map has
+	var unknown []string
+	for name := range object {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		errs.add(append(path, name), "unknown_field", i18n.Msg("validation.unknown_field", fieldPath(append(path, name))))
+	}
+}
+
+// checkValue checks a value that is present against its type and rules.
+// siblings are the other fields of the object it is in.
+func checkValue(errs *Errors, path []string, value interface{}, t reflect.Type, rules []rule, siblings map[string]interface{}) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if reflect.PointerTo(t).Implements(unmarshaler) {
+		return
+	}
+	field := fieldPath(path)
+	wrongType := func() {
+		errs.add(path, "wrong_type", i18n.Msg("validation.wrong_type", field, i18n.Msg(problem.JSONKind(t))))
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		checkStruct(errs, path, value, t)
+
+	case reflect.Slice, reflect.Array:
+		items, ok := value.([]interface{})
+		if !ok {
+			wrongType()
+			return
+		}
+		checkList(errs, path, items, rules)
+		for i, item := range items {
+			if item != nil {
+				checkValue(errs, append(path, strconv.Itoa(i)), item, t.Elem(), nil, nil)
+			}
+		}
+
+	case reflect.String:
+		text, ok := value.(string)
+		if !ok {
+			wrongType()
+			return
+		}
+		for _, r := range rules {
+			if r.name == "oneof" && !slices.Contains(strings.Fields(r.arg), text) {
+				errs.add(path, "one_of", i18n.Msg("validation.one_of", field, strings.Join(strings.Fields(r.arg), ", ")))
+			}
+		}
+
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			wrongType()
+		}
+
+	case reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, ok := value.(json.Number)
+		if !ok {
+			wrongType()
+			return
+		}
+		checkNumber(errs, path, number, t, rules, siblings)
+	}
+}
+
+func checkNumber(errs *Errors, path []string, number json.Number, t reflect.Type, rules []rule, siblings map[string]interface{}) {
+	field := fieldPath(path)
+	n, err := strconv.ParseFloat(number.String(), 64)
+	if err != nil && !math.IsInf(n, 0) {
+		errs.add(path, "wrong_type", i18n.Msg("validation.wrong_type", field, i18n.Msg("json.number")))
+		return
+	}
+	switch t.Kind() {
+	case reflect.Float32, reflect.Float64:
+	default:
+		if n != math.Trunc(n) {
+			errs.add(path, "wrong_type", i18n.Msg("validation.wrong_type", field, i18n.Msg("json.integer")))
+			return
+		}
+	}
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		// Unsigned fields cannot hold a negative number, whatever the rules
+		if n < 0 {
+			errs.add(path, "min", i18n.Msg("validation.min", field, i18n.Amount(0)))
+			return
+		}
+	}
+
+	for _, r := range rules {
+		switch r.name {
+		case "min":
+			if limit := mustFloat(r); n < limit {
+				errs.add(path, "min", i18n.Msg("validation.min", field, i18n.Amount(limit)))
+			}
+		case "max":
+			if limit := mustFloat(r); n > limit {
+				errs.add(path, "max", i18n.Msg("validation.max", field, i18n.Amount(limit)))
+			}
+		case "ltefield":
+			other, ok := siblings[r.arg].(json.Number)
+			if !ok {
+				continue
+			}
+			if limit, err := other.Float64(); err == nil && n > limit {
+				errs.add(path, "lte_field", i18n.Msg("validation.lte_field", field, fieldPath(append(path[:len(path)-1:len(path)-1], r.arg))))
+			}
+		}
+	}
+}
+
+func checkList(errs *Errors, path []string, items []interface{}, rules []rule) {
+	field := fieldPath(path)
+	for _, r := range rules {
+		switch r.name {
+		case "maxitems":
+			limit, err := strconv.Atoi(r.arg)
+			if err != nil {
+				panic(fmt.Sprintf("validate: maxitems=%q is not a number", r.arg))
+			}
+			if len(items) > limit {
+				errs.add(path, "max_items", i18n.Msg("validation.max_items", field, limit))
+			}
+		case "unique":
+			seen := map[string]int{}
+			for i, item := range items {
+				object, ok := item.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				key, ok := object[r.arg].(string)
+				if !ok {
+					continue
+				}
+				entry := append(path[:len(path):len(path)], strconv.Itoa(i), r.arg)
+				if first, ok := seen[key]; ok {
+					errs.add(entry, "unique", i18n.Msg("validation.unique",
+						fieldPath(entry), fieldPath(append(path[:len(path):len(path)], strconv.Itoa(first), r.arg))))
+					continue
+				}
+				seen[key] = i
+			}
+		}
+	}
+}
+
+func (e *Errors) add(path []string, rule string, message i18n.Message) {
+	*e = append(*e, Violation{Field: fieldPath(path), Rule: rule, Message: message})
+}
+
+func fieldPath(path []string) string {
+	return problem.FieldPath(path)
+}
+
+func hasRule(rules []rule, name string) bool {
+	for _, r := range rules {
+		if r.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func mustFloat(r rule) float64 {
+	limit, err := strconv.ParseFloat(r.arg, 64)
+	if err != nil {
+		panic(fmt.Sprintf("validate: %s=%q is not a number", r.name, r.arg))
+	}
+	return limit
+}
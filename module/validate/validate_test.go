@@ -0,0 +1,147 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/internal/model"
+	"github.com/pphee/assessment-tax/module/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newContext(target, body string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	return echo.New().NewContext(req, rec), rec
+}
+
+// rulesBroken binds body to a TaxRequest and returns the rule each field
+// broke.
+func rulesBroken(t *testing.T, body string) map[string]string {
+	c, _ := newContext("/tax/calculations", body)
+	var req model.TaxRequest
+	err := Bind(c, &req)
+	if err == nil {
+		return nil
+	}
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+	broken := map[string]string{}
+	for _, v := range errs {
+		broken[v.Field] = v.Rule
+	}
+	return broken
+}
+
+func TestBind_TaxRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want map[string]string
+	}{
+		{"valid", `{"totalIncome": 500000, "wht": 25000, "allowances": [{"allowanceType": "donation", "amount": 200000}]}`, nil},
+		{"no allowances", `{"totalIncome": 0}`, nil},
+		{"empty body", ``, map[string]string{"totalIncome": "required"}},
+		{"null total", `{"totalIncome": null}`, map[string]string{"totalIncome": "required"}},
+		{"negative income", `{"totalIncome": -1}`, map[string]string{"totalIncome": "min"}},
+		{"absurd income", `{"totalIncome": 1e400}`, map[string]string{"totalIncome": "max"}},
+		{"wht over income", `{"totalIncome": 100, "wht": 200}`, map[string]string{"wht": "lte_field"}},
+		{"wrong type", `{"totalIncome": "NaN"}`, map[string]string{"totalIncome": "wrong_type"}},
+		{"unknown field", `{"totalIncome": 1, "totalIncomee": 2, "Owner": "someone"}`, map[string]string{"totalIncomee": "unknown_field", "Owner": "unknown_field"}},
+		{"unknown allowance", `{"totalIncome": 1, "allowances": [{"allowanceType": "gym", "amount": 1}]}`, map[string]string{"allowances[0].allowanceType": "one_of"}},
+		{"missing amount", `{"totalIncome": 1, "allowances": [{"allowanceType": "donation"}]}`, map[string]string{"allowances[0].amount": "required"}},
+		{"duplicate", `{"totalIncome": 1, "allowances": [{"allowanceType": "donation", "amount": 1}, {"allowanceType": "k-receipt", "amount": 1}, {"allowanceType": "donation", "amount": 2}]}`, map[string]string{"allowances[2].allowanceType": "unique"}},
+		{"fractional id", `{"totalIncome": 1, "taxpayerId": 1.5}`, map[string]string{"taxpayerId": "wrong_type"}},
+		{"negative id", `{"totalIncome": 1, "taxpayerId": -1}`, map[string]string{"taxpayerId": "min"}},
+		{"everything at once", `{"wht": -1, "allowances": [{"allowanceType": "gym", "amount": -5}], "extra": true}`, map[string]string{
+			"totalIncome":                 "required",
+			"wht":                         "min",
+			"allowances[0].allowanceType": "one_of",
+			"allowances[0].amount":        "min",
+			"extra":                       "unknown_field",
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, rulesBroken(t, tt.body))
+		})
+	}
+}
+
+func TestBind_TooManyAllowances(t *testing.T) {
+	allowances := make([]string, 11)
+	for i := range allowances {
+		allowances[i] = `{"allowanceType": "donation", "amount": 1}`
+	}
+	broken := rulesBroken(t, `{"totalIncome": 1, "allowances": [`+strings.Join(allowances, ",")+`]}`)
+
+	assert.Equal(t, "max_items", broken["allowances"])
+}
+
+func TestBind_Decodes(t *testing.T) {
+	c, _ := newContext("/admin/deductions/personal", `{"amount": 70000}`)
+	var req model.AdminRequest
+
+	require.NoError(t, Bind(c, &req))
+
+	assert.Equal(t, 70000.0, req.Amount)
+}
+
+func TestBind_NotJSON(t *testing.T) {
+	c, _ := newContext("/tax/calculations", `{"totalIncome":`)
+	var req model.TaxRequest
+
+	err := Bind(c, &req)
+
+	require.Error(t, err)
+	var errs Errors
+	assert.False(t, errors.As(err, &errs))
+}
+
+func TestStruct(t *testing.T) {
+	assert.NoError(t, Struct(model.TaxRequest{TotalIncome: 500000, Allowances: []model.Allowance{{AllowanceType: "donation", Amount: 200000}}}))
+
+	err := Struct(&model.TaxRequest{TotalIncome: 100, WHT: 200, Allowances: []model.Allowance{{AllowanceType: "rent"}}})
+
+	var errs Errors
+	require.ErrorAs(t, err, &errs)
+	broken := map[string]string{}
+	for _, v := range errs {
+		broken[v.Field] = v.Rule
+	}
+	assert.Equal(t, map[string]string{"wht": "lte_field", "allowances[0].allowanceType": "one_of"}, broken)
+}
+
+func TestRespond(t *testing.T) {
+	c, rec := newContext("/tax/calculations?lang=th", `{"totalIncome": -1, "wht": -1}`)
+	var req model.TaxRequest
+
+	require.NoError(t, Respond(c, Bind(c, &req)))
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	var body problem.Problem
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	assert.Equal(t, problem.CodeInvalidRequest, body.Code)
+	assert.Equal(t, "totalIncome", body.Field)
+	assert.Equal(t, []problem.Violation{
+		{Field: "totalIncome", Code: "min", Detail: "totalIncome ต้องไม่น้อยกว่า 0"},
+		{Field: "wht", Code: "min", Detail: "wht ต้องไม่น้อยกว่า 0"},
+	}, body.Violations)
+	assert.Equal(t, "คำขอไม่ถูกต้อง: totalIncome ต้องไม่น้อยกว่า 0; wht ต้องไม่น้อยกว่า 0", body.Detail)
+}
+
+func TestErrors_Error(t *testing.T) {
+	c, _ := newContext("/admin/deductions/personal", `{"amount": 2e12}`)
+	var req model.AdminRequest
+
+	err := Bind(c, &req)
+
+	assert.EqualError(t, err, "amount must be at most 1,000,000,000,000")
+}
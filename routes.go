@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/module/adminuser"
+	"github.com/pphee/assessment-tax/module/apikey"
+	"github.com/pphee/assessment-tax/module/approval"
+	"github.com/pphee/assessment-tax/module/audit"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/health"
+	"github.com/pphee/assessment-tax/module/i18n"
+	"github.com/pphee/assessment-tax/module/openapi"
+	"github.com/pphee/assessment-tax/module/problem"
+	"github.com/pphee/assessment-tax/module/retention"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/module/taxpayer"
+	"net/http"
+	"time"
+)
+
+// server holds what the routes are served by.
+type server struct {
+	tax           *tax.TaxHandler
+	taxpayer      *taxpayer.TaxpayerHandler
+	recompute     *taxpayer.RecomputeHandler
+	subject       *taxpayer.SubjectHandler
+	changeRequest *approval.ChangeRequestHandler
+	audit         *audit.AuditHandler
+	retention     *retention.RetentionHandler
+	apiKey        *apikey.APIKeyHandler
+	adminUser     *adminuser.AdminUserHandler
+	lockout       *adminuser.LockoutHandler
+
+	spec         *openapi.Spec
+	monitor      *health.Monitor
+	apiKeyGuard  *apikey.Guard
+	authenticate echo.MiddlewareFunc
+
+	// validateRequests checks requests against the OpenAPI document once
+	// the caller is known.
+	validateRequests bool
+	// taxAPIAuth requires callers of the tax API to present an API key or
+	// admin credentials; over gRPC, where there is no login, an API key.
+	taxAPIAuth bool
+	// allowDirectConfigWrites lets a single editor write configuration
+	// without a change request. It is off unless a deployment opts out of
+	// two-person approval.
+	allowDirectConfigWrites bool
+	// v1Sunset, when set, is when the deprecated v1 routes will be removed.
+	v1Sunset time.Time
+}
+
+// v1Deprecated is when the v1 calculation routes were superseded by /v2.
+var v1Deprecated = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// deprecated marks the responses of a v1 route as superseded by successor:
+// with Deprecation (RFC 9745) and a successor-version Link, and with Sunset
+// (RFC 8594) once a date for the route's removal is set.
+func (s *server) deprecated(successor string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			header := c.Response().Header()
+			header.Set("Deprecation", fmt.Sprintf("@%d", v1Deprecated.Unix()))
+			header.Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, successor))
+			if !s.v1Sunset.IsZero() {
+				header.Set("Sunset", s.v1Sunset.UTC().Format(http.TimeFormat))
+			}
+			return next(c)
+		}
+	}
+}
+
+func (s *server) routes(e *echo.Echo) {
+	e.HTTPErrorHandler = problem.ErrorHandler
+	e.Use(i18n.Middleware)
+
+	// The tax API; /v2 serves the same calculations as /tax, in one
+	// envelope, and the /tax calculations are deprecated in its favour
+	taxAPI := []echo.MiddlewareFunc{s.apiKeyGuard.Middleware}
+	if s.taxAPIAuth {
+		taxAPI = append(taxAPI, s.authenticate)
+	}
+	if s.validateRequests {
+		taxAPI = append(taxAPI, s.spec.ValidateRequests)
+	}
+	taxGroup := e.Group("/tax", taxAPI...)
+	taxGroup.POST("/calculations", s.tax.PostTaxCalculation, s.deprecated("/v2/tax/calculations"))
+	taxGroup.POST("/calculations/upload-csv", s.tax.TaxCalculationsCSVHandler, s.deprecated("/v2/tax/calculations/upload-csv"))
+	taxGroup.POST("/taxpayers", s.taxpayer.CreateTaxpayer, taxpayer.RequireOwner, s.monitor.RequireDatabase)
+	taxGroup.POST("/taxpayers/search", s.taxpayer.FindTaxpayer, taxpayer.RequireOwner, s.monitor.RequireDatabase)
+	taxGroup.GET("/taxpayers/:id", s.taxpayer.GetTaxpayer, taxpayer.RequireOwner)
+	taxGroup.PUT("/taxpayers/:id", s.taxpayer.UpdateTaxpayer, taxpayer.RequireOwner, s.monitor.RequireDatabase)
+	taxGroup.GET("/taxpayers/:id/calculations", s.taxpayer.ListCalculations, taxpayer.RequireOwner)
+	taxGroup.GET("/taxpayers/:id/calculations/:calculationId", s.taxpayer.GetCalculation, taxpayer.RequireOwner)
+	taxGroup.DELETE("/taxpayers/:id/calculations/:calculationId", s.taxpayer.DeleteCalculation, taxpayer.RequireOwner, s.monitor.RequireDatabase)
+
+	v2 := e.Group("/v2/tax", taxAPI...)
+	v2.POST("/calculations", s.tax.PostTaxCalculationV2)
+	v2.POST("/calculations/upload-csv", s.tax.TaxCalculationsCSVV2)
+
+	viewer := auth.RequireRole(auth.RoleViewer, auth.RoleEditor, auth.RoleApprover)
+	editor := auth.RequireRole(auth.RoleEditor)
+	approver := auth.RequireRole(auth.RoleApprover)
+	superuser := auth.RequireRole(auth.RoleSuperuser)
+	anyAdmin := auth.RequireRole(auth.Roles...)
+
+	// Configuration changes go through change requests, decided by a
+	// second person, unless direct writes are explicitly allowed
+	writes := []echo.MiddlewareFunc{editor}
+	if !s.allowDirectConfigWrites {
+		writes = append(writes, approval.RequireChangeRequest)
+	}
+
+	// Admin writes are refused while the database is down; the check runs
+	// before authentication, which needs the database too
+	admin := e.Group("/admin")
+	admin.Use(s.monitor.RequireDatabase, s.authenticate)
+	if s.validateRequests {
+		admin.Use(s.spec.ValidateRequests)
+	}
+	{
+		admin.POST("/deductions/personal", s.tax.SetPersonalDeduction, writes...)
+		admin.POST("/deductions/k-receipt", s.tax.SetKreceiptDeduction, writes...)
+		admin.GET("/settings", s.tax.GetAllowanceSettings, viewer)
+		admin.PATCH("/settings", s.tax.UpdateAllowanceSettings, writes...)
+		admin.GET("/settings/:key", s.tax.GetAllowanceSetting, viewer)
+		admin.PUT("/settings/:key", s.tax.UpdateAllowanceSetting, writes...)
+		admin.GET("/tax-years/:year/schedules", s.tax.ListTaxSchedules, viewer)
+		admin.POST("/tax-years/:year/schedules", s.tax.ProposeTaxSchedule, editor)
+		admin.GET("/tax-years/:year/schedules/active", s.tax.GetActiveTaxSchedule, viewer)
+		admin.GET("/tax-years/:year/schedules/:id", s.tax.GetTaxSchedule, viewer)
+		admin.POST("/tax-years/:year/schedules/:id/activate", s.tax.ActivateTaxSchedule, writes...)
+		admin.POST("/change-requests", s.changeRequest.SubmitChangeRequest, editor)
+		admin.GET("/change-requests", s.changeRequest.ListChangeRequests, viewer)
+		admin.GET("/change-requests/:id", s.changeRequest.GetChangeRequest, viewer)
+		admin.POST("/change-requests/:id/approve", s.changeRequest.ApproveChangeRequest, approver)
+		admin.POST("/change-requests/:id/reject", s.changeRequest.RejectChangeRequest, approver)
+		admin.GET("/audit-events", s.audit.ListEvents, approver)
+		admin.POST("/recompute", s.recompute.Recompute, viewer)
+		admin.POST("/data-subjects/export", s.subject.ExportSubject, approver)
+		admin.POST("/data-subjects/erase", s.subject.EraseSubject, superuser)
+		admin.GET("/retention/preview", s.retention.Preview, viewer)
+		admin.GET("/api-keys", s.apiKey.ListKeys, viewer)
+		admin.POST("/api-keys", s.apiKey.CreateKey, superuser)
+		admin.POST("/api-keys/:id/revoke", s.apiKey.RevokeKey, superuser)
+		admin.GET("/api-keys/:id/usage", s.apiKey.GetUsage, viewer)
+		admin.GET("/users", s.adminUser.ListUsers, superuser)
+		admin.POST("/users", s.adminUser.CreateUser, superuser)
+		admin.POST("/users/:username/disable", s.adminUser.DisableUser, superuser)
+		admin.PUT("/users/:username/password", s.adminUser.RotatePassword, anyAdmin)
+		admin.GET("/lockouts", s.lockout.ListLockouts, superuser)
+		admin.POST("/lockouts/unlock", s.lockout.Unlock, superuser)
+	}
+
+	e.GET("/health", s.monitor.Status)
+	e.GET("/openapi.yaml", s.spec.ServeYAML)
+	e.GET("/openapi.json", s.spec.ServeJSON)
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "Hello, Go Bootcamp!")
+	})
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/labstack/echo/v4"
+	"github.com/pphee/assessment-tax/module/adminuser"
+	"github.com/pphee/assessment-tax/module/apikey"
+	"github.com/pphee/assessment-tax/module/approval"
+	"github.com/pphee/assessment-tax/module/audit"
+	"github.com/pphee/assessment-tax/module/auth"
+	"github.com/pphee/assessment-tax/module/health"
+	"github.com/pphee/assessment-tax/module/openapi"
+	"github.com/pphee/assessment-tax/module/retention"
+	"github.com/pphee/assessment-tax/module/tax"
+	"github.com/pphee/assessment-tax/module/taxpayer"
+	"github.com/pphee/assessment-tax/store"
+	"github.com/pphee/assessment-tax/store/envelope"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testAdmin    = "admin"
+	testPassword = "admin-password"
+)
+
+// newTestServer serves the routes from an in-memory database, wired the way
+// main wires them, after applying options to the server.
+func newTestServer(t *testing.T, options ...func(*server)) (*echo.Echo, *openapi.Spec) {
+	dbStore, err := store.Open("memory://", store.Pool{})
+	require.NoError(t, err)
+	t.Cleanup(func() { dbStore.Close() })
+	require.NoError(t, dbStore.Migrate(context.Background()))
+	db := dbStore.Gorm()
+
+	auditService := audit.NewAuditService(audit.NewAuditRepository(db))
+	adminUserRepo := adminuser.NewAdminUserRepository(db)
+	adminUserService := adminuser.NewAdminUserService(adminUserRepo)
+	require.NoError(t, adminUserService.Bootstrap(testAdmin, testPassword))
+	loginGuard := adminuser.NewLoginGuard(adminUserRepo, auditService, adminuser.DefaultLockoutPolicy)
+
+	taxRepo := tax.NewTaxRepository(db)
+	configCache := tax.NewConfigCache(taxRepo)
+	_, err = configCache.Refresh(context.Background())
+	require.NoError(t, err)
+	taxService := tax.NewTaxService(taxRepo, configCache, tax.DefaultTimeouts)
+
+	keyfile, err := envelope.GenerateKeyfile("test")
+	require.NoError(t, err)
+	keys, err := envelope.NewKeyring(keyfile)
+	require.NoError(t, err)
+	taxpayerRepo := taxpayer.NewTaxpayerRepository(db, keys)
+	monitor := health.NewMonitor(dbStore.Ping)
+
+	apiKeyService := apikey.NewAPIKeyService(apikey.NewAPIKeyRepository(db))
+	apiKeyGuard := apikey.NewGuard(apiKeyService, false)
+	taxHandler := tax.NewTaxHandler(taxpayer.NewRecordingTaxService(taxService, taxpayerRepo, monitor))
+	taxHandler.RowQuota = apiKeyGuard
+
+	spec, err := openapi.NewSpec()
+	require.NoError(t, err)
+
+	srv := &server{
+		tax:           taxHandler,
+		taxpayer:      taxpayer.NewTaxpayerHandler(taxpayer.NewTaxpayerService(taxpayerRepo)),
+		recompute:     taxpayer.NewRecomputeHandler(taxpayer.NewRecomputeService(taxpayerRepo, taxService)),
+		subject:       taxpayer.NewSubjectHandler(taxpayer.NewSubjectService(taxpayerRepo, auditService)),
+		changeRequest: approval.NewChangeRequestHandler(approval.NewChangeRequestService(approval.NewChangeRequestRepository(db), taxService, approval.DefaultTTL)),
+		audit:         audit.NewAuditHandler(auditService),
+		retention:     retention.NewRetentionHandler(retention.NewRetentionService(retention.NewRetentionRepository(db), retention.Policy{Calculations: 90 * 24 * time.Hour})),
+		apiKey:        apikey.NewAPIKeyHandler(apiKeyService),
+		adminUser:     adminuser.NewAdminUserHandler(adminUserService),
+		lockout:       adminuser.NewLockoutHandler(loginGuard),
+
+		spec:         spec,
+		monitor:      monitor,
+		apiKeyGuard:  apiKeyGuard,
+		authenticate: auth.Middleware(auth.NewBasicProvider(adminUserService, loginGuard)),
+
+		validateRequests: true,
+	}
+	for _, option := range options {
+		option(srv)
+	}
+	e := echo.New()
+	srv.routes(e)
+	return e, spec
+}
+
+// apiClient sends requests to the server and fails the test when a
+// response is not what the OpenAPI document says it is.
+type apiClient struct {
+	t      *testing.T
+	e      *echo.Echo
+	spec   *openapi.Spec
+	called map[string]bool
+}
+
+type call struct {
+	method, path, body string
+	// user is an admin username, logging in with testPassword.
+	user   string
+	apiKey string
+	header map[string]string
+}
+
+func (a *apiClient) do(c call, status int) *httptest.ResponseRecorder {
+	a.t.Helper()
+	var body io.Reader
+	if c.body != "" {
+		body = strings.NewReader(c.body)
+	}
+	req := httptest.NewRequest(c.method, c.path, body)
+	if c.body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	return a.send(req, c, status)
+}
+
+func (a *apiClient) send(req *http.Request, c call, status int) *httptest.ResponseRecorder {
+	a.t.Helper()
+	if c.user != "" {
+		req.SetBasicAuth(c.user, testPassword)
+	}
+	if c.apiKey != "" {
+		req.Header.Set(apikey.Header, c.apiKey)
+	}
+	for name, value := range c.header {
+		req.Header.Set(name, value)
+	}
+
+	rec := httptest.NewRecorder()
+	a.e.ServeHTTP(rec, req)
+	require.Equal(a.t, status, rec.Code, "%s %s: %s", req.Method, req.URL.Path, rec.Body.String())
+	assert.NoError(a.t, a.spec.CheckResponse(req, rec.Code, rec.Header(), rec.Body.Bytes()))
+
+	for _, route := range a.e.Routes() {
+		if route.Method == req.Method && routePattern(route.Path).MatchString(req.URL.Path) {
+			a.called[route.Method+" "+route.Path] = true
+		}
+	}
+	return rec
+}
+
+// routePattern matches the paths an echo route serves. Static segments take
+// precedence in echo, which is enough for the paths used here.
+func routePattern(path string) *regexp.Regexp {
+	pattern := regexp.MustCompile(`:[A-Za-z]+`).ReplaceAllString(regexp.QuoteMeta(path), `[^/]+`)
+	return regexp.MustCompile("^" + pattern + "$")
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder) map[string]interface{} {
+	var body map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	return body
+}
+
+func TestRoutes_Documented(t *testing.T) {
+	e, spec := newTestServer(t)
+
+	registered := map[string]bool{}
+	for _, route := range e.Routes() {
+		if route.Method == echo.RouteNotFound {
+			continue
+		}
+		path := regexp.MustCompile(`:([A-Za-z]+)`).ReplaceAllString(route.Path, "{$1}")
+		registered[route.Method+" "+path] = true
+		item := spec.Doc.Paths.Find(path)
+		if assert.NotNil(t, item, "%s is not in the OpenAPI document", path) {
+			assert.NotNil(t, item.GetOperation(route.Method), "%s %s is not in the OpenAPI document", route.Method, path)
+		}
+	}
+	for path, item := range spec.Doc.Paths.Map() {
+		for method := range item.Operations() {
+			assert.True(t, registered[method+" "+path], "%s %s is documented but not served", method, path)
+		}
+	}
+}
+
+// TestRoutes_MatchDocument calls every route and checks each response
+// against the OpenAPI document, so that a handler whose output drifts from
+// it fails here.
+func TestRoutes_MatchDocument(t *testing.T) {
+	// Direct configuration writes are allowed so that they can be called
+	e, spec := newTestServer(t, func(s *server) { s.allowDirectConfigWrites = true })
+	api := &apiClient{t: t, e: e, spec: spec, called: map[string]bool{}}
+	admin := testAdmin
+
+	api.do(call{method: http.MethodGet, path: "/"}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: "/health"}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: "/openapi.yaml"}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: "/openapi.json"}, http.StatusOK)
+
+	// Anonymous calculations
+	api.do(call{method: http.MethodPost, path: "/tax/calculations", body: `{"totalIncome":500000,"wht":0,"allowances":[{"allowanceType":"donation","amount":200000}]}`}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/tax/calculations", body: `{"totalIncome":"500000"}`}, http.StatusBadRequest)
+	api.do(call{method: http.MethodPost, path: "/tax/calculations", body: `{"totalIncome":500000,"wht":600000}`}, http.StatusBadRequest)
+	api.do(call{method: http.MethodPost, path: "/tax/calculations", body: `{"totalIncome":-1,"allowances":[{"allowanceType":"gym","amount":1}],"extra":true}`}, http.StatusBadRequest)
+	rec := api.do(call{method: http.MethodPost, path: "/tax/calculations?lang=th", body: `{"totalIncome":3000000,"wht":600000}`, header: map[string]string{"Accept-Language": "en"}}, http.StatusOK)
+	assert.Equal(t, "th", rec.Header().Get("Content-Language"))
+	assert.Contains(t, rec.Body.String(), "2,000,001 ขึ้นไป")
+	rec = api.do(call{method: http.MethodPost, path: "/tax/calculations", body: `{"totalIncome":500000,"wht":600000}`, header: map[string]string{"Accept-Language": "th-TH"}}, http.StatusBadRequest)
+	assert.Equal(t, "คำขอไม่ถูกต้อง: wht ต้องไม่เกิน totalIncome", decode(t, rec)["detail"])
+
+	upload := func(path string) *http.Request {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		part, err := form.CreateFormFile("taxes", "taxes.csv")
+		require.NoError(t, err)
+		_, _ = part.Write([]byte("totalIncome,wht,donation,nationalId\n500000,0,0,1101700203450\n600000,40000,200000,\n"))
+		require.NoError(t, form.Close())
+		req := httptest.NewRequest(http.MethodPost, path, &body)
+		req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+		return req
+	}
+	rec = api.send(upload("/tax/calculations/upload-csv"), call{}, http.StatusOK)
+	assert.Equal(t, `</v2/tax/calculations/upload-csv>; rel="successor-version"`, rec.Header().Get("Link"))
+
+	// The same calculations in v2, which is not deprecated
+	rec = api.do(call{method: http.MethodPost, path: "/tax/calculations", body: `{"totalIncome":500000,"wht":25000}`}, http.StatusOK)
+	assert.Equal(t, "@1792368000", rec.Header().Get("Deprecation"))
+	assert.Equal(t, `</v2/tax/calculations>; rel="successor-version"`, rec.Header().Get("Link"))
+	assert.Empty(t, rec.Header().Get("Sunset"))
+	rec = api.do(call{method: http.MethodPost, path: "/v2/tax/calculations", body: `{"totalIncome":500000,"wht":25000}`}, http.StatusOK)
+	assert.Empty(t, rec.Header().Get("Deprecation"))
+	result := decode(t, rec)["results"].([]interface{})[0].(map[string]interface{})
+	assert.Equal(t, "4000.00", result["tax"])
+	api.do(call{method: http.MethodPost, path: "/v2/tax/calculations", body: `{"totalIncome":-1}`}, http.StatusBadRequest)
+	rec = api.send(upload("/v2/tax/calculations/upload-csv"), call{}, http.StatusOK)
+	results := decode(t, rec)["results"].([]interface{})
+	require.Len(t, results, 2)
+	assert.Equal(t, "21000.00", results[1].(map[string]interface{})["refund"])
+	assert.Empty(t, results[1].(map[string]interface{})["warnings"])
+
+	// API keys and the taxpayer profiles they own
+	api.do(call{method: http.MethodPost, path: "/admin/api-keys", body: `{"name":"partner"}`}, http.StatusUnauthorized)
+	api.do(call{method: http.MethodPost, path: "/admin/api-keys", user: admin}, http.StatusBadRequest)
+	created := decode(t, api.do(call{method: http.MethodPost, path: "/admin/api-keys", user: admin, body: `{"name":"partner","ratePerMinute":600,"dailyRowQuota":1000}`}, http.StatusCreated))
+	key := created["key"].(string)
+	keyID := created["id"].(float64)
+
+	api.do(call{method: http.MethodPost, path: "/tax/taxpayers", body: `{"nationalId":"1101700203450","name":"Somchai","dependents":1}`}, http.StatusUnauthorized)
+	profile := decode(t, api.do(call{method: http.MethodPost, path: "/tax/taxpayers", apiKey: key, body: `{"nationalId":"1101700203450","name":"Somchai","dependents":1}`}, http.StatusCreated))
+	taxpayerPath := fmt.Sprintf("/tax/taxpayers/%.0f", profile["id"].(float64))
+	api.do(call{method: http.MethodPost, path: "/tax/taxpayers", apiKey: key, body: `{"nationalId":"1101700203450","name":"Somchai"}`}, http.StatusConflict)
+	api.do(call{method: http.MethodPost, path: "/tax/taxpayers/search", apiKey: key, body: `{"nationalId":"1101700203450"}`}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: taxpayerPath, apiKey: key}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: "/tax/taxpayers/999", apiKey: key}, http.StatusNotFound)
+	api.do(call{method: http.MethodPut, path: taxpayerPath, apiKey: key, body: `{"nationalId":"1101700203450","name":"Somchai J.","dependents":2}`}, http.StatusOK)
+
+	api.do(call{method: http.MethodPost, path: "/tax/calculations", apiKey: key, body: fmt.Sprintf(`{"totalIncome":500000,"wht":0,"allowances":[{"allowanceType":"k-receipt","amount":50000}],"taxpayerId":%.0f}`, profile["id"])}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/tax/calculations", apiKey: key, body: `{"totalIncome":800000,"wht":0,"allowances":[]}`}, http.StatusOK)
+	calculations := decode(t, api.do(call{method: http.MethodGet, path: taxpayerPath + "/calculations?limit=10", apiKey: key}, http.StatusOK))["calculations"].([]interface{})
+	require.Len(t, calculations, 1)
+	calculationID := calculations[0].(map[string]interface{})["id"].(float64)
+	calculationPath := fmt.Sprintf("%s/calculations/%.0f", taxpayerPath, calculationID)
+	api.do(call{method: http.MethodGet, path: calculationPath, apiKey: key}, http.StatusOK)
+
+	api.do(call{method: http.MethodPost, path: "/admin/recompute", user: admin, body: fmt.Sprintf(`{"calculationId":%.0f}`, calculationID)}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/admin/recompute", user: admin, body: `{"input":{"totalIncome":500000},"asOf":"` + time.Now().Add(time.Minute).UTC().Format(time.RFC3339) + `"}`}, http.StatusOK)
+	api.do(call{method: http.MethodDelete, path: calculationPath, apiKey: key}, http.StatusNoContent)
+	api.do(call{method: http.MethodGet, path: calculationPath, apiKey: key}, http.StatusNotFound)
+	api.send(upload("/v2/tax/calculations/upload-csv"), call{apiKey: key}, http.StatusOK)
+	calculations = decode(t, api.do(call{method: http.MethodGet, path: taxpayerPath + "/calculations?limit=10", apiKey: key}, http.StatusOK))["calculations"].([]interface{})
+	require.Len(t, calculations, 1)
+
+	api.do(call{method: http.MethodGet, path: "/admin/api-keys", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: fmt.Sprintf("/admin/api-keys/%.0f/usage?days=7", keyID), user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: fmt.Sprintf("/admin/api-keys/%.0f/revoke", keyID), user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/admin/api-keys/999/revoke", user: admin}, http.StatusNotFound)
+
+	// Allowance settings, written with If-Match
+	rec = api.do(call{method: http.MethodGet, path: "/admin/settings/PersonalDefault", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/admin/deductions/personal", user: admin, body: `{"amount":70000}`}, http.StatusPreconditionRequired)
+	rec = api.do(call{method: http.MethodPost, path: "/admin/deductions/personal", user: admin, body: `{"amount":70000}`, header: map[string]string{"If-Match": rec.Header().Get("ETag")}}, http.StatusOK)
+	api.do(call{method: http.MethodPut, path: "/admin/settings/PersonalDefault", user: admin, body: `{"amount":60000}`, header: map[string]string{"If-Match": `"PersonalDefault-1"`}}, http.StatusPreconditionFailed)
+	api.do(call{method: http.MethodPut, path: "/admin/settings/PersonalDefault", user: admin, body: `{"amount":60000}`, header: map[string]string{"If-Match": rec.Header().Get("ETag")}}, http.StatusOK)
+	rec = api.do(call{method: http.MethodGet, path: "/admin/settings/KReceiptDefault", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/admin/deductions/k-receipt", user: admin, body: `{"amount":40000}`, header: map[string]string{"If-Match": rec.Header().Get("ETag")}}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: "/admin/settings/Unknown", user: admin}, http.StatusNotFound)
+	rec = api.do(call{method: http.MethodGet, path: "/admin/settings", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodPatch, path: "/admin/settings", user: admin, body: `{"settings":[{"key":"DonationMax","amount":90000}]}`, header: map[string]string{"If-Match": rec.Header().Get("ETag")}}, http.StatusOK)
+	api.do(call{method: http.MethodPatch, path: "/admin/settings", user: admin, body: `{"settings":[{"key":"DonationMax","amount":80000}]}`, header: map[string]string{"If-Match": "*"}}, http.StatusPreconditionFailed)
+	api.do(call{method: http.MethodPatch, path: "/admin/settings", user: admin, body: `{"settings":[{"key":"DonationMax","amount":-1}]}`, header: map[string]string{"If-Match": rec.Header().Get("ETag")}}, http.StatusBadRequest)
+
+	// Tax bracket schedules
+	api.do(call{method: http.MethodGet, path: "/admin/tax-years/2567/schedules", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: "/admin/tax-years/2567/schedules/active", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: "/admin/tax-years/2599/schedules/active", user: admin}, http.StatusNotFound)
+	schedule := decode(t, api.do(call{method: http.MethodPost, path: "/admin/tax-years/2568/schedules", user: admin, body: `{"brackets":[{"min":0,"max":200000,"rate":0},{"min":200000,"rate":0.1}],"referenceIncomes":[300000]}`}, http.StatusCreated))
+	schedulePath := fmt.Sprintf("/admin/tax-years/2568/schedules/%.0f", schedule["id"].(float64))
+	api.do(call{method: http.MethodGet, path: schedulePath, user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: schedulePath + "/activate", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: schedulePath + "/activate", user: admin}, http.StatusConflict)
+
+	// Admin accounts; change requests are decided by someone else
+	api.do(call{method: http.MethodPost, path: "/admin/users", user: admin, body: `{"username":"approver","password":"` + testPassword + `","role":"approver"}`}, http.StatusCreated)
+	api.do(call{method: http.MethodPost, path: "/admin/users", user: admin, body: `{"username":"approver","password":"` + testPassword + `","role":"approver"}`}, http.StatusConflict)
+	api.do(call{method: http.MethodPost, path: "/admin/users", user: admin, body: `{"username":"viewer","password":"` + testPassword + `","role":"viewer"}`}, http.StatusCreated)
+	api.do(call{method: http.MethodGet, path: "/admin/users", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: "/admin/users", user: "viewer"}, http.StatusForbidden)
+	api.do(call{method: http.MethodPut, path: "/admin/users/viewer/password", user: "viewer", body: `{"password":"` + testPassword + `"}`}, http.StatusOK)
+
+	submission := `{"kind":"allowance-settings","settings":[{"key":"DonationMax","amount":80000}],"reason":"budget"}`
+	first := decode(t, api.do(call{method: http.MethodPost, path: "/admin/change-requests", user: admin, body: submission}, http.StatusCreated))
+	second := decode(t, api.do(call{method: http.MethodPost, path: "/admin/change-requests", user: admin, body: submission}, http.StatusCreated))
+	api.do(call{method: http.MethodGet, path: "/admin/change-requests?status=pending", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: fmt.Sprintf("/admin/change-requests/%.0f", first["id"]), user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: fmt.Sprintf("/admin/change-requests/%.0f/approve", first["id"]), user: admin, body: `{"comment":"mine"}`}, http.StatusForbidden)
+	api.do(call{method: http.MethodPost, path: fmt.Sprintf("/admin/change-requests/%.0f/approve", first["id"]), user: "approver", body: `{"comment":"ok"}`}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: fmt.Sprintf("/admin/change-requests/%.0f/reject", second["id"]), user: "approver", body: `{"comment":"duplicate"}`}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: fmt.Sprintf("/admin/change-requests/%.0f/reject", second["id"]), user: "approver", body: `{"comment":"again"}`}, http.StatusConflict)
+	api.do(call{method: http.MethodGet, path: "/admin/change-requests/999", user: admin}, http.StatusNotFound)
+
+	api.do(call{method: http.MethodGet, path: "/admin/audit-events?entity=allowance&limit=5", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodGet, path: "/admin/retention/preview", user: admin}, http.StatusOK)
+
+	// Data subject requests
+	api.do(call{method: http.MethodPost, path: "/admin/data-subjects/export", user: admin, body: `{"nationalId":"1101700203450","reference":"PDPA-1"}`}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/admin/data-subjects/export", user: admin, body: `{"nationalId":"1101700203450","format":"zip"}`}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/admin/data-subjects/erase", user: admin, body: `{"nationalId":"1101700203450"}`}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/admin/data-subjects/erase", user: admin, body: `{"nationalId":"1234"}`}, http.StatusBadRequest)
+
+	// Login lockouts
+	for i := 0; i < adminuser.DefaultLockoutPolicy.UserThreshold; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/admin/settings", nil)
+		req.SetBasicAuth("viewer", "wrong-password")
+		api.send(req, call{}, http.StatusUnauthorized)
+	}
+	api.do(call{method: http.MethodGet, path: "/admin/settings", user: "viewer"}, http.StatusTooManyRequests)
+	api.do(call{method: http.MethodGet, path: "/admin/lockouts", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/admin/lockouts/unlock", user: admin, body: `{"username":"viewer"}`}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/admin/users/viewer/disable", user: admin}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/admin/users/nobody/disable", user: admin}, http.StatusNotFound)
+
+	for _, route := range e.Routes() {
+		if route.Method == echo.RouteNotFound {
+			continue
+		}
+		assert.True(t, api.called[route.Method+" "+route.Path], "%s %s is not called by this test", route.Method, route.Path)
+	}
+}
+
+// TestRoutes_ConfigWritesNeedApproval checks that, by default, no editor
+// can change configuration alone: the direct writes are refused and the
+// change only happens once someone else approves it.
+func TestRoutes_ConfigWritesNeedApproval(t *testing.T) {
+	e, spec := newTestServer(t)
+	api := &apiClient{t: t, e: e, spec: spec, called: map[string]bool{}}
+	admin := testAdmin
+	anyVersion := map[string]string{"If-Match": `"PersonalDefault-1"`}
+
+	for _, c := range []call{
+		{method: http.MethodPost, path: "/admin/deductions/personal", body: `{"amount":70000}`},
+		{method: http.MethodPost, path: "/admin/deductions/k-receipt", body: `{"amount":40000}`},
+		{method: http.MethodPatch, path: "/admin/settings", body: `{"settings":[{"key":"DonationMax","amount":90000}]}`},
+		{method: http.MethodPut, path: "/admin/settings/PersonalDefault", body: `{"amount":70000}`},
+		{method: http.MethodPost, path: "/admin/tax-years/2567/schedules/1/activate"},
+	} {
+		c.user, c.header = admin, anyVersion
+		rec := api.do(c, http.StatusForbidden)
+		assert.Contains(t, rec.Body.String(), `"code":"change_request_required"`)
+	}
+
+	api.do(call{method: http.MethodPost, path: "/admin/users", user: admin, body: `{"username":"approver","password":"` + testPassword + `","role":"approver"}`}, http.StatusCreated)
+	submission := `{"kind":"allowance-settings","settings":[{"key":"PersonalDefault","amount":70000}],"reason":"budget"}`
+	cr := decode(t, api.do(call{method: http.MethodPost, path: "/admin/change-requests", user: admin, body: submission}, http.StatusCreated))
+	api.do(call{method: http.MethodPost, path: fmt.Sprintf("/admin/change-requests/%.0f/approve", cr["id"]), user: "approver", body: `{"comment":"ok"}`}, http.StatusOK)
+
+	setting := decode(t, api.do(call{method: http.MethodGet, path: "/admin/settings/PersonalDefault", user: admin}, http.StatusOK))
+	assert.Equal(t, 70000.0, setting["amount"])
+}
+
+// TestRoutes_TaxAPIAuth checks that, with TAX_API_AUTH, the tax API takes
+// an API key or a login, as the gRPC API takes a key.
+func TestRoutes_TaxAPIAuth(t *testing.T) {
+	e, spec := newTestServer(t, func(s *server) { s.taxAPIAuth = true })
+	api := &apiClient{t: t, e: e, spec: spec, called: map[string]bool{}}
+	created := decode(t, api.do(call{method: http.MethodPost, path: "/admin/api-keys", user: testAdmin, body: `{"name":"partner"}`}, http.StatusCreated))
+	key := created["key"].(string)
+	body := `{"totalIncome":500000}`
+
+	api.do(call{method: http.MethodPost, path: "/v2/tax/calculations", body: body}, http.StatusUnauthorized)
+	api.do(call{method: http.MethodPost, path: "/v2/tax/calculations", body: body, apiKey: "atx_unknown"}, http.StatusUnauthorized)
+	api.do(call{method: http.MethodPost, path: "/v2/tax/calculations", body: body, apiKey: key}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/v2/tax/calculations", body: body, user: testAdmin}, http.StatusOK)
+	api.do(call{method: http.MethodPost, path: "/tax/taxpayers", apiKey: key, body: `{"nationalId":"1101700203450","name":"Somchai"}`}, http.StatusCreated)
+}
+
+func TestServer_Deprecated(t *testing.T) {
+	srv := &server{v1Sunset: time.Date(2027, time.June, 30, 0, 0, 0, 0, time.UTC)}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/tax/calculations", nil), rec)
+
+	handler := srv.deprecated("/v2/tax/calculations")(func(c echo.Context) error {
+		return c.NoContent(http.StatusNoContent)
+	})
+
+	require.NoError(t, handler(c))
+	assert.Equal(t, "@1792368000", rec.Header().Get("Deprecation"))
+	assert.Equal(t, `</v2/tax/calculations>; rel="successor-version"`, rec.Header().Get("Link"))
+	assert.Equal(t, "Wed, 30 Jun 2027 00:00:00 GMT", rec.Header().Get("Sunset"))
+}
@@ -0,0 +1,344 @@
+// Package envelope encrypts sensitive column values. Each value gets its own
+// data key, which is stored with it, encrypted under a key-encryption key
+// from the keyfile. Rotating the key-encryption key only rewraps data keys.
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// prefix marks encrypted values. Values without it were written before
+// encryption was enabled and are returned as they are.
+const prefix = "enc:v1:"
+
+const keySize = 32
+
+var (
+	ErrUnknownKey = errors.New("value is encrypted with a key that is not in the keyfile")
+	ErrMalformed  = errors.New("malformed encrypted value")
+)
+
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// Keyfile is the JSON layout of the keyfile. Keys maps key IDs to base64
+// 32-byte key-encryption keys; Primary names the one new values use. Index
+// is the base64 32-byte key of the blind index.
+type Keyfile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+	Index   string            `json:"index"`
+}
+
+type Keyring struct {
+	primary string
+	keys    map[string]cipher.AEAD
+	index   []byte
+}
+
+// LoadKeyfile reads a keyring from a JSON keyfile.
+func LoadKeyfile(path string) (*Keyring, error) {
+	keyfile, err := ReadKeyfile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(keyfile)
+}
+
+func NewKeyring(keyfile Keyfile) (*Keyring, error) {
+	keyring := &Keyring{primary: keyfile.Primary, keys: map[string]cipher.AEAD{}}
+	for id, encoded := range keyfile.Keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("invalid key id %q", id)
+		}
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", id, err)
+		}
+		if keyring.keys[id], err = newAEAD(key); err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := keyring.keys[keyfile.Primary]; !ok {
+		return nil, fmt.Errorf("primary key %q is not in the keyfile", keyfile.Primary)
+	}
+	index, err := decodeKey(keyfile.Index)
+	if err != nil {
+		return nil, fmt.Errorf("index key: %w", err)
+	}
+	keyring.index = index
+	return keyring, nil
+}
+
+// GenerateKeyfile returns a keyfile with one new key-encryption key and a
+// new index key.
+func GenerateKeyfile(id string) (Keyfile, error) {
+	kek, err := randomBytes(keySize)
+	if err != nil {
+		return Keyfile{}, err
+	}
+	index, err := randomBytes(keySize)
+	if err != nil {
+		return Keyfile{}, err
+	}
+	return Keyfile{
+		Primary: id,
+		Keys:    map[string]string{id: base64.StdEncoding.EncodeToString(kek)},
+		Index:   base64.StdEncoding.EncodeToString(index),
+	}, nil
+}
+
+// Rotate adds a new key-encryption key under id and makes it the primary.
+// Earlier keys stay so existing values can still be read.
+func (k *Keyfile) Rotate(id string) error {
+	if !keyIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid key id %q", id)
+	}
+	if _, ok := k.Keys[id]; ok {
+		return fmt.Errorf("key %s already exists", id)
+	}
+	kek, err := randomBytes(keySize)
+	if err != nil {
+		return err
+	}
+	if k.Keys == nil {
+		k.Keys = map[string]string{}
+	}
+	k.Keys[id] = base64.StdEncoding.EncodeToString(kek)
+	k.Primary = id
+	return nil
+}
+
+// ReadKeyfile reads the keyfile at path without building a keyring.
+func ReadKeyfile(path string) (Keyfile, error) {
+	var keyfile Keyfile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return keyfile, fmt.Errorf("failed to read keyfile: %w", err)
+	}
+	if err := json.Unmarshal(data, &keyfile); err != nil {
+		return keyfile, fmt.Errorf("failed to parse keyfile: %w", err)
+	}
+	return keyfile, nil
+}
+
+// WriteKeyfile replaces the keyfile at path, readable only by its owner.
+func WriteKeyfile(path string, keyfile Keyfile) error {
+	if _, err := NewKeyring(keyfile); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(keyfile, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyfile-*")
+	if err != nil {
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write keyfile: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("key is not valid base64")
+	}
+	if len(key) != keySize {
+		return nil, fmt.Errorf("key must be %d bytes", keySize)
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return b, nil
+}
+
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce, err := randomBytes(aead.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, sealed, additional []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], additional)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	return plaintext, nil
+}
+
+// Encrypt seals plaintext for the named field. The field is authenticated,
+// so a value copied into another column does not decrypt. Empty values are
+// stored empty.
+func (k *Keyring) Encrypt(field, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	dataKey, err := randomBytes(keySize)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := seal(aead, []byte(plaintext), []byte(field))
+	if err != nil {
+		return "", err
+	}
+	return format(k.primary, wrapped, sealed), nil
+}
+
+func format(keyID string, wrapped, sealed []byte) string {
+	return prefix + keyID + ":" + base64.RawStdEncoding.EncodeToString(wrapped) + ":" + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+func parse(value string) (keyID string, wrapped, sealed []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrMalformed
+	}
+	if wrapped, err = base64.RawStdEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	if sealed, err = base64.RawStdEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, ErrMalformed
+	}
+	return parts[0], wrapped, sealed, nil
+}
+
+func (k *Keyring) unwrap(keyID string, wrapped []byte) ([]byte, error) {
+	kek, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, keyID)
+	}
+	return open(kek, wrapped, []byte(keyID))
+}
+
+// Decrypt opens a value sealed by Encrypt for the same field.
+func (k *Keyring) Decrypt(field, value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	keyID, wrapped, sealed, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := k.unwrap(keyID, wrapped)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(aead, sealed, []byte(field))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether value was written by Encrypt.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Current reports whether value is encrypted under the primary key, or is
+// empty. Anything else needs Reencrypt.
+func (k *Keyring) Current(value string) bool {
+	if value == "" {
+		return true
+	}
+	if !IsEncrypted(value) {
+		return false
+	}
+	keyID, _, _, err := parse(value)
+	return err == nil && keyID == k.primary
+}
+
+// Reencrypt brings value under the primary key. Encrypted values only have
+// their data key rewrapped; values written before encryption are encrypted.
+func (k *Keyring) Reencrypt(field, value string) (string, error) {
+	if k.Current(value) {
+		return value, nil
+	}
+	if !IsEncrypted(value) {
+		return k.Encrypt(field, value)
+	}
+	keyID, wrapped, sealed, err := k.parseAndCheck(field, value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := k.unwrap(keyID, wrapped)
+	if err != nil {
+		return "", err
+	}
+	rewrapped, err := seal(k.keys[k.primary], dataKey, []byte(k.primary))
+	if err != nil {
+		return "", err
+	}
+	return format(k.primary, rewrapped, sealed), nil
+}
+
+// parseAndCheck parses value and makes sure it opens for field, so a
+// rewrap never carries a corrupt value forward.
+func (k *Keyring) parseAndCheck(field, value string) (string, []byte, []byte, error) {
+	if _, err := k.Decrypt(field, value); err != nil {
+		return "", nil, nil, err
+	}
+	return parse(value)
+}
+
+// BlindIndex returns a deterministic keyed hash of value, so equal values
+// can be looked up without storing them in the clear.
+func (k *Keyring) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, k.index)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,179 @@
+package envelope
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestKeyring(t *testing.T, id string) (*Keyring, Keyfile) {
+	keyfile, err := GenerateKeyfile(id)
+	require.NoError(t, err)
+	keyring, err := NewKeyring(keyfile)
+	require.NoError(t, err)
+	return keyring, keyfile
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	keyring, _ := newTestKeyring(t, "k1")
+
+	sealed, err := keyring.Encrypt("taxpayer.name", "Somchai")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, "enc:v1:k1:"))
+	assert.NotContains(t, sealed, "Somchai")
+
+	again, err := keyring.Encrypt("taxpayer.name", "Somchai")
+	require.NoError(t, err)
+	assert.NotEqual(t, sealed, again)
+
+	opened, err := keyring.Decrypt("taxpayer.name", sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "Somchai", opened)
+}
+
+func TestDecrypt_WrongField(t *testing.T) {
+	keyring, _ := newTestKeyring(t, "k1")
+	sealed, err := keyring.Encrypt("taxpayer.name", "Somchai")
+	require.NoError(t, err)
+
+	_, err = keyring.Decrypt("taxpayer.national_id", sealed)
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestDecrypt_Tampered(t *testing.T) {
+	keyring, _ := newTestKeyring(t, "k1")
+	sealed, err := keyring.Encrypt("taxpayer.name", "Somchai")
+	require.NoError(t, err)
+
+	i := len(sealed) - 10
+	flipped := byte('A')
+	if sealed[i] == 'A' {
+		flipped = 'B'
+	}
+	tampered := sealed[:i] + string(flipped) + sealed[i+1:]
+	_, err = keyring.Decrypt("taxpayer.name", tampered)
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestDecrypt_Plaintext(t *testing.T) {
+	keyring, _ := newTestKeyring(t, "k1")
+
+	opened, err := keyring.Decrypt("taxpayer.name", "Somchai")
+	assert.NoError(t, err)
+	assert.Equal(t, "Somchai", opened)
+	assert.False(t, keyring.Current("Somchai"))
+	assert.True(t, keyring.Current(""))
+}
+
+func TestReencrypt_Rotation(t *testing.T) {
+	old, oldFile := newTestKeyring(t, "k1")
+	sealed, err := old.Encrypt("calculation.input", `{"totalIncome":500000}`)
+	require.NoError(t, err)
+
+	_, newFile := newTestKeyring(t, "k2")
+	rotated, err := NewKeyring(Keyfile{
+		Primary: "k2",
+		Keys:    map[string]string{"k1": oldFile.Keys["k1"], "k2": newFile.Keys["k2"]},
+		Index:   oldFile.Index,
+	})
+	require.NoError(t, err)
+	assert.False(t, rotated.Current(sealed))
+
+	rewrapped, err := rotated.Reencrypt("calculation.input", sealed)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(rewrapped, "enc:v1:k2:"))
+	assert.True(t, rotated.Current(rewrapped))
+
+	// Once every value is rewrapped the old key can be dropped.
+	retired, err := NewKeyring(Keyfile{Primary: "k2", Keys: map[string]string{"k2": newFile.Keys["k2"]}, Index: oldFile.Index})
+	require.NoError(t, err)
+	opened, err := retired.Decrypt("calculation.input", rewrapped)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"totalIncome":500000}`, opened)
+
+	_, err = retired.Decrypt("calculation.input", sealed)
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestReencrypt_Plaintext(t *testing.T) {
+	keyring, _ := newTestKeyring(t, "k1")
+
+	sealed, err := keyring.Reencrypt("taxpayer.name", "Somchai")
+	require.NoError(t, err)
+	assert.True(t, IsEncrypted(sealed))
+
+	opened, err := keyring.Decrypt("taxpayer.name", sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "Somchai", opened)
+}
+
+func TestBlindIndex(t *testing.T) {
+	keyring, _ := newTestKeyring(t, "k1")
+	other, _ := newTestKeyring(t, "k1")
+
+	assert.Equal(t, keyring.BlindIndex("1101700203450"), keyring.BlindIndex("1101700203450"))
+	assert.NotEqual(t, keyring.BlindIndex("1101700203450"), keyring.BlindIndex("3101200123453"))
+	assert.NotEqual(t, keyring.BlindIndex("1101700203450"), other.BlindIndex("1101700203450"))
+	assert.Len(t, keyring.BlindIndex("1101700203450"), 64)
+}
+
+func TestLoadKeyfile(t *testing.T) {
+	_, keyfile := newTestKeyring(t, "2026-10")
+	data, err := json.Marshal(keyfile)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	keyring, err := LoadKeyfile(path)
+	require.NoError(t, err)
+	sealed, err := keyring.Encrypt("taxpayer.name", "Somchai")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, "enc:v1:2026-10:"))
+}
+
+func TestKeyfileRotate(t *testing.T) {
+	keyring, keyfile := newTestKeyring(t, "k1")
+	sealed, err := keyring.Encrypt("taxpayer.name", "Somchai")
+	require.NoError(t, err)
+
+	require.NoError(t, keyfile.Rotate("k2"))
+	assert.Error(t, keyfile.Rotate("k2"))
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, WriteKeyfile(path, keyfile))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+
+	rotated, err := LoadKeyfile(path)
+	require.NoError(t, err)
+	assert.False(t, rotated.Current(sealed))
+	opened, err := rotated.Decrypt("taxpayer.name", sealed)
+	assert.NoError(t, err)
+	assert.Equal(t, "Somchai", opened)
+}
+
+func TestNewKeyring_Invalid(t *testing.T) {
+	_, valid := newTestKeyring(t, "k1")
+	tests := []struct {
+		name    string
+		keyfile Keyfile
+	}{
+		{"Missing Primary", Keyfile{Primary: "k2", Keys: valid.Keys, Index: valid.Index}},
+		{"Short Key", Keyfile{Primary: "k1", Keys: map[string]string{"k1": "c2hvcnQ="}, Index: valid.Index}},
+		{"Bad Key ID", Keyfile{Primary: "k:1", Keys: map[string]string{"k:1": valid.Keys["k1"]}, Index: valid.Index}},
+		{"Missing Index", Keyfile{Primary: "k1", Keys: valid.Keys}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewKeyring(tt.keyfile)
+			assert.Error(t, err)
+		})
+	}
+}
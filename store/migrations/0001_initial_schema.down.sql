@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS calculation_batch_gorms;
+DROP TABLE IF EXISTS calculation_gorms;
+DROP TABLE IF EXISTS taxpayer_gorms;
+DROP TABLE IF EXISTS login_failure_gorms;
+DROP TABLE IF EXISTS api_key_usage_gorms;
+DROP TABLE IF EXISTS api_key_gorms;
+DROP TABLE IF EXISTS change_request_gorms;
+DROP TABLE IF EXISTS audit_event_gorms;
+DROP TABLE IF EXISTS admin_user_gorms;
+DROP TABLE IF EXISTS config_revision_gorms;
+DROP TABLE IF EXISTS tax_bracket_gorms;
+DROP TABLE IF EXISTS tax_schedule_gorms;
+DROP TABLE IF EXISTS allowance_gorms;
+DROP TYPE IF EXISTS allowance_type;
@@ -0,0 +1,149 @@
+-- Baseline of the schema previously created by AutoMigrate. Every statement
+-- is idempotent so databases created that way are adopted unchanged.
+
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM pg_type WHERE typname = 'allowance_type') THEN
+        CREATE TYPE allowance_type AS ENUM ('PersonalDefault', 'PersonalMax', 'DonationMax', 'KReceiptDefault', 'KReceiptMax');
+    END IF;
+END
+$$;
+
+CREATE TABLE IF NOT EXISTS allowance_gorms (
+    id             bigserial PRIMARY KEY,
+    allowance_type varchar(255)  NOT NULL,
+    amount         decimal(18,2) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS tax_schedule_gorms (
+    id           bigserial PRIMARY KEY,
+    tax_year     bigint      NOT NULL,
+    status       varchar(20) NOT NULL,
+    created_at   timestamptz NOT NULL,
+    activated_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_tax_schedule_gorms_tax_year ON tax_schedule_gorms (tax_year);
+
+CREATE TABLE IF NOT EXISTS tax_bracket_gorms (
+    id          bigserial PRIMARY KEY,
+    schedule_id bigint        NOT NULL,
+    "position"  bigint        NOT NULL,
+    min_income  decimal(18,2) NOT NULL,
+    max_income  decimal(18,2),
+    rate        decimal(5,4)  NOT NULL,
+    CONSTRAINT fk_tax_schedule_gorms_brackets FOREIGN KEY (schedule_id)
+        REFERENCES tax_schedule_gorms (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_tax_bracket_gorms_schedule_id ON tax_bracket_gorms (schedule_id);
+
+CREATE TABLE IF NOT EXISTS config_revision_gorms (
+    id         bigserial PRIMARY KEY,
+    reason     varchar(255) NOT NULL,
+    snapshot   text         NOT NULL,
+    created_at timestamptz  NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_config_revision_gorms_created_at ON config_revision_gorms (created_at);
+
+CREATE TABLE IF NOT EXISTS admin_user_gorms (
+    id                  bigserial PRIMARY KEY,
+    username            varchar(100) NOT NULL,
+    password_hash       varchar(255) NOT NULL,
+    role                varchar(20)  NOT NULL,
+    disabled            boolean      NOT NULL DEFAULT false,
+    password_changed_at timestamptz  NOT NULL,
+    created_at          timestamptz  NOT NULL,
+    updated_at          timestamptz  NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_admin_user_gorms_username ON admin_user_gorms (username);
+
+CREATE TABLE IF NOT EXISTS audit_event_gorms (
+    id         bigserial PRIMARY KEY,
+    actor      varchar(100) NOT NULL,
+    action     varchar(100) NOT NULL,
+    entity     varchar(100) NOT NULL,
+    entity_id  varchar(100) NOT NULL,
+    detail     text,
+    created_at timestamptz  NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_audit_event_gorms_actor ON audit_event_gorms (actor);
+CREATE INDEX IF NOT EXISTS idx_audit_entity ON audit_event_gorms (entity, entity_id);
+CREATE INDEX IF NOT EXISTS idx_audit_event_gorms_created_at ON audit_event_gorms (created_at);
+
+CREATE TABLE IF NOT EXISTS change_request_gorms (
+    id             bigserial PRIMARY KEY,
+    kind           varchar(50)  NOT NULL,
+    payload        text         NOT NULL,
+    status         varchar(20)  NOT NULL,
+    submitted_by   varchar(100) NOT NULL,
+    reviewed_by    varchar(100),
+    review_comment text,
+    apply_error    text,
+    created_at     timestamptz  NOT NULL,
+    expires_at     timestamptz  NOT NULL,
+    decided_at     timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_change_request_gorms_status ON change_request_gorms (status);
+CREATE INDEX IF NOT EXISTS idx_change_request_gorms_expires_at ON change_request_gorms (expires_at);
+
+CREATE TABLE IF NOT EXISTS api_key_gorms (
+    id              bigserial PRIMARY KEY,
+    name            varchar(100) NOT NULL,
+    prefix          varchar(20)  NOT NULL,
+    hash            char(64)     NOT NULL,
+    rate_per_minute bigint       NOT NULL,
+    daily_row_quota bigint       NOT NULL,
+    created_by      varchar(100) NOT NULL,
+    created_at      timestamptz  NOT NULL,
+    revoked_at      timestamptz
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_api_key_gorms_hash ON api_key_gorms (hash);
+
+CREATE TABLE IF NOT EXISTS api_key_usage_gorms (
+    key_id   bigint NOT NULL,
+    day      date   NOT NULL,
+    requests bigint NOT NULL DEFAULT 0,
+    "rows"   bigint NOT NULL DEFAULT 0,
+    PRIMARY KEY (key_id, day)
+);
+
+CREATE TABLE IF NOT EXISTS login_failure_gorms (
+    subject         varchar(150) PRIMARY KEY,
+    failures        bigint       NOT NULL,
+    last_failure_at timestamptz  NOT NULL,
+    locked_until    timestamptz
+);
+CREATE INDEX IF NOT EXISTS idx_login_failure_gorms_locked_until ON login_failure_gorms (locked_until);
+
+CREATE TABLE IF NOT EXISTS taxpayer_gorms (
+    id          bigserial PRIMARY KEY,
+    owner       varchar(100) NOT NULL,
+    national_id varchar(13)  NOT NULL,
+    name        varchar(200) NOT NULL,
+    dependents  bigint       NOT NULL DEFAULT 0,
+    created_at  timestamptz  NOT NULL,
+    updated_at  timestamptz  NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_taxpayer_owner_national_id ON taxpayer_gorms (owner, national_id);
+
+CREATE TABLE IF NOT EXISTS calculation_gorms (
+    id             bigserial PRIMARY KEY,
+    owner          varchar(100) NOT NULL,
+    taxpayer_id    bigint,
+    batch_id       bigint,
+    input          text         NOT NULL,
+    output         text         NOT NULL,
+    tax            decimal      NOT NULL,
+    config_version bigint       NOT NULL,
+    created_at     timestamptz  NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_calculation_gorms_owner ON calculation_gorms (owner);
+CREATE INDEX IF NOT EXISTS idx_calculation_gorms_taxpayer_id ON calculation_gorms (taxpayer_id);
+CREATE INDEX IF NOT EXISTS idx_calculation_gorms_batch_id ON calculation_gorms (batch_id);
+CREATE INDEX IF NOT EXISTS idx_calculation_gorms_created_at ON calculation_gorms (created_at);
+
+CREATE TABLE IF NOT EXISTS calculation_batch_gorms (
+    id             bigserial PRIMARY KEY,
+    "rows"         bigint      NOT NULL,
+    config_version bigint      NOT NULL,
+    created_at     timestamptz NOT NULL
+);
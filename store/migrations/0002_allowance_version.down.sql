@@ -0,0 +1 @@
+ALTER TABLE allowance_gorms DROP COLUMN IF EXISTS version;
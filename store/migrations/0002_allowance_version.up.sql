@@ -0,0 +1 @@
+ALTER TABLE allowance_gorms ADD COLUMN IF NOT EXISTS version bigint NOT NULL DEFAULT 1;
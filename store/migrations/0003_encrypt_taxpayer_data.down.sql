@@ -0,0 +1,5 @@
+-- Encrypted values do not fit the old column sizes, so the columns stay
+-- text and the old index is only restored without its uniqueness.
+DROP INDEX IF EXISTS idx_taxpayer_owner_national_id_index;
+ALTER TABLE taxpayer_gorms DROP COLUMN IF EXISTS national_id_index;
+CREATE INDEX IF NOT EXISTS idx_taxpayer_owner_national_id ON taxpayer_gorms (owner, national_id);
@@ -0,0 +1,8 @@
+-- National IDs and names are stored encrypted, which no longer fits the
+-- old column sizes, and uniqueness moves to the blind index. Rows written
+-- before this migration keep an empty index until "reencrypt" runs.
+ALTER TABLE taxpayer_gorms ALTER COLUMN national_id TYPE text;
+ALTER TABLE taxpayer_gorms ALTER COLUMN name TYPE text;
+ALTER TABLE taxpayer_gorms ADD COLUMN IF NOT EXISTS national_id_index varchar(64) NOT NULL DEFAULT '';
+DROP INDEX IF EXISTS idx_taxpayer_owner_national_id;
+CREATE UNIQUE INDEX IF NOT EXISTS idx_taxpayer_owner_national_id_index ON taxpayer_gorms (owner, national_id_index) WHERE national_id_index <> '';
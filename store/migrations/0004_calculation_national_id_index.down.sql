@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_calculation_gorms_national_id_index;
+ALTER TABLE calculation_gorms DROP COLUMN IF EXISTS national_id_index;
@@ -0,0 +1,2 @@
+ALTER TABLE calculation_gorms ADD COLUMN IF NOT EXISTS national_id_index varchar(64) NOT NULL DEFAULT '';
+CREATE INDEX IF NOT EXISTS idx_calculation_gorms_national_id_index ON calculation_gorms (national_id_index);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_tax_schedule_gorms_active_tax_year;
+DROP INDEX IF EXISTS idx_allowance_gorms_allowance_type;
@@ -0,0 +1,13 @@
+-- Instances starting together used to seed at the same time. Keep the first
+-- of any allowance seeded twice and of any year's schedules active at once.
+DELETE FROM allowance_gorms a
+    USING allowance_gorms b
+    WHERE a.allowance_type = b.allowance_type AND a.id > b.id;
+UPDATE tax_schedule_gorms s SET status = 'retired'
+    WHERE s.status = 'active' AND EXISTS (
+        SELECT 1 FROM tax_schedule_gorms other
+        WHERE other.tax_year = s.tax_year AND other.status = 'active' AND other.id < s.id
+    );
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_allowance_gorms_allowance_type ON allowance_gorms (allowance_type);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_tax_schedule_gorms_active_tax_year ON tax_schedule_gorms (tax_year) WHERE status = 'active';
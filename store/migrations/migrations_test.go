@@ -0,0 +1,205 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMigrations(t *testing.T) []Migration {
+	migrations, err := Load(fstest.MapFS{
+		"0002_add_notes.up.sql":   {Data: []byte("ALTER TABLE items ADD COLUMN notes text;")},
+		"0002_add_notes.down.sql": {Data: []byte("ALTER TABLE items DROP COLUMN notes;")},
+		"0001_items.up.sql":       {Data: []byte("CREATE TABLE items (id bigserial PRIMARY KEY);")},
+		"0001_items.down.sql":     {Data: []byte("DROP TABLE items;")},
+		"README.md":               {Data: []byte("ignored")},
+	})
+	require.NoError(t, err)
+	return migrations
+}
+
+func TestLoad(t *testing.T) {
+	migrations := testMigrations(t)
+
+	require.Len(t, migrations, 2)
+	assert.Equal(t, int64(1), migrations[0].Version)
+	assert.Equal(t, "items", migrations[0].Name)
+	assert.Equal(t, "DROP TABLE items;", migrations[0].Down)
+	assert.Len(t, migrations[0].Checksum, 64)
+	assert.Equal(t, int64(2), migrations[1].Version)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"missing down": {
+			"0001_items.up.sql": {Data: []byte("CREATE TABLE items ();")},
+		},
+		"mismatched names": {
+			"0001_items.up.sql":    {Data: []byte("CREATE TABLE items ();")},
+			"0001_things.down.sql": {Data: []byte("DROP TABLE items;")},
+		},
+		"zero version": {
+			"0000_items.up.sql":   {Data: []byte("CREATE TABLE items ();")},
+			"0000_items.down.sql": {Data: []byte("DROP TABLE items;")},
+		},
+	}
+	for name, fsys := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := Load(fsys)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := Load(files)
+
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		assert.Equal(t, int64(i+1), migration.Version, "migration versions must have no gaps")
+	}
+}
+
+func newTestMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return &Migrator{DB: db, Schema: "assessment_tax", Migrations: testMigrations(t)}, mock
+}
+
+func expectSession(mock sqlmock.Sqlmock, applied *sqlmock.Rows) {
+	mock.ExpectExec(`SELECT pg_advisory_lock\(\$1\)`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE SCHEMA IF NOT EXISTS assessment_tax`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`SET search_path TO assessment_tax`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT version, checksum, applied_at FROM schema_migrations`).WillReturnRows(applied)
+}
+
+func appliedRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"version", "checksum", "applied_at"})
+}
+
+func TestUp_AppliesPendingInOrder(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectSession(mock, appliedRows().AddRow(1, m.Migrations[0].Checksum, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(`ALTER TABLE items ADD COLUMN notes text`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(int64(2), "add_notes", m.Migrations[1].Checksum).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ran, err := m.Up(context.Background())
+
+	assert.NoError(t, err)
+	require.Len(t, ran, 1)
+	assert.Equal(t, int64(2), ran[0].Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_SeedsBeforeUnlocking(t *testing.T) {
+	m, mock := newTestMigrator(t)
+	m.Seed = func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "INSERT INTO items DEFAULT VALUES")
+		return err
+	}
+
+	expectSession(mock, appliedRows().AddRow(1, m.Migrations[0].Checksum, time.Now()).AddRow(2, m.Migrations[1].Checksum, time.Now()))
+	mock.ExpectExec(`INSERT INTO items DEFAULT VALUES`).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ran, err := m.Up(context.Background())
+
+	assert.NoError(t, err)
+	assert.Empty(t, ran)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_FailedMigrationRollsBack(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectSession(mock, appliedRows())
+	mock.ExpectBegin()
+	mock.ExpectExec(`CREATE TABLE items`).WillReturnError(assert.AnError)
+	mock.ExpectRollback()
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ran, err := m.Up(context.Background())
+
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Empty(t, ran)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_ChecksumMismatch(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectSession(mock, appliedRows().AddRow(1, "0000000000000000000000000000000000000000000000000000000000000000", time.Now()))
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	_, err := m.Up(context.Background())
+
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestDown_RevertsNewestFirst(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	expectSession(mock, appliedRows().
+		AddRow(1, m.Migrations[0].Checksum, time.Now()).
+		AddRow(2, m.Migrations[1].Checksum, time.Now()))
+	mock.ExpectBegin()
+	mock.ExpectExec(`ALTER TABLE items DROP COLUMN notes`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM schema_migrations WHERE version = \$1`).WithArgs(int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec(`SELECT pg_advisory_unlock\(\$1\)`).WithArgs(lockKey).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	ran, err := m.Down(context.Background(), 1)
+
+	assert.NoError(t, err)
+	require.Len(t, ran, 1)
+	assert.Equal(t, int64(2), ran[0].Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStatusAndVerify(t *testing.T) {
+	m, mock := newTestMigrator(t)
+	appliedAt := time.Date(2024, 3, 1, 9, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT to_regclass\(\$1\) IS NOT NULL`).WithArgs("assessment_tax.schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT version, checksum, applied_at FROM assessment_tax.schema_migrations`).
+		WillReturnRows(appliedRows().AddRow(1, m.Migrations[0].Checksum, appliedAt))
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+	require.Len(t, statuses, 2)
+	assert.Equal(t, appliedAt, *statuses[0].AppliedAt)
+	assert.Nil(t, statuses[1].AppliedAt)
+
+	mock.ExpectQuery(`SELECT to_regclass\(\$1\) IS NOT NULL`).WithArgs("assessment_tax.schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+	assert.ErrorIs(t, m.Verify(context.Background()), ErrPending)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestStatus_UnknownMigration(t *testing.T) {
+	m, mock := newTestMigrator(t)
+
+	mock.ExpectQuery(`SELECT to_regclass\(\$1\) IS NOT NULL`).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery(`SELECT version, checksum, applied_at FROM`).
+		WillReturnRows(appliedRows().AddRow(3, "abc", time.Now()))
+
+	_, err := m.Status(context.Background())
+
+	assert.ErrorIs(t, err, ErrUnknownMigration)
+}
@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	modelgorm "github.com/pphee/assessment-tax/store/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm/schema"
+)
+
+// Models are no longer auto-migrated, so every column they map must be
+// created by some migration.
+func TestMigrationsCoverModels(t *testing.T) {
+	migrations, err := Load(files)
+	require.NoError(t, err)
+	var all strings.Builder
+	for _, migration := range migrations {
+		all.WriteString(migration.Up)
+	}
+	ddl := all.String()
+
+	for _, model := range modelgorm.Models {
+		s, err := schema.Parse(model, &sync.Map{}, schema.NamingStrategy{})
+		require.NoError(t, err)
+
+		assert.Contains(t, ddl, "CREATE TABLE IF NOT EXISTS "+s.Table+" (", "no migration creates %s", s.Table)
+		for _, field := range s.Fields {
+			if field.DBName == "" {
+				continue
+			}
+			column := regexp.MustCompile(`(?m)^\s+"?` + field.DBName + `"?\s|ADD COLUMN (IF NOT EXISTS )?"?` + field.DBName + `"?\s`)
+			assert.Regexp(t, column, ddl, "no migration creates %s.%s", s.Table, field.DBName)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package modelgorm
+
+import "time"
+
+type AdminUserGorm struct {
+	ID                uint      `gorm:"primaryKey"`
+	Username          string    `gorm:"type:varchar(100);uniqueIndex;not null"`
+	PasswordHash      string    `gorm:"type:varchar(255);not null"`
+	Role              string    `gorm:"type:varchar(20);not null"`
+	Disabled          bool      `gorm:"not null;default:false"`
+	PasswordChangedAt time.Time `gorm:"not null"`
+	CreatedAt         time.Time `gorm:"not null"`
+	UpdatedAt         time.Time `gorm:"not null"`
+}
@@ -0,0 +1,23 @@
+package modelgorm
+
+import "time"
+
+type APIKeyGorm struct {
+	ID            uint       `gorm:"primaryKey"`
+	Name          string     `gorm:"type:varchar(100);not null"`
+	Prefix        string     `gorm:"type:varchar(20);not null"`
+	Hash          string     `gorm:"type:char(64);uniqueIndex;not null"`
+	RatePerMinute int        `gorm:"not null"`
+	DailyRowQuota int64      `gorm:"not null"`
+	CreatedBy     string     `gorm:"type:varchar(100);not null"`
+	CreatedAt     time.Time  `gorm:"not null"`
+	RevokedAt     *time.Time `gorm:""`
+}
+
+// APIKeyUsageGorm counts one key's usage for one UTC day.
+type APIKeyUsageGorm struct {
+	KeyID    uint      `gorm:"primaryKey"`
+	Day      time.Time `gorm:"primaryKey;type:date"`
+	Requests int64     `gorm:"not null;default:0"`
+	Rows     int64     `gorm:"not null;default:0"`
+}
@@ -0,0 +1,25 @@
+package modelgorm
+
+import "time"
+
+const (
+	ChangePending  = "pending"
+	ChangeApproved = "approved"
+	ChangeRejected = "rejected"
+	ChangeExpired  = "expired"
+	ChangeFailed   = "failed"
+)
+
+type ChangeRequestGorm struct {
+	ID            uint       `gorm:"primaryKey"`
+	Kind          string     `gorm:"type:varchar(50);not null"`
+	Payload       string     `gorm:"type:text;not null"`
+	Status        string     `gorm:"type:varchar(20);not null;index"`
+	SubmittedBy   string     `gorm:"type:varchar(100);not null"`
+	ReviewedBy    string     `gorm:"type:varchar(100)"`
+	ReviewComment string     `gorm:"type:text"`
+	ApplyError    string     `gorm:"type:text"`
+	CreatedAt     time.Time  `gorm:"not null"`
+	ExpiresAt     time.Time  `gorm:"not null;index"`
+	DecidedAt     *time.Time `gorm:""`
+}
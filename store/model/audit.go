@@ -0,0 +1,13 @@
+package modelgorm
+
+import "time"
+
+type AuditEventGorm struct {
+	ID        uint      `gorm:"primaryKey"`
+	Actor     string    `gorm:"type:varchar(100);not null;index"`
+	Action    string    `gorm:"type:varchar(100);not null"`
+	Entity    string    `gorm:"type:varchar(100);not null;index:idx_audit_entity"`
+	EntityID  string    `gorm:"type:varchar(100);not null;index:idx_audit_entity"`
+	Detail    string    `gorm:"type:text"`
+	CreatedAt time.Time `gorm:"not null;index"`
+}
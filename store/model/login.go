@@ -0,0 +1,12 @@
+package modelgorm
+
+import "time"
+
+// LoginFailureGorm tracks failed admin logins for one subject, either
+// "user:<username>" or "ip:<address>".
+type LoginFailureGorm struct {
+	Subject       string     `gorm:"type:varchar(150);primaryKey"`
+	Failures      int        `gorm:"not null"`
+	LastFailureAt time.Time  `gorm:"not null"`
+	LockedUntil   *time.Time `gorm:"index"`
+}
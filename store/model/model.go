@@ -5,15 +5,63 @@ import (
 	"gorm.io/gorm"
 )
 
+const (
+	PersonalDefault = "PersonalDefault"
+	PersonalMax     = "PersonalMax"
+	DonationMax     = "DonationMax"
+	KReceiptDefault = "KReceiptDefault"
+	KReceiptMax     = "KReceiptMax"
+)
+
+// Models lists every table. Postgres creates them through the versioned
+// migrations; SQLite is migrated from this list directly.
+var Models = []interface{}{
+	&AllowanceGorm{}, &TaxScheduleGorm{}, &TaxBracketGorm{}, &ConfigRevisionGorm{},
+	&AdminUserGorm{}, &AuditEventGorm{}, &ChangeRequestGorm{}, &APIKeyGorm{},
+	&APIKeyUsageGorm{}, &LoginFailureGorm{}, &TaxpayerGorm{}, &CalculationGorm{},
+	&CalculationBatchGorm{},
+}
+
 type Allowance struct {
 	AllowanceType string  `json:"allowanceType"`
 	Amount        float64 `json:"amount"`
+	// Version, when set on an update, is the version the allowance must
+	// still have for the update to apply.
+	Version int64 `json:"-"`
 }
 
+// AllowanceGorm.Version goes up by one with every change to the allowance.
 type AllowanceGorm struct {
 	ID            uint    `gorm:"primaryKey"`
-	AllowanceType string  `gorm:"type:varchar(255);not null"`
+	AllowanceType string  `gorm:"type:varchar(255);not null;uniqueIndex"`
 	Amount        float64 `gorm:"type:decimal(18,2);not null"`
+	Version       int64   `gorm:"not null;default:1"`
+}
+
+// AllowanceSpec describes a configurable allowance: its seeded value and the
+// bounds an admin may set it to.
+type AllowanceSpec struct {
+	AllowanceType string
+	Default       float64
+	Min           float64
+	Max           float64
+}
+
+var AllowanceSpecs = []AllowanceSpec{
+	{AllowanceType: PersonalDefault, Default: 60000, Min: 10000, Max: 100000},
+	{AllowanceType: PersonalMax, Default: 100000, Min: 10000, Max: 100000},
+	{AllowanceType: DonationMax, Default: 100000, Min: 0, Max: 100000},
+	{AllowanceType: KReceiptDefault, Default: 50000, Min: 0, Max: 100000},
+	{AllowanceType: KReceiptMax, Default: 100000, Min: 0, Max: 100000},
+}
+
+func LookupAllowanceSpec(allowanceType string) (AllowanceSpec, bool) {
+	for _, spec := range AllowanceSpecs {
+		if spec.AllowanceType == allowanceType {
+			return spec, true
+		}
+	}
+	return AllowanceSpec{}, false
 }
 
 func InitializeData(db *gorm.DB) error {
@@ -22,25 +70,14 @@ func InitializeData(db *gorm.DB) error {
 		return tx.Error
 	}
 
-	configs := []struct {
-		AllowanceType string
-		Amount        float64
-	}{
-		{"PersonalDefault", 60000},
-		{"PersonalMax", 100000},
-		{"DonationMax", 100000},
-		{"KReceiptDefault", 50000},
-		{"KReceiptMax", 100000},
-	}
-
-	for _, cfg := range configs {
+	for _, spec := range AllowanceSpecs {
 		allowance := AllowanceGorm{
-			AllowanceType: cfg.AllowanceType,
-			Amount:        cfg.Amount,
+			AllowanceType: spec.AllowanceType,
+			Amount:        spec.Default,
 		}
-		if err := tx.FirstOrCreate(&allowance, AllowanceGorm{AllowanceType: cfg.AllowanceType}).Error; err != nil {
+		if err := tx.FirstOrCreate(&allowance, AllowanceGorm{AllowanceType: spec.AllowanceType}).Error; err != nil {
 			tx.Rollback()
-			return fmt.Errorf("failed to initialize data for %s: %v", cfg.AllowanceType, err)
+			return fmt.Errorf("failed to initialize data for %s: %v", spec.AllowanceType, err)
 		}
 	}
 
@@ -0,0 +1,158 @@
+package modelgorm
+
+import (
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"strconv"
+	"time"
+)
+
+// ConfigChannel is the Postgres NOTIFY channel that carries the new
+// configuration version whenever a revision is recorded.
+const ConfigChannel = "tax_config_changed"
+
+// configLockKey identifies the transaction-level advisory lock held while a
+// revision is recorded, so that revisions are recorded one at a time.
+const configLockKey int64 = 0x7461785f636f6e66
+
+// ConfigRevisionGorm records every change to the calculation configuration.
+// Its ID is the configuration version and Snapshot holds the full ConfigState
+// that was in force from CreatedAt onwards.
+type ConfigRevisionGorm struct {
+	ID        int64     `gorm:"primaryKey"`
+	Reason    string    `gorm:"type:varchar(255);not null"`
+	Snapshot  string    `gorm:"type:text;not null"`
+	CreatedAt time.Time `gorm:"not null;index"`
+}
+
+type ConfigState struct {
+	Allowances []Allowance     `json:"allowances"`
+	Schedules  []ScheduleState `json:"schedules"`
+}
+
+type ScheduleState struct {
+	TaxYear    int            `json:"taxYear"`
+	ScheduleID uint           `json:"scheduleId"`
+	Brackets   []BracketState `json:"brackets"`
+}
+
+type BracketState struct {
+	Min  float64  `json:"min"`
+	Max  *float64 `json:"max,omitempty"`
+	Rate float64  `json:"rate"`
+}
+
+func (r ConfigRevisionGorm) State() (ConfigState, error) {
+	var state ConfigState
+	if err := json.Unmarshal([]byte(r.Snapshot), &state); err != nil {
+		return ConfigState{}, fmt.Errorf("failed to decode config revision %d: %v", r.ID, err)
+	}
+	return state, nil
+}
+
+func LoadConfigState(db *gorm.DB) (ConfigState, error) {
+	var state ConfigState
+
+	var allowances []AllowanceGorm
+	if err := db.Order("id").Find(&allowances).Error; err != nil {
+		return state, err
+	}
+	for _, allowance := range allowances {
+		state.Allowances = append(state.Allowances, Allowance{
+			AllowanceType: allowance.AllowanceType,
+			Amount:        allowance.Amount,
+		})
+	}
+
+	var schedules []TaxScheduleGorm
+	err := db.Preload("Brackets", func(db *gorm.DB) *gorm.DB { return db.Order("position") }).
+		Where("status = ?", ScheduleActive).
+		Order("tax_year").
+		Find(&schedules).Error
+	if err != nil {
+		return state, err
+	}
+	for _, schedule := range schedules {
+		scheduleState := ScheduleState{TaxYear: schedule.TaxYear, ScheduleID: schedule.ID}
+		for _, bracket := range schedule.Brackets {
+			scheduleState.Brackets = append(scheduleState.Brackets, BracketState{
+				Min:  bracket.MinIncome,
+				Max:  bracket.MaxIncome,
+				Rate: bracket.Rate,
+			})
+		}
+		state.Schedules = append(state.Schedules, scheduleState)
+	}
+
+	return state, nil
+}
+
+// LockConfig waits, on Postgres, for any other transaction recording a
+// revision to commit, and keeps them waiting until tx ends. A state loaded
+// afterwards includes every earlier change as well as tx's own.
+func LockConfig(tx *gorm.DB) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return tx.Exec("SELECT pg_advisory_xact_lock(?)", configLockKey).Error
+}
+
+// RecordConfigRevision snapshots the configuration as seen by tx and stores
+// it as a new revision. Call it inside the transaction that made the change.
+//
+// It takes LockConfig before reading the snapshot. Without the lock, two
+// writes to different settings would each record a revision missing the
+// other.
+func RecordConfigRevision(tx *gorm.DB, reason string) (ConfigRevisionGorm, error) {
+	if err := LockConfig(tx); err != nil {
+		return ConfigRevisionGorm{}, err
+	}
+	state, err := LoadConfigState(tx)
+	if err != nil {
+		return ConfigRevisionGorm{}, err
+	}
+	return SaveConfigRevision(tx, reason, state)
+}
+
+// SaveConfigRevision stores state as a new revision. The caller must hold
+// LockConfig and have loaded state after taking it.
+func SaveConfigRevision(tx *gorm.DB, reason string, state ConfigState) (ConfigRevisionGorm, error) {
+	snapshot, err := json.Marshal(state)
+	if err != nil {
+		return ConfigRevisionGorm{}, err
+	}
+
+	revision := ConfigRevisionGorm{
+		Reason:    reason,
+		Snapshot:  string(snapshot),
+		CreatedAt: time.Now(),
+	}
+	if err := tx.Create(&revision).Error; err != nil {
+		return ConfigRevisionGorm{}, err
+	}
+
+	if tx.Dialector.Name() == "postgres" {
+		if err := tx.Exec("SELECT pg_notify(?, ?)", ConfigChannel, strconv.FormatInt(revision.ID, 10)).Error; err != nil {
+			return ConfigRevisionGorm{}, err
+		}
+	}
+	return revision, nil
+}
+
+func InitializeConfigRevision(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&ConfigRevisionGorm{}).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check config revisions: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if _, err := RecordConfigRevision(tx, "initial configuration"); err != nil {
+			return fmt.Errorf("failed to initialize config revision: %v", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,51 @@
+package modelgorm
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+)
+
+func TestRecordConfigRevision_LocksBeforeSnapshot(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`SELECT pg_advisory_xact_lock\(\$1\)`).
+		WithArgs(configLockKey).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT \* FROM "allowance_gorms" ORDER BY id`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "allowance_type", "amount"}).AddRow(1, PersonalDefault, 60000))
+	mock.ExpectQuery(`SELECT \* FROM "tax_schedule_gorms" WHERE status = \$1 ORDER BY tax_year`).
+		WithArgs(ScheduleActive).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "tax_year", "status"}))
+	mock.ExpectQuery(`INSERT INTO "config_revision_gorms"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectExec(`SELECT pg_notify\(\$1, \$2\)`).
+		WithArgs(ConfigChannel, "2").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	err = gormDB.Transaction(func(tx *gorm.DB) error {
+		_, err := RecordConfigRevision(tx, "allowance settings updated")
+		return err
+	})
+	if err != nil {
+		t.Errorf("RecordConfigRevision failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
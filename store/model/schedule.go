@@ -0,0 +1,71 @@
+package modelgorm
+
+import (
+	"fmt"
+	"github.com/pphee/assessment-tax/utils"
+	"gorm.io/gorm"
+	"time"
+)
+
+const DefaultTaxYear = 2567
+
+const (
+	ScheduleProposed = "proposed"
+	ScheduleActive   = "active"
+	ScheduleRetired  = "retired"
+)
+
+type TaxScheduleGorm struct {
+	ID          uint             `gorm:"primaryKey"`
+	TaxYear     int              `gorm:"not null;index;index:idx_tax_schedule_gorms_active_tax_year,unique,where:status = 'active'"`
+	Status      string           `gorm:"type:varchar(20);not null"`
+	CreatedAt   time.Time        `gorm:"not null"`
+	ActivatedAt *time.Time       `gorm:""`
+	Brackets    []TaxBracketGorm `gorm:"foreignKey:ScheduleID;constraint:OnDelete:CASCADE"`
+}
+
+type TaxBracketGorm struct {
+	ID         uint     `gorm:"primaryKey"`
+	ScheduleID uint     `gorm:"not null;index"`
+	Position   int      `gorm:"not null"`
+	MinIncome  float64  `gorm:"type:decimal(18,2);not null"`
+	MaxIncome  *float64 `gorm:"type:decimal(18,2)"`
+	Rate       float64  `gorm:"type:decimal(5,4);not null"`
+}
+
+// DefaultTaxSchedule returns the active schedule seeded for DefaultTaxYear.
+func DefaultTaxSchedule(now time.Time) TaxScheduleGorm {
+	schedule := TaxScheduleGorm{
+		TaxYear:     DefaultTaxYear,
+		Status:      ScheduleActive,
+		CreatedAt:   now,
+		ActivatedAt: &now,
+	}
+	for i, rate := range utils.DefaultTaxRates {
+		bracket := TaxBracketGorm{Position: i, MinIncome: rate.Min, Rate: rate.Rate}
+		if rate.Max != nil {
+			max := *rate.Max
+			bracket.MaxIncome = &max
+		}
+		schedule.Brackets = append(schedule.Brackets, bracket)
+	}
+	return schedule
+}
+
+// InitializeTaxSchedule seeds the active bracket schedule for the default tax
+// year when no schedule exists for it yet.
+func InitializeTaxSchedule(db *gorm.DB) error {
+	var count int64
+	if err := db.Model(&TaxScheduleGorm{}).Where("tax_year = ?", DefaultTaxYear).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check tax schedule: %v", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	schedule := DefaultTaxSchedule(time.Now())
+	if err := db.Create(&schedule).Error; err != nil {
+		return fmt.Errorf("failed to initialize tax schedule: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package modelgorm
+
+import (
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/pphee/assessment-tax/utils"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"testing"
+	"time"
+)
+
+func TestInitializeTaxSchedule(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "tax_schedule_gorms" WHERE tax_year = \$1`).
+		WithArgs(DefaultTaxYear).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "tax_schedule_gorms"`).
+		WithArgs(DefaultTaxYear, ScheduleActive, sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectQuery(`INSERT INTO "tax_bracket_gorms"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1).AddRow(2).AddRow(3).AddRow(4).AddRow(5))
+	mock.ExpectCommit()
+
+	if err := InitializeTaxSchedule(gormDB); err != nil {
+		t.Errorf("InitializeTaxSchedule failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestInitializeTaxSchedule_AlreadySeeded(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("An error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	gormDB, err := gorm.Open(postgres.New(postgres.Config{
+		Conn: db,
+	}), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("Failed to open gorm database: %v", err)
+	}
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "tax_schedule_gorms"`).
+		WithArgs(DefaultTaxYear).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	if err := InitializeTaxSchedule(gormDB); err != nil {
+		t.Errorf("InitializeTaxSchedule failed: %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDefaultTaxSchedule(t *testing.T) {
+	schedule := DefaultTaxSchedule(time.Now())
+
+	if len(schedule.Brackets) != len(utils.DefaultTaxRates) {
+		t.Fatalf("got %d brackets, want %d", len(schedule.Brackets), len(utils.DefaultTaxRates))
+	}
+	for i, rate := range utils.DefaultTaxRates {
+		bracket := schedule.Brackets[i]
+		if bracket.Position != i || bracket.MinIncome != rate.Min || bracket.Rate != rate.Rate {
+			t.Errorf("bracket %d is %+v, want %+v", i, bracket, rate)
+		}
+		if (bracket.MaxIncome == nil) != (rate.Max == nil) || (rate.Max != nil && (*bracket.MaxIncome != *rate.Max || bracket.MaxIncome == rate.Max)) {
+			t.Errorf("bracket %d must have its own copy of max %v", i, rate.Max)
+		}
+	}
+}
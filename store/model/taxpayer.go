@@ -0,0 +1,43 @@
+package modelgorm
+
+import "time"
+
+// TaxpayerGorm is a profile kept by one caller; Owner is the API key or user
+// that created it. NationalID and Name are encrypted in the database, so
+// profiles are looked up by NationalIDIndex, a blind index of the ID.
+type TaxpayerGorm struct {
+	ID              uint      `gorm:"primaryKey"`
+	Owner           string    `gorm:"type:varchar(100);not null;uniqueIndex:idx_taxpayer_owner_national_id_index"`
+	NationalID      string    `gorm:"type:text;not null"`
+	NationalIDIndex string    `gorm:"type:varchar(64);not null;default:'';uniqueIndex:idx_taxpayer_owner_national_id_index"`
+	Name            string    `gorm:"type:text;not null"`
+	Dependents      int       `gorm:"not null;default:0"`
+	CreatedAt       time.Time `gorm:"not null"`
+	UpdatedAt       time.Time `gorm:"not null"`
+}
+
+// CalculationGorm stores one calculation's input and output as JSON, with
+// the configuration version it was calculated under. Input and Output are
+// encrypted in the database. NationalID, the ID given with the calculation,
+// is not stored; NationalIDIndex holds its blind index so a data subject's
+// calculations can be found.
+type CalculationGorm struct {
+	ID              uint      `gorm:"primaryKey"`
+	Owner           string    `gorm:"type:varchar(100);not null;index"`
+	TaxpayerID      *uint     `gorm:"index"`
+	BatchID         *uint     `gorm:"index"`
+	NationalID      string    `gorm:"-"`
+	NationalIDIndex string    `gorm:"type:varchar(64);not null;default:'';index"`
+	Input           string    `gorm:"type:text;not null"`
+	Output          string    `gorm:"type:text;not null"`
+	Tax             float64   `gorm:"not null"`
+	ConfigVersion   int64     `gorm:"not null"`
+	CreatedAt       time.Time `gorm:"not null;index"`
+}
+
+type CalculationBatchGorm struct {
+	ID            uint      `gorm:"primaryKey"`
+	Rows          int       `gorm:"not null"`
+	ConfigVersion int64     `gorm:"not null"`
+	CreatedAt     time.Time `gorm:"not null"`
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"github.com/pphee/assessment-tax/store/model"
+	"log"
+	"strconv"
+	"time"
+)
+
+// ListenConfigChanges subscribes to configuration change notifications on a
+// dedicated connection and calls onChange with each announced version. It
+// reconnects with backoff until ctx is cancelled.
+func ListenConfigChanges(ctx context.Context, dsn string, onChange func(version int64)) {
+	backoff := time.Second
+	for {
+		err := listen(ctx, dsn, onChange)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Configuration listener stopped: %v; reconnecting in %s", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff < time.Minute {
+			backoff *= 2
+		}
+	}
+}
+
+func listen(ctx context.Context, dsn string, onChange func(version int64)) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{modelgorm.ConfigChannel}.Sanitize()); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+		version, err := strconv.ParseInt(notification.Payload, 10, 64)
+		if err != nil {
+			log.Printf("Ignoring malformed configuration notification %q", notification.Payload)
+			continue
+		}
+		onChange(version)
+	}
+}
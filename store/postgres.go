@@ -1,53 +1,146 @@
 package store
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/pphee/assessment-tax/store/migrations"
 	"github.com/pphee/assessment-tax/store/model"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
 	"log"
 )
 
+const Schema = "assessment_tax"
+
 type PostgresStore struct {
 	DB *gorm.DB
 }
 
-func NewPostgresStore(dsn string) *PostgresStore {
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// NewPostgresStore prepares a connection pool without connecting; call
+// Wait before relying on it. Every connection uses the assessment_tax
+// schema. It does not change the schema; call Migrate, or CheckMigrations
+// when migrations are run separately.
+func NewPostgresStore(dsn string, pool Pool) (*PostgresStore, error) {
+	config, err := pgx.ParseConfig(dsn)
 	if err != nil {
-		log.Fatal("Error connecting to database: ", err)
+		return nil, fmt.Errorf("invalid database URL: %w", err)
 	}
-	sqlDB, err := db.DB()
+	config.RuntimeParams["search_path"] = Schema
+
+	sqlDB := stdlib.OpenDB(*config)
+	sqlDB.SetMaxOpenConns(pool.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(pool.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(pool.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(pool.ConnMaxIdleTime)
+
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{TranslateError: true, DisableAutomaticPing: true})
 	if err != nil {
-		log.Fatal("Error getting database connection: ", err)
+		sqlDB.Close()
+		return nil, fmt.Errorf("error connecting to database: %w", err)
 	}
+	return &PostgresStore{DB: db}, nil
+}
 
-	if err := sqlDB.Ping(); err != nil {
-		log.Fatal("Error pinging database: ", err)
+func (s *PostgresStore) migrator() (*migrations.Migrator, error) {
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return nil, err
 	}
+	return migrations.New(sqlDB, Schema)
+}
 
-	if err := db.Exec("CREATE SCHEMA IF NOT EXISTS assessment_tax;").Error; err != nil {
-		log.Fatal("Failed to create schema: ", err)
+// Migrate applies pending migrations and seeds the default configuration,
+// holding the migration lock throughout.
+func (s *PostgresStore) Migrate(ctx context.Context) error {
+	migrator, err := s.migrator()
+	if err != nil {
+		return err
+	}
+	migrator.Seed = func(ctx context.Context, conn *sql.Conn) error {
+		// Seed through the connection holding the migration lock
+		db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{TranslateError: true, DisableAutomaticPing: true, Logger: s.DB.Logger})
+		if err != nil {
+			return err
+		}
+		return seed(db.WithContext(ctx))
 	}
+	ran, err := migrator.Up(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range ran {
+		log.Printf("Applied migration %d_%s", migration.Version, migration.Name)
+	}
+	return nil
+}
 
-	if err := db.Exec("SET search_path TO assessment_tax;").Error; err != nil {
-		log.Fatal("Failed to set schema: ", err)
+// RollbackMigrations reverts the latest steps migrations.
+func (s *PostgresStore) RollbackMigrations(ctx context.Context, steps int) error {
+	migrator, err := s.migrator()
+	if err != nil {
+		return err
+	}
+	ran, err := migrator.Down(ctx, steps)
+	for _, migration := range ran {
+		log.Printf("Reverted migration %d_%s", migration.Version, migration.Name)
 	}
+	return err
+}
 
-	var typeName string
-	db.Raw("SELECT typname FROM pg_type WHERE typname = 'allowance_type'").Scan(&typeName)
-	if typeName == "" {
-		if err := db.Exec("CREATE TYPE assessment_tax.allowance_type AS ENUM ('PersonalDefault', 'PersonalMax', 'DonationMax', 'KReceiptDefault', 'KReceiptMax');").Error; err != nil {
-			log.Fatal("Failed to create ENUM type: ", err)
-		}
+func (s *PostgresStore) MigrationStatus(ctx context.Context) ([]migrations.Status, error) {
+	migrator, err := s.migrator()
+	if err != nil {
+		return nil, err
 	}
+	return migrator.Status(ctx)
+}
 
-	if err := db.AutoMigrate(&modelgorm.AllowanceGorm{}); err != nil {
-		log.Fatal("Failed to migrate database: ", err)
+// CheckMigrations fails when the database is behind this build or its
+// applied migrations differ from the embedded ones.
+func (s *PostgresStore) CheckMigrations(ctx context.Context) error {
+	migrator, err := s.migrator()
+	if err != nil {
+		return err
 	}
+	return migrator.Verify(ctx)
+}
 
+func (s *PostgresStore) Gorm() *gorm.DB {
+	return s.DB
+}
+
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (s *PostgresStore) Close() error {
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
+
+// seed inserts the default allowances, tax schedule and first configuration
+// revision where they are missing.
+func seed(db *gorm.DB) error {
 	if err := modelgorm.InitializeData(db); err != nil {
-		log.Fatal("Failed to initialize data: ", err)
+		return fmt.Errorf("failed to initialize data: %w", err)
+	}
+
+	if err := modelgorm.InitializeTaxSchedule(db); err != nil {
+		return fmt.Errorf("failed to initialize tax schedule: %w", err)
 	}
 
-	return &PostgresStore{DB: db}
+	if err := modelgorm.InitializeConfigRevision(db); err != nil {
+		return fmt.Errorf("failed to initialize config revision: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,67 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"github.com/glebarez/sqlite"
+	"github.com/pphee/assessment-tax/store/model"
+	"gorm.io/gorm"
+)
+
+// SQLiteStore runs the repositories on an SQLite file, or in memory, so the
+// API can be run without a database server. It is meant for development:
+// the schema comes from the models rather than the versioned migrations.
+type SQLiteStore struct {
+	DB *gorm.DB
+}
+
+// NewSQLiteStore opens path, which may be ":memory:".
+func NewSQLiteStore(path string) (*SQLiteStore, error) {
+	db, err := gorm.Open(sqlite.Open(path+"?_pragma=foreign_keys(1)"), &gorm.Config{TranslateError: true})
+	if err != nil {
+		return nil, fmt.Errorf("error opening database: %w", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, fmt.Errorf("error getting database connection: %w", err)
+	}
+	// An in-memory database exists per connection, and SQLite serialises
+	// writers anyway.
+	sqlDB.SetMaxOpenConns(1)
+
+	return &SQLiteStore{DB: db}, nil
+}
+
+func (s *SQLiteStore) Gorm() *gorm.DB {
+	return s.DB
+}
+
+// Migrate creates the tables from the models and seeds the defaults.
+func (s *SQLiteStore) Migrate(ctx context.Context) error {
+	if err := s.DB.WithContext(ctx).AutoMigrate(modelgorm.Models...); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
+	}
+	return seed(s.DB)
+}
+
+// CheckMigrations migrates as well: SQLite has no migration history to
+// check, so its schema always follows the models.
+func (s *SQLiteStore) CheckMigrations(ctx context.Context) error {
+	return s.Migrate(ctx)
+}
+
+func (s *SQLiteStore) Ping(ctx context.Context) error {
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+func (s *SQLiteStore) Close() error {
+	sqlDB, err := s.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	SchemePostgres = "postgres"
+	SchemeSQLite   = "sqlite"
+	SchemeMemory   = "memory"
+)
+
+// Store is a database the repositories can run on.
+type Store interface {
+	Gorm() *gorm.DB
+	Migrate(ctx context.Context) error
+	CheckMigrations(ctx context.Context) error
+	Ping(ctx context.Context) error
+	Close() error
+}
+
+// Pool limits the database connection pool. Zero values keep the
+// database/sql defaults.
+type Pool struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Scheme reports the backend a DATABASE_URL selects: postgres:// and
+// postgresql:// for Postgres, sqlite://<path> for an SQLite file and
+// memory:// for a database that lives only as long as the process.
+func Scheme(dsn string) (string, error) {
+	scheme, _, found := strings.Cut(dsn, "://")
+	if !found {
+		// Postgres key=value connection strings have no scheme.
+		return SchemePostgres, nil
+	}
+	switch scheme {
+	case "postgres", "postgresql":
+		return SchemePostgres, nil
+	case SchemeSQLite:
+		return SchemeSQLite, nil
+	case SchemeMemory:
+		return SchemeMemory, nil
+	}
+	return "", fmt.Errorf("unsupported database scheme %q", scheme)
+}
+
+// Open prepares the database selected by dsn without waiting for it to
+// answer; see Wait. The in-memory backend is an in-memory SQLite database;
+// callers may also swap in repositories that keep their state in Go, such
+// as tax.NewMemoryTaxRepository. SQLite ignores pool.
+func Open(dsn string, pool Pool) (Store, error) {
+	scheme, err := Scheme(dsn)
+	if err != nil {
+		return nil, err
+	}
+	switch scheme {
+	case SchemeSQLite:
+		path := strings.TrimPrefix(dsn, "sqlite://")
+		if path == "" {
+			return nil, fmt.Errorf("sqlite:// needs a file path")
+		}
+		return NewSQLiteStore(path)
+	case SchemeMemory:
+		return NewSQLiteStore(":memory:")
+	}
+	return NewPostgresStore(dsn, pool)
+}
+
+const maxWaitBackoff = 10 * time.Second
+
+// Wait pings s until it answers or ctx is done, backing off between
+// attempts, so the API can start before its database is ready.
+func Wait(ctx context.Context, s Store) error {
+	backoff := 500 * time.Millisecond
+	for {
+		err := s.Ping(ctx)
+		if err == nil {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("database is not available: %w", err)
+		}
+		log.Printf("Database is not available: %v; retrying in %s", err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("database is not available: %w", err)
+		case <-time.After(backoff):
+		}
+		if backoff < maxWaitBackoff {
+			backoff = min(backoff*2, maxWaitBackoff)
+		}
+	}
+}
@@ -2,58 +2,68 @@ package utils
 
 import "github.com/pphee/assessment-tax/internal/model"
 
-func CalculateIncomeTaxDetailed(income float64) (float64, []model.TaxBracket) {
-	tax := calculateTotalTax(income)
-	taxBrackets := calculateTaxBrackets(income)
-	return tax, taxBrackets
+// DefaultTaxRates is the progressive schedule for tax year 2567.
+// modelgorm.DefaultTaxSchedule seeds it, and tests use it as a reference.
+var DefaultTaxRates = []model.TaxRate{
+	{Min: 0, Max: ceiling(150000), Rate: 0},
+	{Min: 150000, Max: ceiling(500000), Rate: 0.1},
+	{Min: 500000, Max: ceiling(1000000), Rate: 0.15},
+	{Min: 1000000, Max: ceiling(2000000), Rate: 0.2},
+	{Min: 2000000, Rate: 0.35},
 }
 
-func calculateTotalTax(income float64) float64 {
-	var tax float64
+func ceiling(amount float64) *float64 {
+	return &amount
+}
 
-	if income > 2000000 {
-		tax += (income - 2000000) * 0.35
-		income = 2000000
-	}
-	if income > 1000000 {
-		tax += (income - 1000000) * 0.2
-		income = 1000000
+func CalculateIncomeTaxDetailed(income float64, rates []model.TaxRate) (float64, []model.TaxBracket) {
+	tax := calculateTotalTax(income, rates)
+	taxBrackets := calculateTaxBrackets(income, rates)
+	return tax, taxBrackets
+}
+
+// TaxLevelLabel renders a bracket as shown to users, e.g. "150,001-500,000".
+func TaxLevelLabel(rate model.TaxRate) string {
+	lower := FormatAmount(rate.Min)
+	if rate.Min > 0 {
+		lower = FormatAmount(rate.Min + 1)
 	}
-	if income > 500000 {
-		tax += (income - 500000) * 0.15
-		income = 500000
+	if rate.Max == nil {
+		return lower + " ขึ้นไป"
 	}
-	if income > 150000 {
-		tax += (income - 150000) * 0.1
+	return lower + "-" + FormatAmount(*rate.Max)
+}
+
+func calculateTotalTax(income float64, rates []model.TaxRate) float64 {
+	var tax float64
+
+	for i := len(rates) - 1; i >= 0; i-- {
+		tax += bracketTax(income, rates[i])
 	}
 
 	return tax
 }
 
-func calculateTaxBrackets(income float64) []model.TaxBracket {
-	taxBrackets := []model.TaxBracket{
-		{Level: "0-150,000", Tax: 0},
-		{Level: "150,001-500,000", Tax: 0},
-		{Level: "500,001-1,000,000", Tax: 0},
-		{Level: "1,000,001-2,000,000", Tax: 0},
-		{Level: "2,000,001 ขึ้นไป", Tax: 0},
-	}
+func calculateTaxBrackets(income float64, rates []model.TaxRate) []model.TaxBracket {
+	taxBrackets := make([]model.TaxBracket, len(rates))
 
-	if income > 2000000 {
-		taxBrackets[4].Tax = (income - 2000000) * 0.35
-		income = 2000000
-	}
-	if income > 1000000 {
-		taxBrackets[3].Tax = (income - 1000000) * 0.2
-		income = 1000000
-	}
-	if income > 500000 {
-		taxBrackets[2].Tax = (income - 500000) * 0.15
-		income = 500000
-	}
-	if income > 150000 {
-		taxBrackets[1].Tax = (income - 150000) * 0.1
+	for i, rate := range rates {
+		taxBrackets[i] = model.TaxBracket{
+			Level: TaxLevelLabel(rate),
+			Tax:   bracketTax(income, rate),
+			Rate:  rate,
+		}
 	}
 
 	return taxBrackets
 }
+
+func bracketTax(income float64, rate model.TaxRate) float64 {
+	if income <= rate.Min {
+		return 0
+	}
+	if rate.Max != nil && income > *rate.Max {
+		income = *rate.Max
+	}
+	return (income - rate.Min) * rate.Rate
+}
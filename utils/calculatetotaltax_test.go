@@ -21,7 +21,7 @@ func TestCalculateTotalTax(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := calculateTotalTax(tt.income)
+			got := calculateTotalTax(tt.income, DefaultTaxRates)
 			assert.Equal(t, tt.want, got)
 		})
 	}
@@ -37,29 +37,29 @@ func TestCalculateTaxBrackets(t *testing.T) {
 			name:   "No Tax",
 			income: 100000,
 			want: []model.TaxBracket{
-				{Level: "0-150,000", Tax: 0},
-				{Level: "150,001-500,000", Tax: 0},
-				{Level: "500,001-1,000,000", Tax: 0},
-				{Level: "1,000,001-2,000,000", Tax: 0},
-				{Level: "2,000,001 ขึ้นไป", Tax: 0},
+				{Level: "0-150,000", Tax: 0, Rate: DefaultTaxRates[0]},
+				{Level: "150,001-500,000", Tax: 0, Rate: DefaultTaxRates[1]},
+				{Level: "500,001-1,000,000", Tax: 0, Rate: DefaultTaxRates[2]},
+				{Level: "1,000,001-2,000,000", Tax: 0, Rate: DefaultTaxRates[3]},
+				{Level: "2,000,001 ขึ้นไป", Tax: 0, Rate: DefaultTaxRates[4]},
 			},
 		},
 		{
 			name:   "Highest Bracket",
 			income: 3000000,
 			want: []model.TaxBracket{
-				{Level: "0-150,000", Tax: 0},
-				{Level: "150,001-500,000", Tax: (500000 - 150000) * 0.1},
-				{Level: "500,001-1,000,000", Tax: (1000000 - 500000) * 0.15},
-				{Level: "1,000,001-2,000,000", Tax: (2000000 - 1000000) * 0.2},
-				{Level: "2,000,001 ขึ้นไป", Tax: (3000000 - 2000000) * 0.35},
+				{Level: "0-150,000", Tax: 0, Rate: DefaultTaxRates[0]},
+				{Level: "150,001-500,000", Tax: (500000 - 150000) * 0.1, Rate: DefaultTaxRates[1]},
+				{Level: "500,001-1,000,000", Tax: (1000000 - 500000) * 0.15, Rate: DefaultTaxRates[2]},
+				{Level: "1,000,001-2,000,000", Tax: (2000000 - 1000000) * 0.2, Rate: DefaultTaxRates[3]},
+				{Level: "2,000,001 ขึ้นไป", Tax: (3000000 - 2000000) * 0.35, Rate: DefaultTaxRates[4]},
 			},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := calculateTaxBrackets(tt.income)
+			got := calculateTaxBrackets(tt.income, DefaultTaxRates)
 			assert.Equal(t, tt.want, got)
 		})
 	}
@@ -69,14 +69,29 @@ func TestCalculateIncomeTaxDetailed(t *testing.T) {
 	income := 3000000.0
 	expectedTax := (500000-150000)*0.1 + (1000000-500000)*0.15 + (2000000-1000000)*0.2 + (3000000-2000000)*0.35
 	expectedBrackets := []model.TaxBracket{
-		{Level: "0-150,000", Tax: 0},
-		{Level: "150,001-500,000", Tax: (500000 - 150000) * 0.1},
-		{Level: "500,001-1,000,000", Tax: (1000000 - 500000) * 0.15},
-		{Level: "1,000,001-2,000,000", Tax: (2000000 - 1000000) * 0.2},
-		{Level: "2,000,001 ขึ้นไป", Tax: (3000000 - 2000000) * 0.35},
+		{Level: "0-150,000", Tax: 0, Rate: DefaultTaxRates[0]},
+		{Level: "150,001-500,000", Tax: (500000 - 150000) * 0.1, Rate: DefaultTaxRates[1]},
+		{Level: "500,001-1,000,000", Tax: (1000000 - 500000) * 0.15, Rate: DefaultTaxRates[2]},
+		{Level: "1,000,001-2,000,000", Tax: (2000000 - 1000000) * 0.2, Rate: DefaultTaxRates[3]},
+		{Level: "2,000,001 ขึ้นไป", Tax: (3000000 - 2000000) * 0.35, Rate: DefaultTaxRates[4]},
 	}
 
-	tax, taxBrackets := CalculateIncomeTaxDetailed(income)
+	tax, taxBrackets := CalculateIncomeTaxDetailed(income, DefaultTaxRates)
 	assert.Equal(t, expectedTax, tax)
 	assert.Equal(t, expectedBrackets, taxBrackets)
 }
+
+func TestCalculateIncomeTaxDetailed_CustomRates(t *testing.T) {
+	rates := []model.TaxRate{
+		{Min: 0, Max: ceiling(100000), Rate: 0},
+		{Min: 100000, Rate: 0.25},
+	}
+
+	tax, taxBrackets := CalculateIncomeTaxDetailed(300000, rates)
+
+	assert.Equal(t, 50000.0, tax)
+	assert.Equal(t, []model.TaxBracket{
+		{Level: "0-100,000", Tax: 0, Rate: rates[0]},
+		{Level: "100,001 ขึ้นไป", Tax: 50000, Rate: rates[1]},
+	}, taxBrackets)
+}
@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+)
+
+// FormatAmount renders a whole-baht amount with thousands separators, e.g. 150,001.
+func FormatAmount(amount float64) string {
+	digits := strconv.FormatFloat(amount, 'f', 0, 64)
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
@@ -0,0 +1,26 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestFormatAmount(t *testing.T) {
+	tests := []struct {
+		amount float64
+		want   string
+	}{
+		{0, "0"},
+		{999, "999"},
+		{10000, "10,000"},
+		{150001, "150,001"},
+		{2000000, "2,000,000"},
+		{-60000, "-60,000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, tt.want, FormatAmount(tt.amount))
+		})
+	}
+}
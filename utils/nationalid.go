@@ -0,0 +1,40 @@
+package utils
+
+import "strings"
+
+// NormalizeNationalID drops the spaces and dashes people write Thai national
+// IDs with, e.g. 1-1017-00203-45-0.
+func NormalizeNationalID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r == ' ' || r == '-' {
+			return -1
+		}
+		return r
+	}, id)
+}
+
+// ValidNationalID reports whether id is 13 digits ending in the check digit
+// of the Thai national ID and tax ID scheme.
+func ValidNationalID(id string) bool {
+	if len(id) != 13 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 13; i++ {
+		if id[i] < '0' || id[i] > '9' {
+			return false
+		}
+		if i < 12 {
+			sum += int(id[i]-'0') * (13 - i)
+		}
+	}
+	return int(id[12]-'0') == (11-sum%11)%10
+}
+
+// MaskNationalID hides all but the last four characters of id.
+func MaskNationalID(id string) string {
+	if len(id) <= 4 {
+		return strings.Repeat("*", len(id))
+	}
+	return strings.Repeat("*", len(id)-4) + id[len(id)-4:]
+}
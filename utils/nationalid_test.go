@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestValidNationalID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"1101700203450", true},
+		{"3101200123453", true},
+		{"1234567890121", true},
+		{"1101700203451", false},
+		{"110170020345", false},
+		{"11017002034500", false},
+		{"11017002034a0", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.id, func(t *testing.T) {
+			assert.Equal(t, tt.want, ValidNationalID(tt.id))
+		})
+	}
+}
+
+func TestNormalizeNationalID(t *testing.T) {
+	assert.Equal(t, "1101700203450", NormalizeNationalID("1-1017-00203-45-0"))
+	assert.Equal(t, "1101700203450", NormalizeNationalID(" 1101700203450 "))
+}
+
+func TestMaskNationalID(t *testing.T) {
+	assert.Equal(t, "*********3450", MaskNationalID("1101700203450"))
+	assert.Equal(t, "***", MaskNationalID("123"))
+	assert.Equal(t, "", MaskNationalID(""))
+}